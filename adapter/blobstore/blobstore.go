@@ -0,0 +1,36 @@
+// Package blobstore keeps the files users upload, on the local disk or in an S3 bucket
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/bozoteam/roshan/adapter/config"
+)
+
+// ErrNotFound is returned when no blob has the key
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores opaque blobs under slash separated keys such as "attachments/<id>"
+type BlobStore interface {
+	// Put stores size bytes read from r under key, replacing any blob already there
+	Put(ctx context.Context, key string, contentType string, r io.Reader, size int64) error
+	// Get opens the blob, the caller closes it
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob, deleting a missing blob isn't an error
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns the store configured by cfg.Store
+func New(cfg config.AttachmentsConfig) (BlobStore, error) {
+	switch cfg.Store {
+	case config.BlobStoreLocal:
+		return NewLocalStore(cfg.Dir)
+	case config.BlobStoreS3:
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", cfg.Store)
+	}
+}
@@ -0,0 +1,475 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bozoteam/roshan/helpers"
+)
+
+// Config is everything roshan reads at startup. Every field can be set in the
+// config file (file tag) and overridden through the environment (env tag).
+type Config struct {
+	Server    ServerConfig    `file:"server"`
+	Database  DatabaseConfig  `file:"database"`
+	JWT       JWTConfig       `file:"jwt"`
+	Auth      AuthConfig      `file:"auth"`
+	WebSocket WebSocketConfig `file:"websocket"`
+	Chat      ChatConfig      `file:"chat"`
+	OIDC      OIDCConfig      `file:"oidc"`
+	Metrics   MetricsConfig   `file:"metrics"`
+	Tracing   TracingConfig   `file:"tracing"`
+	Log       LogConfig       `file:"log"`
+	Health    HealthConfig    `file:"health"`
+}
+
+type ServerConfig struct {
+	ListenAddr     string   `file:"listen_addr" env:"LISTEN_ADDR"`
+	AllowedOrigins []string `file:"allowed_origins" env:"ALLOWED_ORIGINS"`
+	// How long in-flight requests and open sockets get to finish on SIGTERM
+	ShutdownTimeout time.Duration `file:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	// Origins added on top of AllowedOrigins, handy for local frontends
+	ExtraOrigins []string `file:"extra_origins" env:"CORS_ALLOWED_ORIGINS"`
+	// Addresses or CIDR ranges of the proxies in front of roshan. Only they are believed
+	// when they forward the client's address, nobody is by default.
+	TrustedProxies []string `file:"trusted_proxies" env:"TRUSTED_PROXIES"`
+}
+
+// Origins returns every origin allowed to call the API or open a WebSocket
+func (c ServerConfig) Origins() []string {
+	origins := append([]string{}, c.AllowedOrigins...)
+	if helpers.IsDevelopment {
+		origins = append(origins,
+			"http://127.0.0.1:5173",
+			"http://localhost:50000",
+			"http://127.0.0.1:50000",
+			"http://bozo.mateusbento.com",
+		)
+	}
+	return append(origins, c.ExtraOrigins...)
+}
+
+type DatabaseConfig struct {
+	Host            string        `file:"host" env:"DB_HOST"`
+	Port            int           `file:"port" env:"DB_PORT"`
+	User            string        `file:"user" env:"DB_USER"`
+	Password        string        `file:"password" env:"DB_PASSWORD"`
+	Name            string        `file:"name" env:"DB_NAME"`
+	SSLMode         string        `file:"ssl_mode" env:"DB_SSL_MODE"`
+	MaxOpenConns    int           `file:"max_open_conns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `file:"max_idle_conns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `file:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`
+	// Logs every query with its values, which include secrets. Keep it off outside development.
+	Debug bool `file:"debug" env:"DB_DEBUG"`
+	// Queries slower than this are logged as warnings
+	SlowQueryThreshold time.Duration `file:"slow_query_threshold" env:"DB_SLOW_QUERY_THRESHOLD"`
+	// Applies pending migrations at startup instead of refusing to serve
+	AutoMigrate bool `file:"auto_migrate" env:"DB_AUTO_MIGRATE"`
+}
+
+func (c DatabaseConfig) DSN() string {
+	return fmt.Sprintf(
+		"host=%s user=%s password=%s dbname=%s port=%d sslmode=%s",
+		c.Host, c.User, c.Password, c.Name, c.Port, c.SSLMode,
+	)
+}
+
+type JWTConfig struct {
+	Secret        string `file:"secret" env:"JWT_SECRET"`
+	RefreshSecret string `file:"refresh_secret" env:"JWT_REFRESH_SECRET"`
+	// Plain numbers are read as seconds
+	TokenExpiration        time.Duration `file:"token_expiration" env:"JWT_TOKEN_EXPIRATION"`
+	RefreshTokenExpiration time.Duration `file:"refresh_token_expiration" env:"JWT_REFRESH_TOKEN_EXPIRATION"`
+}
+
+type AuthConfig struct {
+	Lockout LockoutConfig `file:"lockout"`
+	// How long a WebSocket ticket can be redeemed for
+	TicketTTL time.Duration `file:"ticket_ttl" env:"WS_TICKET_TTL"`
+}
+
+// LockoutConfig controls how failed logins are throttled
+type LockoutConfig struct {
+	// Failures tolerated before progressive delays kick in
+	FreeAttempts int `file:"free_attempts" env:"LOCKOUT_FREE_ATTEMPTS"`
+	// Delay after the first throttled failure, doubled for every failure after it
+	BaseDelay time.Duration `file:"base_delay" env:"LOCKOUT_BASE_DELAY"`
+	MaxDelay  time.Duration `file:"max_delay" env:"LOCKOUT_MAX_DELAY"`
+
+	// Failures after which an account or an IP is locked out
+	AccountThreshold int           `file:"account_threshold" env:"LOCKOUT_ACCOUNT_THRESHOLD"`
+	IPThreshold      int           `file:"ip_threshold" env:"LOCKOUT_IP_THRESHOLD"`
+	LockoutDuration  time.Duration `file:"duration" env:"LOCKOUT_DURATION"`
+
+	// Counters whose last failure is older than this start over
+	FailureWindow time.Duration `file:"failure_window" env:"LOCKOUT_FAILURE_WINDOW"`
+}
+
+type WebSocketConfig struct {
+	MaxConnsPerUser   int   `file:"max_connections_per_user" env:"WS_MAX_CONNECTIONS_PER_USER"`
+	ReadLimit         int64 `file:"read_limit" env:"WS_READ_LIMIT"`
+	EnableCompression bool  `file:"compression" env:"WS_COMPRESSION"`
+
+	HandshakeTimeout time.Duration `file:"handshake_timeout" env:"WS_HANDSHAKE_TIMEOUT"`
+	// How often the server pings clients and how long it waits for the PONG
+	PingInterval time.Duration `file:"ping_interval" env:"WS_PING_INTERVAL"`
+	PongWait     time.Duration `file:"pong_wait" env:"WS_PONG_WAIT"`
+	WriteWait    time.Duration `file:"write_wait" env:"WS_WRITE_WAIT"`
+	// Minimum delay clients are told to wait before reconnecting after a shutdown
+	ReconnectDelay time.Duration `file:"reconnect_delay" env:"WS_RECONNECT_DELAY"`
+}
+
+type ChatConfig struct {
+	Presence PresenceConfig `file:"presence"`
+	// ReadReceipts tells rooms how far each of their users read
+	ReadReceipts bool              `file:"read_receipts" env:"CHAT_READ_RECEIPTS"`
+	Attachments  AttachmentsConfig `file:"attachments"`
+	Moderation   ModerationConfig  `file:"moderation"`
+}
+
+const (
+	BlobStoreLocal = "local"
+	BlobStoreS3    = "s3"
+)
+
+// AttachmentsConfig controls the files users attach to chat messages
+type AttachmentsConfig struct {
+	// One of local or s3
+	Store string `file:"store" env:"ATTACHMENTS_STORE"`
+	// Where the local store keeps files
+	Dir string   `file:"dir" env:"ATTACHMENTS_DIR"`
+	S3  S3Config `file:"s3"`
+
+	// Largest file accepted, in bytes
+	MaxSize int64 `file:"max_size" env:"ATTACHMENTS_MAX_SIZE"`
+	// MIME types accepted, checked against the file's content rather than what the client says
+	AllowedTypes []string `file:"allowed_types" env:"ATTACHMENTS_ALLOWED_TYPES"`
+	// Images are given a thumbnail fitting in a square of this many pixels
+	ThumbnailSize int `file:"thumbnail_size" env:"ATTACHMENTS_THUMBNAIL_SIZE"`
+
+	// Signs download URLs, a key derived from jwt.secret is used when it's empty
+	SigningKey string `file:"signing_key" env:"ATTACHMENTS_SIGNING_KEY"`
+	// How long a download URL works
+	URLTTL time.Duration `file:"url_ttl" env:"ATTACHMENTS_URL_TTL"`
+}
+
+// S3Config points at a bucket of S3 or of any store speaking its API, such as MinIO.
+// Objects are addressed path-style, <endpoint>/<bucket>/<key>.
+type S3Config struct {
+	Endpoint        string `file:"endpoint" env:"S3_ENDPOINT"`
+	Region          string `file:"region" env:"S3_REGION"`
+	Bucket          string `file:"bucket" env:"S3_BUCKET"`
+	AccessKeyID     string `file:"access_key_id" env:"S3_ACCESS_KEY_ID"`
+	SecretAccessKey string `file:"secret_access_key" env:"S3_SECRET_ACCESS_KEY"`
+}
+
+const (
+	WordActionRedact = "redact"
+	WordActionReject = "reject"
+	WordActionFlag   = "flag"
+)
+
+// ModerationConfig controls the filters messages go through before they're sent
+type ModerationConfig struct {
+	// Longest message accepted, in characters
+	MaxLength int `file:"max_length" env:"MODERATION_MAX_LENGTH"`
+	// Words matched as whole words regardless of case, WordAction decides what happens to
+	// the messages containing them
+	Words      []string `file:"words" env:"MODERATION_WORDS"`
+	WordAction string   `file:"word_action" env:"MODERATION_WORD_ACTION"`
+	// Whether rooms block links until a moderator allows them
+	BlockLinks bool `file:"block_links" env:"MODERATION_BLOCK_LINKS"`
+	// A user can send the same message in a room RepeatLimit times per RepeatWindow
+	RepeatLimit  int           `file:"repeat_limit" env:"MODERATION_REPEAT_LIMIT"`
+	RepeatWindow time.Duration `file:"repeat_window" env:"MODERATION_REPEAT_WINDOW"`
+}
+
+// PresenceConfig controls how presence and typing indicators are tracked
+type PresenceConfig struct {
+	// Connected users with no activity for this long are idle, and away after AwayAfter
+	IdleAfter time.Duration `file:"idle_after" env:"PRESENCE_IDLE_AFTER"`
+	AwayAfter time.Duration `file:"away_after" env:"PRESENCE_AWAY_AFTER"`
+	// How long someone shows as typing without sending another typing_started
+	TypingTimeout time.Duration `file:"typing_timeout" env:"PRESENCE_TYPING_TIMEOUT"`
+	// Changes are batched and sent to each room at most once per interval
+	FlushInterval time.Duration `file:"flush_interval" env:"PRESENCE_FLUSH_INTERVAL"`
+	// How long the last activity of users who went offline is remembered
+	ForgetAfter time.Duration `file:"forget_after" env:"PRESENCE_FORGET_AFTER"`
+}
+
+type OIDCConfig struct {
+	// Where the browser is sent back to once a login or link finishes
+	FrontendURL string               `file:"frontend_url" env:"OIDC_FRONTEND_URL"`
+	Providers   []OIDCProviderConfig `file:"providers"`
+}
+
+// OIDCProviderConfig describes an OIDC provider registered as an OAuth2 client. In the
+// environment providers are listed in OIDC_PROVIDERS, each configured through
+// OIDC_<NAME>_ISSUER_URL, _CLIENT_ID, _CLIENT_SECRET and _REDIRECT_URL.
+type OIDCProviderConfig struct {
+	Name         string `file:"name"`
+	IssuerURL    string `file:"issuer_url" env:"ISSUER_URL"`
+	ClientID     string `file:"client_id" env:"CLIENT_ID"`
+	ClientSecret string `file:"client_secret" env:"CLIENT_SECRET"`
+	// Absolute URL of /api/v1/auth/oidc/<name>/callback as registered at the provider
+	RedirectURL string `file:"redirect_url" env:"REDIRECT_URL"`
+}
+
+type MetricsConfig struct {
+	// Serves Prometheus metrics on the API listener when enabled
+	Enabled bool   `file:"enabled" env:"METRICS_ENABLED"`
+	Path    string `file:"path" env:"METRICS_PATH"`
+}
+
+const (
+	TracingExporterNone   = "none"
+	TracingExporterStdout = "stdout"
+	TracingExporterOTLP   = "otlp"
+)
+
+type TracingConfig struct {
+	// One of none, stdout or otlp. The OTLP exporter reads OTEL_EXPORTER_OTLP_* itself.
+	Exporter    string  `file:"exporter" env:"TRACING_EXPORTER"`
+	ServiceName string  `file:"service_name" env:"OTEL_SERVICE_NAME"`
+	SampleRatio float64 `file:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+type LogConfig struct {
+	// One of debug, info, warn or error
+	Level string `file:"level" env:"LOG_LEVEL"`
+	// One of json or text
+	Format string `file:"format" env:"LOG_FORMAT"`
+}
+
+type HealthConfig struct {
+	// How long a single readiness check may take before it counts as failed
+	CheckTimeout time.Duration `file:"check_timeout" env:"HEALTH_CHECK_TIMEOUT"`
+	// How often the gRPC health service is refreshed
+	Interval time.Duration `file:"interval" env:"HEALTH_CHECK_INTERVAL"`
+}
+
+// Default returns the configuration used for anything that isn't set explicitly
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			ListenAddr:      "0.0.0.0:8080",
+			ShutdownTimeout: 15 * time.Second,
+			AllowedOrigins: []string{
+				"https://bozo.mateusbento.com",
+				"http://localhost:5173",
+			},
+		},
+		Database: DatabaseConfig{
+			Port:               5432,
+			SSLMode:            "disable",
+			MaxOpenConns:       25,
+			MaxIdleConns:       5,
+			ConnMaxLifetime:    30 * time.Minute,
+			SlowQueryThreshold: 200 * time.Millisecond,
+		},
+		JWT: JWTConfig{
+			TokenExpiration:        time.Hour,
+			RefreshTokenExpiration: 24 * time.Hour,
+		},
+		Auth: AuthConfig{
+			Lockout: LockoutConfig{
+				FreeAttempts:     3,
+				BaseDelay:        time.Second,
+				MaxDelay:         30 * time.Second,
+				AccountThreshold: 10,
+				IPThreshold:      50,
+				LockoutDuration:  15 * time.Minute,
+				FailureWindow:    time.Hour,
+			},
+			TicketTTL: 30 * time.Second,
+		},
+		WebSocket: WebSocketConfig{
+			MaxConnsPerUser: 5,
+			// Clients only send heartbeats and small events such as typing_started
+			ReadLimit:        512,
+			HandshakeTimeout: 5 * time.Second,
+			PingInterval:     10 * time.Second,
+			PongWait:         5 * time.Second,
+			WriteWait:        5 * time.Second,
+			ReconnectDelay:   time.Second,
+		},
+		Chat: ChatConfig{
+			Presence: PresenceConfig{
+				IdleAfter:     time.Minute,
+				AwayAfter:     10 * time.Minute,
+				TypingTimeout: 6 * time.Second,
+				FlushInterval: 500 * time.Millisecond,
+				ForgetAfter:   24 * time.Hour,
+			},
+			ReadReceipts: true,
+			Attachments: AttachmentsConfig{
+				Store:   BlobStoreLocal,
+				Dir:     "data/attachments",
+				MaxSize: 10 << 20,
+				AllowedTypes: []string{
+					"image/png", "image/jpeg", "image/gif", "image/webp",
+					"application/pdf", "text/plain",
+				},
+				ThumbnailSize: 256,
+				URLTTL:        15 * time.Minute,
+			},
+			Moderation: ModerationConfig{
+				MaxLength:    2000,
+				WordAction:   WordActionRedact,
+				RepeatLimit:  3,
+				RepeatWindow: 30 * time.Second,
+			},
+		},
+		Metrics: MetricsConfig{
+			Path: "/metrics",
+		},
+		Tracing: TracingConfig{
+			Exporter:    TracingExporterNone,
+			ServiceName: "roshan",
+			SampleRatio: 1,
+		},
+		Log: defaultLogConfig(),
+		Health: HealthConfig{
+			CheckTimeout: 2 * time.Second,
+			Interval:     10 * time.Second,
+		},
+	}
+}
+
+// defaultLogConfig is readable in a terminal during development and machine friendly otherwise
+func defaultLogConfig() LogConfig {
+	if helpers.IsDevelopment {
+		return LogConfig{Level: "debug", Format: LogFormatText}
+	}
+	return LogConfig{Level: "info", Format: LogFormatJSON}
+}
+
+// validate returns every problem with the configuration instead of stopping at the first one
+func (c *Config) validate() []string {
+	var problems []string
+	require := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.ListenAddr); err != nil {
+		problems = append(problems, fmt.Sprintf("server.listen_addr: %v", err))
+	}
+	require(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	for _, origin := range c.Server.Origins() {
+		u, err := url.Parse(origin)
+		require(err == nil && u.Scheme != "" && u.Host != "" && u.Path == "", "server: invalid origin %q", origin)
+	}
+	if _, err := helpers.ParseTrustedProxies(c.Server.TrustedProxies); err != nil {
+		problems = append(problems, fmt.Sprintf("server.trusted_proxies: %v", err))
+	}
+
+	require(c.Database.Host != "", "database.host (DB_HOST) is required")
+	require(c.Database.User != "", "database.user (DB_USER) is required")
+	require(c.Database.Password != "", "database.password (DB_PASSWORD) is required")
+	require(c.Database.Name != "", "database.name (DB_NAME) is required")
+	require(c.Database.Port > 0 && c.Database.Port < 65536, "database.port must be between 1 and 65535")
+	require(c.Database.MaxOpenConns > 0, "database.max_open_conns must be positive")
+	require(c.Database.MaxIdleConns >= 0 && c.Database.MaxIdleConns <= c.Database.MaxOpenConns,
+		"database.max_idle_conns must be between 0 and max_open_conns")
+	require(c.Database.SlowQueryThreshold > 0, "database.slow_query_threshold must be positive")
+
+	require(len(c.JWT.Secret) >= 32, "jwt.secret (JWT_SECRET) must be at least 32 characters")
+	require(len(c.JWT.RefreshSecret) >= 32, "jwt.refresh_secret (JWT_REFRESH_SECRET) must be at least 32 characters")
+	require(c.JWT.Secret == "" || c.JWT.Secret != c.JWT.RefreshSecret, "jwt.secret and jwt.refresh_secret must differ")
+	require(c.JWT.TokenExpiration > 0, "jwt.token_expiration must be positive")
+	require(c.JWT.RefreshTokenExpiration >= c.JWT.TokenExpiration,
+		"jwt.refresh_token_expiration must not be shorter than jwt.token_expiration")
+
+	lockout := c.Auth.Lockout
+	require(lockout.FreeAttempts >= 0, "auth.lockout.free_attempts must not be negative")
+	require(lockout.BaseDelay > 0 && lockout.MaxDelay >= lockout.BaseDelay,
+		"auth.lockout.base_delay must be positive and not above max_delay")
+	require(lockout.AccountThreshold > 0, "auth.lockout.account_threshold must be positive")
+	require(lockout.IPThreshold > 0, "auth.lockout.ip_threshold must be positive")
+	require(lockout.LockoutDuration > 0, "auth.lockout.duration must be positive")
+	require(lockout.FailureWindow > 0, "auth.lockout.failure_window must be positive")
+	require(c.Auth.TicketTTL > 0, "auth.ticket_ttl must be positive")
+
+	ws := c.WebSocket
+	require(ws.MaxConnsPerUser > 0, "websocket.max_connections_per_user must be positive")
+	require(ws.ReadLimit > 0, "websocket.read_limit must be positive")
+	require(ws.HandshakeTimeout > 0, "websocket.handshake_timeout must be positive")
+	require(ws.PingInterval > 0, "websocket.ping_interval must be positive")
+	require(ws.PongWait > 0, "websocket.pong_wait must be positive")
+	require(ws.WriteWait > 0, "websocket.write_wait must be positive")
+	require(ws.ReconnectDelay >= 0, "websocket.reconnect_delay must not be negative")
+
+	presence := c.Chat.Presence
+	require(presence.IdleAfter > 0 && presence.AwayAfter > presence.IdleAfter,
+		"chat.presence.idle_after must be positive and below away_after")
+	require(presence.TypingTimeout > 0, "chat.presence.typing_timeout must be positive")
+	require(presence.FlushInterval > 0, "chat.presence.flush_interval must be positive")
+	require(presence.ForgetAfter > 0, "chat.presence.forget_after must be positive")
+
+	attachments := c.Chat.Attachments
+	switch attachments.Store {
+	case BlobStoreLocal:
+		require(attachments.Dir != "", "chat.attachments.dir (ATTACHMENTS_DIR) is required by the local store")
+	case BlobStoreS3:
+		s3 := attachments.S3
+		endpoint, err := url.Parse(s3.Endpoint)
+		require(err == nil && endpoint.Scheme != "" && endpoint.Host != "",
+			"chat.attachments.s3.endpoint (S3_ENDPOINT) must be an absolute URL")
+		require(s3.Region != "", "chat.attachments.s3.region (S3_REGION) is required")
+		require(s3.Bucket != "", "chat.attachments.s3.bucket (S3_BUCKET) is required")
+		require(s3.AccessKeyID != "" && s3.SecretAccessKey != "",
+			"chat.attachments.s3 needs access_key_id and secret_access_key (S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY)")
+	default:
+		require(false, "chat.attachments.store must be local or s3")
+	}
+	require(attachments.MaxSize > 0, "chat.attachments.max_size must be positive")
+	require(len(attachments.AllowedTypes) > 0, "chat.attachments.allowed_types must not be empty")
+	require(attachments.ThumbnailSize > 0, "chat.attachments.thumbnail_size must be positive")
+	require(attachments.SigningKey == "" || len(attachments.SigningKey) >= 32,
+		"chat.attachments.signing_key (ATTACHMENTS_SIGNING_KEY) must be at least 32 characters")
+	require(attachments.URLTTL > 0, "chat.attachments.url_ttl must be positive")
+
+	moderation := c.Chat.Moderation
+	require(moderation.MaxLength > 0, "chat.moderation.max_length must be positive")
+	require(slices.Contains([]string{WordActionRedact, WordActionReject, WordActionFlag}, moderation.WordAction),
+		"chat.moderation.word_action must be one of redact, reject or flag")
+	require(moderation.RepeatLimit > 0, "chat.moderation.repeat_limit must be positive")
+	require(moderation.RepeatWindow > 0, "chat.moderation.repeat_window must be positive")
+
+	require(!c.Metrics.Enabled || strings.HasPrefix(c.Metrics.Path, "/"), "metrics.path must start with /")
+
+	require(slices.Contains([]string{TracingExporterNone, TracingExporterStdout, TracingExporterOTLP}, c.Tracing.Exporter),
+		"tracing.exporter must be one of none, stdout or otlp")
+	require(c.Tracing.ServiceName != "", "tracing.service_name must not be empty")
+	require(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+
+	require(slices.Contains([]string{"debug", "info", "warn", "error"}, strings.ToLower(c.Log.Level)),
+		"log.level must be one of debug, info, warn or error")
+	require(slices.Contains([]string{LogFormatJSON, LogFormatText}, c.Log.Format), "log.format must be json or text")
+
+	require(c.Health.CheckTimeout > 0, "health.check_timeout must be positive")
+	require(c.Health.Interval > 0, "health.interval must be positive")
+
+	seen := map[string]bool{}
+	for _, p := range c.OIDC.Providers {
+		require(p.Name != "", "oidc.providers: every provider needs a name")
+		require(!seen[p.Name], "oidc.providers: %q is configured twice", p.Name)
+		seen[p.Name] = true
+		require(p.IssuerURL != "", "oidc provider %q: issuer_url is required", p.Name)
+		require(p.ClientID != "", "oidc provider %q: client_id is required", p.Name)
+		require(p.RedirectURL != "", "oidc provider %q: redirect_url is required", p.Name)
+	}
+
+	return problems
+}
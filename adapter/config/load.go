@@ -0,0 +1,254 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the environment variable pointing at an optional YAML or TOML config file
+const FileEnv = "ROSHAN_CONFIG"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Error lists every problem found while loading the configuration
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Load builds the configuration from the defaults, then the file named by ROSHAN_CONFIG,
+// then the environment, later sources overriding earlier ones. Callers are expected to
+// have loaded .env files into the environment beforehand.
+func Load() (*Config, error) {
+	cfg := Default()
+	var problems []string
+
+	if path := os.Getenv(FileEnv); path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			problems = append(problems, err.Error())
+		} else {
+			problems = append(problems, applyFile(reflect.ValueOf(cfg).Elem(), values, "")...)
+		}
+	}
+
+	problems = append(problems, applyEnv(reflect.ValueOf(cfg).Elem(), "")...)
+	problems = append(problems, cfg.applyOIDCEnv()...)
+
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		return nil, &Error{Problems: problems}
+	}
+	return cfg, nil
+}
+
+func readFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+
+	values := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// applyFile copies the values of a decoded config file onto v, keyed by the file tags
+func applyFile(v reflect.Value, values map[string]any, path string) []string {
+	var problems []string
+	known := map[string]bool{}
+
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		key := field.Tag.Get("file")
+		if key == "" {
+			continue
+		}
+		known[key] = true
+
+		raw, ok := values[key]
+		if !ok {
+			continue
+		}
+		name := path + key
+		fv := v.Field(i)
+
+		switch {
+		case fv.Kind() == reflect.Struct && fv.Type() != durationType:
+			section, ok := raw.(map[string]any)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: expected a section", name))
+				continue
+			}
+			problems = append(problems, applyFile(fv, section, name+".")...)
+
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.Struct:
+			list, ok := raw.([]any)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: expected a list", name))
+				continue
+			}
+			items := reflect.MakeSlice(fv.Type(), len(list), len(list))
+			for j, item := range list {
+				section, ok := item.(map[string]any)
+				if !ok {
+					problems = append(problems, fmt.Sprintf("%s[%d]: expected a section", name, j))
+					continue
+				}
+				problems = append(problems, applyFile(items.Index(j), section, fmt.Sprintf("%s[%d].", name, j))...)
+			}
+			fv.Set(items)
+
+		default:
+			if err := setValue(fv, raw); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+	}
+
+	for key := range values {
+		if !known[key] {
+			problems = append(problems, fmt.Sprintf("%s%s: unknown key", path, key))
+		}
+	}
+	slices.Sort(problems)
+	return problems
+}
+
+// applyEnv overrides fields of v with the environment variables named by their env tags.
+// Empty variables count as unset.
+func applyEnv(v reflect.Value, prefix string) []string {
+	var problems []string
+
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		fv := v.Field(i)
+
+		if fv.Kind() == reflect.Struct && fv.Type() != durationType {
+			problems = append(problems, applyEnv(fv, prefix)...)
+			continue
+		}
+
+		key := field.Tag.Get("env")
+		if key == "" {
+			continue
+		}
+		value := os.Getenv(prefix + key)
+		if value == "" {
+			continue
+		}
+		if err := setValue(fv, value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s%s: %v", prefix, key, err))
+		}
+	}
+	return problems
+}
+
+// applyOIDCEnv adds or overrides the providers listed in OIDC_PROVIDERS
+func (c *Config) applyOIDCEnv() []string {
+	var problems []string
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		i := slices.IndexFunc(c.OIDC.Providers, func(p OIDCProviderConfig) bool { return p.Name == name })
+		if i < 0 {
+			c.OIDC.Providers = append(c.OIDC.Providers, OIDCProviderConfig{Name: name})
+			i = len(c.OIDC.Providers) - 1
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		problems = append(problems, applyEnv(reflect.ValueOf(&c.OIDC.Providers[i]).Elem(), prefix)...)
+	}
+	return problems
+}
+
+// setValue parses raw into fv. Values never end up in errors, some of them are secrets.
+func setValue(fv reflect.Value, raw any) error {
+	if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.String {
+		var items []string
+		switch r := raw.(type) {
+		case []any:
+			for _, item := range r {
+				items = append(items, fmt.Sprint(item))
+			}
+		case string:
+			for _, item := range strings.Split(r, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+		default:
+			return errors.New("expected a list")
+		}
+		fv.Set(reflect.ValueOf(items))
+		return nil
+	}
+
+	s := fmt.Sprint(raw)
+	switch {
+	case fv.Type() == durationType:
+		d, err := parseDuration(s)
+		if err != nil {
+			return errors.New("invalid duration, use seconds or a value such as 30s or 5m")
+		}
+		fv.SetInt(int64(d))
+	case fv.Kind() == reflect.String:
+		fv.SetString(s)
+	case fv.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("invalid boolean")
+		}
+		fv.SetBool(b)
+	case fv.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return errors.New("invalid number")
+		}
+		fv.SetFloat(f)
+	case fv.Kind() == reflect.Int || fv.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return errors.New("invalid integer")
+		}
+		fv.SetInt(n)
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return nil
+}
+
+// parseDuration accepts plain numbers as seconds, which is how durations were always set in env files
+func parseDuration(s string) (time.Duration, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -4,33 +4,36 @@ import (
 	"fmt"
 
 	// gorm_postgres "gorm.io/driver/postgres"
-	"github.com/bozoteam/roshan/helpers"
+	"github.com/bozoteam/roshan/adapter/config"
+	"github.com/bozoteam/roshan/adapter/tracing"
 	gorm_postgres "gorm.io/driver/postgres"
 
 	"gorm.io/gorm"
 )
 
 // GetDBConnection returns a singleton instance of a database connection
-func GetDBConnection() *gorm.DB {
+func GetDBConnection(cfg config.DatabaseConfig) *gorm.DB {
 	var err error
 
-	user := helpers.GetEnv("DB_USER")
-	password := helpers.GetEnv("DB_PASSWORD")
-	host := helpers.GetEnv("DB_HOST")
-	port := helpers.GetEnv("DB_PORT")
-	dbName := helpers.GetEnv("DB_NAME")
-
-	dsn := fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-		host, user, password, dbName, port,
-	)
-
-	dbInstance, err := gorm.Open(gorm_postgres.Open(dsn), &gorm.Config{})
+	dbInstance, err := gorm.Open(gorm_postgres.Open(cfg.DSN()), &gorm.Config{
+		Logger: newQueryLogger(cfg),
+	})
 	if err != nil {
 		err = fmt.Errorf("error opening database connection: %v", err)
 		panic(err)
 	}
 
-	// return dbInstance
-	return dbInstance.Debug()
+	if err := dbInstance.Use(tracing.GormPlugin()); err != nil {
+		panic(fmt.Errorf("error installing tracing: %v", err))
+	}
+
+	sqlDB, err := dbInstance.DB()
+	if err != nil {
+		panic(fmt.Errorf("error configuring database pool: %v", err))
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	return dbInstance
 }
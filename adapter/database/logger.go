@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	"github.com/bozoteam/roshan/adapter/log"
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+)
+
+// queryLogger sends gorm's output through the structured logger, so queries carry the
+// request and trace ids of the context they ran with
+type queryLogger struct {
+	logger        *slog.Logger
+	debug         bool
+	slowThreshold time.Duration
+}
+
+func newQueryLogger(cfg config.DatabaseConfig) *queryLogger {
+	return &queryLogger{
+		logger:        log.LogWithModule("database"),
+		debug:         cfg.Debug,
+		slowThreshold: cfg.SlowQueryThreshold,
+	}
+}
+
+// LogMode is how gorm's Debug() switches logging on, which is already decided by the config
+func (l *queryLogger) LogMode(gormLogger.LogLevel) gormLogger.Interface {
+	return l
+}
+
+func (l *queryLogger) Info(ctx context.Context, msg string, args ...any) {
+	l.logger.InfoContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+func (l *queryLogger) Warn(ctx context.Context, msg string, args ...any) {
+	l.logger.WarnContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+func (l *queryLogger) Error(ctx context.Context, msg string, args ...any) {
+	l.logger.ErrorContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+func (l *queryLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+	failed := err != nil && !errors.Is(err, gorm.ErrRecordNotFound)
+	slow := elapsed >= l.slowThreshold
+	if !failed && !slow && !l.debug {
+		return
+	}
+
+	sql, rows := fc()
+	attrs := []any{"sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds()}
+	switch {
+	case failed:
+		l.logger.ErrorContext(ctx, "query failed", append(attrs, "error", err)...)
+	case slow:
+		l.logger.WarnContext(ctx, "slow query", attrs...)
+	default:
+		l.logger.InfoContext(ctx, "query", attrs...)
+	}
+}
+
+// ParamsFilter keeps bound values out of the logged SQL unless debugging, they include
+// password hashes and token digests
+func (l *queryLogger) ParamsFilter(ctx context.Context, sql string, params ...any) (string, []any) {
+	if l.debug {
+		return sql, params
+	}
+	return sql, nil
+}
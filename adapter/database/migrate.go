@@ -0,0 +1,390 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bozoteam/roshan/adapter/log"
+	"github.com/bozoteam/roshan/db"
+)
+
+const sumFile = "atlas.sum"
+
+// migrationLockId keys the advisory lock that keeps replicas from migrating at the same time
+const migrationLockId = 0x726f7368616e // "roshan"
+
+var (
+	ErrSchemaBehind    = errors.New("database schema is behind, run `migrate up` or enable database.auto_migrate")
+	ErrChecksum        = errors.New("migration directory doesn't match atlas.sum")
+	ErrNothingToRevert = errors.New("no migration to revert")
+)
+
+// Migration is one file of the migration directory
+type Migration struct {
+	Version     string
+	Description string
+	// Hash is the file's atlas.sum entry, it covers every migration before it too
+	Hash string
+	Up   string
+	Down string
+}
+
+func (m *Migration) Name() string {
+	return m.Version + "_" + m.Description
+}
+
+// MigrationStatus tells whether a migration ran against the database
+type MigrationStatus struct {
+	*Migration
+	AppliedAt *time.Time
+	// Modified is set when the file changed after being applied
+	Modified bool
+}
+
+// Migrator applies the migration directory in atlas.sum order and records each version in
+// the schema_migration table
+type Migrator struct {
+	db         *sql.DB
+	logger     *slog.Logger
+	migrations []*Migration
+}
+
+// NewMigrator reads the migrations embedded in the binary
+func NewMigrator(sqlDB *sql.DB) (*Migrator, error) {
+	dir, err := fs.Sub(db.Migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := readMigrations(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         sqlDB,
+		logger:     log.LogWithModule("migrator"),
+		migrations: migrations,
+	}, nil
+}
+
+// readMigrations loads the files listed in atlas.sum, checking them against their hashes
+// the same way atlas does so a hand edited migration is caught before it runs
+func readMigrations(dir fs.FS) ([]*Migration, error) {
+	sum, err := fs.ReadFile(dir, sumFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		total   string
+		entries [][2]string
+	)
+	scanner := bufio.NewScanner(strings.NewReader(string(sum)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if total == "" {
+			total = line
+			continue
+		}
+		name, hash, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("%w: malformed line %q", ErrChecksum, line)
+		}
+		entries = append(entries, [2]string{name, hash})
+	}
+
+	files, err := fs.Glob(dir, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	if len(files) != len(entries) {
+		return nil, fmt.Errorf("%w: %d files, %d entries", ErrChecksum, len(files), len(entries))
+	}
+
+	migrations := make([]*Migration, 0, len(entries))
+	fileHash, totalHash := sha256.New(), sha256.New()
+	for _, entry := range entries {
+		name, want := entry[0], entry[1]
+		up, err := fs.ReadFile(dir, name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrChecksum, err)
+		}
+
+		fileHash.Write([]byte(name))
+		fileHash.Write(up)
+		got := "h1:" + base64.StdEncoding.EncodeToString(fileHash.Sum(nil))
+		if got != want {
+			return nil, fmt.Errorf("%w: %s was modified", ErrChecksum, name)
+		}
+		totalHash.Write([]byte(name))
+		totalHash.Write([]byte(strings.TrimPrefix(got, "h1:")))
+
+		version, description, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("%w: %s isn't named <version>_<description>.sql", ErrChecksum, name)
+		}
+
+		down, err := fs.ReadFile(dir, path.Join("down", name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		migrations = append(migrations, &Migration{
+			Version:     version,
+			Description: description,
+			Hash:        want,
+			Up:          string(up),
+			Down:        string(down),
+		})
+	}
+
+	if got := "h1:" + base64.StdEncoding.EncodeToString(totalHash.Sum(nil)); got != total {
+		return nil, fmt.Errorf("%w: directory sum is %s, atlas.sum says %s", ErrChecksum, got, total)
+	}
+	return migrations, nil
+}
+
+// Status lists every migration, oldest first, with when it was applied. It holds the
+// migration lock too, since the first call creates schema_migration and copies atlas'
+// revisions over, which replicas starting together mustn't do at once.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var applied map[string]appliedRow
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		var err error
+		applied, err = m.applied(ctx, conn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Migration: migration}
+		if row, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &row.appliedAt
+			status.Modified = row.hash != "" && row.hash != migration.Hash
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations that haven't been applied yet
+func (m *Migrator) Pending(ctx context.Context) ([]*Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []*Migration{}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, status.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration, each in its own transaction
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	var done []*Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			start := time.Now()
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx,
+					`INSERT INTO "public"."schema_migration" ("version", "description", "hash") VALUES ($1, $2, $3)`,
+					migration.Version, migration.Description, migration.Hash,
+				)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("applying %s: %w", migration.Name(), err)
+			}
+			m.logger.InfoContext(ctx, "applied migration", "version", migration.Version, "description", migration.Description, "duration_ms", time.Since(start).Milliseconds())
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the last steps applied migrations, newest first
+func (m *Migrator) Down(ctx context.Context, steps int) ([]*Migration, error) {
+	var done []*Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range slices.Backward(m.migrations) {
+			if len(done) == steps {
+				break
+			}
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("%s has no down migration", migration.Name())
+			}
+
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, `DELETE FROM "public"."schema_migration" WHERE "version" = $1`, migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("reverting %s: %w", migration.Name(), err)
+			}
+			m.logger.InfoContext(ctx, "reverted migration", "version", migration.Version, "description", migration.Description)
+			done = append(done, migration)
+		}
+
+		if len(done) == 0 {
+			return ErrNothingToRevert
+		}
+		return nil
+	})
+	return done, err
+}
+
+// CheckSchema makes sure every migration was applied. With autoMigrate the pending ones
+// are applied, otherwise ErrSchemaBehind is returned and the server shouldn't start.
+func CheckSchema(ctx context.Context, migrator *Migrator, autoMigrate bool) error {
+	pending, err := migrator.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if !autoMigrate {
+		versions := make([]string, 0, len(pending))
+		for _, migration := range pending {
+			versions = append(versions, migration.Name())
+		}
+		return fmt.Errorf("%w, pending: %s", ErrSchemaBehind, strings.Join(versions, ", "))
+	}
+
+	_, err = migrator.Up(ctx)
+	return err
+}
+
+type appliedRow struct {
+	hash      string
+	appliedAt time.Time
+}
+
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// ensureTable creates schema_migration. Databases migrated with atlas have their versions
+// copied over from atlas' revision table, so the two can be used side by side.
+func (m *Migrator) ensureTable(ctx context.Context, q querier) error {
+	_, err := q.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS "public"."schema_migration" (
+  "version" character varying(32) NOT NULL,
+  "description" character varying(255) NOT NULL,
+  "hash" character varying(64) NOT NULL DEFAULT '',
+  "applied_at" timestamp NOT NULL DEFAULT now (),
+  PRIMARY KEY ("version")
+)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.ExecContext(ctx, `DO $$
+BEGIN
+  IF to_regclass('atlas_schema_revisions.atlas_schema_revisions') IS NOT NULL THEN
+    INSERT INTO "public"."schema_migration" ("version", "description", "applied_at")
+    SELECT "version", "description", "executed_at" FROM "atlas_schema_revisions"."atlas_schema_revisions"
+    WHERE "applied" = "total" AND "error" IS NULL AND "version" ~ '^[0-9]+$'
+    ON CONFLICT DO NOTHING;
+  END IF;
+END $$`)
+	return err
+}
+
+func (m *Migrator) applied(ctx context.Context, q querier) (map[string]appliedRow, error) {
+	rows, err := q.QueryContext(ctx, `SELECT "version", "hash", "applied_at" FROM "public"."schema_migration"`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[string]appliedRow{}
+	for rows.Next() {
+		var (
+			version string
+			row     appliedRow
+		)
+		if err := rows.Scan(&version, &row.hash, &row.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = row
+	}
+	return applied, rows.Err()
+}
+
+// locked runs fn on a connection holding the migration lock
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockId); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, migrationLockId)
+
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/bozoteam/roshan/db"
+)
+
+func embeddedDir(t *testing.T) fs.FS {
+	t.Helper()
+
+	dir, err := fs.Sub(db.Migrations, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestEmbeddedMigrationsMatchAtlasSum(t *testing.T) {
+	migrations, err := readMigrations(embeddedDir(t))
+	if err != nil {
+		t.Fatalf("readMigrations: %v", err)
+	}
+
+	for i, migration := range migrations {
+		if migration.Down == "" {
+			t.Errorf("%s has no down migration", migration.Name())
+		}
+		if i > 0 && migrations[i-1].Version >= migration.Version {
+			t.Errorf("%s comes after %s", migration.Name(), migrations[i-1].Name())
+		}
+	}
+}
+
+func TestReadMigrationsRejectsEditedFiles(t *testing.T) {
+	dir := fstest.MapFS{}
+	err := fs.WalkDir(embeddedDir(t), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(embeddedDir(t), name)
+		dir[name] = &fstest.MapFile{Data: data}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir["20250408202302_init.sql"].Data = append(dir["20250408202302_init.sql"].Data, "-- edited\n"...)
+	if _, err := readMigrations(dir); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("edited migration: got %v, want ErrChecksum", err)
+	}
+
+	delete(dir, "20250408202302_init.sql")
+	if _, err := readMigrations(dir); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("missing migration: got %v, want ErrChecksum", err)
+	}
+}
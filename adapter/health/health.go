@@ -0,0 +1,177 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	"github.com/bozoteam/roshan/adapter/log"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// Check probes one dependency and returns nil when it is usable
+type Check func(ctx context.Context) error
+
+// CheckResult is the outcome of a single check
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	// Failures are only described in broad terms, the details go to the logs
+	Error string `json:"error,omitempty"`
+}
+
+// Report is the JSON body of the readiness endpoint
+type Report struct {
+	Status       string                 `json:"status"`
+	ShuttingDown bool                   `json:"shutting_down,omitempty"`
+	Checks       map[string]CheckResult `json:"checks"`
+}
+
+// Registry holds the checks subsystems register and decides whether the server is ready
+type Registry struct {
+	logger  *slog.Logger
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	checks map[string]Check
+
+	shuttingDown atomic.Bool
+	grpc         *health.Server
+}
+
+func NewRegistry(cfg config.HealthConfig) *Registry {
+	return &Registry{
+		logger:  log.LogWithModule("health"),
+		timeout: cfg.CheckTimeout,
+		checks:  map[string]Check{},
+		grpc:    health.NewServer(),
+	}
+}
+
+// Register adds a check that has to pass for the server to be ready. Registering a name
+// twice replaces the previous check.
+func (r *Registry) Register(name string, check Check) {
+	r.mu.Lock()
+	r.checks[name] = check
+	r.mu.Unlock()
+}
+
+// SetShuttingDown makes readiness fail from now on, so load balancers stop sending traffic
+// while in-flight work drains
+func (r *Registry) SetShuttingDown() {
+	r.shuttingDown.Store(true)
+	r.grpc.Shutdown()
+}
+
+// Report runs every check concurrently, each bounded by the configured timeout
+func (r *Registry) Report(ctx context.Context) *Report {
+	r.mu.RLock()
+	checks := maps.Clone(r.checks)
+	r.mu.RUnlock()
+
+	report := &Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(checks))}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := r.run(ctx, name, check)
+
+			mu.Lock()
+			report.Checks[name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusUnavailable
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if r.shuttingDown.Load() {
+		report.Status = StatusUnavailable
+		report.ShuttingDown = true
+	}
+	return report
+}
+
+func (r *Registry) run(ctx context.Context, name string, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	result := CheckResult{
+		Status:    StatusOK,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusUnavailable
+		result.Error = "check failed"
+		if errors.Is(err, context.DeadlineExceeded) {
+			result.Error = "timed out"
+		}
+		r.logger.WarnContext(ctx, "health check failed", "check", name, "error", err)
+	}
+	return result
+}
+
+// LivenessHandler only tells the process is up and serving HTTP, it never probes dependencies
+// so a database outage doesn't get every instance restarted
+func (r *Registry) LivenessHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": StatusOK})
+}
+
+// ReadinessHandler answers 200 when every check passes and 503 otherwise
+func (r *Registry) ReadinessHandler(ctx *gin.Context) {
+	report := r.Report(ctx.Request.Context())
+	code := http.StatusOK
+	if report.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(code, report)
+}
+
+// GRPCServer is the standard grpc.health.v1.Health service, kept in sync by Watch
+func (r *Registry) GRPCServer() healthpb.HealthServer {
+	return r.grpc
+}
+
+// Watch runs the checks every interval and publishes the overall result through the gRPC
+// health service until ctx is done
+func (r *Registry) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		status := healthpb.HealthCheckResponse_SERVING
+		if r.Report(ctx).Status != StatusOK {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		// ignored once the server is shut down, so a late result can't flip it back
+		r.grpc.SetServingStatus("", status)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
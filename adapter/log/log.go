@@ -1,17 +1,74 @@
 package log
 
 import (
+	"context"
+	"io"
 	"log/slog"
 	"os"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var globalLogger *slog.Logger
 
 func init() {
-	globalLogger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	globalLogger = newLogger(os.Stdout, slog.LevelDebug, config.LogFormatJSON)
+}
+
+// Configure replaces the global logger. Loggers are bound when modules are built, so this
+// has to run before any constructor calls LogWithModule.
+func Configure(cfg config.LogConfig) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return err
+	}
+
+	globalLogger = newLogger(os.Stdout, level, cfg.Format)
+	// stray slog and stdlib log calls end up in the same stream
+	slog.SetDefault(globalLogger)
+	return nil
+}
+
+func newLogger(w io.Writer, level slog.Level, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{
 		AddSource: true,
-		Level:     slog.LevelDebug,
-	}))
+		Level:     level,
+	}
+
+	var handler slog.Handler
+	if format == config.LogFormatText {
+		handler = slog.NewTextHandler(w, opts)
+	} else {
+		handler = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(&contextHandler{handler})
+}
+
+// contextHandler adds the request id and the trace and span ids of the context to records logged with one
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestIdFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
 }
 
 func LogWithModule(module string) *slog.Logger {
@@ -0,0 +1,83 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/bozoteam/roshan/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIdHeader carries the request id in both directions
+const RequestIdHeader = "X-Request-Id"
+
+const maxRequestIdLength = 128
+
+type requestIdKey struct{}
+
+// WithRequestId returns a copy of ctx carrying the request id
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// RequestIdFromContext returns the request id stored in ctx, or an empty string
+func RequestIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// validRequestId accepts the ids proxies and clients usually send and nothing that could
+// forge extra log fields or headers
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// GinMiddleware reuses the request id sent by the caller or generates one, stores it in the
+// request context and echoes it back. It also writes one access log line per request, except
+// for the skipped paths. The context reaches gRPC handlers through the transcoder.
+func GinMiddleware(skip ...string) gin.HandlerFunc {
+	logger := LogWithModule("http")
+
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(RequestIdHeader)
+		if !validRequestId(id) {
+			id = helpers.GenUUID()
+		}
+		ctx.Request.Header.Set(RequestIdHeader, id)
+		ctx.Request = ctx.Request.WithContext(WithRequestId(ctx.Request.Context(), id))
+		ctx.Header(RequestIdHeader, id)
+
+		start := time.Now()
+		ctx.Next()
+
+		if slices.Contains(skip, ctx.Request.URL.Path) {
+			return
+		}
+
+		status := ctx.Writer.Status()
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		logger.Log(ctx.Request.Context(), level, "request handled",
+			"method", ctx.Request.Method,
+			"path", ctx.Request.URL.Path,
+			"status", status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client_ip", ctx.ClientIP(),
+		)
+	}
+}
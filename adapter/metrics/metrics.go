@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const namespace = "roshan"
+
+// registry holds every roshan metric. Collectors always record, the endpoint
+// serving them is only mounted when metrics are enabled.
+var registry = prometheus.NewRegistry()
+
+var (
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Duration of unary RPCs by method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	// WebSocketFrames counts frames queued for clients, or dropped because a client's buffer was full
+	WebSocketFrames = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_frames_total",
+		Help:      "WebSocket frames handed to clients by hub and result (sent or dropped).",
+	}, []string{"hub", "result"})
+
+	// PingTimeouts counts connections closed because the client didn't answer a PING in time
+	PingTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_ping_timeouts_total",
+		Help:      "WebSocket connections closed because a PING went unanswered.",
+	})
+
+	hubs = &hubCollector{
+		hubs: map[string]HubStats{},
+		connections: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "websocket", "connections"),
+			"Clients currently connected to rooms, by hub.",
+			[]string{"hub"}, nil,
+		),
+		rooms: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "rooms"),
+			"Rooms currently open, by hub.",
+			[]string{"hub"}, nil,
+		),
+	}
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if err := Register(registry); err != nil {
+		panic(err)
+	}
+}
+
+// Register adds roshan's own collectors to registerer, tests use it to read them from a
+// registry of their own
+func Register(registerer prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{rpcDuration, WebSocketFrames, PingTimeouts, hubs} {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
+
+// Mount serves the metrics on the router at the configured path, when they're enabled
+func Mount(router gin.IRoutes, cfg config.MetricsConfig) {
+	if cfg.Enabled {
+		router.GET(cfg.Path, gin.WrapH(Handler()))
+	}
+}
+
+// UnaryInterceptor records the latency and status code of every unary RPC
+func UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	rpcDuration.WithLabelValues(info.FullMethod, status.Code(err).String()).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// RegisterDB exposes the connection pool statistics of db
+func RegisterDB(db *sql.DB) {
+	registry.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+}
+
+// HubStats is implemented by the WebSocket hubs
+type HubStats interface {
+	Stats() (rooms int, connections int)
+}
+
+// TrackHub reports the rooms and connections of hub under the given name,
+// replacing any hub tracked under the same name before
+func TrackHub(name string, hub HubStats) {
+	hubs.mu.Lock()
+	hubs.hubs[name] = hub
+	hubs.mu.Unlock()
+}
+
+// hubCollector reads hub sizes at scrape time, so the numbers always match the hubs' own state
+type hubCollector struct {
+	mu          sync.Mutex
+	hubs        map[string]HubStats
+	connections *prometheus.Desc
+	rooms       *prometheus.Desc
+}
+
+func (c *hubCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connections
+	ch <- c.rooms
+}
+
+func (c *hubCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, hub := range c.hubs {
+		rooms, connections := hub.Stats()
+		ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(connections), name)
+		ch <- prometheus.MustNewConstMetric(c.rooms, prometheus.GaugeValue, float64(rooms), name)
+	}
+}
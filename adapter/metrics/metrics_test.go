@@ -0,0 +1,141 @@
+package metrics_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	"github.com/bozoteam/roshan/adapter/metrics"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	ws_hub "github.com/bozoteam/roshan/modules/websocket/hub"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newRegistry(t *testing.T) *prometheus.Registry {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	if err := metrics.Register(registry); err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+// find returns the metric of the family name carrying every label given, nil when there's none
+func find(t *testing.T, registry *prometheus.Registry, name string, labels map[string]string) *dto.Metric {
+	t.Helper()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			for _, pair := range metric.GetLabel() {
+				if want, ok := labels[pair.GetName()]; ok && want != pair.GetValue() {
+					continue metrics
+				}
+			}
+			return metric
+		}
+	}
+	return nil
+}
+
+func TestUnaryInterceptorLabels(t *testing.T) {
+	registry := newRegistry(t)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/metrics.Test/Lookup"}
+	metrics.UnaryInterceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return "found", nil
+	})
+	for range 2 {
+		metrics.UnaryInterceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+			return nil, status.Error(codes.NotFound, "missing")
+		})
+	}
+
+	for code, want := range map[string]uint64{"OK": 1, "NotFound": 2} {
+		metric := find(t, registry, "roshan_rpc_duration_seconds", map[string]string{"method": info.FullMethod, "code": code})
+		if metric == nil || metric.GetHistogram().GetSampleCount() != want {
+			t.Errorf("%s calls: got %v, want %d", code, metric, want)
+		}
+	}
+}
+
+type fakeClient struct {
+	user *userModel.User
+	send chan []byte
+}
+
+func (c *fakeClient) GetID() string                 { return c.user.Id }
+func (c *fakeClient) GetSender() chan []byte        { return c.send }
+func (c *fakeClient) GetUser() *userModel.User      { return c.user }
+func (c *fakeClient) WaitUnregister()               {}
+func (c *fakeClient) Close(code int, reason string) {}
+
+func TestHubFrameCounters(t *testing.T) {
+	registry := newRegistry(t)
+
+	hub := ws_hub.NewHub("metrics_test")
+	room := ws_hub.NewRoom("general", "creator", []string{"chat"}, "chat")
+	hub.CreateRoom(room)
+	// alice never reads, her buffer fills after one frame
+	alice := &fakeClient{user: &userModel.User{Id: "alice"}, send: make(chan []byte, 1)}
+	hub.Register(alice, room.GetID(), "chat")
+	// registering announces her, that frame is counted too
+	for len(alice.send) > 0 {
+		<-alice.send
+	}
+	frames := func(result string) float64 {
+		metric := find(t, registry, "roshan_websocket_frames_total", map[string]string{"hub": "metrics_test", "result": result})
+		return metric.GetCounter().GetValue()
+	}
+	sent, dropped := frames("sent"), frames("dropped")
+
+	hub.BroadcastBytes(context.Background(), room.GetID(), []byte("first"))
+	hub.BroadcastBytes(context.Background(), room.GetID(), []byte("second"))
+	hub.BroadcastBytes(context.Background(), room.GetID(), []byte("third"))
+
+	if got := frames("sent") - sent; got != 1 {
+		t.Errorf("%v frames sent, want 1", got)
+	}
+	if got := frames("dropped") - dropped; got != 2 {
+		t.Errorf("%v frames dropped, want 2", got)
+	}
+	for name, want := range map[string]float64{"roshan_websocket_connections": 1, "roshan_rooms": 1} {
+		metric := find(t, registry, name, map[string]string{"hub": "metrics_test"})
+		if metric == nil || metric.GetGauge().GetValue() != want {
+			t.Errorf("%s: got %v, want %v", name, metric, want)
+		}
+	}
+}
+
+func TestMountOnlyWhenEnabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, enabled := range []bool{false, true} {
+		router := gin.New()
+		metrics.Mount(router, config.MetricsConfig{Enabled: enabled, Path: "/metrics"})
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		switch {
+		case !enabled && recorder.Code != http.StatusNotFound:
+			t.Errorf("disabled metrics answered %d", recorder.Code)
+		case enabled && (recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "roshan_websocket_frames_total")):
+			t.Errorf("enabled metrics answered %d", recorder.Code)
+		}
+	}
+}
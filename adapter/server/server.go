@@ -0,0 +1,373 @@
+package server
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"connectrpc.com/vanguard"
+	"connectrpc.com/vanguard/vanguardgrpc"
+	"github.com/bozoteam/roshan/adapter/blobstore"
+	"github.com/bozoteam/roshan/adapter/config"
+	authGen "github.com/bozoteam/roshan/adapter/grpc/gen/auth"
+	chatGen "github.com/bozoteam/roshan/adapter/grpc/gen/chat"
+	gameGen "github.com/bozoteam/roshan/adapter/grpc/gen/game"
+	userGen "github.com/bozoteam/roshan/adapter/grpc/gen/user"
+	"github.com/bozoteam/roshan/adapter/health"
+	"github.com/bozoteam/roshan/adapter/log"
+	"github.com/bozoteam/roshan/adapter/metrics"
+	auth_service "github.com/bozoteam/roshan/adapter/service/auth"
+	chat_service "github.com/bozoteam/roshan/adapter/service/chat"
+	game_service "github.com/bozoteam/roshan/adapter/service/game"
+	user_service "github.com/bozoteam/roshan/adapter/service/user"
+	"github.com/bozoteam/roshan/adapter/tracing"
+	"github.com/bozoteam/roshan/helpers"
+	adminUsecase "github.com/bozoteam/roshan/modules/admin/usecase"
+	"github.com/bozoteam/roshan/modules/auth/middlewares"
+	apiKeyRepository "github.com/bozoteam/roshan/modules/auth/repository/apikey"
+	jwtRepository "github.com/bozoteam/roshan/modules/auth/repository/jwt"
+	lockoutRepository "github.com/bozoteam/roshan/modules/auth/repository/lockout"
+	ticketRepository "github.com/bozoteam/roshan/modules/auth/repository/ticket"
+	authUsecase "github.com/bozoteam/roshan/modules/auth/usecase"
+	messageRepository "github.com/bozoteam/roshan/modules/chat/repository/message"
+	chatUsecase "github.com/bozoteam/roshan/modules/chat/usecase"
+	gameUsecase "github.com/bozoteam/roshan/modules/game/usecase"
+	identityRepository "github.com/bozoteam/roshan/modules/oidc/repository/identity"
+	providerRepository "github.com/bozoteam/roshan/modules/oidc/repository/provider"
+	oidcUsecase "github.com/bozoteam/roshan/modules/oidc/usecase"
+	userRepository "github.com/bozoteam/roshan/modules/user/repository"
+	userUsecase "github.com/bozoteam/roshan/modules/user/usecase"
+	"github.com/bozoteam/roshan/modules/websocket/ws_upgrader"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"gorm.io/gorm"
+)
+
+// healthPrefixes are where the health routes are mounted, each proxy in front of roshan
+// forwards a different one
+var healthPrefixes = []string{"", "/roshan", "/api/v1"}
+
+// attachmentKeyLabel derives the attachment signing key from jwt.secret when none is set
+const attachmentKeyLabel = "roshan chat attachment urls"
+
+// Repositories are the storage backends the server runs on
+type Repositories struct {
+	Users      userRepository.UserRepository
+	Lockouts   lockoutRepository.LockoutRepository
+	ApiKeys    apiKeyRepository.ApiKeyRepository
+	Identities identityRepository.IdentityRepository
+	Messages   messageRepository.MessageRepository
+}
+
+// GormRepositories returns the Postgres backed repositories
+func GormRepositories(db *gorm.DB) Repositories {
+	return Repositories{
+		Users:      userRepository.NewUserRepository(db),
+		Lockouts:   lockoutRepository.NewLockoutRepository(db),
+		ApiKeys:    apiKeyRepository.NewApiKeyRepository(db),
+		Identities: identityRepository.NewIdentityRepository(db),
+		Messages:   messageRepository.NewMessageRepository(db),
+	}
+}
+
+// MemoryRepositories returns empty in-memory repositories, nothing survives the process
+func MemoryRepositories() Repositories {
+	return Repositories{
+		Users:      userRepository.NewMemoryUserRepository(),
+		Lockouts:   lockoutRepository.NewMemoryLockoutRepository(),
+		ApiKeys:    apiKeyRepository.NewMemoryApiKeyRepository(),
+		Identities: identityRepository.NewMemoryIdentityRepository(),
+		Messages:   messageRepository.NewMemoryMessageRepository(),
+	}
+}
+
+// Server is the API: gRPC services behind the vanguard transcoder, and the gin routes for
+// health, OIDC and WebSockets, all served from one listener
+type Server struct {
+	cfg    *config.Config
+	logger *slog.Logger
+
+	http     *http.Server
+	health   *health.Registry
+	upgrader *ws_upgrader.Upgrader
+	chat     *chatUsecase.ChatUsecase
+	game     *gameUsecase.GameUsecase
+}
+
+// New wires every module on top of the given repositories. Nothing listens until Serve.
+func New(cfg *config.Config, repos Repositories) (*Server, error) {
+	logger := log.LogWithModule("server")
+
+	blacklistedPaths, err := protoOptionToShouldPermission(authGen.File_auth_auth_proto, userGen.File_user_user_proto)
+	if err != nil {
+		return nil, err
+	}
+	// probes must work without credentials, Watch is a stream and never hits the unary interceptor
+	blacklistedPaths[healthpb.Health_Check_FullMethodName] = struct{}{}
+	blacklistedPaths[healthpb.Health_List_FullMethodName] = struct{}{}
+	logger.Debug("methods callable without authentication", "methods", slices.Sorted(maps.Keys(blacklistedPaths)))
+
+	allowedOrigins := cfg.Server.Origins()
+
+	trustedProxies, err := helpers.ParseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	jwtRepository := jwtRepository.NewJWTRepository(cfg.JWT)
+	ticketRepository := ticketRepository.NewTicketRepository(cfg.Auth.TicketTTL)
+	authMiddleware := middlewares.NewAuthMiddleware(jwtRepository, repos.Users, repos.ApiKeys, ticketRepository, blacklistedPaths)
+	wsUpgrader := ws_upgrader.NewUpgrader(cfg.WebSocket, allowedOrigins)
+	blobStore, err := blobstore.New(cfg.Chat.Attachments)
+	if err != nil {
+		return nil, err
+	}
+	chatConfig := cfg.Chat
+	if chatConfig.Attachments.SigningKey == "" {
+		// a key of its own, so download links and access tokens never share one
+		key, err := hkdf.Key(sha256.New, []byte(cfg.JWT.Secret), nil, attachmentKeyLabel, sha256.Size)
+		if err != nil {
+			return nil, err
+		}
+		chatConfig.Attachments.SigningKey = string(key)
+	}
+	chatUsecase := chatUsecase.NewChatUsecase(repos.Users, repos.Messages, jwtRepository, wsUpgrader, blobStore, chatConfig)
+	userUsecase := userUsecase.NewUserUsecase(repos.Users)
+	gameUsecase := gameUsecase.NewGameUsecase(wsUpgrader)
+	// tickets are only issued for rooms that exist, so the hubs come first
+	authUsecase := authUsecase.NewAuthUsecase(repos.Users, jwtRepository, repos.Lockouts, repos.ApiKeys, ticketRepository, cfg.Auth.Lockout, trustedProxies,
+		[]authUsecase.RoomFinder{chatUsecase, gameUsecase})
+	providerRepository := providerRepository.NewProviderRepository(cfg.OIDC.Providers)
+	oidcUsecase := oidcUsecase.NewOIDCUsecase(authUsecase, repos.Users, repos.Identities, providerRepository, cfg.OIDC.FrontendURL)
+	adminUsecase := adminUsecase.NewAdminUsecase(chatUsecase, gameUsecase)
+
+	healthRegistry := health.NewRegistry(cfg.Health)
+	healthRegistry.Register("chat_hub", chatUsecase.HealthCheck)
+	healthRegistry.Register("game_hub", gameUsecase.HealthCheck)
+
+	authInterceptor := authMiddleware.UnaryInterceptor
+	httpMiddleware := authMiddleware.AuthMiddleware
+
+	encoding.RegisterCodec(vanguardgrpc.NewCodec(&vanguard.JSONCodec{
+		MarshalOptions:   protojson.MarshalOptions{EmitUnpopulated: true, UseProtoNames: true},
+		UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+	}))
+
+	server := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		// metrics come first so rejected calls are counted too
+		grpc.ChainUnaryInterceptor(metrics.UnaryInterceptor, authInterceptor),
+	)
+	// Create the Connect service implementation
+	authService := auth_service.NewAuthService(authUsecase, oidcUsecase)
+	userService := user_service.NewUserService(userUsecase)
+	chatService := chat_service.NewChatService(chatUsecase)
+	gameService := game_service.NewGameService(gameUsecase)
+
+	authGen.RegisterAuthServiceServer(server, authService)
+	userGen.RegisterUserServiceServer(server, userService)
+	chatGen.RegisterChatServiceServer(server, chatService)
+	gameGen.RegisterGameServiceServer(server, gameService)
+	healthpb.RegisterHealthServer(server, healthRegistry.GRPCServer())
+
+	handler, err := vanguardgrpc.NewTranscoder(server)
+	if err != nil {
+		return nil, err
+	}
+
+	if !helpers.IsDevelopment {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	ginRouter := gin.New()
+	// the access log's client_ip follows the same rule as login throttling
+	if err := ginRouter.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		return nil, err
+	}
+
+	probePaths := []string{cfg.Metrics.Path}
+	for _, prefix := range healthPrefixes {
+		probePaths = append(probePaths, prefix+"/health", prefix+"/health/live", prefix+"/health/ready")
+	}
+	// tracing first so the request id middleware's log line carries the trace id
+	ginRouter.Use(tracing.GinMiddleware(probePaths...))
+	ginRouter.Use(log.GinMiddleware(probePaths...))
+	// inside the logging middleware so panics show up as 500s in the access log
+	ginRouter.Use(gin.Recovery())
+
+	// add cors middleware
+	ginRouter.Use(cors.New(cors.Config{
+		AllowOrigins:     allowedOrigins,
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", log.RequestIdHeader},
+		ExposeHeaders:    []string{log.RequestIdHeader},
+		AllowCredentials: true,
+	}))
+
+	ginRouter.GET("/roshan/version", func(ctx *gin.Context) {
+		a, err := strconv.Atoi(helpers.BuildTime)
+		if err != nil {
+			panic(err)
+		}
+		ctx.JSON(200, gin.H{
+			"unix": helpers.BuildTime,
+			"date": time.Unix(int64(a), 0),
+		})
+	})
+
+	metrics.Mount(ginRouter, cfg.Metrics)
+
+	// health --------------------------------------------------
+	for _, prefix := range healthPrefixes {
+		// the plain route predates the probes and keeps answering OK for whoever still polls it
+		ginRouter.GET(prefix+"/health", func(ctx *gin.Context) {
+			ctx.String(http.StatusOK, "OK")
+		})
+		ginRouter.GET(prefix+"/health/live", healthRegistry.LivenessHandler)
+		ginRouter.GET(prefix+"/health/ready", healthRegistry.ReadinessHandler)
+	}
+	// --------------------------------------------------------
+
+	oidcEnd := ginRouter.Group("/api/v1/auth/oidc")
+	oidcEnd.GET("/:provider/login", oidcUsecase.Login)
+	oidcEnd.GET("/:provider/callback", oidcUsecase.Callback)
+	oidcEnd.GET("/:provider/link", httpMiddleware(), oidcUsecase.Link)
+
+	wsEnd := ginRouter.Group("/api/v1", httpMiddleware())
+	wsEnd.GET("/chat/rooms/:id/ws", func(ctx *gin.Context) {
+		roomID := ctx.Param("id")
+		chatUsecase.JoinRoom(ctx, roomID)
+	})
+	wsEnd.GET("/game/rooms/:id/ws", func(ctx *gin.Context) {
+		roomID := ctx.Param("id")
+		gameUsecase.JoinRoom(ctx, roomID, "TEAM_1")
+	})
+
+	// downloads carry their own signature, they're linked from places that can't send a token
+	ginRouter.POST("/api/v1/chat/attachments", httpMiddleware(), chatUsecase.UploadAttachment)
+	ginRouter.GET("/api/v1/chat/attachments/:id/:variant", chatUsecase.DownloadAttachment)
+
+	adminEnd := ginRouter.Group("/api/v1/admin", httpMiddleware(), authMiddleware.RequireAdmin())
+	adminEnd.GET("/rooms", adminUsecase.ListRooms)
+
+	ginRouter.NoRoute(func(ctx *gin.Context) {
+		// gin primes NoRoute responses with a 404 and native gRPC never calls WriteHeader,
+		// it only flushes, which would send that 404
+		ctx.Status(http.StatusOK)
+		handler.ServeHTTP(ctx.Writer, ctx.Request)
+	})
+
+	httpServer := &http.Server{Handler: ginRouter}
+	// gRPC clients and load balancers speak HTTP/2 without TLS
+	httpServer.Protocols = new(http.Protocols)
+	httpServer.Protocols.SetHTTP1(true)
+	httpServer.Protocols.SetUnencryptedHTTP2(true)
+
+	return &Server{
+		cfg:      cfg,
+		logger:   logger,
+		http:     httpServer,
+		health:   healthRegistry,
+		upgrader: wsUpgrader,
+		chat:     chatUsecase,
+		game:     gameUsecase,
+	}, nil
+}
+
+// Health is where dependencies owned by the caller, like the database, register their checks
+func (s *Server) Health() *health.Registry {
+	return s.health
+}
+
+// Handler serves every route of the server
+func (s *Server) Handler() http.Handler {
+	return s.http.Handler
+}
+
+// Serve accepts connections on listener until Shutdown is called, it then returns nil
+func (s *Server) Serve(listener net.Listener) error {
+	err := s.http.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown drains the server. WebSockets are hijacked connections that http.Server.Shutdown
+// doesn't track, so the hubs are told to close them explicitly.
+func (s *Server) Shutdown(ctx context.Context) {
+	s.health.SetShuttingDown()
+	s.upgrader.Shutdown()
+	s.chat.Shutdown(s.cfg.WebSocket.ReconnectDelay)
+	s.game.Shutdown(s.cfg.WebSocket.ReconnectDelay)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := s.http.Shutdown(ctx); err != nil {
+			s.logger.Warn("in-flight requests did not finish in time", "error", err)
+			s.http.Close()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := s.upgrader.Wait(ctx); err != nil {
+			s.logger.Warn("websockets did not close in time", "error", err)
+		}
+	}()
+	wg.Wait()
+}
+
+func protoOptionToShouldPermission(fd ...protoreflect.FileDescriptor) (map[string]struct{}, error) {
+	var (
+		optionName = "required"
+	)
+
+	blacklistedPaths := map[string]struct{}{}
+
+	for _, f := range fd {
+		services := f.Services()
+		for y := range services.Len() {
+			for x := range services.Get(y).Methods().Len() {
+				method := services.Get(y).Methods().Get(x)
+				methodName := string(method.FullName())
+				methodNameIdx := strings.LastIndex(string(method.FullName()), ".")
+				methodName = "/" + methodName[:methodNameIdx] + "/" + methodName[methodNameIdx+1:]
+
+				opts, ok := method.Options().(*descriptorpb.MethodOptions)
+				if ok {
+					proto.RangeExtensions(opts, func(et protoreflect.ExtensionType, i any) bool {
+						if (et.TypeDescriptor().Name()) == protoreflect.Name(optionName) {
+							value := reflect.ValueOf(i)
+							if value.Kind() == reflect.Bool && !value.Bool() {
+								blacklistedPaths[methodName] = struct{}{}
+							}
+						}
+						return true
+					})
+				}
+			}
+		}
+	}
+
+	return blacklistedPaths, nil
+}
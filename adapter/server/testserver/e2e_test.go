@@ -0,0 +1,169 @@
+package testserver
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	chatGen "github.com/bozoteam/roshan/adapter/grpc/gen/chat"
+	gameGen "github.com/bozoteam/roshan/adapter/grpc/gen/game"
+	chatModel "github.com/bozoteam/roshan/modules/chat/models"
+	ws_hub "github.com/bozoteam/roshan/modules/websocket/hub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// hasUsers matches the user list frame once it lists exactly these users in team
+func hasUsers(roomId string, team string, userIds ...string) func(*ws_hub.RoomUserList) bool {
+	return func(frame *ws_hub.RoomUserList) bool {
+		users := frame.Teams[team]
+		if frame.RoomID != roomId || len(users) != len(userIds) {
+			return false
+		}
+		for i, user := range users {
+			if user.Id != userIds[i] {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func TestChatFlow(t *testing.T) {
+	s := New(t)
+	alice := s.CreateUser("alice", "alice@example.com", "correct horse")
+	bob := s.CreateUser("bob", "bob@example.com", "battery staple")
+	aliceToken := s.Login("alice@example.com", "correct horse")
+	bobToken := s.Login("bob@example.com", "battery staple")
+	ctx := context.Background()
+
+	if _, err := s.Chat.CreateRoom(ctx, &chatGen.CreateRoomRequest{Name: "general"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("CreateRoom without a token: got %v, want Unauthenticated", err)
+	}
+
+	created, err := s.Chat.CreateRoom(Authorized(ctx, aliceToken), &chatGen.CreateRoomRequest{Name: "general"})
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	roomId := created.Room.Id
+
+	listed, err := s.Chat.ListRooms(Authorized(ctx, bobToken), &chatGen.ListRoomsRequest{})
+	if err != nil || len(listed.Rooms) != 1 || listed.Rooms[0].Id != roomId {
+		t.Fatalf("ListRooms: %+v, %v", listed, err)
+	}
+
+	aliceSocket := s.DialRoom("chat", roomId, aliceToken)
+	ExpectFrame(aliceSocket, hasUsers(roomId, "chat", alice.Id))
+
+	bobSocket := s.DialRoom("chat", roomId, bobToken)
+	ExpectFrame(aliceSocket, hasUsers(roomId, "chat", alice.Id, bob.Id))
+	ExpectFrame(bobSocket, hasUsers(roomId, "chat", alice.Id, bob.Id))
+
+	if _, err := s.Chat.SendMessage(Authorized(ctx, bobToken), &chatGen.SendMessageRequest{RoomId: roomId, Content: "hi alice"}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	message := ExpectFrame(aliceSocket, func(frame *chatModel.Message) bool {
+		return frame.Content != ""
+	})
+	if message.Content != "hi alice" || message.User == nil || message.User.Id != bob.Id || message.RoomID != roomId {
+		t.Fatalf("unexpected message %+v", message)
+	}
+
+	bobSocket.Close()
+	ExpectFrame(aliceSocket, hasUsers(roomId, "chat", alice.Id))
+
+	// bob left, so he can't talk in the room anymore
+	_, err = s.Chat.SendMessage(Authorized(ctx, bobToken), &chatGen.SendMessageRequest{RoomId: roomId, Content: "still here?"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("SendMessage after leaving: got %v, want PermissionDenied", err)
+	}
+}
+
+func TestTypingAndPresence(t *testing.T) {
+	s := New(t, WithConfig(func(cfg *config.Config) {
+		cfg.Chat.Presence.FlushInterval = 20 * time.Millisecond
+	}))
+	alice := s.CreateUser("alice", "alice@example.com", "correct horse")
+	bob := s.CreateUser("bob", "bob@example.com", "battery staple")
+	aliceToken := s.Login("alice@example.com", "correct horse")
+	bobToken := s.Login("bob@example.com", "battery staple")
+	ctx := context.Background()
+
+	created, err := s.Chat.CreateRoom(Authorized(ctx, aliceToken), &chatGen.CreateRoomRequest{Name: "general"})
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	roomId := created.Room.Id
+
+	aliceSocket := s.DialRoom("chat", roomId, aliceToken)
+	bobSocket := s.DialRoom("chat", roomId, bobToken)
+	ExpectFrame(aliceSocket, hasUsers(roomId, "chat", alice.Id, bob.Id))
+
+	bobSocket.Send(chatModel.ClientEvent{Type: chatModel.EventTypingStarted})
+	ExpectFrame(aliceSocket, func(frame *chatModel.RoomPresence) bool {
+		return frame.Type == "presence" && slices.Equal(frame.Typing, []string{bob.Id})
+	})
+
+	// sending the message ends the typing
+	if _, err := s.Chat.SendMessage(Authorized(ctx, bobToken), &chatGen.SendMessageRequest{RoomId: roomId, Content: "hi"}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	ExpectFrame(aliceSocket, func(frame *chatModel.RoomPresence) bool {
+		return frame.Type == "presence" && len(frame.Typing) == 0
+	})
+
+	presence, err := s.Chat.GetPresence(Authorized(ctx, aliceToken), &chatGen.GetPresenceRequest{UserIds: []string{bob.Id, "nobody"}})
+	if err != nil {
+		t.Fatalf("GetPresence: %v", err)
+	}
+	if len(presence.Presences) != 2 ||
+		presence.Presences[0].State != chatGen.PresenceState_PRESENCE_STATE_ONLINE ||
+		presence.Presences[1].State != chatGen.PresenceState_PRESENCE_STATE_OFFLINE {
+		t.Fatalf("unexpected presences %+v", presence.Presences)
+	}
+
+	bobSocket.Close()
+	ExpectFrame(aliceSocket, func(frame *chatModel.RoomPresence) bool {
+		return len(frame.Users) == 1 && frame.Users[0].UserID == bob.Id && frame.Users[0].State == chatModel.PresenceOffline
+	})
+}
+
+func TestGameFlow(t *testing.T) {
+	s := New(t)
+	alice := s.CreateUser("alice", "alice@example.com", "correct horse")
+	aliceToken := s.Login("alice@example.com", "correct horse")
+	ctx := Authorized(context.Background(), aliceToken)
+
+	created, err := s.Game.CreateRoom(ctx, &gameGen.CreateGameRoomRequest{Name: "tictactoe"})
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	roomId := created.Room.Id
+
+	socket := s.DialRoom("game", roomId, aliceToken)
+	ExpectFrame(socket, hasUsers(roomId, "TEAM_1", alice.Id))
+
+	listed, err := s.Game.ListRooms(ctx, &gameGen.ListGameRoomsRequest{})
+	if err != nil || len(listed.Rooms) != 1 || listed.Rooms[0].Id != roomId {
+		t.Fatalf("ListRooms: %+v, %v", listed, err)
+	}
+
+	// the last player leaving closes the room
+	socket.Close()
+	deadline := time.Now().Add(FrameTimeout)
+	for {
+		listed, err := s.Game.ListRooms(ctx, &gameGen.ListGameRoomsRequest{})
+		if err != nil {
+			t.Fatalf("ListRooms: %v", err)
+		}
+		if len(listed.Rooms) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("game room outlived its last player")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
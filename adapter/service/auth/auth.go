@@ -2,13 +2,15 @@ package auth_service
 
 import (
 	"context"
-	"fmt"
 	"log/slog"
 	"net/http"
 
 	gen "github.com/bozoteam/roshan/adapter/grpc/gen/auth"
 	"github.com/bozoteam/roshan/adapter/log"
+	authModel "github.com/bozoteam/roshan/modules/auth/models"
 	"github.com/bozoteam/roshan/modules/auth/usecase"
+	oidcModel "github.com/bozoteam/roshan/modules/oidc/models"
+	oidcUsecase "github.com/bozoteam/roshan/modules/oidc/usecase"
 	"github.com/bozoteam/roshan/roshan_errors"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
@@ -17,12 +19,14 @@ import (
 type AuthService struct {
 	logger      *slog.Logger
 	authUsecase *usecase.AuthUsecase
+	oidcUsecase *oidcUsecase.OIDCUsecase
 	gen.UnimplementedAuthServiceServer
 }
 
-func NewAuthService(authUsecase *usecase.AuthUsecase) *AuthService {
+func NewAuthService(authUsecase *usecase.AuthUsecase, oidcUsecase *oidcUsecase.OIDCUsecase) *AuthService {
 	return &AuthService{
 		authUsecase: authUsecase,
+		oidcUsecase: oidcUsecase,
 		logger:      log.LogWithModule("auth_service"),
 	}
 }
@@ -38,30 +42,20 @@ func genAuthResponseFromToken(token *usecase.TokenResponse) *gen.AuthenticateRes
 }
 
 func (s *AuthService) setAuthCookie(ctx context.Context, respToken *usecase.TokenResponse) {
-	md := metadata.Pairs(
-		"Set-Cookie", fmt.Sprintf("access_token=%s; HttpOnly; SameSite=Strict; Path=/api; Max-Age=%d",
-			respToken.AccessToken,
-			respToken.ExpiresIn,
-		),
-		"Set-Cookie", fmt.Sprintf("refresh_token=%s; HttpOnly; SameSite=Strict; Path=/api/v1/auth/refresh; Max-Age=%d",
-			respToken.RefreshToken,
-			respToken.RefreshExpiresIn,
-		),
-		"Cache-Control", "no-store",
-	)
+	md := metadata.MD{}
+	md.Append("Set-Cookie", usecase.AuthCookies(respToken)...)
+	md.Append("Cache-Control", "no-store")
 	if err := grpc.SetHeader(ctx, md); err != nil {
-		s.logger.Error("Failed to set cookie header", "error", err)
+		s.logger.ErrorContext(ctx, "Failed to set cookie header", "error", err)
 	}
 }
 
 func (s *AuthService) deleteAuthCookie(ctx context.Context) {
-	md := metadata.Pairs(
-		"Set-Cookie", fmt.Sprintf("access_token=deleted; HttpOnly; SameSite=Strict; Path=/api; Max-Age=0"),
-		"Set-Cookie", fmt.Sprintf("refresh_token=deleted; HttpOnly; SameSite=Strict; Path=/api/v1/auth/refresh; Max-Age=0"),
-		"Cache-Control", "no-store",
-	)
+	md := metadata.MD{}
+	md.Append("Set-Cookie", usecase.ClearedAuthCookies()...)
+	md.Append("Cache-Control", "no-store")
 	if err := grpc.SetHeader(ctx, md); err != nil {
-		s.logger.Error("Failed to set cookie header", "error", err)
+		s.logger.ErrorContext(ctx, "Failed to set cookie header", "error", err)
 	}
 }
 
@@ -130,3 +124,134 @@ func (s *AuthService) Logout(ctx context.Context, req *gen.LogoutRequest) (*gen.
 
 	return &gen.LogoutResponse{}, nil
 }
+
+func (s *AuthService) UnlockAccount(ctx context.Context, req *gen.UnlockAccountRequest) (*gen.UnlockAccountResponse, error) {
+	err := s.authUsecase.UnlockAccount(ctx, req.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gen.UnlockAccountResponse{}, nil
+}
+
+func genApiKey(key *authModel.ApiKey) *gen.ApiKey {
+	out := &gen.ApiKey{
+		Id:        key.Id,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		Scopes:    key.ScopeList(),
+		CreatedAt: key.CreatedAt.Unix(),
+	}
+	if key.ExpiresAt != nil {
+		expiresAt := key.ExpiresAt.Unix()
+		out.ExpiresAt = &expiresAt
+	}
+	if key.LastUsedAt != nil {
+		lastUsedAt := key.LastUsedAt.Unix()
+		out.LastUsedAt = &lastUsedAt
+	}
+	if key.RevokedAt != nil {
+		revokedAt := key.RevokedAt.Unix()
+		out.RevokedAt = &revokedAt
+	}
+	return out
+}
+
+func (s *AuthService) CreateApiKey(ctx context.Context, req *gen.CreateApiKeyRequest) (*gen.CreateApiKeyResponse, error) {
+	key, err := s.authUsecase.CreateApiKey(ctx, &usecase.ApiKeyCreateInput{
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresIn: req.ExpiresIn,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := grpc.SetHeader(ctx, metadata.Pairs("Cache-Control", "no-store")); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to set cache header", "error", err)
+	}
+
+	return &gen.CreateApiKeyResponse{
+		ApiKey: genApiKey(key.ApiKey),
+		Key:    key.Key,
+	}, nil
+}
+
+func (s *AuthService) ListApiKeys(ctx context.Context, req *gen.ListApiKeysRequest) (*gen.ListApiKeysResponse, error) {
+	keys, err := s.authUsecase.ListApiKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	outKeys := make([]*gen.ApiKey, 0, len(keys))
+	for _, key := range keys {
+		outKeys = append(outKeys, genApiKey(key))
+	}
+
+	return &gen.ListApiKeysResponse{
+		ApiKeys: outKeys,
+	}, nil
+}
+
+func (s *AuthService) RevokeApiKey(ctx context.Context, req *gen.RevokeApiKeyRequest) (*gen.RevokeApiKeyResponse, error) {
+	key, err := s.authUsecase.RevokeApiKey(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gen.RevokeApiKeyResponse{
+		ApiKey: genApiKey(key),
+	}, nil
+}
+
+func genIdentity(identity *oidcModel.UserIdentity) *gen.Identity {
+	return &gen.Identity{
+		Id:        identity.Id,
+		Provider:  identity.Provider,
+		Email:     identity.Email,
+		CreatedAt: identity.CreatedAt.Unix(),
+	}
+}
+
+func (s *AuthService) ListIdentities(ctx context.Context, req *gen.ListIdentitiesRequest) (*gen.ListIdentitiesResponse, error) {
+	identities, err := s.oidcUsecase.ListIdentities(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	outIdentities := make([]*gen.Identity, 0, len(identities))
+	for _, identity := range identities {
+		outIdentities = append(outIdentities, genIdentity(identity))
+	}
+
+	return &gen.ListIdentitiesResponse{
+		Identities: outIdentities,
+	}, nil
+}
+
+func (s *AuthService) UnlinkIdentity(ctx context.Context, req *gen.UnlinkIdentityRequest) (*gen.UnlinkIdentityResponse, error) {
+	identity, err := s.oidcUsecase.UnlinkIdentity(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gen.UnlinkIdentityResponse{
+		Identity: genIdentity(identity),
+	}, nil
+}
+
+func (s *AuthService) IssueSocketTicket(ctx context.Context, req *gen.IssueSocketTicketRequest) (*gen.IssueSocketTicketResponse, error) {
+	ticket, err := s.authUsecase.IssueSocketTicket(ctx, req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := grpc.SetHeader(ctx, metadata.Pairs("Cache-Control", "no-store")); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to set cache header", "error", err)
+	}
+
+	return &gen.IssueSocketTicketResponse{
+		Ticket:    ticket.Ticket,
+		ExpiresIn: ticket.ExpiresIn,
+	}, nil
+}
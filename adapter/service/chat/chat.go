@@ -5,6 +5,7 @@ import (
 
 	gen "github.com/bozoteam/roshan/adapter/grpc/gen/chat"
 	commonGen "github.com/bozoteam/roshan/adapter/grpc/gen/common"
+	chatModel "github.com/bozoteam/roshan/modules/chat/models"
 	"github.com/bozoteam/roshan/modules/chat/usecase"
 )
 
@@ -20,12 +21,14 @@ func NewChatService(chatUsecase *usecase.ChatUsecase) *ChatService {
 }
 
 func (s *ChatService) SendMessage(ctx context.Context, req *gen.SendMessageRequest) (*gen.SendMessageResponse, error) {
-	err := s.chatUsecase.SendMessage(ctx, req.Content, req.RoomId)
+	message, err := s.chatUsecase.SendMessage(ctx, req.Content, req.RoomId, req.ParentId, req.AttachmentIds)
 	if err != nil {
 		return nil, err
 	}
 
-	return &gen.SendMessageResponse{}, nil
+	return &gen.SendMessageResponse{
+		Message: chatModel.MessageToGRPC(message),
+	}, nil
 }
 
 func (s *ChatService) CreateRoom(ctx context.Context, req *gen.CreateRoomRequest) (*gen.CreateRoomResponse, error) {
@@ -35,7 +38,7 @@ func (s *ChatService) CreateRoom(ctx context.Context, req *gen.CreateRoomRequest
 	}
 
 	return &gen.CreateRoomResponse{
-		Room: room.ToGRPCRoom(),
+		Room: chatModel.RoomToGRPC(room.RoomSnapshot),
 	}, nil
 }
 
@@ -46,12 +49,22 @@ func (s *ChatService) ListRooms(ctx context.Context, req *gen.ListRoomsRequest)
 	}
 
 	outRooms := make([]*commonGen.Room, 0, len(rooms))
+	unread := make(map[string]int64)
+	topics := make(map[string]string)
 	for _, room := range rooms {
-		outRooms = append(outRooms, room.ToGRPCRoom())
+		outRooms = append(outRooms, chatModel.RoomToGRPC(room.RoomSnapshot))
+		if room.Unread > 0 {
+			unread[room.Id] = room.Unread
+		}
+		if room.Topic != "" {
+			topics[room.Id] = room.Topic
+		}
 	}
 
 	return &gen.ListRoomsResponse{
-		Rooms: outRooms,
+		Rooms:        outRooms,
+		UnreadCounts: unread,
+		Topics:       topics,
 	}, nil
 }
 
@@ -62,6 +75,197 @@ func (s *ChatService) DeleteRoom(ctx context.Context, req *gen.DeleteRoomRequest
 	}
 
 	return &gen.DeleteRoomResponse{
-		Room: room.ToGRPCRoom(),
+		Room: chatModel.RoomToGRPC(room.RoomSnapshot),
+	}, nil
+}
+
+func (s *ChatService) GetPresence(ctx context.Context, req *gen.GetPresenceRequest) (*gen.GetPresenceResponse, error) {
+	presences, err := s.chatUsecase.GetPresence(ctx, req.UserIds)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*gen.UserPresence, 0, len(presences))
+	for _, presence := range presences {
+		out = append(out, chatModel.PresenceToGRPC(presence))
+	}
+
+	return &gen.GetPresenceResponse{
+		Presences: out,
+	}, nil
+}
+
+func (s *ChatService) EditMessage(ctx context.Context, req *gen.EditMessageRequest) (*gen.EditMessageResponse, error) {
+	message, err := s.chatUsecase.EditMessage(ctx, req.MessageId, req.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gen.EditMessageResponse{
+		Message: chatModel.MessageToGRPC(message),
+	}, nil
+}
+
+func (s *ChatService) DeleteMessage(ctx context.Context, req *gen.DeleteMessageRequest) (*gen.DeleteMessageResponse, error) {
+	message, err := s.chatUsecase.DeleteMessage(ctx, req.MessageId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gen.DeleteMessageResponse{
+		Message: chatModel.MessageToGRPC(message),
+	}, nil
+}
+
+func (s *ChatService) ReactToMessage(ctx context.Context, req *gen.ReactToMessageRequest) (*gen.ReactToMessageResponse, error) {
+	message, err := s.chatUsecase.ReactToMessage(ctx, req.MessageId, req.Emoji, req.Remove)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gen.ReactToMessageResponse{
+		Message: chatModel.MessageToGRPC(message),
+	}, nil
+}
+
+func (s *ChatService) ListMessageRevisions(ctx context.Context, req *gen.ListMessageRevisionsRequest) (*gen.ListMessageRevisionsResponse, error) {
+	revisions, err := s.chatUsecase.ListMessageRevisions(ctx, req.MessageId)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*gen.MessageRevision, 0, len(revisions))
+	for _, revision := range revisions {
+		out = append(out, chatModel.RevisionToGRPC(revision))
+	}
+
+	return &gen.ListMessageRevisionsResponse{
+		Revisions: out,
 	}, nil
 }
+
+func (s *ChatService) ListThread(ctx context.Context, req *gen.ListThreadRequest) (*gen.ListThreadResponse, error) {
+	parent, replies, err := s.chatUsecase.ListThread(ctx, req.ParentId)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*gen.ChatMessage, 0, len(replies))
+	for _, reply := range replies {
+		out = append(out, chatModel.MessageToGRPC(reply))
+	}
+
+	return &gen.ListThreadResponse{
+		Parent:  chatModel.MessageToGRPC(parent),
+		Replies: out,
+	}, nil
+}
+
+func (s *ChatService) ListMentions(ctx context.Context, req *gen.ListMentionsRequest) (*gen.ListMentionsResponse, error) {
+	messages, unread, err := s.chatUsecase.ListMentions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*gen.ChatMessage, 0, len(messages))
+	for _, message := range messages {
+		out = append(out, chatModel.MessageToGRPC(message))
+	}
+
+	return &gen.ListMentionsResponse{
+		Messages:    out,
+		UnreadCount: unread,
+	}, nil
+}
+
+func (s *ChatService) MarkMentionsRead(ctx context.Context, req *gen.MarkMentionsReadRequest) (*gen.MarkMentionsReadResponse, error) {
+	unread, err := s.chatUsecase.MarkMentionsRead(ctx, req.MessageIds)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gen.MarkMentionsReadResponse{
+		UnreadCount: unread,
+	}, nil
+}
+
+func (s *ChatService) MarkRead(ctx context.Context, req *gen.MarkReadRequest) (*gen.MarkReadResponse, error) {
+	unread, err := s.chatUsecase.MarkRead(ctx, req.RoomId, req.MessageId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gen.MarkReadResponse{
+		UnreadCount: unread,
+	}, nil
+}
+
+func (s *ChatService) GetAttachmentURL(ctx context.Context, req *gen.GetAttachmentURLRequest) (*gen.GetAttachmentURLResponse, error) {
+	urls, err := s.chatUsecase.GetAttachmentURL(ctx, req.AttachmentId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gen.GetAttachmentURLResponse{
+		Url:          urls.URL,
+		ThumbnailUrl: urls.ThumbnailURL,
+		ExpiresAt:    urls.ExpiresAt.Unix(),
+	}, nil
+}
+
+func (s *ChatService) SearchMessages(ctx context.Context, req *gen.SearchMessagesRequest) (*gen.SearchMessagesResponse, error) {
+	results, nextCursor, err := s.chatUsecase.SearchMessages(ctx, &usecase.SearchQuery{
+		Text:     req.Query,
+		RoomId:   req.RoomId,
+		AuthorId: req.AuthorId,
+		Since:    req.Since,
+		Until:    req.Until,
+		Cursor:   req.Cursor,
+		PageSize: int(req.PageSize),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*gen.SearchResult, 0, len(results))
+	for _, result := range results {
+		out = append(out, chatModel.SearchResultToGRPC(result))
+	}
+
+	return &gen.SearchMessagesResponse{
+		Results:    out,
+		NextCursor: nextCursor,
+	}, nil
+}
+
+func (s *ChatService) ListFlaggedMessages(ctx context.Context, req *gen.ListFlaggedMessagesRequest) (*gen.ListFlaggedMessagesResponse, error) {
+	flags, err := s.chatUsecase.ListFlaggedMessages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*gen.MessageFlag, 0, len(flags))
+	for _, flag := range flags {
+		out = append(out, chatModel.FlagToGRPC(flag))
+	}
+
+	return &gen.ListFlaggedMessagesResponse{
+		Flags: out,
+	}, nil
+}
+
+func (s *ChatService) ResolveFlag(ctx context.Context, req *gen.ResolveFlagRequest) (*gen.ResolveFlagResponse, error) {
+	if err := s.chatUsecase.ResolveFlag(ctx, req.FlagId); err != nil {
+		return nil, err
+	}
+
+	return &gen.ResolveFlagResponse{}, nil
+}
+
+func (s *ChatService) SetRoomLinks(ctx context.Context, req *gen.SetRoomLinksRequest) (*gen.SetRoomLinksResponse, error) {
+	if err := s.chatUsecase.SetRoomLinks(ctx, req.RoomId, req.Allowed); err != nil {
+		return nil, err
+	}
+
+	return &gen.SetRoomLinksResponse{}, nil
+}
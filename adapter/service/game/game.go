@@ -5,6 +5,7 @@ import (
 
 	commonGen "github.com/bozoteam/roshan/adapter/grpc/gen/common"
 	gen "github.com/bozoteam/roshan/adapter/grpc/gen/game"
+	chatModel "github.com/bozoteam/roshan/modules/chat/models"
 	"github.com/bozoteam/roshan/modules/game/usecase"
 )
 
@@ -26,7 +27,7 @@ func (s *GameService) CreateRoom(ctx context.Context, req *gen.CreateGameRoomReq
 	}
 
 	return &gen.CreateGameRoomResponse{
-		Room: room.ToGRPCRoom(),
+		Room: chatModel.RoomToGRPC(room.RoomSnapshot),
 	}, nil
 }
 
@@ -38,7 +39,7 @@ func (s *GameService) ListRooms(ctx context.Context, req *gen.ListGameRoomsReque
 
 	outRooms := make([]*commonGen.Room, 0, len(rooms))
 	for _, room := range rooms {
-		outRooms = append(outRooms, room.ToGRPCRoom())
+		outRooms = append(outRooms, chatModel.RoomToGRPC(room.RoomSnapshot))
 	}
 
 	return &gen.ListGameRoomsResponse{
@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// GinMiddleware starts a server span for every HTTP request except the skipped paths.
+// The span is written back into the request headers, so RPCs going through the vanguard
+// transcoder become its children instead of siblings.
+func GinMiddleware(skip ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if slices.Contains(skip, ctx.Request.URL.Path) {
+			ctx.Next()
+			return
+		}
+
+		carrier := propagation.HeaderCarrier(ctx.Request.Header)
+		parent := otel.GetTextMapPropagator().Extract(ctx.Request.Context(), carrier)
+
+		name := ctx.Request.Method
+		if route := ctx.FullPath(); route != "" {
+			name += " " + route
+		}
+		spanCtx, span := Tracer().Start(parent, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", ctx.Request.Method),
+				attribute.String("http.route", ctx.FullPath()),
+			),
+		)
+		defer span.End()
+
+		otel.GetTextMapPropagator().Inject(spanCtx, carrier)
+		ctx.Request = ctx.Request.WithContext(spanCtx)
+
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	database "github.com/bozoteam/roshan/adapter/database"
+	"github.com/bozoteam/roshan/adapter/log"
+	"github.com/bozoteam/roshan/adapter/server"
+	"github.com/bozoteam/roshan/helpers"
+	"google.golang.org/grpc/status"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []command{
+	{"serve", "run the API server, the default", runServe},
+	{"migrate", "up | down [steps] | status", runMigrate},
+	{"user", "create | disable | enable | set-role | reset-password", runUser},
+	{"token", "issue an access token for a user", runToken},
+	{"rooms", "list the rooms of a running instance", runRooms},
+	{"config", "check the configuration", runConfig},
+}
+
+// run dispatches to the subcommand named by args[0] and returns the exit code
+func run(args []string) int {
+	if len(args) == 0 {
+		return runServe(nil)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+
+	// asking for help isn't an error, so it goes to stdout and exits 0
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(os.Stdout)
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	usage(os.Stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: roshan <command> [arguments]")
+	fmt.Fprintln(w)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+}
+
+func runServe(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return fail(err)
+	}
+	RunServer(cfg)
+	return 0
+}
+
+// loadConfig reads the configuration and sets up logging from it
+func loadConfig() (*config.Config, error) {
+	helpers.LoadDotEnv()
+
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	// every constructor binds its logger, so this comes before anything else is built
+	if err := log.Configure(cfg.Log); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// withDatabase runs fn against the configured database, for the operator commands
+func withDatabase(fn func(cfg *config.Config, repos server.Repositories) int) int {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fail(err)
+	}
+
+	db := database.GetDBConnection(cfg.Database)
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fail(err)
+	}
+	defer sqlDB.Close()
+
+	return fn(cfg, server.GormRepositories(db))
+}
+
+// readSecret reads one line from stdin, so secrets stay out of the shell history
+func readSecret(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// fail prints err for a human and returns the exit code for errors
+func fail(err error) int {
+	if s, ok := status.FromError(err); ok {
+		fmt.Fprintln(os.Stderr, "error:", s.Message())
+	} else {
+		fmt.Fprintln(os.Stderr, "error:", err)
+	}
+	return 1
+}
+
+// usageError prints the usage of a subcommand and returns the exit code for bad arguments
+func usageError(flags *flag.FlagSet, format string, args ...any) int {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	flags.Usage()
+	return 2
+}
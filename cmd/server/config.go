@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bozoteam/roshan/adapter/config"
+)
+
+// runConfig validates the configuration the server would start with
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: roshan config check [-file config.yaml]")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("config check", flag.ContinueOnError)
+	file := flags.String("file", os.Getenv(config.FileEnv), "config file to check, defaults to $"+config.FileEnv)
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	os.Setenv(config.FileEnv, *file)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("configuration is valid, listening on %s with database %s on %s:%d\n",
+		cfg.Server.ListenAddr, cfg.Database.Name, cfg.Database.Host, cfg.Database.Port)
+	return 0
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	database "github.com/bozoteam/roshan/adapter/database"
+)
+
+const migrateUsage = "usage: roshan migrate up | down [steps] | status"
+
+// runMigrate is the migrate subcommand, it returns the exit code
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return fail(err)
+	}
+
+	db := database.GetDBConnection(cfg.Database)
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fail(err)
+	}
+	defer sqlDB.Close()
+
+	migrator, err := database.NewMigrator(sqlDB)
+	if err != nil {
+		return fail(err)
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Println("applied", migration.Name())
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, migrateUsage)
+				return 2
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Println("reverted", migration.Name())
+		}
+		if errors.Is(err, database.ErrNothingToRevert) {
+			fmt.Println(err)
+		} else if err != nil {
+			return fail(err)
+		}
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return fail(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tDESCRIPTION\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			if status.Modified {
+				appliedAt += " (modified since)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", status.Version, status.Description, appliedAt)
+		}
+		w.Flush()
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	adminUsecase "github.com/bozoteam/roshan/modules/admin/usecase"
+)
+
+// runRooms asks a running instance for its rooms through the admin API
+func runRooms(args []string) int {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Fprintln(os.Stderr, "usage: roshan rooms list [-url http://host:port] [-token <admin token>]")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("rooms list", flag.ContinueOnError)
+	baseURL := flags.String("url", "", "instance to ask, the configured listen address when unset")
+	token := flags.String("token", os.Getenv("ROSHAN_TOKEN"), "access token of an admin, defaults to $ROSHAN_TOKEN")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *token == "" {
+		return usageError(flags, "an admin token is required, see `roshan token issue`")
+	}
+
+	if *baseURL == "" {
+		cfg, err := loadConfig()
+		if err != nil {
+			return fail(err)
+		}
+		host, port, _ := net.SplitHostPort(cfg.Server.ListenAddr)
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "127.0.0.1"
+		}
+		*baseURL = "http://" + net.JoinHostPort(host, port)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(*baseURL, "/")+"/api/v1/admin/rooms", nil)
+	if err != nil {
+		return fail(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+*token)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fail(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Message string `json:"message"`
+			Error   string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return fail(fmt.Errorf("%s: %s%s", resp.Status, body.Message, body.Error))
+	}
+
+	var body struct {
+		Rooms []adminUsecase.RoomSummary `json:"rooms"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fail(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tKIND\tNAME\tCONNECTIONS\tCREATOR")
+	for _, room := range body.Rooms {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", room.Id, room.Kind, room.Name, room.Connections, room.CreatorId)
+	}
+	w.Flush()
+	return 0
+}
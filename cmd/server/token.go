@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	"github.com/bozoteam/roshan/adapter/server"
+	jwtRepository "github.com/bozoteam/roshan/modules/auth/repository/jwt"
+	ticketRepository "github.com/bozoteam/roshan/modules/auth/repository/ticket"
+	authUsecase "github.com/bozoteam/roshan/modules/auth/usecase"
+)
+
+// runToken mints access tokens for debugging, the token is printed on stdout
+func runToken(args []string) int {
+	if len(args) == 0 || args[0] != "issue" {
+		fmt.Fprintln(os.Stderr, "usage: roshan token issue -email <email> [-ttl 1h]")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("token issue", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the account to act as")
+	ttl := flags.Duration("ttl", 0, "lifetime of the token, the configured one when unset")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *email == "" {
+		return usageError(flags, "-email is required")
+	}
+	if *ttl < 0 {
+		return usageError(flags, "-ttl must not be negative")
+	}
+
+	return withDatabase(func(cfg *config.Config, repos server.Repositories) int {
+		auth := authUsecase.NewAuthUsecase(
+			repos.Users,
+			jwtRepository.NewJWTRepository(cfg.JWT),
+			repos.Lockouts,
+			repos.ApiKeys,
+			ticketRepository.NewTicketRepository(cfg.Auth.TicketTTL),
+			cfg.Auth.Lockout,
+			nil,
+			nil,
+		)
+
+		token, err := auth.IssueAccessToken(context.Background(), *email, *ttl)
+		if err != nil {
+			return fail(err)
+		}
+		fmt.Println(token)
+		return 0
+	})
+}
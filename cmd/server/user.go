@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	"github.com/bozoteam/roshan/adapter/server"
+	"github.com/bozoteam/roshan/modules/user/models"
+	userUsecase "github.com/bozoteam/roshan/modules/user/usecase"
+)
+
+const userUsage = "usage: roshan user create | disable | enable | set-role | reset-password [flags]"
+
+// runUser manages accounts straight in the database, without going through the API
+func runUser(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	email := flags.String("email", "", "email of the account")
+
+	var action func(ctx context.Context, users *userUsecase.UserUsecase) (*models.User, error)
+	switch args[0] {
+	case "create":
+		name := flags.String("name", "", "display name, letters and digits only")
+		role := flags.String("role", models.RoleUser, "user, moderator or admin")
+		action = func(ctx context.Context, users *userUsecase.UserUsecase) (*models.User, error) {
+			// checked up front so a bad role doesn't leave a half made account behind
+			if !models.IsRole(*role) {
+				return nil, userUsecase.ErrInvalidRole
+			}
+			password, err := readSecret("password: ")
+			if err != nil {
+				return nil, err
+			}
+			user, err := users.CreateUser(ctx, &userUsecase.UserCreateInput{Name: *name, Email: *email, Password: password})
+			if err != nil || *role == models.RoleUser {
+				return user, err
+			}
+			return users.SetRole(ctx, *email, *role)
+		}
+
+	case "disable", "enable":
+		disabled := args[0] == "disable"
+		action = func(ctx context.Context, users *userUsecase.UserUsecase) (*models.User, error) {
+			return users.SetDisabled(ctx, *email, disabled)
+		}
+
+	case "set-role":
+		role := flags.String("role", "", "user, moderator or admin")
+		action = func(ctx context.Context, users *userUsecase.UserUsecase) (*models.User, error) {
+			return users.SetRole(ctx, *email, *role)
+		}
+
+	case "reset-password":
+		action = func(ctx context.Context, users *userUsecase.UserUsecase) (*models.User, error) {
+			password, err := readSecret("new password: ")
+			if err != nil {
+				return nil, err
+			}
+			return users.ResetPassword(ctx, *email, password)
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *email == "" {
+		return usageError(flags, "-email is required")
+	}
+
+	return withDatabase(func(cfg *config.Config, repos server.Repositories) int {
+		user, err := action(context.Background(), userUsecase.NewUserUsecase(repos.Users))
+		if err != nil {
+			return fail(err)
+		}
+
+		state := "enabled"
+		if user.IsDisabled() {
+			state = "disabled"
+		}
+		fmt.Printf("%s %s role=%s %s\n", user.Id, user.Email, user.Role, state)
+		return 0
+	})
+}
@@ -0,0 +1,10 @@
+// Package db holds the SQL migrations. They're embedded so the binary can apply them itself.
+package db
+
+import "embed"
+
+// Migrations is the migration directory: the Atlas generated files, atlas.sum, and down/
+// with the statements that revert each of them
+//
+//go:embed migrations
+var Migrations embed.FS
@@ -0,0 +1,25 @@
+-- Modify "user" table
+ALTER TABLE "public"."user"
+ADD COLUMN "role" character varying(32) NOT NULL DEFAULT 'user';
+-- Create "login_attempt" table
+CREATE TABLE "public"."login_attempt" (
+  "kind" character varying(16) NOT NULL,
+  "subject" character varying(255) NOT NULL,
+  "failed_count" integer NOT NULL DEFAULT 0,
+  "last_failed_at" timestamp NOT NULL,
+  "locked_until" timestamp NULL,
+  PRIMARY KEY ("kind", "subject")
+);
+-- Create "lockout_event" table
+CREATE TABLE "public"."lockout_event" (
+  "id" uuid NOT NULL,
+  "kind" character varying(16) NOT NULL,
+  "subject" character varying(255) NOT NULL,
+  "event" character varying(16) NOT NULL,
+  "locked_until" timestamp NULL,
+  "actor_id" uuid NULL,
+  "created_at" timestamp NOT NULL DEFAULT now (),
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_lockout_event_subject" to table: "lockout_event"
+CREATE INDEX "idx_lockout_event_subject" ON "public"."lockout_event" ("kind", "subject");
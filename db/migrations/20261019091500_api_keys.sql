@@ -0,0 +1,18 @@
+-- Create "api_key" table
+CREATE TABLE "public"."api_key" (
+  "id" uuid NOT NULL,
+  "user_id" uuid NOT NULL,
+  "name" character varying(255) NOT NULL,
+  "prefix" character varying(16) NOT NULL,
+  "hash" character varying(64) NOT NULL,
+  "scopes" character varying(255) NOT NULL,
+  "expires_at" timestamp NULL,
+  "last_used_at" timestamp NULL,
+  "revoked_at" timestamp NULL,
+  "created_at" timestamp NOT NULL DEFAULT now (),
+  PRIMARY KEY ("id"),
+  CONSTRAINT "unique_api_key_prefix" UNIQUE ("prefix"),
+  CONSTRAINT "fk_api_key_user" FOREIGN KEY ("user_id") REFERENCES "public"."user" ("id") ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create index "idx_api_key_user_id" to table: "api_key"
+CREATE INDEX "idx_api_key_user_id" ON "public"."api_key" ("user_id");
@@ -0,0 +1,14 @@
+-- Create "user_identity" table
+CREATE TABLE "public"."user_identity" (
+  "id" uuid NOT NULL,
+  "user_id" uuid NOT NULL,
+  "provider" character varying(64) NOT NULL,
+  "subject" character varying(255) NOT NULL,
+  "email" character varying(255) NULL,
+  "created_at" timestamp NOT NULL DEFAULT now (),
+  PRIMARY KEY ("id"),
+  CONSTRAINT "unique_provider_subject" UNIQUE ("provider", "subject"),
+  CONSTRAINT "fk_user_identity_user" FOREIGN KEY ("user_id") REFERENCES "public"."user" ("id") ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create index "idx_user_identity_user_id" to table: "user_identity"
+CREATE INDEX "idx_user_identity_user_id" ON "public"."user_identity" ("user_id");
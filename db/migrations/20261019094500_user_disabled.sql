@@ -0,0 +1,3 @@
+-- Modify "user" table
+ALTER TABLE "public"."user"
+ADD COLUMN "disabled_at" timestamp NULL;
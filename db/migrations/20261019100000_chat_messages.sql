@@ -0,0 +1,36 @@
+-- Create "message" table
+CREATE TABLE "public"."message" (
+  "id" uuid NOT NULL,
+  "room_id" uuid NOT NULL,
+  "user_id" uuid NOT NULL,
+  "content" character varying(4000) NOT NULL,
+  "timestamp" bigint NOT NULL,
+  "edited_at" bigint NOT NULL DEFAULT 0,
+  "deleted_at" bigint NOT NULL DEFAULT 0,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_message_user" FOREIGN KEY ("user_id") REFERENCES "public"."user" ("id") ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create index "idx_message_room_id" to table: "message"
+CREATE INDEX "idx_message_room_id" ON "public"."message" ("room_id", "id");
+-- Create "message_reaction" table
+CREATE TABLE "public"."message_reaction" (
+  "message_id" uuid NOT NULL,
+  "user_id" uuid NOT NULL,
+  "emoji" character varying(32) NOT NULL,
+  "created_at" timestamp NOT NULL DEFAULT now (),
+  PRIMARY KEY ("message_id", "user_id", "emoji"),
+  CONSTRAINT "fk_message_reaction_message" FOREIGN KEY ("message_id") REFERENCES "public"."message" ("id") ON UPDATE NO ACTION ON DELETE CASCADE,
+  CONSTRAINT "fk_message_reaction_user" FOREIGN KEY ("user_id") REFERENCES "public"."user" ("id") ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create "message_revision" table
+CREATE TABLE "public"."message_revision" (
+  "id" uuid NOT NULL,
+  "message_id" uuid NOT NULL,
+  "content" character varying(4000) NOT NULL,
+  "edited_by" uuid NOT NULL,
+  "created_at" timestamp NOT NULL DEFAULT now (),
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_message_revision_message" FOREIGN KEY ("message_id") REFERENCES "public"."message" ("id") ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create index "idx_message_revision_message_id" to table: "message_revision"
+CREATE INDEX "idx_message_revision_message_id" ON "public"."message_revision" ("message_id");
@@ -0,0 +1,19 @@
+-- Modify "message" table
+ALTER TABLE "public"."message"
+ADD COLUMN "parent_id" uuid NULL,
+ADD CONSTRAINT "fk_message_parent" FOREIGN KEY ("parent_id") REFERENCES "public"."message" ("id") ON UPDATE NO ACTION ON DELETE CASCADE;
+-- Create index "idx_message_parent_id" to table: "message"
+CREATE INDEX "idx_message_parent_id" ON "public"."message" ("parent_id", "id");
+-- Create "message_mention" table
+CREATE TABLE "public"."message_mention" (
+  "message_id" uuid NOT NULL,
+  "user_id" uuid NOT NULL,
+  "room_id" uuid NOT NULL,
+  "created_at" timestamp NOT NULL DEFAULT now (),
+  "read_at" timestamp NULL,
+  PRIMARY KEY ("message_id", "user_id"),
+  CONSTRAINT "fk_message_mention_message" FOREIGN KEY ("message_id") REFERENCES "public"."message" ("id") ON UPDATE NO ACTION ON DELETE CASCADE,
+  CONSTRAINT "fk_message_mention_user" FOREIGN KEY ("user_id") REFERENCES "public"."user" ("id") ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create index "idx_message_mention_user_id" to table: "message_mention"
+CREATE INDEX "idx_message_mention_user_id" ON "public"."message_mention" ("user_id", "read_at");
@@ -0,0 +1,9 @@
+-- Create "room_read_marker" table
+CREATE TABLE "public"."room_read_marker" (
+  "user_id" uuid NOT NULL,
+  "room_id" uuid NOT NULL,
+  "message_id" uuid NOT NULL,
+  "updated_at" timestamp NOT NULL DEFAULT now (),
+  PRIMARY KEY ("user_id", "room_id"),
+  CONSTRAINT "fk_room_read_marker_user" FOREIGN KEY ("user_id") REFERENCES "public"."user" ("id") ON UPDATE NO ACTION ON DELETE CASCADE
+);
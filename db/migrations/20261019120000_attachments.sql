@@ -0,0 +1,18 @@
+-- Create "attachment" table
+CREATE TABLE "public"."attachment" (
+  "id" uuid NOT NULL,
+  "user_id" uuid NOT NULL,
+  "message_id" uuid NULL,
+  "name" character varying(255) NOT NULL,
+  "content_type" character varying(127) NOT NULL,
+  "size" bigint NOT NULL,
+  "width" integer NOT NULL DEFAULT 0,
+  "height" integer NOT NULL DEFAULT 0,
+  "has_thumbnail" boolean NOT NULL DEFAULT false,
+  "created_at" timestamp NOT NULL DEFAULT now (),
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_attachment_message" FOREIGN KEY ("message_id") REFERENCES "public"."message" ("id") ON UPDATE NO ACTION ON DELETE CASCADE,
+  CONSTRAINT "fk_attachment_user" FOREIGN KEY ("user_id") REFERENCES "public"."user" ("id") ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- Create index "idx_attachment_message_id" to table: "attachment"
+CREATE INDEX "idx_attachment_message_id" ON "public"."attachment" ("message_id");
@@ -0,0 +1,4 @@
+-- Modify "message" table
+ALTER TABLE "public"."message" ADD COLUMN "search" tsvector NULL GENERATED ALWAYS AS (to_tsvector('simple'::regconfig, (content)::text)) STORED;
+-- Create index "idx_message_search" to table: "message"
+CREATE INDEX "idx_message_search" ON "public"."message" USING GIN ("search");
@@ -0,0 +1,15 @@
+-- Create "message_flag" table
+CREATE TABLE "public"."message_flag" (
+  "id" uuid NOT NULL,
+  "message_id" uuid NOT NULL,
+  "filter" character varying(32) NOT NULL,
+  "reason" character varying(255) NOT NULL,
+  "created_at" timestamp NOT NULL DEFAULT now (),
+  "resolved_by" uuid NULL,
+  "resolved_at" timestamp NULL,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_message_flag_message" FOREIGN KEY ("message_id") REFERENCES "public"."message" ("id") ON UPDATE NO ACTION ON DELETE CASCADE,
+  CONSTRAINT "fk_message_flag_resolved_by" FOREIGN KEY ("resolved_by") REFERENCES "public"."user" ("id") ON UPDATE NO ACTION ON DELETE SET NULL
+);
+-- Create index "idx_message_flag_pending" to table: "message_flag"
+CREATE INDEX "idx_message_flag_pending" ON "public"."message_flag" ("id") WHERE (resolved_at IS NULL);
@@ -0,0 +1,2 @@
+-- Modify "message" table
+ALTER TABLE "public"."message" ADD COLUMN "kind" character varying(16) NOT NULL DEFAULT '', ADD COLUMN "nick" character varying(32) NOT NULL DEFAULT '';
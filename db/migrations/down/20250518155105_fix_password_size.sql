@@ -0,0 +1,3 @@
+-- Modify "user" table
+ALTER TABLE "public"."user"
+ALTER COLUMN "password" TYPE character varying(60);
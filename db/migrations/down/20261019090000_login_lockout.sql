@@ -0,0 +1,7 @@
+-- Drop "lockout_event" table
+DROP TABLE "public"."lockout_event";
+-- Drop "login_attempt" table
+DROP TABLE "public"."login_attempt";
+-- Modify "user" table
+ALTER TABLE "public"."user"
+DROP COLUMN "role";
@@ -0,0 +1,2 @@
+-- Drop "api_key" table
+DROP TABLE "public"."api_key";
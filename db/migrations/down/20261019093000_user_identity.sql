@@ -0,0 +1,2 @@
+-- Drop "user_identity" table
+DROP TABLE "public"."user_identity";
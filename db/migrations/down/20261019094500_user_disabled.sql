@@ -0,0 +1,3 @@
+-- Modify "user" table
+ALTER TABLE "public"."user"
+DROP COLUMN "disabled_at";
@@ -0,0 +1,6 @@
+-- Drop "message_revision" table
+DROP TABLE "public"."message_revision";
+-- Drop "message_reaction" table
+DROP TABLE "public"."message_reaction";
+-- Drop "message" table
+DROP TABLE "public"."message";
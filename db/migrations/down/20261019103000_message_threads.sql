@@ -0,0 +1,8 @@
+-- Drop "message_mention" table
+DROP TABLE "public"."message_mention";
+-- Drop index "idx_message_parent_id" from table: "message"
+DROP INDEX "public"."idx_message_parent_id";
+-- Modify "message" table
+ALTER TABLE "public"."message"
+DROP CONSTRAINT "fk_message_parent",
+DROP COLUMN "parent_id";
@@ -0,0 +1,2 @@
+-- Drop "room_read_marker" table
+DROP TABLE "public"."room_read_marker";
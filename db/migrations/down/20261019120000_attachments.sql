@@ -0,0 +1,2 @@
+-- Drop "attachment" table
+DROP TABLE "public"."attachment";
@@ -0,0 +1,4 @@
+-- Drop index "idx_message_search" from table: "message"
+DROP INDEX "public"."idx_message_search";
+-- Modify "message" table
+ALTER TABLE "public"."message" DROP COLUMN "search";
@@ -0,0 +1,2 @@
+-- Drop "message_flag" table
+DROP TABLE "public"."message_flag";
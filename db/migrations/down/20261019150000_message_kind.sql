@@ -0,0 +1,2 @@
+-- Modify "message" table
+ALTER TABLE "public"."message" DROP COLUMN "kind", DROP COLUMN "nick";
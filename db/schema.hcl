@@ -19,10 +19,19 @@ table "user" {
     type     = varchar(72)
     null     = false
   }
+  column "role" {
+    type     = varchar(32)
+    null     = false
+    default  = "user"
+  }
   column "refresh_token" {
     type     = varchar(1024)
     null     = true
   }
+  column "disabled_at" {
+    type     = timestamp
+    null     = true
+  }
   column "created_at" {
     type     = timestamp
     default  = sql("NOW()")
@@ -39,3 +48,507 @@ table "user" {
     columns = [column.id]
   }
 }
+
+table "login_attempt" {
+  schema = schema.public
+  column "kind" {
+    type     = varchar(16)
+    null     = false
+  }
+  column "subject" {
+    type     = varchar(255)
+    null     = false
+  }
+  column "failed_count" {
+    type     = integer
+    null     = false
+    default  = 0
+  }
+  column "last_failed_at" {
+    type     = timestamp
+    null     = false
+  }
+  column "locked_until" {
+    type     = timestamp
+    null     = true
+  }
+
+  primary_key {
+    columns = [column.kind, column.subject]
+  }
+}
+
+table "lockout_event" {
+  schema = schema.public
+  column "id" {
+    type     = uuid
+    null     = false
+  }
+  column "kind" {
+    type     = varchar(16)
+    null     = false
+  }
+  column "subject" {
+    type     = varchar(255)
+    null     = false
+  }
+  column "event" {
+    type     = varchar(16)
+    null     = false
+  }
+  column "locked_until" {
+    type     = timestamp
+    null     = true
+  }
+  column "actor_id" {
+    type     = uuid
+    null     = true
+  }
+  column "created_at" {
+    type     = timestamp
+    default  = sql("NOW()")
+  }
+
+  primary_key {
+    columns = [column.id]
+  }
+  index "idx_lockout_event_subject" {
+    columns = [column.kind, column.subject]
+  }
+}
+
+table "api_key" {
+  schema = schema.public
+  column "id" {
+    type     = uuid
+    null     = false
+  }
+  column "user_id" {
+    type     = uuid
+    null     = false
+  }
+  column "name" {
+    type     = varchar(255)
+    null     = false
+  }
+  column "prefix" {
+    type     = varchar(16)
+    null     = false
+  }
+  column "hash" {
+    type     = varchar(64)
+    null     = false
+  }
+  column "scopes" {
+    type     = varchar(255)
+    null     = false
+  }
+  column "expires_at" {
+    type     = timestamp
+    null     = true
+  }
+  column "last_used_at" {
+    type     = timestamp
+    null     = true
+  }
+  column "revoked_at" {
+    type     = timestamp
+    null     = true
+  }
+  column "created_at" {
+    type     = timestamp
+    default  = sql("NOW()")
+  }
+
+  primary_key {
+    columns = [column.id]
+  }
+  unique "unique_api_key_prefix" {
+    columns = [column.prefix]
+  }
+  foreign_key "fk_api_key_user" {
+    columns     = [column.user_id]
+    ref_columns = [table.user.column.id]
+    on_delete   = CASCADE
+  }
+  index "idx_api_key_user_id" {
+    columns = [column.user_id]
+  }
+}
+
+table "user_identity" {
+  schema = schema.public
+  column "id" {
+    type     = uuid
+    null     = false
+  }
+  column "user_id" {
+    type     = uuid
+    null     = false
+  }
+  column "provider" {
+    type     = varchar(64)
+    null     = false
+  }
+  column "subject" {
+    type     = varchar(255)
+    null     = false
+  }
+  column "email" {
+    type     = varchar(255)
+    null     = true
+  }
+  column "created_at" {
+    type     = timestamp
+    default  = sql("NOW()")
+  }
+
+  primary_key {
+    columns = [column.id]
+  }
+  unique "unique_provider_subject" {
+    columns = [column.provider, column.subject]
+  }
+  foreign_key "fk_user_identity_user" {
+    columns     = [column.user_id]
+    ref_columns = [table.user.column.id]
+    on_delete   = CASCADE
+  }
+  index "idx_user_identity_user_id" {
+    columns = [column.user_id]
+  }
+}
+
+table "message" {
+  schema = schema.public
+  column "id" {
+    type     = uuid
+    null     = false
+  }
+  column "room_id" {
+    type     = uuid
+    null     = false
+  }
+  column "user_id" {
+    type     = uuid
+    null     = false
+  }
+  column "content" {
+    type     = varchar(4000)
+    null     = false
+  }
+  column "kind" {
+    type     = varchar(16)
+    null     = false
+    default  = ""
+  }
+  column "nick" {
+    type     = varchar(32)
+    null     = false
+    default  = ""
+  }
+  column "timestamp" {
+    type     = bigint
+    null     = false
+  }
+  column "edited_at" {
+    type     = bigint
+    null     = false
+    default  = 0
+  }
+  column "deleted_at" {
+    type     = bigint
+    null     = false
+    default  = 0
+  }
+  column "parent_id" {
+    type     = uuid
+    null     = true
+  }
+  column "search" {
+    type     = tsvector
+    null     = true
+    as {
+      expr = "to_tsvector('simple'::regconfig, (content)::text)"
+      type = STORED
+    }
+  }
+
+  primary_key {
+    columns = [column.id]
+  }
+  foreign_key "fk_message_user" {
+    columns     = [column.user_id]
+    ref_columns = [table.user.column.id]
+    on_delete   = CASCADE
+  }
+  foreign_key "fk_message_parent" {
+    columns     = [column.parent_id]
+    ref_columns = [table.message.column.id]
+    on_delete   = CASCADE
+  }
+  index "idx_message_room_id" {
+    columns = [column.room_id, column.id]
+  }
+  index "idx_message_parent_id" {
+    columns = [column.parent_id, column.id]
+  }
+  index "idx_message_search" {
+    type    = GIN
+    columns = [column.search]
+  }
+}
+
+table "message_reaction" {
+  schema = schema.public
+  column "message_id" {
+    type     = uuid
+    null     = false
+  }
+  column "user_id" {
+    type     = uuid
+    null     = false
+  }
+  column "emoji" {
+    type     = varchar(32)
+    null     = false
+  }
+  column "created_at" {
+    type     = timestamp
+    default  = sql("NOW()")
+  }
+
+  primary_key {
+    columns = [column.message_id, column.user_id, column.emoji]
+  }
+  foreign_key "fk_message_reaction_message" {
+    columns     = [column.message_id]
+    ref_columns = [table.message.column.id]
+    on_delete   = CASCADE
+  }
+  foreign_key "fk_message_reaction_user" {
+    columns     = [column.user_id]
+    ref_columns = [table.user.column.id]
+    on_delete   = CASCADE
+  }
+}
+
+table "message_revision" {
+  schema = schema.public
+  column "id" {
+    type     = uuid
+    null     = false
+  }
+  column "message_id" {
+    type     = uuid
+    null     = false
+  }
+  column "content" {
+    type     = varchar(4000)
+    null     = false
+  }
+  column "edited_by" {
+    type     = uuid
+    null     = false
+  }
+  column "created_at" {
+    type     = timestamp
+    default  = sql("NOW()")
+  }
+
+  primary_key {
+    columns = [column.id]
+  }
+  foreign_key "fk_message_revision_message" {
+    columns     = [column.message_id]
+    ref_columns = [table.message.column.id]
+    on_delete   = CASCADE
+  }
+  index "idx_message_revision_message_id" {
+    columns = [column.message_id]
+  }
+}
+
+table "message_mention" {
+  schema = schema.public
+  column "message_id" {
+    type     = uuid
+    null     = false
+  }
+  column "user_id" {
+    type     = uuid
+    null     = false
+  }
+  column "room_id" {
+    type     = uuid
+    null     = false
+  }
+  column "created_at" {
+    type     = timestamp
+    default  = sql("NOW()")
+  }
+  column "read_at" {
+    type     = timestamp
+    null     = true
+  }
+
+  primary_key {
+    columns = [column.message_id, column.user_id]
+  }
+  foreign_key "fk_message_mention_message" {
+    columns     = [column.message_id]
+    ref_columns = [table.message.column.id]
+    on_delete   = CASCADE
+  }
+  foreign_key "fk_message_mention_user" {
+    columns     = [column.user_id]
+    ref_columns = [table.user.column.id]
+    on_delete   = CASCADE
+  }
+  index "idx_message_mention_user_id" {
+    columns = [column.user_id, column.read_at]
+  }
+}
+
+table "room_read_marker" {
+  schema = schema.public
+  column "user_id" {
+    type     = uuid
+    null     = false
+  }
+  column "room_id" {
+    type     = uuid
+    null     = false
+  }
+  column "message_id" {
+    type     = uuid
+    null     = false
+  }
+  column "updated_at" {
+    type     = timestamp
+    default  = sql("NOW()")
+  }
+
+  primary_key {
+    columns = [column.user_id, column.room_id]
+  }
+  foreign_key "fk_room_read_marker_user" {
+    columns     = [column.user_id]
+    ref_columns = [table.user.column.id]
+    on_delete   = CASCADE
+  }
+}
+
+table "attachment" {
+  schema = schema.public
+  column "id" {
+    type     = uuid
+    null     = false
+  }
+  column "user_id" {
+    type     = uuid
+    null     = false
+  }
+  column "message_id" {
+    type     = uuid
+    null     = true
+  }
+  column "name" {
+    type     = varchar(255)
+    null     = false
+  }
+  column "content_type" {
+    type     = varchar(127)
+    null     = false
+  }
+  column "size" {
+    type     = bigint
+    null     = false
+  }
+  column "width" {
+    type     = integer
+    null     = false
+    default  = 0
+  }
+  column "height" {
+    type     = integer
+    null     = false
+    default  = 0
+  }
+  column "has_thumbnail" {
+    type     = boolean
+    null     = false
+    default  = false
+  }
+  column "created_at" {
+    type     = timestamp
+    default  = sql("NOW()")
+  }
+
+  primary_key {
+    columns = [column.id]
+  }
+  foreign_key "fk_attachment_message" {
+    columns     = [column.message_id]
+    ref_columns = [table.message.column.id]
+    on_delete   = CASCADE
+  }
+  foreign_key "fk_attachment_user" {
+    columns     = [column.user_id]
+    ref_columns = [table.user.column.id]
+    on_delete   = CASCADE
+  }
+  index "idx_attachment_message_id" {
+    columns = [column.message_id]
+  }
+}
+
+table "message_flag" {
+  schema = schema.public
+  column "id" {
+    type     = uuid
+    null     = false
+  }
+  column "message_id" {
+    type     = uuid
+    null     = false
+  }
+  column "filter" {
+    type     = varchar(32)
+    null     = false
+  }
+  column "reason" {
+    type     = varchar(255)
+    null     = false
+  }
+  column "created_at" {
+    type     = timestamp
+    default  = sql("NOW()")
+  }
+  column "resolved_by" {
+    type     = uuid
+    null     = true
+  }
+  column "resolved_at" {
+    type     = timestamp
+    null     = true
+  }
+
+  primary_key {
+    columns = [column.id]
+  }
+  foreign_key "fk_message_flag_message" {
+    columns     = [column.message_id]
+    ref_columns = [table.message.column.id]
+    on_delete   = CASCADE
+  }
+  foreign_key "fk_message_flag_resolved_by" {
+    columns     = [column.resolved_by]
+    ref_columns = [table.user.column.id]
+    on_delete   = SET_NULL
+  }
+  index "idx_message_flag_pending" {
+    columns = [column.id]
+    where   = "(resolved_at IS NULL)"
+  }
+}
@@ -1,2 +1,2 @@
 #!/usr/bin/env fish
-atlas migrate apply --env postgres --config file://dev/atlas.hcl
+go run ./cmd/server migrate up
@@ -1,9 +1,8 @@
 package helpers
 
 import (
-	"log"
+	"log/slog"
 	"os"
-	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -18,23 +17,6 @@ func LoadDotEnv() {
 		file = ".env.dev"
 	}
 	if err := godotenv.Load(file); err != nil {
-		log.Println("Warning: .env file not found")
+		slog.Warn(".env file not found", "file", file)
 	}
 }
-
-func GetEnv(key string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		log.Fatalf("%s is not set", key)
-	}
-	return value
-}
-
-func GetEnvAsInt(key string) int64 {
-	valueStr := GetEnv(key)
-	value, err := strconv.ParseInt(valueStr, 10, 64)
-	if err != nil {
-		log.Fatalf("Invalid integer value for %s: %v", key, err)
-	}
-	return value
-}
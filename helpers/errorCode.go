@@ -8,3 +8,17 @@ func IsErrorCode(err error, code string) bool {
 	}
 	return false
 }
+
+// UniqueViolation is the code Postgres reports when a unique constraint is violated
+const UniqueViolation = "23505"
+
+// NewUniqueViolation returns the error Postgres would return for constraint, for the
+// in-memory repositories that have to fail the same way
+func NewUniqueViolation(constraint string) error {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           UniqueViolation,
+		Message:        "duplicate key value violates unique constraint \"" + constraint + "\"",
+		ConstraintName: constraint,
+	}
+}
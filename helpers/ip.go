@@ -0,0 +1,89 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// TrustedProxies are the networks of the proxies allowed to tell the client's address
+// through X-Real-IP and X-Forwarded-For
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies reads addresses and CIDR ranges, e.g. 10.0.0.0/8 or ::1
+func ParseTrustedProxies(proxies []string) (TrustedProxies, error) {
+	trusted := make(TrustedProxies, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if prefix, err := netip.ParsePrefix(proxy); err == nil {
+			trusted = append(trusted, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+		trusted = append(trusted, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return trusted, nil
+}
+
+// Contains tells whether the address belongs to a trusted proxy
+func (t TrustedProxies) Contains(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range t {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIPFromContext returns the address of the caller of an RPC.
+// Proxy headers are only read when the peer is a trusted proxy, anyone else could put
+// whatever they like in them. X-Forwarded-For is walked from the right, skipping our own
+// proxies, so the first untrusted hop is the client.
+func ClientIPFromContext(ctx context.Context, trusted TrustedProxies) string {
+	peerIP := peerIPFromContext(ctx)
+	if peerIP == "" || !trusted.Contains(peerIP) {
+		return peerIP
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if realIP := md.Get("x-real-ip"); len(realIP) > 0 && strings.TrimSpace(realIP[0]) != "" {
+			return strings.TrimSpace(realIP[0])
+		}
+		var hops []string
+		for _, forwarded := range md.Get("x-forwarded-for") {
+			hops = append(hops, strings.Split(forwarded, ",")...)
+		}
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop != "" && !trusted.Contains(hop) {
+				return hop
+			}
+		}
+	}
+	return peerIP
+}
+
+func peerIPFromContext(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
@@ -0,0 +1,24 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// GenSecureToken returns n random bytes encoded as unpadded url-safe base64
+func GenSecureToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// HashToken returns the hex encoded SHA-256 of a high entropy token.
+// Unlike passwords these don't need a slow hash, so lookups stay cheap.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
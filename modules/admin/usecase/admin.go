@@ -0,0 +1,70 @@
+package usecase
+
+import (
+	"log/slog"
+	"net/http"
+
+	log "github.com/bozoteam/roshan/adapter/log"
+	chatUsecase "github.com/bozoteam/roshan/modules/chat/usecase"
+	gameUsecase "github.com/bozoteam/roshan/modules/game/usecase"
+	ws_hub "github.com/bozoteam/roshan/modules/websocket/hub"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminUsecase serves the operator endpoints under /api/v1/admin
+type AdminUsecase struct {
+	logger      *slog.Logger
+	chatUsecase *chatUsecase.ChatUsecase
+	gameUsecase *gameUsecase.GameUsecase
+}
+
+func NewAdminUsecase(chatUsecase *chatUsecase.ChatUsecase, gameUsecase *gameUsecase.GameUsecase) *AdminUsecase {
+	return &AdminUsecase{
+		logger:      log.LogWithModule("admin_usecase"),
+		chatUsecase: chatUsecase,
+		gameUsecase: gameUsecase,
+	}
+}
+
+// RoomSummary is what operators see of a room, without the users themselves
+type RoomSummary struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	CreatorId   string `json:"creator_id"`
+	Connections int    `json:"connections"`
+}
+
+func summarize(room *ws_hub.RoomSnapshot) RoomSummary {
+	return RoomSummary{
+		Id:          room.Id,
+		Name:        room.Name,
+		Kind:        room.Kind,
+		CreatorId:   room.CreatorId,
+		Connections: len(room.Members),
+	}
+}
+
+// ListRooms returns the open rooms of every hub
+func (u *AdminUsecase) ListRooms(ctx *gin.Context) {
+	chatRooms, err := u.chatUsecase.ListRooms(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list chat rooms"})
+		return
+	}
+	gameRooms, err := u.gameUsecase.ListRooms(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list game rooms"})
+		return
+	}
+
+	rooms := make([]RoomSummary, 0, len(chatRooms)+len(gameRooms))
+	for _, room := range chatRooms {
+		rooms = append(rooms, summarize(room.RoomSnapshot))
+	}
+	for _, room := range gameRooms {
+		rooms = append(rooms, summarize(room.RoomSnapshot))
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"rooms": rooms})
+}
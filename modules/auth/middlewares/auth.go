@@ -3,81 +3,209 @@ package middlewares
 import (
 	"context"
 	"log/slog"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/bozoteam/roshan/adapter/log"
+	authModel "github.com/bozoteam/roshan/modules/auth/models"
+	apiKeyRepository "github.com/bozoteam/roshan/modules/auth/repository/apikey"
 	jwtRepository "github.com/bozoteam/roshan/modules/auth/repository/jwt"
+	ticketRepository "github.com/bozoteam/roshan/modules/auth/repository/ticket"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
 	userRepository "github.com/bozoteam/roshan/modules/user/repository"
 	"github.com/bozoteam/roshan/roshan_errors"
 	"github.com/gin-gonic/gin"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+const schemeApiKey = "apikey"
+
 type AuthMiddleware struct {
 	logger             *slog.Logger
 	jwtRepository      *jwtRepository.JWTRepository
-	userRepository     *userRepository.UserRepository
+	userRepository     userRepository.UserRepository
+	apiKeyRepository   apiKeyRepository.ApiKeyRepository
+	ticketRepository   *ticketRepository.TicketRepository
 	blacklistedMethods map[string]struct{}
 }
 
-func NewAuthMiddleware(jwtRepository *jwtRepository.JWTRepository, userRepository *userRepository.UserRepository, blacklistedMethods map[string]struct{}) *AuthMiddleware {
-	return &AuthMiddleware{jwtRepository: jwtRepository, userRepository: userRepository, logger: log.LogWithModule("auth_middleware"), blacklistedMethods: blacklistedMethods}
+func NewAuthMiddleware(
+	jwtRepository *jwtRepository.JWTRepository,
+	userRepository userRepository.UserRepository,
+	apiKeyRepository apiKeyRepository.ApiKeyRepository,
+	ticketRepository *ticketRepository.TicketRepository,
+	blacklistedMethods map[string]struct{},
+) *AuthMiddleware {
+	return &AuthMiddleware{
+		jwtRepository:      jwtRepository,
+		userRepository:     userRepository,
+		apiKeyRepository:   apiKeyRepository,
+		ticketRepository:   ticketRepository,
+		logger:             log.LogWithModule("auth_middleware"),
+		blacklistedMethods: blacklistedMethods,
+	}
 }
 
-func authErrorLikeGRPC(err error) gin.H {
-	return gin.H{
-		"code":    16,
+func authErrorLikeGRPC(err error) (int, gin.H) {
+	httpStatus := http.StatusUnauthorized
+	code := status.Code(err)
+	if code == codes.PermissionDenied {
+		httpStatus = http.StatusForbidden
+	}
+
+	return httpStatus, gin.H{
+		"code":    int(code),
 		"message": err.Error(),
 		"details": []any{},
 	}
 }
 
+// credential is what the client presented, a JWT access token unless the scheme says otherwise
+type credential struct {
+	scheme string
+	value  string
+}
+
+func parseAuthorization(header string) (credential, error) {
+	tokenParts := strings.Split(header, " ")
+	if len(tokenParts) != 2 {
+		return credential{}, roshan_errors.ErrWrongTokenFormat
+	}
+	return credential{scheme: strings.ToLower(tokenParts[0]), value: tokenParts[1]}, nil
+}
+
+// methodScope maps a gRPC method such as /chat.ChatService/SendMessage to the "chat" scope
+func methodScope(fullMethod string) string {
+	service, _, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	service = service[strings.LastIndex(service, ".")+1:]
+	return strings.ToLower(strings.TrimSuffix(service, "Service"))
+}
+
+// pathScope maps an HTTP route such as /api/v1/chat/rooms/:id/ws to the "chat" scope
+func pathScope(path string) string {
+	scope, _, _ := strings.Cut(strings.TrimPrefix(path, "/api/v1/"), "/")
+	return scope
+}
+
+// authenticate resolves a credential to its user. For API keys the key is returned too,
+// and it must have been granted the scope of the service being called.
+func (m *AuthMiddleware) authenticate(ctx context.Context, cred credential, scope string) (*userModel.User, *authModel.ApiKey, error) {
+	if cred.scheme == schemeApiKey {
+		key, err := m.apiKeyRepository.ValidateApiKey(ctx, cred.value, time.Now())
+		if err != nil {
+			return nil, nil, roshan_errors.ErrInvalidToken
+		}
+
+		if !key.HasScope(scope) {
+			m.logger.WarnContext(ctx, "api key used outside of its scopes", "api_key_id", key.Id, "scope", scope)
+			return nil, nil, roshan_errors.ErrInsufficientScope
+		}
+
+		user, err := m.userRepository.FindUserById(ctx, key.UserId)
+		// disabled accounts lose access right away, whatever they still hold
+		if err != nil || user.IsDisabled() {
+			return nil, nil, roshan_errors.ErrInvalidToken
+		}
+		return user, key, nil
+	}
+
+	_, claims, err := m.jwtRepository.ValidateToken(cred.value, jwtRepository.ACCESS_TOKEN)
+	if err != nil {
+		return nil, nil, roshan_errors.ErrInvalidToken
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil {
+		return nil, nil, roshan_errors.ErrInvalidToken
+	}
+
+	user, err := m.userRepository.FindUserById(ctx, subject)
+	if err != nil || user.IsDisabled() {
+		return nil, nil, roshan_errors.ErrInvalidToken
+	}
+	return user, nil, nil
+}
+
+// authenticateTicket resolves a WebSocket ticket, which is only valid for the room it was issued for
+func (m *AuthMiddleware) authenticateTicket(ctx context.Context, ticket string, roomId string) (*userModel.User, error) {
+	userId, ok := m.ticketRepository.Consume(ticket, roomId)
+	if !ok {
+		return nil, roshan_errors.ErrInvalidToken
+	}
+
+	user, err := m.userRepository.FindUserById(ctx, userId)
+	if err != nil || user.IsDisabled() {
+		return nil, roshan_errors.ErrInvalidToken
+	}
+	return user, nil
+}
+
 func (m *AuthMiddleware) AuthMiddleware() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		var token string
+		// Browsers can't set headers on WebSocket upgrades, they present a ticket instead
+		if ticket := ctx.Query("ticket"); ticket != "" {
+			user, err := m.authenticateTicket(ctx.Request.Context(), ticket, ctx.Param("id"))
+			if err != nil {
+				ctx.AbortWithStatusJSON(authErrorLikeGRPC(err))
+				return
+			}
+
+			ctx.Set("user", user)
+			ctx.Next()
+			return
+		}
+
+		var cred credential
 		tokenHeader := ctx.GetHeader("authorization")
 
 		// First try authorization header
 		if tokenHeader != "" {
-			tokenParts := strings.Split(tokenHeader, " ")
-			if len(tokenParts) != 2 {
-				m.logger.Error("invalid authorization header format")
-				ctx.AbortWithStatusJSON(401, authErrorLikeGRPC(roshan_errors.ErrWrongTokenFormat))
+			var err error
+			cred, err = parseAuthorization(tokenHeader)
+			if err != nil {
+				m.logger.ErrorContext(ctx.Request.Context(), "invalid authorization header format")
+				ctx.AbortWithStatusJSON(authErrorLikeGRPC(err))
 				return
 			}
-			token = tokenParts[1]
 		} else {
 			// Fallback to cookie
 			cookie, err := ctx.Cookie("access_token")
 			if err != nil {
-				m.logger.Error("missing authorization header and cookie")
-				ctx.AbortWithStatusJSON(401, authErrorLikeGRPC(roshan_errors.ErrMissingToken))
+				m.logger.ErrorContext(ctx.Request.Context(), "missing authorization header and cookie")
+				ctx.AbortWithStatusJSON(authErrorLikeGRPC(roshan_errors.ErrMissingToken))
 				return
 			}
-			token = cookie
+			cred = credential{value: cookie}
 		}
 
 		// Validate token and proceed
-		_, claims, err := m.jwtRepository.ValidateToken(token, jwtRepository.ACCESS_TOKEN)
+		user, apiKey, err := m.authenticate(ctx.Request.Context(), cred, pathScope(ctx.Request.URL.Path))
 		if err != nil {
-			ctx.AbortWithStatusJSON(401, authErrorLikeGRPC(roshan_errors.ErrInvalidToken))
+			ctx.AbortWithStatusJSON(authErrorLikeGRPC(err))
 			return
 		}
 
-		subject, err := claims.GetSubject()
-		if err != nil {
-			ctx.AbortWithStatusJSON(401, authErrorLikeGRPC(roshan_errors.ErrInvalidToken))
-			return
+		ctx.Set("user", user)
+		if apiKey != nil {
+			ctx.Set("api_key", apiKey)
 		}
+		ctx.Next()
+	}
+}
 
-		user, err := m.userRepository.FindUserById(subject)
-		if err != nil {
-			ctx.AbortWithStatusJSON(401, authErrorLikeGRPC(roshan_errors.ErrInvalidToken))
+// RequireAdmin lets only admins through, it goes after AuthMiddleware. API keys never
+// reach it since no key can be granted the admin scope.
+func (m *AuthMiddleware) RequireAdmin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user := ctx.MustGet("user").(*userModel.User)
+		if !user.IsAdmin() {
+			ctx.AbortWithStatusJSON(authErrorLikeGRPC(roshan_errors.ErrAdminRequired))
 			return
 		}
-
-		ctx.Set("user", user)
 		ctx.Next()
 	}
 }
@@ -89,64 +217,55 @@ func (m *AuthMiddleware) UnaryInterceptor(ctx context.Context, req any, info *gr
 
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		m.logger.Error("missing metadata")
+		m.logger.ErrorContext(ctx, "missing metadata")
 		return nil, roshan_errors.ErrMissingToken
 	}
 
-	var token string
+	var cred credential
 
 	// First try authorization header
 	authorization, ok := md["authorization"]
 	if ok {
 		if len(authorization) != 1 {
-			m.logger.Error("invalid authorization header format")
+			m.logger.ErrorContext(ctx, "invalid authorization header format")
 			return nil, roshan_errors.ErrWrongTokenFormat
 		}
 
-		tokenParts := strings.Split(authorization[0], " ")
-		if len(tokenParts) == 2 {
-			token = tokenParts[1]
-		} else {
-			return nil, roshan_errors.ErrWrongTokenFormat
+		cred, err = parseAuthorization(authorization[0])
+		if err != nil {
+			return nil, err
 		}
 	} else {
 		// Fallback to cookie
 		cookies, hasCookies := md["cookie"]
 		if !hasCookies {
-			m.logger.Error("missing authorization header and cookie")
+			m.logger.ErrorContext(ctx, "missing authorization header and cookie")
 			return nil, roshan_errors.ErrMissingToken
 		}
 
 		// Parse access_token from cookies
 		for _, c := range cookies {
 			if strings.HasPrefix(c, "access_token=") {
-				token = strings.TrimPrefix(c, "access_token=")
+				cred.value = strings.TrimPrefix(c, "access_token=")
 				break
 			}
 		}
 
-		if token == "" {
-			m.logger.Error("access_token cookie not found")
+		if cred.value == "" {
+			m.logger.ErrorContext(ctx, "access_token cookie not found")
 			return nil, roshan_errors.ErrMissingToken
 		}
 	}
 
 	// Validate token and proceed
-	_, claims, err := m.jwtRepository.ValidateToken(token, jwtRepository.ACCESS_TOKEN)
+	user, apiKey, err := m.authenticate(ctx, cred, methodScope(info.FullMethod))
 	if err != nil {
-		return nil, roshan_errors.ErrInvalidToken
-	}
-
-	subject, err := claims.GetSubject()
-	if err != nil {
-		return nil, roshan_errors.ErrInvalidToken
-	}
-
-	user, err := m.userRepository.FindUserById(subject)
-	if err != nil {
-		return nil, roshan_errors.ErrInvalidToken
+		return nil, err
 	}
 
 	ctx = context.WithValue(ctx, "user", user)
+	if apiKey != nil {
+		ctx = context.WithValue(ctx, "api_key", apiKey)
+	}
 	return handler(ctx, req)
 }
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeUser = "user"
+	ScopeChat = "chat"
+	ScopeGame = "game"
+)
+
+// ApiKeyScopes lists the scopes an API key can be granted, one per service
+var ApiKeyScopes = []string{ScopeUser, ScopeChat, ScopeGame}
+
+// ApiKey is a long lived, user scoped credential for bots and scripts.
+// Only the hash of the key is stored; the prefix is kept in clear to find it.
+type ApiKey struct {
+	Id         string `gorm:"primaryKey"`
+	UserId     string `gorm:"not null"`
+	Name       string `gorm:"type:varchar(255);not null"`
+	Prefix     string `gorm:"type:varchar(16);unique;not null"`
+	Hash       string `gorm:"type:varchar(64);not null"`
+	Scopes     string `gorm:"type:varchar(255);not null"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+func (ApiKey) TableName() string {
+	return "api_key"
+}
+
+func (k *ApiKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+func (k *ApiKey) HasScope(scope string) bool {
+	return slices.Contains(k.ScopeList(), scope)
+}
+
+func (k *ApiKey) IsActive(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || k.ExpiresAt.After(now)
+}
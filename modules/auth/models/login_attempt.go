@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+type AttemptKind = string
+
+const (
+	AttemptKindAccount AttemptKind = "account"
+	AttemptKindIP      AttemptKind = "ip"
+)
+
+// LoginAttempt tracks consecutive failed logins for an account or a client IP
+type LoginAttempt struct {
+	Kind         AttemptKind `gorm:"type:varchar(16);primaryKey"`
+	Subject      string      `gorm:"type:varchar(255);primaryKey"`
+	FailedCount  int         `gorm:"not null;default:0"`
+	LastFailedAt time.Time   `gorm:"not null"`
+	LockedUntil  *time.Time
+}
+
+func (LoginAttempt) TableName() string {
+	return "login_attempt"
+}
+
+func (a *LoginAttempt) IsLocked(now time.Time) bool {
+	return a.LockedUntil != nil && a.LockedUntil.After(now)
+}
+
+type LockoutEventKind = string
+
+const (
+	LockoutEventLocked   LockoutEventKind = "locked"
+	LockoutEventUnlocked LockoutEventKind = "unlocked"
+)
+
+// LockoutEvent is the audit trail entry written whenever a subject is locked or unlocked
+type LockoutEvent struct {
+	Id          string           `gorm:"primaryKey"`
+	Kind        AttemptKind      `gorm:"type:varchar(16);not null"`
+	Subject     string           `gorm:"type:varchar(255);not null"`
+	Event       LockoutEventKind `gorm:"type:varchar(16);not null"`
+	LockedUntil *time.Time
+	ActorId     *string
+	CreatedAt   time.Time
+}
+
+func (LockoutEvent) TableName() string {
+	return "lockout_event"
+}
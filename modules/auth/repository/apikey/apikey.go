@@ -0,0 +1,146 @@
+package apiKeyRepository
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	log "github.com/bozoteam/roshan/adapter/log"
+	"github.com/bozoteam/roshan/helpers"
+	"github.com/bozoteam/roshan/modules/auth/models"
+	"gorm.io/gorm"
+)
+
+const (
+	keyPrefix   = "roshan"
+	prefixBytes = 6
+	secretBytes = 32
+	touchEvery  = time.Minute
+)
+
+var prefixLen = base64.RawURLEncoding.EncodedLen(prefixBytes)
+
+var (
+	ErrInvalidApiKey = errors.New("invalid api key")
+	ErrApiKeyExpired = errors.New("api key expired or revoked")
+)
+
+// ApiKeyRepository stores API keys, only ever by hash
+type ApiKeyRepository interface {
+	SaveApiKey(ctx context.Context, key *models.ApiKey) error
+	ListApiKeysByUser(ctx context.Context, userId string) ([]*models.ApiKey, error)
+	CountActiveApiKeys(ctx context.Context, userId string, now time.Time) (int64, error)
+	FindApiKeyById(ctx context.Context, userId string, id string) (*models.ApiKey, error)
+	RevokeApiKey(ctx context.Context, key *models.ApiKey, now time.Time) error
+	// ValidateApiKey resolves a plaintext key to its stored record
+	ValidateApiKey(ctx context.Context, plaintext string, now time.Time) (*models.ApiKey, error)
+}
+
+var _ ApiKeyRepository = (*GormApiKeyRepository)(nil)
+
+func NewApiKeyRepository(db *gorm.DB) *GormApiKeyRepository {
+	return &GormApiKeyRepository{db: db, logger: log.LogWithModule("api_key_repository")}
+}
+
+// GormApiKeyRepository is the ApiKeyRepository backed by Postgres
+type GormApiKeyRepository struct {
+	logger *slog.Logger
+	db     *gorm.DB
+}
+
+// GenerateKey returns a new plaintext key along with its lookup prefix and hash.
+// Keys look like roshan_<prefix>_<secret>.
+func GenerateKey() (key string, prefix string, hash string) {
+	prefix = helpers.GenSecureToken(prefixBytes)
+	key = keyPrefix + "_" + prefix + "_" + helpers.GenSecureToken(secretBytes)
+	return key, prefix, helpers.HashToken(key)
+}
+
+func parsePrefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, keyPrefix+"_")
+	if !ok {
+		return "", false
+	}
+	// the prefix is base64url encoded and can itself contain '_', so it is cut by length
+	if len(rest) <= prefixLen+1 || rest[prefixLen] != '_' {
+		return "", false
+	}
+	return rest[:prefixLen], true
+}
+
+// checkKey tells whether plaintext is the key stored as key and can still be used
+func checkKey(key *models.ApiKey, plaintext string, now time.Time) error {
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(helpers.HashToken(plaintext))) != 1 {
+		return ErrInvalidApiKey
+	}
+	if !key.IsActive(now) {
+		return ErrApiKeyExpired
+	}
+	return nil
+}
+
+func (r *GormApiKeyRepository) SaveApiKey(ctx context.Context, key *models.ApiKey) error {
+	return r.db.WithContext(ctx).Create(key).Error
+}
+
+func (r *GormApiKeyRepository) ListApiKeysByUser(ctx context.Context, userId string) ([]*models.ApiKey, error) {
+	var keys []*models.ApiKey
+	err := r.db.WithContext(ctx).Where("user_id = ? AND revoked_at IS NULL", userId).Order("created_at").Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *GormApiKeyRepository) CountActiveApiKeys(ctx context.Context, userId string, now time.Time) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.ApiKey{}).
+		Where("user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", userId, now).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *GormApiKeyRepository) FindApiKeyById(ctx context.Context, userId string, id string) (*models.ApiKey, error) {
+	var key models.ApiKey
+	if err := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userId).First(&key).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *GormApiKeyRepository) RevokeApiKey(ctx context.Context, key *models.ApiKey, now time.Time) error {
+	if err := r.db.WithContext(ctx).Model(key).Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	key.RevokedAt = &now
+	return nil
+}
+
+func (r *GormApiKeyRepository) ValidateApiKey(ctx context.Context, plaintext string, now time.Time) (*models.ApiKey, error) {
+	prefix, ok := parsePrefix(plaintext)
+	if !ok {
+		return nil, ErrInvalidApiKey
+	}
+
+	var key models.ApiKey
+	if err := r.db.WithContext(ctx).Where("prefix = ?", prefix).First(&key).Error; err != nil {
+		return nil, ErrInvalidApiKey
+	}
+
+	if err := checkKey(&key, plaintext, now); err != nil {
+		return nil, err
+	}
+
+	// last_used_at is informational, avoid a write on every single request
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > touchEvery {
+		if err := r.db.WithContext(ctx).Model(&key).Update("last_used_at", now).Error; err != nil {
+			r.logger.ErrorContext(ctx, "failed to update api key usage", "error", err, "api_key_id", key.Id)
+		}
+	}
+
+	return &key, nil
+}
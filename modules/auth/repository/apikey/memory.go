@@ -0,0 +1,118 @@
+package apiKeyRepository
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bozoteam/roshan/helpers"
+	"github.com/bozoteam/roshan/modules/auth/models"
+	"gorm.io/gorm"
+)
+
+var _ ApiKeyRepository = (*MemoryApiKeyRepository)(nil)
+
+// MemoryApiKeyRepository is a thread-safe ApiKeyRepository kept in memory
+type MemoryApiKeyRepository struct {
+	mu   sync.RWMutex
+	keys map[string]models.ApiKey
+}
+
+func NewMemoryApiKeyRepository() *MemoryApiKeyRepository {
+	return &MemoryApiKeyRepository{keys: map[string]models.ApiKey{}}
+}
+
+func (r *MemoryApiKeyRepository) SaveApiKey(ctx context.Context, key *models.ApiKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, other := range r.keys {
+		if other.Prefix == key.Prefix {
+			return helpers.NewUniqueViolation("unique_api_key_prefix")
+		}
+	}
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	r.keys[key.Id] = *key
+	return nil
+}
+
+func (r *MemoryApiKeyRepository) ListApiKeysByUser(ctx context.Context, userId string) ([]*models.ApiKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := []*models.ApiKey{}
+	for _, key := range r.keys {
+		if key.UserId == userId && key.RevokedAt == nil {
+			keys = append(keys, &key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b *models.ApiKey) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.Id, b.Id))
+	})
+	return keys, nil
+}
+
+func (r *MemoryApiKeyRepository) CountActiveApiKeys(ctx context.Context, userId string, now time.Time) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var count int64
+	for _, key := range r.keys {
+		if key.UserId == userId && key.IsActive(now) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *MemoryApiKeyRepository) FindApiKeyById(ctx context.Context, userId string, id string) (*models.ApiKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := r.keys[id]
+	if !ok || key.UserId != userId {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &key, nil
+}
+
+func (r *MemoryApiKeyRepository) RevokeApiKey(ctx context.Context, key *models.ApiKey, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, ok := r.keys[key.Id]; ok {
+		stored.RevokedAt = &now
+		r.keys[key.Id] = stored
+	}
+	key.RevokedAt = &now
+	return nil
+}
+
+func (r *MemoryApiKeyRepository) ValidateApiKey(ctx context.Context, plaintext string, now time.Time) (*models.ApiKey, error) {
+	prefix, ok := parsePrefix(plaintext)
+	if !ok {
+		return nil, ErrInvalidApiKey
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, key := range r.keys {
+		if key.Prefix != prefix {
+			continue
+		}
+		if err := checkKey(&key, plaintext, now); err != nil {
+			return nil, err
+		}
+		if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > touchEvery {
+			key.LastUsedAt = &now
+			r.keys[id] = key
+		}
+		return &key, nil
+	}
+	return nil, ErrInvalidApiKey
+}
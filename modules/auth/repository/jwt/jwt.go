@@ -4,6 +4,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/bozoteam/roshan/adapter/config"
 	"github.com/bozoteam/roshan/helpers"
 	"github.com/bozoteam/roshan/modules/user/models"
 	"github.com/golang-jwt/jwt/v5"
@@ -16,12 +17,12 @@ const (
 	REFRESH_TOKEN TokenType = 1
 )
 
-func NewJWTRepository() *JWTRepository {
+func NewJWTRepository(cfg config.JWTConfig) *JWTRepository {
 	return &JWTRepository{
-		secretKey:            []byte(helpers.GetEnv("JWT_SECRET")),
-		refreshSecretKey:     []byte(helpers.GetEnv("JWT_REFRESH_SECRET")),
-		tokenDuration:        time.Duration(helpers.GetEnvAsInt("JWT_TOKEN_EXPIRATION")) * time.Second,
-		refreshTokenDuration: time.Duration(helpers.GetEnvAsInt("JWT_REFRESH_TOKEN_EXPIRATION")) * time.Second,
+		secretKey:            []byte(cfg.Secret),
+		refreshSecretKey:     []byte(cfg.RefreshSecret),
+		tokenDuration:        cfg.TokenExpiration,
+		refreshTokenDuration: cfg.RefreshTokenExpiration,
 		issuer:               "roshan",
 	}
 }
@@ -80,6 +81,17 @@ func (r *JWTRepository) GenerateAccessAndRefreshTokens(user *models.User) (*Toke
 	}, nil
 }
 
+// GenerateAccessToken signs a lone access token valid for ttl, with no refresh token to go
+// with it. A zero ttl means the configured lifetime.
+func (r *JWTRepository) GenerateAccessToken(user *models.User, ttl time.Duration) (string, error) {
+	if ttl == 0 {
+		return r.generateToken(user, ACCESS_TOKEN, time.Now())
+	}
+	custom := *r
+	custom.tokenDuration = ttl
+	return custom.generateToken(user, ACCESS_TOKEN, time.Now())
+}
+
 func (r *JWTRepository) generateToken(user *models.User, tokenType TokenType, now time.Time) (string, error) {
 	uuid := helpers.GenUUID()
 
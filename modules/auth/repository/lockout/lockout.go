@@ -0,0 +1,138 @@
+package lockoutRepository
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	log "github.com/bozoteam/roshan/adapter/log"
+	"github.com/bozoteam/roshan/helpers"
+	"github.com/bozoteam/roshan/modules/auth/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LockoutRepository keeps the failed login counters and the audit trail of lockouts
+type LockoutRepository interface {
+	// FindAttempt returns the failure counter for a subject, or nil when it has none
+	FindAttempt(ctx context.Context, kind models.AttemptKind, subject string) (*models.LoginAttempt, error)
+	// RegisterAttempt counts an attempt as a failure before its password is checked, unless
+	// allow turns it down given the counter as it stands. Checking and counting are one atomic
+	// step, so concurrent attempts see each other. Counters whose last failure is older than
+	// window are handed to allow as zero. The counter is returned either way.
+	RegisterAttempt(ctx context.Context, kind models.AttemptKind, subject string, now time.Time, window time.Duration, allow func(attempt *models.LoginAttempt) bool) (attempt *models.LoginAttempt, allowed bool, err error)
+	// ReleaseAttempt takes back an attempt registered for a login that succeeded
+	ReleaseAttempt(ctx context.Context, kind models.AttemptKind, subject string) error
+	// Lock marks a subject as locked until the given time and records it in the audit trail
+	Lock(ctx context.Context, attempt *models.LoginAttempt, until time.Time) error
+	// ResetAttempts clears the failure counter of a subject after a successful login
+	ResetAttempts(ctx context.Context, kind models.AttemptKind, subject string) error
+	// Unlock clears the failure counter of a subject and records who lifted the lock
+	Unlock(ctx context.Context, kind models.AttemptKind, subject string, actorId string) error
+}
+
+var _ LockoutRepository = (*GormLockoutRepository)(nil)
+
+func NewLockoutRepository(db *gorm.DB) *GormLockoutRepository {
+	return &GormLockoutRepository{db: db, logger: log.LogWithModule("lockout_repository")}
+}
+
+// GormLockoutRepository is the LockoutRepository backed by Postgres, so counters survive restarts
+type GormLockoutRepository struct {
+	logger *slog.Logger
+	db     *gorm.DB
+}
+
+func (r *GormLockoutRepository) FindAttempt(ctx context.Context, kind models.AttemptKind, subject string) (*models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	err := r.db.WithContext(ctx).First(&attempt, "kind = ? AND subject = ?", kind, subject).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+func (r *GormLockoutRepository) RegisterAttempt(ctx context.Context, kind models.AttemptKind, subject string, now time.Time, window time.Duration, allow func(attempt *models.LoginAttempt) bool) (*models.LoginAttempt, bool, error) {
+	var attempt models.LoginAttempt
+	allowed := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// the row exists before it's locked, so attempts on a new subject queue up behind each other too
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.LoginAttempt{Kind: kind, Subject: subject, LastFailedAt: now}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&attempt, "kind = ? AND subject = ?", kind, subject).Error
+		if err != nil {
+			return err
+		}
+		if attempt.LastFailedAt.Before(now.Add(-window)) {
+			attempt.FailedCount = 0
+		}
+		if !allow(&attempt) {
+			return nil
+		}
+		allowed = true
+
+		return tx.Model(&attempt).Clauses(clause.Returning{}).
+			Where("kind = ? AND subject = ?", kind, subject).
+			Updates(map[string]any{"failed_count": attempt.FailedCount + 1, "last_failed_at": now}).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return &attempt, allowed, nil
+}
+
+func (r *GormLockoutRepository) ReleaseAttempt(ctx context.Context, kind models.AttemptKind, subject string) error {
+	return r.db.WithContext(ctx).Model(&models.LoginAttempt{}).
+		Where("kind = ? AND subject = ? AND failed_count > 0", kind, subject).
+		Update("failed_count", gorm.Expr("failed_count - 1")).Error
+}
+
+func (r *GormLockoutRepository) Lock(ctx context.Context, attempt *models.LoginAttempt, until time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.LoginAttempt{}).
+			Where("kind = ? AND subject = ?", attempt.Kind, attempt.Subject).
+			Update("locked_until", until).Error
+		if err != nil {
+			return err
+		}
+		attempt.LockedUntil = &until
+
+		return tx.Create(&models.LockoutEvent{
+			Id:          helpers.GenUUID(),
+			Kind:        attempt.Kind,
+			Subject:     attempt.Subject,
+			Event:       models.LockoutEventLocked,
+			LockedUntil: &until,
+		}).Error
+	})
+}
+
+func (r *GormLockoutRepository) ResetAttempts(ctx context.Context, kind models.AttemptKind, subject string) error {
+	return r.db.WithContext(ctx).Where("kind = ? AND subject = ?", kind, subject).Delete(&models.LoginAttempt{}).Error
+}
+
+func (r *GormLockoutRepository) Unlock(ctx context.Context, kind models.AttemptKind, subject string, actorId string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("kind = ? AND subject = ?", kind, subject).Delete(&models.LoginAttempt{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&models.LockoutEvent{
+			Id:      helpers.GenUUID(),
+			Kind:    kind,
+			Subject: subject,
+			Event:   models.LockoutEventUnlocked,
+			ActorId: &actorId,
+		}).Error
+	})
+}
@@ -0,0 +1,127 @@
+package lockoutRepository
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bozoteam/roshan/helpers"
+	"github.com/bozoteam/roshan/modules/auth/models"
+)
+
+var _ LockoutRepository = (*MemoryLockoutRepository)(nil)
+
+type attemptKey struct {
+	kind    models.AttemptKind
+	subject string
+}
+
+// MemoryLockoutRepository is a thread-safe LockoutRepository kept in memory. Its counters
+// are lost on restart, so it is only meant for tests.
+type MemoryLockoutRepository struct {
+	mu       sync.Mutex
+	attempts map[attemptKey]models.LoginAttempt
+	events   []models.LockoutEvent
+}
+
+func NewMemoryLockoutRepository() *MemoryLockoutRepository {
+	return &MemoryLockoutRepository{attempts: map[attemptKey]models.LoginAttempt{}}
+}
+
+func (r *MemoryLockoutRepository) FindAttempt(ctx context.Context, kind models.AttemptKind, subject string) (*models.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempt, ok := r.attempts[attemptKey{kind, subject}]
+	if !ok {
+		return nil, nil
+	}
+	return &attempt, nil
+}
+
+func (r *MemoryLockoutRepository) RegisterAttempt(ctx context.Context, kind models.AttemptKind, subject string, now time.Time, window time.Duration, allow func(attempt *models.LoginAttempt) bool) (*models.LoginAttempt, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := attemptKey{kind, subject}
+	attempt, ok := r.attempts[key]
+	if !ok {
+		attempt = models.LoginAttempt{Kind: kind, Subject: subject, LastFailedAt: now}
+	}
+	if attempt.LastFailedAt.Before(now.Add(-window)) {
+		attempt.FailedCount = 0
+	}
+	if !allow(&attempt) {
+		return &attempt, false, nil
+	}
+
+	attempt.FailedCount++
+	attempt.LastFailedAt = now
+	r.attempts[key] = attempt
+	return &attempt, true, nil
+}
+
+func (r *MemoryLockoutRepository) ReleaseAttempt(ctx context.Context, kind models.AttemptKind, subject string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := attemptKey{kind, subject}
+	if attempt, ok := r.attempts[key]; ok && attempt.FailedCount > 0 {
+		attempt.FailedCount--
+		r.attempts[key] = attempt
+	}
+	return nil
+}
+
+func (r *MemoryLockoutRepository) Lock(ctx context.Context, attempt *models.LoginAttempt, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := attemptKey{attempt.Kind, attempt.Subject}
+	if stored, ok := r.attempts[key]; ok {
+		stored.LockedUntil = &until
+		r.attempts[key] = stored
+	}
+	attempt.LockedUntil = &until
+
+	r.events = append(r.events, models.LockoutEvent{
+		Id:          helpers.GenUUID(),
+		Kind:        attempt.Kind,
+		Subject:     attempt.Subject,
+		Event:       models.LockoutEventLocked,
+		LockedUntil: &until,
+		CreatedAt:   time.Now(),
+	})
+	return nil
+}
+
+func (r *MemoryLockoutRepository) ResetAttempts(ctx context.Context, kind models.AttemptKind, subject string) error {
+	r.mu.Lock()
+	delete(r.attempts, attemptKey{kind, subject})
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *MemoryLockoutRepository) Unlock(ctx context.Context, kind models.AttemptKind, subject string, actorId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.attempts, attemptKey{kind, subject})
+	r.events = append(r.events, models.LockoutEvent{
+		Id:        helpers.GenUUID(),
+		Kind:      kind,
+		Subject:   subject,
+		Event:     models.LockoutEventUnlocked,
+		ActorId:   &actorId,
+		CreatedAt: time.Now(),
+	})
+	return nil
+}
+
+// Events returns the audit trail recorded so far, oldest first
+func (r *MemoryLockoutRepository) Events() []models.LockoutEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.events)
+}
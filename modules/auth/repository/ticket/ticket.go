@@ -0,0 +1,71 @@
+package ticketRepository
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bozoteam/roshan/helpers"
+)
+
+type ticket struct {
+	userId    string
+	roomId    string
+	expiresAt time.Time
+}
+
+// TicketRepository keeps short lived, single use WebSocket tickets in memory.
+// Tickets are stored by hash, the plaintext only ever exists on the client.
+type TicketRepository struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	tickets map[string]ticket
+}
+
+func NewTicketRepository(ttl time.Duration) *TicketRepository {
+	return &TicketRepository{
+		ttl:     ttl,
+		tickets: make(map[string]ticket),
+	}
+}
+
+func (r *TicketRepository) TTL() time.Duration {
+	return r.ttl
+}
+
+// Issue creates a ticket that lets userId join roomId once
+func (r *TicketRepository) Issue(userId string, roomId string) string {
+	plaintext := helpers.GenSecureToken(32)
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, t := range r.tickets {
+		if now.After(t.expiresAt) {
+			delete(r.tickets, hash)
+		}
+	}
+
+	r.tickets[helpers.HashToken(plaintext)] = ticket{
+		userId:    userId,
+		roomId:    roomId,
+		expiresAt: now.Add(r.ttl),
+	}
+	return plaintext
+}
+
+// Consume redeems a ticket for roomId and returns the user it was issued to.
+// A ticket is burned on first use, even when it was presented for the wrong room.
+func (r *TicketRepository) Consume(plaintext string, roomId string) (string, bool) {
+	hash := helpers.HashToken(plaintext)
+
+	r.mu.Lock()
+	t, ok := r.tickets[hash]
+	delete(r.tickets, hash)
+	r.mu.Unlock()
+
+	if !ok || time.Now().After(t.expiresAt) || t.roomId != roomId {
+		return "", false
+	}
+	return t.userId, true
+}
@@ -0,0 +1,141 @@
+package usecase
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bozoteam/roshan/helpers"
+	authModel "github.com/bozoteam/roshan/modules/auth/models"
+	apiKeyRepository "github.com/bozoteam/roshan/modules/auth/repository/apikey"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	"github.com/bozoteam/roshan/roshan_errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const maxApiKeysPerUser = 25
+
+var (
+	ErrApiKeyNotFound      = status.Error(codes.NotFound, "api key not found")
+	ErrApiKeyLimitReached  = status.Error(codes.ResourceExhausted, "api key limit reached")
+	ErrInvalidApiKeyScope  = status.Error(codes.InvalidArgument, "invalid api key scope")
+	ErrInvalidApiKeyName   = status.Error(codes.InvalidArgument, "api key name must be between 1 and 255 characters")
+	ErrSessionRequired     = status.Error(codes.PermissionDenied, "api keys cannot do this, sign in with a password or OIDC")
+	ErrApiKeyScopeRequired = status.Error(codes.InvalidArgument, "at least one scope is required")
+)
+
+// ApiKeyCreateInput represents the input for creating an API key
+type ApiKeyCreateInput struct {
+	Name   string
+	Scopes []string
+	// Lifetime of the key in seconds, zero means it never expires
+	ExpiresIn uint64
+}
+
+// CreatedApiKey holds a new key; Key is the plaintext and is never retrievable again
+type CreatedApiKey struct {
+	*authModel.ApiKey
+	Key string
+}
+
+// RequireSession makes sure the caller authenticated with a password or OIDC session,
+// an API key must not be able to mint or revoke other keys, nor take over the account.
+func RequireSession(ctx context.Context) (*userModel.User, error) {
+	if ctx.Value("api_key") != nil {
+		return nil, ErrSessionRequired
+	}
+	return ctx.Value("user").(*userModel.User), nil
+}
+
+func (u *AuthUsecase) CreateApiKey(ctx context.Context, input *ApiKeyCreateInput) (*CreatedApiKey, error) {
+	user, err := RequireSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" || len(name) > 255 {
+		return nil, ErrInvalidApiKeyName
+	}
+
+	if len(input.Scopes) == 0 {
+		return nil, ErrApiKeyScopeRequired
+	}
+	scopes := make([]string, 0, len(input.Scopes))
+	for _, scope := range input.Scopes {
+		if !slices.Contains(authModel.ApiKeyScopes, scope) {
+			return nil, ErrInvalidApiKeyScope
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	now := time.Now()
+	count, err := u.apiKeyRepo.CountActiveApiKeys(ctx, user.Id, now)
+	if err != nil {
+		return nil, roshan_errors.ErrInternalServerError
+	}
+	if count >= maxApiKeysPerUser {
+		return nil, ErrApiKeyLimitReached
+	}
+
+	plaintext, prefix, hash := apiKeyRepository.GenerateKey()
+	key := &authModel.ApiKey{
+		Id:        helpers.GenUUID(),
+		UserId:    user.Id,
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    strings.Join(scopes, " "),
+		CreatedAt: now,
+	}
+	if input.ExpiresIn > 0 {
+		expiresAt := now.Add(time.Duration(input.ExpiresIn) * time.Second)
+		key.ExpiresAt = &expiresAt
+	}
+
+	if err := u.apiKeyRepo.SaveApiKey(ctx, key); err != nil {
+		u.logger.ErrorContext(ctx, "failed to save api key", "error", err)
+		return nil, roshan_errors.ErrInternalServerError
+	}
+
+	u.logger.InfoContext(ctx, "api key created", "user_id", user.Id, "api_key_id", key.Id)
+	return &CreatedApiKey{ApiKey: key, Key: plaintext}, nil
+}
+
+func (u *AuthUsecase) ListApiKeys(ctx context.Context) ([]*authModel.ApiKey, error) {
+	user, err := RequireSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := u.apiKeyRepo.ListApiKeysByUser(ctx, user.Id)
+	if err != nil {
+		return nil, roshan_errors.ErrInternalServerError
+	}
+	return keys, nil
+}
+
+func (u *AuthUsecase) RevokeApiKey(ctx context.Context, id string) (*authModel.ApiKey, error) {
+	user, err := RequireSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := u.apiKeyRepo.FindApiKeyById(ctx, user.Id, id)
+	if err != nil {
+		return nil, ErrApiKeyNotFound
+	}
+
+	if key.RevokedAt == nil {
+		if err := u.apiKeyRepo.RevokeApiKey(ctx, key, time.Now()); err != nil {
+			return nil, roshan_errors.ErrInternalServerError
+		}
+		u.logger.InfoContext(ctx, "api key revoked", "user_id", user.Id, "api_key_id", key.Id)
+	}
+
+	return key, nil
+}
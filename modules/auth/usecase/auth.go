@@ -1,29 +1,62 @@
 package usecase
 
 import (
+	"fmt"
 	"log/slog"
+	"slices"
+	"time"
 
 	"context"
 
+	"github.com/bozoteam/roshan/adapter/config"
 	log "github.com/bozoteam/roshan/adapter/log"
 	"github.com/bozoteam/roshan/helpers"
+	authModel "github.com/bozoteam/roshan/modules/auth/models"
+	apiKeyRepository "github.com/bozoteam/roshan/modules/auth/repository/apikey"
 	jwtRepository "github.com/bozoteam/roshan/modules/auth/repository/jwt"
+	lockoutRepository "github.com/bozoteam/roshan/modules/auth/repository/lockout"
+	ticketRepository "github.com/bozoteam/roshan/modules/auth/repository/ticket"
 	userModel "github.com/bozoteam/roshan/modules/user/models"
 	userRepository "github.com/bozoteam/roshan/modules/user/repository"
 	"github.com/bozoteam/roshan/roshan_errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type AuthUsecase struct {
 	logger        *slog.Logger
 	jwtRepository *jwtRepository.JWTRepository
-	userRepo      *userRepository.UserRepository
+	userRepo      userRepository.UserRepository
+	lockoutRepo   lockoutRepository.LockoutRepository
+	apiKeyRepo    apiKeyRepository.ApiKeyRepository
+	ticketRepo    *ticketRepository.TicketRepository
+	lockoutPolicy LockoutPolicy
+	// trustedProxies may tell the client's address, which IP lockouts go by
+	trustedProxies helpers.TrustedProxies
+	// rooms are where socket tickets can be issued for
+	rooms []RoomFinder
 }
 
-func NewAuthUsecase(userRepository *userRepository.UserRepository, jwtRepository *jwtRepository.JWTRepository) *AuthUsecase {
+func NewAuthUsecase(
+	userRepository userRepository.UserRepository,
+	jwtRepository *jwtRepository.JWTRepository,
+	lockoutRepository lockoutRepository.LockoutRepository,
+	apiKeyRepository apiKeyRepository.ApiKeyRepository,
+	ticketRepository *ticketRepository.TicketRepository,
+	lockoutConfig config.LockoutConfig,
+	trustedProxies helpers.TrustedProxies,
+	rooms []RoomFinder,
+) *AuthUsecase {
 	return &AuthUsecase{
-		logger:        log.LogWithModule("auth_usecase"),
-		jwtRepository: jwtRepository,
-		userRepo:      userRepository,
+		logger:         log.LogWithModule("auth_usecase"),
+		jwtRepository:  jwtRepository,
+		userRepo:       userRepository,
+		lockoutRepo:    lockoutRepository,
+		apiKeyRepo:     apiKeyRepository,
+		ticketRepo:     ticketRepository,
+		lockoutPolicy:  LockoutPolicy(lockoutConfig),
+		trustedProxies: trustedProxies,
+		rooms:          rooms,
 	}
 }
 
@@ -37,17 +70,47 @@ type TokenResponse struct {
 }
 
 func (u *AuthUsecase) Authenticate(ctx context.Context, email string, password string) (*TokenResponse, error) {
-	user, err := u.userRepo.FindUserByEmail(email)
+	now := time.Now()
+	subjects := u.loginSubjects(ctx, email)
+
+	attempts, err := u.startAttempt(ctx, subjects, now)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := u.userRepo.FindUserByEmail(ctx, email)
 	if err != nil || !helpers.CheckPasswordHash(password, user.Password) {
+		u.registerFailure(ctx, attempts, now)
 		return nil, roshan_errors.ErrAuthFailed
 	}
 
+	// Only the account counter is reset, a valid login must not clear failures racked up by its
+	// IP, it only takes back its own attempt
+	if err := u.lockoutRepo.ResetAttempts(ctx, authModel.AttemptKindAccount, normalizeEmail(email)); err != nil {
+		u.logger.ErrorContext(ctx, "failed to reset login attempts", "error", err)
+	}
+	u.releaseAttempt(ctx, slices.DeleteFunc(attempts, func(attempt *authModel.LoginAttempt) bool {
+		return attempt.Kind == authModel.AttemptKindAccount
+	}))
+
+	return u.IssueTokens(ctx, user)
+}
+
+// ErrAccountDisabled is only returned once the password checked out, so it doesn't reveal more than a login would
+var ErrAccountDisabled = status.Error(codes.PermissionDenied, "account is disabled")
+
+// IssueTokens generates a new access/refresh token pair for a user and stores the refresh token
+func (u *AuthUsecase) IssueTokens(ctx context.Context, user *userModel.User) (*TokenResponse, error) {
+	if user.IsDisabled() {
+		return nil, ErrAccountDisabled
+	}
+
 	tokenData, err := u.jwtRepository.GenerateAccessAndRefreshTokens(user)
 	if err != nil {
 		return nil, roshan_errors.ErrInternalServerError
 	}
 
-	err = u.userRepo.SaveRefreshToken(user, tokenData.RefreshToken)
+	err = u.userRepo.SaveRefreshToken(ctx, user, tokenData.RefreshToken)
 	if err != nil {
 		return nil, roshan_errors.ErrInternalServerError
 	}
@@ -61,6 +124,47 @@ func (u *AuthUsecase) Authenticate(ctx context.Context, email string, password s
 	}, nil
 }
 
+// IssueAccessToken mints an access token for any account, for operators debugging as that user.
+// The account's refresh token is left alone so its sessions keep working.
+func (u *AuthUsecase) IssueAccessToken(ctx context.Context, email string, ttl time.Duration) (string, error) {
+	user, err := u.userRepo.FindUserByEmail(ctx, email)
+	if err != nil {
+		return "", roshan_errors.ErrAuthFailed
+	}
+	if user.IsDisabled() {
+		return "", ErrAccountDisabled
+	}
+
+	token, err := u.jwtRepository.GenerateAccessToken(user, ttl)
+	if err != nil {
+		return "", roshan_errors.ErrInternalServerError
+	}
+	u.logger.WarnContext(ctx, "access token issued outside of a login", "user_id", user.Id, "ttl", ttl.String())
+	return token, nil
+}
+
+// AuthCookies returns the Set-Cookie values that hand a token pair over to browsers
+func AuthCookies(token *TokenResponse) []string {
+	return []string{
+		fmt.Sprintf("access_token=%s; HttpOnly; SameSite=Strict; Path=/api; Max-Age=%d",
+			token.AccessToken,
+			token.ExpiresIn,
+		),
+		fmt.Sprintf("refresh_token=%s; HttpOnly; SameSite=Strict; Path=/api/v1/auth/refresh; Max-Age=%d",
+			token.RefreshToken,
+			token.RefreshExpiresIn,
+		),
+	}
+}
+
+// ClearedAuthCookies returns the Set-Cookie values that remove the token pair from browsers
+func ClearedAuthCookies() []string {
+	return []string{
+		"access_token=deleted; HttpOnly; SameSite=Strict; Path=/api; Max-Age=0",
+		"refresh_token=deleted; HttpOnly; SameSite=Strict; Path=/api/v1/auth/refresh; Max-Age=0",
+	}
+}
+
 // RefreshRequest represents the refresh token request
 type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token" binding:"required" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
@@ -77,31 +181,20 @@ func (u *AuthUsecase) Refresh(ctx context.Context, refreshToken string) (*TokenR
 		return nil, roshan_errors.ErrInvalidToken
 	}
 
-	user, err := u.userRepo.FindUserByIdAndToken(subject, refreshToken)
-	if err != nil {
-		return nil, roshan_errors.ErrInvalidToken
-	}
-
-	tokenData, err := u.jwtRepository.GenerateAccessAndRefreshTokens(user)
+	user, err := u.userRepo.FindUserByIdAndToken(ctx, subject, refreshToken)
 	if err != nil {
 		return nil, roshan_errors.ErrInvalidToken
 	}
 
-	err = u.userRepo.SaveRefreshToken(user, tokenData.RefreshToken)
+	token, err := u.IssueTokens(ctx, user)
 	if err != nil {
 		return nil, roshan_errors.ErrInvalidToken
 	}
 
-	return &TokenResponse{
-		AccessToken:      tokenData.AccessToken,
-		RefreshToken:     tokenData.RefreshToken,
-		TokenType:        tokenData.TokenType,
-		ExpiresIn:        tokenData.ExpiresIn,
-		RefreshExpiresIn: tokenData.RefreshExpiresIn,
-	}, nil
+	return token, nil
 }
 
 func (u *AuthUsecase) Logout(ctx context.Context) error {
 	user := ctx.Value("user").(*userModel.User)
-	return u.userRepo.DeleteRefreshToken(user)
+	return u.userRepo.DeleteRefreshToken(ctx, user)
 }
@@ -0,0 +1,374 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	"github.com/bozoteam/roshan/helpers"
+	authModel "github.com/bozoteam/roshan/modules/auth/models"
+	apiKeyRepository "github.com/bozoteam/roshan/modules/auth/repository/apikey"
+	jwtRepository "github.com/bozoteam/roshan/modules/auth/repository/jwt"
+	lockoutRepository "github.com/bozoteam/roshan/modules/auth/repository/lockout"
+	ticketRepository "github.com/bozoteam/roshan/modules/auth/repository/ticket"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	userRepository "github.com/bozoteam/roshan/modules/user/repository"
+	"github.com/bozoteam/roshan/roshan_errors"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// trustedProxies stand for the load balancer in front of the tests' server
+var trustedProxies = helpers.TrustedProxies{netip.MustParsePrefix("10.0.0.0/8")}
+
+// openRooms stands for the chat and game hubs
+type openRooms []string
+
+func (r openRooms) HasRoom(roomId string) bool { return slices.Contains(r, roomId) }
+
+type authFixture struct {
+	usecase  *AuthUsecase
+	users    *userRepository.MemoryUserRepository
+	lockouts *lockoutRepository.MemoryLockoutRepository
+	apiKeys  *apiKeyRepository.MemoryApiKeyRepository
+}
+
+func newAuthFixture(t *testing.T, lockout config.LockoutConfig) *authFixture {
+	t.Helper()
+
+	cfg := config.Default()
+	cfg.JWT.Secret = "test-access-secret-at-least-32-characters"
+	cfg.JWT.RefreshSecret = "test-refresh-secret-at-least-32-characters"
+
+	f := &authFixture{
+		users:    userRepository.NewMemoryUserRepository(),
+		lockouts: lockoutRepository.NewMemoryLockoutRepository(),
+		apiKeys:  apiKeyRepository.NewMemoryApiKeyRepository(),
+	}
+	f.usecase = NewAuthUsecase(
+		f.users,
+		jwtRepository.NewJWTRepository(cfg.JWT),
+		f.lockouts,
+		f.apiKeys,
+		ticketRepository.NewTicketRepository(cfg.Auth.TicketTTL),
+		lockout,
+		trustedProxies,
+		[]RoomFinder{openRooms{"general"}},
+	)
+	return f
+}
+
+func (f *authFixture) createUser(t *testing.T, email string, password string) *userModel.User {
+	t.Helper()
+
+	hash, err := helpers.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &userModel.User{
+		Id:       helpers.GenUUID(),
+		Name:     "tester",
+		Email:    email,
+		Password: hash,
+		Role:     userModel.RoleUser,
+	}
+	if err := f.users.SaveUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// lenientLockout never throttles, so tests only see the behaviour they configure
+func lenientLockout() config.LockoutConfig {
+	lockout := config.Default().Auth.Lockout
+	lockout.FreeAttempts = 100
+	lockout.AccountThreshold = 100
+	lockout.IPThreshold = 100
+	return lockout
+}
+
+func TestAuthenticateIssuesTokensThatRefresh(t *testing.T) {
+	f := newAuthFixture(t, lenientLockout())
+	user := f.createUser(t, "alice@example.com", "correct horse")
+	ctx := context.Background()
+
+	token, err := f.usecase.Authenticate(ctx, "alice@example.com", "correct horse")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	stored, err := f.users.FindUserById(ctx, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.RefreshToken != token.RefreshToken {
+		t.Fatal("refresh token was not stored")
+	}
+
+	if _, err := f.usecase.Refresh(ctx, token.RefreshToken); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	if err := f.usecase.Logout(context.WithValue(ctx, "user", stored)); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if _, err := f.usecase.Refresh(ctx, token.RefreshToken); !errors.Is(err, roshan_errors.ErrInvalidToken) {
+		t.Fatalf("Refresh after logout: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestAuthenticateRejectsBadCredentials(t *testing.T) {
+	f := newAuthFixture(t, lenientLockout())
+	f.createUser(t, "alice@example.com", "correct horse")
+	ctx := context.Background()
+
+	for _, tc := range []struct{ email, password string }{
+		{"alice@example.com", "wrong"},
+		{"nobody@example.com", "correct horse"},
+	} {
+		if _, err := f.usecase.Authenticate(ctx, tc.email, tc.password); !errors.Is(err, roshan_errors.ErrAuthFailed) {
+			t.Errorf("Authenticate(%q): got %v, want ErrAuthFailed", tc.email, err)
+		}
+	}
+
+	attempt, err := f.lockouts.FindAttempt(ctx, authModel.AttemptKindAccount, "alice@example.com")
+	if err != nil || attempt == nil || attempt.FailedCount != 1 {
+		t.Fatalf("failure was not counted: %+v, %v", attempt, err)
+	}
+}
+
+func TestAuthenticateLocksOutAfterThreshold(t *testing.T) {
+	lockout := lenientLockout()
+	lockout.AccountThreshold = 3
+	f := newAuthFixture(t, lockout)
+	f.createUser(t, "alice@example.com", "correct horse")
+	ctx := context.Background()
+
+	for range lockout.AccountThreshold {
+		if _, err := f.usecase.Authenticate(ctx, "Alice@example.com ", "wrong"); !errors.Is(err, roshan_errors.ErrAuthFailed) {
+			t.Fatalf("got %v, want ErrAuthFailed", err)
+		}
+	}
+
+	// the right password doesn't get through a lockout either
+	if _, err := f.usecase.Authenticate(ctx, "alice@example.com", "correct horse"); !errors.Is(err, ErrLoginThrottled) {
+		t.Fatalf("got %v, want ErrLoginThrottled", err)
+	}
+
+	events := f.lockouts.Events()
+	if len(events) != 1 || events[0].Event != authModel.LockoutEventLocked || events[0].Subject != "alice@example.com" {
+		t.Fatalf("unexpected audit trail: %+v", events)
+	}
+}
+
+func TestAuthenticateThrottlesUnknownAccountsAlike(t *testing.T) {
+	lockout := lenientLockout()
+	lockout.FreeAttempts = 1
+	lockout.BaseDelay = time.Hour
+	lockout.MaxDelay = time.Hour
+	f := newAuthFixture(t, lockout)
+	ctx := context.Background()
+
+	if _, err := f.usecase.Authenticate(ctx, "ghost@example.com", "whatever"); !errors.Is(err, roshan_errors.ErrAuthFailed) {
+		t.Fatalf("got %v, want ErrAuthFailed", err)
+	}
+	if _, err := f.usecase.Authenticate(ctx, "ghost@example.com", "whatever"); !errors.Is(err, ErrLoginThrottled) {
+		t.Fatalf("got %v, want ErrLoginThrottled", err)
+	}
+}
+
+// fromAddress is a call made from addr carrying the given proxy headers
+func fromAddress(addr string, headers ...string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 40000}})
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(headers...))
+}
+
+func TestLoginSubjectsOnlyBelieveTrustedProxies(t *testing.T) {
+	f := newAuthFixture(t, lenientLockout())
+
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		ip   string
+	}{
+		{"direct", fromAddress("203.0.113.7"), "203.0.113.7"},
+		{"spoofed real ip", fromAddress("203.0.113.7", "x-real-ip", "198.51.100.1"), "203.0.113.7"},
+		{"spoofed forwarded for", fromAddress("203.0.113.7", "x-forwarded-for", "198.51.100.1, 198.51.100.2"), "203.0.113.7"},
+		{"proxied", fromAddress("10.0.0.2", "x-forwarded-for", "198.51.100.1, 203.0.113.7"), "203.0.113.7"},
+		{"through two proxies", fromAddress("10.0.0.2", "x-forwarded-for", "198.51.100.1, 203.0.113.7, 10.0.0.3"), "203.0.113.7"},
+		{"real ip from a proxy", fromAddress("10.0.0.2", "x-real-ip", "203.0.113.7"), "203.0.113.7"},
+	} {
+		subjects := f.usecase.loginSubjects(tc.ctx, "alice@example.com")
+		if len(subjects) != 2 || subjects[1].kind != authModel.AttemptKindIP || subjects[1].subject != tc.ip {
+			t.Errorf("%s: got %+v, want the ip %s", tc.name, subjects, tc.ip)
+		}
+	}
+}
+
+func TestSpoofedHeadersDontDodgeTheIPThrottle(t *testing.T) {
+	lockout := lenientLockout()
+	lockout.IPThreshold = 2
+	f := newAuthFixture(t, lockout)
+
+	for i := range lockout.IPThreshold {
+		ctx := fromAddress("203.0.113.7", "x-real-ip", fmt.Sprintf("198.51.100.%d", i))
+		if _, err := f.usecase.Authenticate(ctx, fmt.Sprintf("user%d@example.com", i), "wrong"); !errors.Is(err, roshan_errors.ErrAuthFailed) {
+			t.Fatalf("got %v, want ErrAuthFailed", err)
+		}
+	}
+	ctx := fromAddress("203.0.113.7", "x-real-ip", "198.51.100.99", "x-forwarded-for", "198.51.100.98")
+	if _, err := f.usecase.Authenticate(ctx, "someone@example.com", "wrong"); !errors.Is(err, ErrLoginThrottled) {
+		t.Fatalf("got %v, want ErrLoginThrottled", err)
+	}
+}
+
+func TestConcurrentGuessesDontOutrunTheThrottle(t *testing.T) {
+	lockout := lenientLockout()
+	lockout.FreeAttempts = 3
+	lockout.BaseDelay = time.Hour
+	lockout.MaxDelay = time.Hour
+	f := newAuthFixture(t, lockout)
+	f.createUser(t, "alice@example.com", "correct horse")
+
+	const guesses = 20
+	errs := make(chan error, guesses)
+	for range guesses {
+		go func() {
+			_, err := f.usecase.Authenticate(context.Background(), "alice@example.com", "wrong")
+			errs <- err
+		}()
+	}
+
+	checked := 0
+	for range guesses {
+		switch err := <-errs; {
+		case errors.Is(err, roshan_errors.ErrAuthFailed):
+			checked++
+		case !errors.Is(err, ErrLoginThrottled):
+			t.Fatalf("got %v", err)
+		}
+	}
+	if checked != lockout.FreeAttempts {
+		t.Fatalf("%d guesses had their password checked, want %d", checked, lockout.FreeAttempts)
+	}
+}
+
+func TestSuccessfulLoginTakesBackItsIPAttempt(t *testing.T) {
+	f := newAuthFixture(t, lenientLockout())
+	f.createUser(t, "alice@example.com", "correct horse")
+	ctx := fromAddress("203.0.113.7")
+
+	f.usecase.Authenticate(ctx, "bob@example.com", "wrong")
+	if _, err := f.usecase.Authenticate(ctx, "alice@example.com", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	attempt, err := f.lockouts.FindAttempt(ctx, authModel.AttemptKindIP, "203.0.113.7")
+	if err != nil || attempt == nil || attempt.FailedCount != 1 {
+		t.Fatalf("ip counter %+v, %v, want the one failure", attempt, err)
+	}
+}
+
+func TestUnlockAccount(t *testing.T) {
+	lockout := lenientLockout()
+	lockout.AccountThreshold = 1
+	f := newAuthFixture(t, lockout)
+	alice := f.createUser(t, "alice@example.com", "correct horse")
+	bob := f.createUser(t, "bob@example.com", "battery staple")
+	ctx := context.Background()
+
+	f.usecase.Authenticate(ctx, "alice@example.com", "wrong")
+
+	if err := f.usecase.UnlockAccount(context.WithValue(ctx, "user", bob), "alice@example.com"); !errors.Is(err, ErrCannotUnlock) {
+		t.Fatalf("got %v, want ErrCannotUnlock", err)
+	}
+	if err := f.usecase.UnlockAccount(context.WithValue(ctx, "user", alice), "alice@example.com"); err != nil {
+		t.Fatalf("UnlockAccount: %v", err)
+	}
+	if _, err := f.usecase.Authenticate(ctx, "alice@example.com", "correct horse"); err != nil {
+		t.Fatalf("Authenticate after unlock: %v", err)
+	}
+}
+
+func TestAuthenticateRefusesDisabledAccounts(t *testing.T) {
+	f := newAuthFixture(t, lenientLockout())
+	user := f.createUser(t, "alice@example.com", "correct horse")
+	ctx := context.Background()
+
+	token, err := f.usecase.Authenticate(ctx, "alice@example.com", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	user.DisabledAt = &now
+	if err := f.users.SaveUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.usecase.Authenticate(ctx, "alice@example.com", "correct horse"); !errors.Is(err, ErrAccountDisabled) {
+		t.Fatalf("got %v, want ErrAccountDisabled", err)
+	}
+	if _, err := f.usecase.Refresh(ctx, token.RefreshToken); err == nil {
+		t.Fatal("a disabled account refreshed its token")
+	}
+	if _, err := f.usecase.IssueAccessToken(ctx, "alice@example.com", time.Minute); !errors.Is(err, ErrAccountDisabled) {
+		t.Fatalf("got %v, want ErrAccountDisabled", err)
+	}
+}
+
+func TestApiKeyLifecycle(t *testing.T) {
+	f := newAuthFixture(t, lenientLockout())
+	user := f.createUser(t, "alice@example.com", "correct horse")
+	ctx := context.WithValue(context.Background(), "user", user)
+
+	created, err := f.usecase.CreateApiKey(ctx, &ApiKeyCreateInput{Name: "bot", Scopes: []string{authModel.ScopeChat}})
+	if err != nil {
+		t.Fatalf("CreateApiKey: %v", err)
+	}
+
+	key, err := f.apiKeys.ValidateApiKey(ctx, created.Key, time.Now())
+	if err != nil {
+		t.Fatalf("ValidateApiKey: %v", err)
+	}
+	if !key.HasScope(authModel.ScopeChat) || key.HasScope(authModel.ScopeUser) {
+		t.Fatalf("unexpected scopes %q", key.Scopes)
+	}
+
+	// a key can't be used to mint more keys
+	keyCtx := context.WithValue(ctx, "api_key", key)
+	if _, err := f.usecase.CreateApiKey(keyCtx, &ApiKeyCreateInput{Name: "x", Scopes: []string{authModel.ScopeChat}}); !errors.Is(err, ErrSessionRequired) {
+		t.Fatalf("got %v, want ErrSessionRequired", err)
+	}
+
+	if _, err := f.usecase.RevokeApiKey(ctx, created.Id); err != nil {
+		t.Fatalf("RevokeApiKey: %v", err)
+	}
+	if _, err := f.apiKeys.ValidateApiKey(ctx, created.Key, time.Now()); !errors.Is(err, apiKeyRepository.ErrApiKeyExpired) {
+		t.Fatalf("got %v, want ErrApiKeyExpired", err)
+	}
+
+	keys, err := f.usecase.ListApiKeys(ctx)
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("ListApiKeys after revoke: %d keys, %v", len(keys), err)
+	}
+}
+
+func TestIssueSocketTicketOnlyForOpenRooms(t *testing.T) {
+	f := newAuthFixture(t, lenientLockout())
+	user := f.createUser(t, "alice@example.com", "correct horse")
+	ctx := context.WithValue(context.Background(), "user", user)
+
+	if _, err := f.usecase.IssueSocketTicket(ctx, "made-up"); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("got %v, want ErrRoomNotFound", err)
+	}
+	ticket, err := f.usecase.IssueSocketTicket(ctx, "general")
+	if err != nil || ticket.Ticket == "" {
+		t.Fatalf("IssueSocketTicket: %+v, %v", ticket, err)
+	}
+}
@@ -0,0 +1,140 @@
+package usecase
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	"github.com/bozoteam/roshan/helpers"
+	authModel "github.com/bozoteam/roshan/modules/auth/models"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	"github.com/bozoteam/roshan/roshan_errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LockoutPolicy controls how failed logins are throttled
+type LockoutPolicy config.LockoutConfig
+
+// delay returns how long a subject with failedCount failures must wait before its next attempt
+func (p LockoutPolicy) delay(failedCount int) time.Duration {
+	if failedCount < p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts; i < failedCount && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+func (p LockoutPolicy) threshold(kind authModel.AttemptKind) int {
+	if kind == authModel.AttemptKindIP {
+		return p.IPThreshold
+	}
+	return p.AccountThreshold
+}
+
+var (
+	// ErrLoginThrottled is returned for both existing and unknown accounts, so it can't be used to enumerate users
+	ErrLoginThrottled  = status.Error(codes.ResourceExhausted, "too many failed login attempts, try again later")
+	ErrCannotUnlock    = status.Error(codes.PermissionDenied, "user cannot unlock this account")
+	ErrMissingUnlockId = status.Error(codes.InvalidArgument, "email is required")
+)
+
+type loginSubject struct {
+	kind    authModel.AttemptKind
+	subject string
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (u *AuthUsecase) loginSubjects(ctx context.Context, email string) []loginSubject {
+	subjects := []loginSubject{{kind: authModel.AttemptKindAccount, subject: normalizeEmail(email)}}
+	if ip := helpers.ClientIPFromContext(ctx, u.trustedProxies); ip != "" {
+		subjects = append(subjects, loginSubject{kind: authModel.AttemptKindIP, subject: ip})
+	}
+	return subjects
+}
+
+// throttled tells whether an attempt must be turned down given the subject's counter
+func (p LockoutPolicy) throttled(attempt *authModel.LoginAttempt, now time.Time) bool {
+	if attempt.IsLocked(now) {
+		return true
+	}
+	return attempt.FailedCount > 0 && now.Before(attempt.LastFailedAt.Add(p.delay(attempt.FailedCount)))
+}
+
+// startAttempt counts the attempt against every subject before the password is checked, so
+// concurrent guesses can't all get past the throttle before any of them failed. It fails when
+// a subject is locked or still inside its back-off delay, without counting anything.
+func (u *AuthUsecase) startAttempt(ctx context.Context, subjects []loginSubject, now time.Time) ([]*authModel.LoginAttempt, error) {
+	allow := func(attempt *authModel.LoginAttempt) bool { return !u.lockoutPolicy.throttled(attempt, now) }
+
+	attempts := make([]*authModel.LoginAttempt, 0, len(subjects))
+	for _, s := range subjects {
+		attempt, allowed, err := u.lockoutRepo.RegisterAttempt(ctx, s.kind, s.subject, now, u.lockoutPolicy.FailureWindow, allow)
+		if err == nil && allowed {
+			attempts = append(attempts, attempt)
+			continue
+		}
+
+		u.releaseAttempt(ctx, attempts)
+		if err != nil {
+			u.logger.ErrorContext(ctx, "failed to register login attempt", "error", err, "kind", s.kind)
+			return nil, roshan_errors.ErrInternalServerError
+		}
+		return nil, ErrLoginThrottled
+	}
+	return attempts, nil
+}
+
+// releaseAttempt takes back the attempts counted by startAttempt
+func (u *AuthUsecase) releaseAttempt(ctx context.Context, attempts []*authModel.LoginAttempt) {
+	for _, attempt := range attempts {
+		if err := u.lockoutRepo.ReleaseAttempt(ctx, attempt.Kind, attempt.Subject); err != nil {
+			u.logger.ErrorContext(ctx, "failed to release login attempt", "error", err, "kind", attempt.Kind)
+		}
+	}
+}
+
+// registerFailure locks the subjects whose counted attempts reached their threshold
+func (u *AuthUsecase) registerFailure(ctx context.Context, attempts []*authModel.LoginAttempt, now time.Time) {
+	for _, attempt := range attempts {
+		if attempt.FailedCount < u.lockoutPolicy.threshold(attempt.Kind) || attempt.IsLocked(now) {
+			continue
+		}
+
+		until := now.Add(u.lockoutPolicy.LockoutDuration)
+		if err := u.lockoutRepo.Lock(ctx, attempt, until); err != nil {
+			u.logger.ErrorContext(ctx, "failed to lock subject", "error", err, "kind", attempt.Kind)
+			continue
+		}
+		u.logger.WarnContext(ctx, "login locked out", "kind", attempt.Kind, "subject", attempt.Subject, "failed_count", attempt.FailedCount, "locked_until", until)
+	}
+}
+
+// UnlockAccount lifts the lockout of an account. Admins may unlock any account, users only their own.
+func (u *AuthUsecase) UnlockAccount(ctx context.Context, email string) error {
+	user := ctx.Value("user").(*userModel.User)
+
+	subject := normalizeEmail(email)
+	if subject == "" {
+		return ErrMissingUnlockId
+	}
+
+	if !user.IsAdmin() && normalizeEmail(user.Email) != subject {
+		return ErrCannotUnlock
+	}
+
+	if err := u.lockoutRepo.Unlock(ctx, authModel.AttemptKindAccount, subject, user.Id); err != nil {
+		u.logger.ErrorContext(ctx, "failed to unlock account", "error", err)
+		return roshan_errors.ErrInternalServerError
+	}
+
+	u.logger.InfoContext(ctx, "login unlocked", "kind", authModel.AttemptKindAccount, "subject", subject, "actor_id", user.Id)
+	return nil
+}
@@ -0,0 +1,43 @@
+package usecase
+
+import (
+	"context"
+	"slices"
+
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrMissingRoomId = status.Error(codes.InvalidArgument, "room id is required")
+	ErrRoomNotFound  = status.Error(codes.NotFound, "room not found")
+)
+
+// RoomFinder tells whether a room is open, the chat and game usecases implement it
+type RoomFinder interface {
+	HasRoom(roomId string) bool
+}
+
+// SocketTicket lets a browser open a room WebSocket without sending cookies or headers
+type SocketTicket struct {
+	Ticket    string
+	ExpiresIn uint64
+}
+
+// IssueSocketTicket hands out a ticket for one of the open chat or game rooms
+func (u *AuthUsecase) IssueSocketTicket(ctx context.Context, roomId string) (*SocketTicket, error) {
+	user := ctx.Value("user").(*userModel.User)
+
+	if roomId == "" {
+		return nil, ErrMissingRoomId
+	}
+	if !slices.ContainsFunc(u.rooms, func(rooms RoomFinder) bool { return rooms.HasRoom(roomId) }) {
+		return nil, ErrRoomNotFound
+	}
+
+	return &SocketTicket{
+		Ticket:    u.ticketRepo.Issue(user.Id, roomId),
+		ExpiresIn: uint64(u.ticketRepo.TTL().Seconds()),
+	}, nil
+}
@@ -0,0 +1,141 @@
+// Package command parses the chat messages starting with a slash and runs them through the
+// commands registered by the modules.
+package command
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	ws_hub "github.com/bozoteam/roshan/modules/websocket/hub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Call is a command typed in a room
+type Call struct {
+	User *userModel.User
+	Room *ws_hub.RoomSnapshot
+	// ParentId is the thread the command was typed in, empty outside of threads
+	ParentId string
+	// Args is what follows the command's name, trimmed
+	Args string
+}
+
+// Result is what comes out of a command. Post is sent to the room as a message of Kind
+// from the user running the command, Notice is only shown to them.
+type Result struct {
+	Post   string
+	Kind   string
+	Notice string
+}
+
+// Command is run by typing its name after a slash
+type Command struct {
+	// Name is typed after the slash, in lowercase
+	Name string
+	// Usage describes the arguments, e.g. "<dice>"
+	Usage       string
+	Description string
+	// Allowed tells whether the user may run the command, everyone may when it's nil
+	Allowed func(call *Call) bool
+	Run     func(ctx context.Context, call *Call) (*Result, error)
+}
+
+// UsageError tells the user how the command is typed
+func (c *Command) UsageError() error {
+	return status.Errorf(codes.InvalidArgument, "usage: /%s %s", c.Name, c.Usage)
+}
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// Registry holds the commands by name. Modules register theirs while the server starts.
+type Registry struct {
+	mu       sync.RWMutex
+	commands map[string]*Command
+}
+
+func NewRegistry() *Registry {
+	return &Registry{commands: map[string]*Command{}}
+}
+
+// Register adds a command, its name must be free
+func (r *Registry) Register(command *Command) error {
+	if !namePattern.MatchString(command.Name) {
+		return fmt.Errorf("invalid command name %q", command.Name)
+	}
+	if command.Run == nil {
+		return fmt.Errorf("command /%s has nothing to run", command.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.commands[command.Name]; exists {
+		return fmt.Errorf("command /%s is already registered", command.Name)
+	}
+	r.commands[command.Name] = command
+	return nil
+}
+
+// Lookup returns the command, nil when there's none by that name
+func (r *Registry) Lookup(name string) *Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.commands[name]
+}
+
+// Available returns the commands the call's user may run, sorted by name
+func (r *Registry) Available(call *Call) []*Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var commands []*Command
+	for _, command := range r.commands {
+		if command.Allowed == nil || command.Allowed(call) {
+			commands = append(commands, command)
+		}
+	}
+	slices.SortFunc(commands, func(a, b *Command) int { return strings.Compare(a.Name, b.Name) })
+	return commands
+}
+
+// Parse splits a message typed as "/name args" into the command's name and arguments. It's
+// false for anything else, including messages escaped by a double slash.
+func Parse(content string) (name string, args string, ok bool) {
+	if !strings.HasPrefix(content, "/") || strings.HasPrefix(content, "//") {
+		return "", "", false
+	}
+	rest := content[1:]
+	end := strings.IndexFunc(rest, unicode.IsSpace)
+	if end < 0 {
+		end = len(rest)
+	}
+	if end == 0 {
+		return "", "", false
+	}
+	name, args = rest[:end], strings.TrimSpace(rest[end:])
+	return strings.ToLower(name), args, true
+}
+
+// Help lists commands, one per line
+func Help(commands []*Command) string {
+	lines := make([]string, len(commands))
+	for i, command := range commands {
+		lines[i] = HelpLine(command)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HelpLine describes a command
+func HelpLine(command *Command) string {
+	line := "/" + command.Name
+	if command.Usage != "" {
+		line += " " + command.Usage
+	}
+	return line + ": " + command.Description
+}
@@ -0,0 +1,51 @@
+package command
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		content, name, args string
+		ok                  bool
+	}{
+		{"/roll 2d6", "roll", "2d6", true},
+		{"/ME  waves\n", "me", "waves", true},
+		{"/help", "help", "", true},
+		{"//not a command", "", "", false},
+		{"/ spaced", "", "", false},
+		{"hello /roll", "", "", false},
+	} {
+		name, args, ok := Parse(tt.content)
+		if name != tt.name || args != tt.args || ok != tt.ok {
+			t.Errorf("Parse(%q) = %q, %q, %v, want %q, %q, %v", tt.content, name, args, ok, tt.name, tt.args, tt.ok)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	run := func(ctx context.Context, call *Call) (*Result, error) { return &Result{}, nil }
+	registry := NewRegistry()
+	for _, command := range []*Command{
+		{Name: "wave", Description: "Waves", Run: run},
+		{Name: "ban", Usage: "<name>", Description: "Bans", Run: run, Allowed: func(call *Call) bool { return call.Args == "mod" }},
+	} {
+		if err := registry.Register(command); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := registry.Register(&Command{Name: "wave", Run: run}); err == nil {
+		t.Error("registered /wave twice")
+	}
+	if err := registry.Register(&Command{Name: "Bad Name", Run: run}); err == nil {
+		t.Error("registered an invalid name")
+	}
+
+	if got, want := Help(registry.Available(&Call{})), "/wave: Waves"; got != want {
+		t.Errorf("help for users %q, want %q", got, want)
+	}
+	if got, want := Help(registry.Available(&Call{Args: "mod"})), "/ban <name>: Bans\n/wave: Waves"; got != want {
+		t.Errorf("help for moderators %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	chatGen "github.com/bozoteam/roshan/adapter/grpc/gen/chat"
+)
+
+// MaxAttachments is how many files a message can carry
+const MaxAttachments = 10
+
+// Attachment is a file uploaded to the chat. It belongs to its uploader until it's sent
+// with a message, after which it's readable by whoever can read the message's room.
+type Attachment struct {
+	Id     string `json:"id" gorm:"primaryKey"`
+	UserId string `json:"-" gorm:"not null"`
+	// MessageId is nil until the attachment is sent
+	MessageId   *string `json:"-"`
+	Name        string  `json:"name" gorm:"type:varchar(255);not null"`
+	ContentType string  `json:"content_type" gorm:"type:varchar(127);not null"`
+	Size        int64   `json:"size" gorm:"not null"`
+	// Width and Height are 0 for anything that isn't an image
+	Width        int       `json:"width,omitempty" gorm:"not null;default:0"`
+	Height       int       `json:"height,omitempty" gorm:"not null;default:0"`
+	HasThumbnail bool      `json:"has_thumbnail" gorm:"not null;default:false"`
+	CreatedAt    time.Time `json:"-"`
+}
+
+func (Attachment) TableName() string {
+	return "attachment"
+}
+
+// BlobKey is where the file is kept in the blob store
+func (a *Attachment) BlobKey() string {
+	return "attachments/" + a.Id
+}
+
+// ThumbnailKey is where the thumbnail of an image is kept in the blob store
+func (a *Attachment) ThumbnailKey() string {
+	return "thumbnails/" + a.Id
+}
+
+// AttachmentToGRPC converts an attachment to its API representation
+func AttachmentToGRPC(attachment *Attachment) *chatGen.Attachment {
+	return &chatGen.Attachment{
+		Id:           attachment.Id,
+		Name:         attachment.Name,
+		ContentType:  attachment.ContentType,
+		Size:         attachment.Size,
+		Width:        int32(attachment.Width),
+		Height:       int32(attachment.Height),
+		HasThumbnail: attachment.HasThumbnail,
+	}
+}
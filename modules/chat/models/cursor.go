@@ -0,0 +1,41 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// messageCursor is where a page of messages, newest first, stopped. Clients get it as an
+// opaque string so fields can be added without breaking the cursors they hold; message
+// search uses it and any other listing of messages should too.
+type messageCursor struct {
+	Before string `json:"before"`
+}
+
+// EncodeCursor returns the cursor of the page after the message with id before
+func EncodeCursor(before string) string {
+	data, err := json.Marshal(&messageCursor{Before: before})
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor returns the id of the message a page continues after, empty for the first page
+func DecodeCursor(cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", errInvalidCursor
+	}
+	var decoded messageCursor
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.Before == "" {
+		return "", errInvalidCursor
+	}
+	return decoded.Before, nil
+}
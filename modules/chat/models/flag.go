@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	chatGen "github.com/bozoteam/roshan/adapter/grpc/gen/chat"
+)
+
+// MessageFlag is a message a moderation filter let through for moderators to review
+type MessageFlag struct {
+	Id        string   `json:"id" gorm:"primaryKey"`
+	MessageId string   `json:"-" gorm:"not null"`
+	Message   *Message `json:"message" gorm:"foreignKey:MessageId"`
+	// Filter is the name of the filter that flagged the message
+	Filter    string    `json:"filter" gorm:"type:varchar(32);not null"`
+	Reason    string    `json:"reason" gorm:"type:varchar(255);not null"`
+	CreatedAt time.Time `json:"created_at"`
+	// ResolvedBy is the moderator who reviewed the flag, nil while it's pending
+	ResolvedBy *string    `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+func (MessageFlag) TableName() string {
+	return "message_flag"
+}
+
+func FlagToGRPC(flag *MessageFlag) *chatGen.MessageFlag {
+	return &chatGen.MessageFlag{
+		Id:        flag.Id,
+		Message:   MessageToGRPC(flag.Message),
+		Filter:    flag.Filter,
+		Reason:    flag.Reason,
+		CreatedAt: flag.CreatedAt.Unix(),
+	}
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MaxMentions caps how many names a message can mention
+const MaxMentions = 20
+
+// Mention is a user mentioned in a message, until they read it
+type Mention struct {
+	MessageId string `gorm:"primaryKey"`
+	UserId    string `gorm:"primaryKey"`
+	RoomId    string `gorm:"not null"`
+	CreatedAt time.Time
+	ReadAt    *time.Time
+}
+
+func (Mention) TableName() string {
+	return "message_mention"
+}
+
+// EventMention is the type of a MentionEvent
+const EventMention = "mention"
+
+// MentionEvent is sent to the sockets of a mentioned user
+type MentionEvent struct {
+	Type    string   `json:"type"`
+	Message *Message `json:"message"`
+	// UnreadMentions counts the user's mentions not read yet, this one included
+	UnreadMentions int64 `json:"unread_mentions"`
+	Timestamp      int64 `json:"timestamp"`
+}
+
+// an @ that doesn't follow a letter or digit, so email addresses aren't mentions
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}])@([\p{L}\p{N}]+)`)
+
+// ParseMentions returns the names mentioned in content, lowercased and without duplicates,
+// in the order they appear
+func ParseMentions(content string) []string {
+	var names []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.ToLower(match[1])
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+		if len(names) == MaxMentions {
+			break
+		}
+	}
+	return names
+}
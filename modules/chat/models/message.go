@@ -1,11 +1,193 @@
 package models
 
-import userModel "github.com/bozoteam/roshan/modules/user/models"
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
-// Message represents a chat message
+	chatGen "github.com/bozoteam/roshan/adapter/grpc/gen/chat"
+	userGen "github.com/bozoteam/roshan/adapter/grpc/gen/user"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	"github.com/go-playground/validator/v10"
+)
+
+// MaxMessageLength is the longest content a message can have, in bytes
+const MaxMessageLength = 4000
+
+// Kinds of message, a plain message has none
+const (
+	// MessageKindAction describes what its author does, as typed with /me
+	MessageKindAction = "action"
+	// MessageKindSystem is written by a command on behalf of its author, e.g. a dice roll
+	MessageKindSystem = "system"
+	// MessageKindNotice answers a command to the user who ran it alone, it's never stored
+	MessageKindNotice = "notice"
+)
+
+// Message represents a chat message. Times are unix nanoseconds, like every WebSocket frame.
 type Message struct {
-	RoomID    string          `json:"room_id"`
-	User      *userModel.User `json:"user"`
-	Content   string          `json:"content"`
-	Timestamp int64           `json:"timestamp"`
+	Id      string          `json:"id" gorm:"primaryKey"`
+	RoomID  string          `json:"room_id" gorm:"not null"`
+	UserID  string          `json:"-" gorm:"not null"`
+	User    *userModel.User `json:"user" gorm:"foreignKey:UserID"`
+	Content string          `json:"content" gorm:"type:varchar(4000);not null"`
+	Kind    string          `json:"kind,omitempty" gorm:"type:varchar(16);not null"`
+	// Nick is the name the author went by in the room when they sent the message
+	Nick string `json:"nick,omitempty" gorm:"type:varchar(32);not null"`
+	// ParentId is the thread the message replies to, empty for top level messages
+	ParentId *string `json:"parent_id,omitempty"`
+	// Mentions are the ids of the users mentioned when the message was sent
+	Mentions []string `json:"mentions,omitempty" gorm:"-"`
+	// Reactions are grouped by emoji, in the order each emoji was first used
+	Reactions   []Reaction   `json:"reactions" gorm:"-"`
+	Attachments []Attachment `json:"attachments,omitempty" gorm:"-"`
+
+	Timestamp int64 `json:"timestamp" gorm:"not null"`
+	// EditedAt is 0 until the author edits the message
+	EditedAt int64 `json:"edited_at,omitempty" gorm:"not null;default:0"`
+	// DeletedAt is set, and Content cleared, once the message is deleted
+	DeletedAt int64 `json:"deleted_at,omitempty" gorm:"not null;default:0"`
+}
+
+func (Message) TableName() string {
+	return "message"
+}
+
+func (m *Message) IsDeleted() bool {
+	return m.DeletedAt != 0
+}
+
+// ThreadId is the id of the message's thread, its parent's or its own for top level messages
+func (m *Message) ThreadId() string {
+	if m.ParentId != nil {
+		return *m.ParentId
+	}
+	return m.Id
+}
+
+// Reaction is everyone who reacted to a message with the same emoji
+type Reaction struct {
+	Emoji   string   `json:"emoji"`
+	UserIDs []string `json:"user_ids"`
+}
+
+// MessageReaction is one user's reaction to a message
+type MessageReaction struct {
+	MessageId string `gorm:"primaryKey"`
+	UserId    string `gorm:"primaryKey"`
+	Emoji     string `gorm:"primaryKey;type:varchar(32)"`
+	CreatedAt time.Time
+}
+
+func (MessageReaction) TableName() string {
+	return "message_reaction"
+}
+
+// MessageRevision keeps the content a message had before an edit or a delete
+type MessageRevision struct {
+	Id        string `gorm:"primaryKey"`
+	MessageId string `gorm:"not null"`
+	Content   string `gorm:"type:varchar(4000);not null"`
+	// EditedBy is who replaced the content, the author or a moderator deleting the message
+	EditedBy  string `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+func (MessageRevision) TableName() string {
+	return "message_revision"
+}
+
+// GroupReactions turns reactions, oldest first, into one Reaction per emoji
+func GroupReactions(reactions []MessageReaction) []Reaction {
+	grouped := []Reaction{}
+	index := map[string]int{}
+	for _, reaction := range reactions {
+		i, exists := index[reaction.Emoji]
+		if !exists {
+			i = len(grouped)
+			index[reaction.Emoji] = i
+			grouped = append(grouped, Reaction{Emoji: reaction.Emoji})
+		}
+		grouped[i].UserIDs = append(grouped[i].UserIDs, reaction.UserId)
+	}
+	return grouped
+}
+
+var modelValidator = validator.New()
+
+// ValidateContent checks the content of a new or edited message
+func ValidateContent(content string) error {
+	return modelValidator.Var(content, "required,max="+strconv.Itoa(MaxMessageLength))
+}
+
+// ValidateEmoji accepts up to 32 bytes without spaces or control characters
+func ValidateEmoji(emoji string) error {
+	if err := modelValidator.Var(emoji, "required,max=32"); err != nil {
+		return err
+	}
+	if strings.ContainsFunc(emoji, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) {
+		return errors.New("emoji can't contain spaces or control characters")
+	}
+	return nil
+}
+
+// Events sent to a room when one of its messages changes, so clients can patch their view
+const (
+	EventMessageEdited    = "message_edited"
+	EventMessageDeleted   = "message_deleted"
+	EventMessageReactions = "message_reactions"
+)
+
+// MessageEvent carries the message as it is after the change
+type MessageEvent struct {
+	Type      string   `json:"type"`
+	RoomID    string   `json:"room_id"`
+	Message   *Message `json:"message"`
+	Timestamp int64    `json:"timestamp"`
+}
+
+// MessageToGRPC converts a message to its API representation
+func MessageToGRPC(message *Message) *chatGen.ChatMessage {
+	out := &chatGen.ChatMessage{
+		Id:        message.Id,
+		RoomId:    message.RoomID,
+		Content:   message.Content,
+		Kind:      message.Kind,
+		Nick:      message.Nick,
+		Timestamp: message.Timestamp,
+		EditedAt:  message.EditedAt,
+		DeletedAt: message.DeletedAt,
+		Mentions:  message.Mentions,
+		Reactions: make([]*chatGen.Reaction, len(message.Reactions)),
+	}
+	for i := range message.Attachments {
+		out.Attachments = append(out.Attachments, AttachmentToGRPC(&message.Attachments[i]))
+	}
+	if message.ParentId != nil {
+		out.ParentId = *message.ParentId
+	}
+	if message.User != nil {
+		out.User = &userGen.User{
+			Id:    message.User.Id,
+			Name:  message.User.Name,
+			Email: message.User.Email,
+		}
+	}
+	for i, reaction := range message.Reactions {
+		out.Reactions[i] = &chatGen.Reaction{Emoji: reaction.Emoji, UserIds: reaction.UserIDs}
+	}
+	return out
+}
+
+// RevisionToGRPC converts a revision to its API representation
+func RevisionToGRPC(revision *MessageRevision) *chatGen.MessageRevision {
+	return &chatGen.MessageRevision{
+		Id:        revision.Id,
+		MessageId: revision.MessageId,
+		Content:   revision.Content,
+		EditedBy:  revision.EditedBy,
+		CreatedAt: revision.CreatedAt.Unix(),
+	}
 }
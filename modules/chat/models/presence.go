@@ -0,0 +1,68 @@
+package models
+
+import chatGen "github.com/bozoteam/roshan/adapter/grpc/gen/chat"
+
+// PresenceState tells whether a user is around
+type PresenceState string
+
+const (
+	PresenceOffline PresenceState = "offline"
+	PresenceOnline  PresenceState = "online"
+	PresenceIdle    PresenceState = "idle"
+	PresenceAway    PresenceState = "away"
+)
+
+// Presence is what a user is up to
+type Presence struct {
+	UserID string        `json:"user_id"`
+	State  PresenceState `json:"state"`
+	// Unix nanoseconds of the last activity, 0 when the user wasn't seen since the server started
+	LastActiveAt int64 `json:"last_active_at"`
+}
+
+// RoomPresence is sent to a room when presences or the typing list changed, batching
+// everything that happened since the previous one
+type RoomPresence struct {
+	Type   string `json:"type"`
+	RoomID string `json:"room_id"`
+	// Users whose presence changed
+	Users []Presence `json:"users"`
+	// Typing lists everyone typing in the room right now
+	Typing    []string `json:"typing"`
+	Timestamp int64    `json:"timestamp"`
+}
+
+// Events clients send on a room's WebSocket
+const (
+	EventTypingStarted = "typing_started"
+	EventTypingStopped = "typing_stopped"
+	// EventActive is sent on user interaction, so reading without typing doesn't turn idle
+	EventActive = "active"
+)
+
+// ClientEvent is a frame sent by a client
+type ClientEvent struct {
+	Type string `json:"type"`
+	// MessageID is the message read, for EventRead
+	MessageID string `json:"message_id,omitempty"`
+}
+
+var presenceStates = map[PresenceState]chatGen.PresenceState{
+	PresenceOffline: chatGen.PresenceState_PRESENCE_STATE_OFFLINE,
+	PresenceOnline:  chatGen.PresenceState_PRESENCE_STATE_ONLINE,
+	PresenceIdle:    chatGen.PresenceState_PRESENCE_STATE_IDLE,
+	PresenceAway:    chatGen.PresenceState_PRESENCE_STATE_AWAY,
+}
+
+// PresenceToGRPC converts a presence to its API representation
+func PresenceToGRPC(presence Presence) *chatGen.UserPresence {
+	out := &chatGen.UserPresence{
+		UserId: presence.UserID,
+		State:  presenceStates[presence.State],
+	}
+	if presence.LastActiveAt != 0 {
+		lastActiveAt := presence.LastActiveAt / 1e9
+		out.LastActiveAt = &lastActiveAt
+	}
+	return out
+}
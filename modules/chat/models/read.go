@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// ReadMarker is the last message a user read in a room, everything after it is unread
+type ReadMarker struct {
+	UserId    string `gorm:"primaryKey"`
+	RoomId    string `gorm:"primaryKey"`
+	MessageId string `gorm:"not null"`
+	UpdatedAt time.Time
+}
+
+func (ReadMarker) TableName() string {
+	return "room_read_marker"
+}
+
+// EventRead is sent by a client that read a room up to a message, and to the room as a
+// ReadReceipt when read receipts are on
+const EventRead = "read"
+
+// ReadReceipt tells a room how far one of its users read
+type ReadReceipt struct {
+	Type      string `json:"type"`
+	RoomID    string `json:"room_id"`
+	UserID    string `json:"user_id"`
+	MessageID string `json:"message_id"`
+	Timestamp int64  `json:"timestamp"`
+}
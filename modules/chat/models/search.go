@@ -0,0 +1,154 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	chatGen "github.com/bozoteam/roshan/adapter/grpc/gen/chat"
+)
+
+const (
+	// MaxSearchTerms caps the words a search looks for, the rest of the query is ignored
+	MaxSearchTerms = 10
+	// snippetContext is how many words a snippet keeps on each side of the first match
+	snippetContext = 8
+)
+
+// MessageSearch selects messages containing every term, newest first
+type MessageSearch struct {
+	Terms []string
+	// RoomIds limits the search to these rooms unless AllRooms is set
+	RoomIds  []string
+	AllRooms bool
+	AuthorId string
+	// Since and Until bound the message timestamps, in unix nanoseconds, when not 0
+	Since int64
+	Until int64
+	// Before is the decoded cursor, only messages with a smaller id are returned when it's set
+	Before string
+	Limit  int
+}
+
+// SnippetPart is a piece of a snippet, Match is set on the words the search looked for
+type SnippetPart struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match"`
+}
+
+// SearchResult is a message found by a search with the part of it that matched
+type SearchResult struct {
+	Message *Message      `json:"message"`
+	Snippet []SnippetPart `json:"snippet"`
+}
+
+// isWordRune splits text into words the way Postgres' simple configuration mostly does
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// ParseSearchTerms returns the words of a search query, lowercased and without duplicates
+func ParseSearchTerms(query string) []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(query), func(r rune) bool { return !isWordRune(r) }) {
+		if !slices.Contains(terms, word) {
+			terms = append(terms, word)
+		}
+		if len(terms) == MaxSearchTerms {
+			break
+		}
+	}
+	return terms
+}
+
+// word is a word of a text, with its byte offsets
+type word struct {
+	start, end int
+	match      bool
+}
+
+func words(content string, terms []string) []word {
+	var found []word
+	start := -1
+	for i, r := range content + " " {
+		switch {
+		case isWordRune(r) && start < 0:
+			start = i
+		case !isWordRune(r) && start >= 0:
+			found = append(found, word{start: start, end: i, match: slices.Contains(terms, strings.ToLower(content[start:i]))})
+			start = -1
+		}
+	}
+	return found
+}
+
+// ContainsTerms tells whether every term is a word of content
+func ContainsTerms(content string, terms []string) bool {
+	found := words(content, terms)
+	for _, term := range terms {
+		if !slices.ContainsFunc(found, func(w word) bool { return strings.ToLower(content[w.start:w.end]) == term }) {
+			return false
+		}
+	}
+	return true
+}
+
+// BuildSnippet cuts content around its first match, splitting it into the matching words and
+// the text between them. Cut ends are marked with an ellipsis.
+func BuildSnippet(content string, terms []string) []SnippetPart {
+	found := words(content, terms)
+	first := slices.IndexFunc(found, func(w word) bool { return w.match })
+	if first < 0 {
+		first = 0
+	}
+
+	start, end := 0, len(content)
+	if from := first - snippetContext; from > 0 {
+		start = found[from].start
+	}
+	if to := first + snippetContext; to < len(found)-1 {
+		end = found[to].end
+	}
+
+	var parts []SnippetPart
+	add := func(text string, match bool) {
+		if text == "" {
+			return
+		}
+		if n := len(parts); n > 0 && parts[n-1].Match == match {
+			parts[n-1].Text += text
+			return
+		}
+		parts = append(parts, SnippetPart{Text: text, Match: match})
+	}
+
+	if start > 0 {
+		add("…", false)
+	}
+	offset := start
+	for _, w := range found {
+		if !w.match || w.start < start || w.end > end {
+			continue
+		}
+		add(content[offset:w.start], false)
+		add(content[w.start:w.end], true)
+		offset = w.end
+	}
+	add(content[offset:end], false)
+	if end < len(content) {
+		add("…", false)
+	}
+	return parts
+}
+
+// SearchResultToGRPC converts a search result to its API representation
+func SearchResultToGRPC(result *SearchResult) *chatGen.SearchResult {
+	out := &chatGen.SearchResult{
+		Message: MessageToGRPC(result.Message),
+		Snippet: make([]*chatGen.SnippetPart, len(result.Snippet)),
+	}
+	for i, part := range result.Snippet {
+		out.Snippet[i] = &chatGen.SnippetPart{Text: part.Text, Match: part.Match}
+	}
+	return out
+}
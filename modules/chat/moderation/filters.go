@@ -0,0 +1,220 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// LengthFilter rejects messages longer than a number of characters
+type LengthFilter struct {
+	max int
+}
+
+func NewLengthFilter(max int) *LengthFilter {
+	return &LengthFilter{max: max}
+}
+
+func (f *LengthFilter) Name() string {
+	return "length"
+}
+
+func (f *LengthFilter) Check(ctx context.Context, message *Message) (Verdict, error) {
+	if utf8.RuneCountInString(message.Content) > f.max {
+		return Verdict{Action: Reject, Reason: fmt.Sprintf("messages can be at most %d characters", f.max)}, nil
+	}
+	return Verdict{}, nil
+}
+
+var wordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// WordFilter applies its action to messages containing one of its words, compared as whole
+// words regardless of case. Redacting masks each of those words with asterisks.
+type WordFilter struct {
+	words  []string
+	action Action
+}
+
+func NewWordFilter(words []string, action Action) *WordFilter {
+	lowered := make([]string, len(words))
+	for i, word := range words {
+		lowered[i] = strings.ToLower(word)
+	}
+	return &WordFilter{words: lowered, action: action}
+}
+
+func (f *WordFilter) Name() string {
+	return "words"
+}
+
+func (f *WordFilter) Check(ctx context.Context, message *Message) (Verdict, error) {
+	var found []string
+	redacted := wordPattern.ReplaceAllStringFunc(message.Content, func(word string) string {
+		lowered := strings.ToLower(word)
+		if !slices.Contains(f.words, lowered) {
+			return word
+		}
+		if !slices.Contains(found, lowered) {
+			found = append(found, lowered)
+		}
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	})
+	if len(found) == 0 {
+		return Verdict{}, nil
+	}
+
+	switch f.action {
+	case Redact:
+		return Verdict{Action: Redact, Content: redacted}, nil
+	case Flag:
+		return Verdict{Action: Flag, Reason: "contains " + strings.Join(found, ", ")}, nil
+	default:
+		return Verdict{Action: Reject, Reason: "message contains a blocked word"}, nil
+	}
+}
+
+// anything with a scheme or starting with www., and bare domains under common TLDs
+var linkPattern = regexp.MustCompile(`(?i)\b(?:[a-z][a-z0-9+.-]*://|www\.)\S+|\b[a-z0-9-]+(?:\.[a-z0-9-]+)*\.(?:com|net|org|io|gg|co|me|ly|xyz|app|dev|ru|br)\b`)
+
+// LinkFilter rejects links in the rooms blocking them. Rooms follow the default until a
+// moderator decides otherwise for them.
+type LinkFilter struct {
+	mu             sync.RWMutex
+	blockByDefault bool
+	rooms          map[string]bool
+}
+
+func NewLinkFilter(blockByDefault bool) *LinkFilter {
+	return &LinkFilter{blockByDefault: blockByDefault, rooms: map[string]bool{}}
+}
+
+func (f *LinkFilter) Name() string {
+	return "links"
+}
+
+// SetBlocked decides whether the room blocks links
+func (f *LinkFilter) SetBlocked(roomId string, blocked bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rooms[roomId] = blocked
+}
+
+// Forget puts the room back on the default
+func (f *LinkFilter) Forget(roomId string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.rooms, roomId)
+}
+
+func (f *LinkFilter) Blocked(roomId string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if blocked, exists := f.rooms[roomId]; exists {
+		return blocked
+	}
+	return f.blockByDefault
+}
+
+func (f *LinkFilter) Check(ctx context.Context, message *Message) (Verdict, error) {
+	if f.Blocked(message.RoomId) && linkPattern.MatchString(message.Content) {
+		return Verdict{Action: Reject, Reason: "links aren't allowed in this room"}, nil
+	}
+	return Verdict{}, nil
+}
+
+// maxRemembered caps the messages a RepeatFilter keeps for each user and room
+const maxRemembered = 50
+
+type sentMessage struct {
+	content string
+	at      time.Time
+}
+
+// RepeatFilter rejects a message its author already sent limit times in the room within the
+// window. Messages are compared regardless of case and spacing, edits aren't counted. Only
+// the messages the whole chain accepted are remembered.
+type RepeatFilter struct {
+	limit  int
+	window time.Duration
+
+	mu sync.Mutex
+	// sent are the recent messages keyed by user and room, oldest first
+	sent      map[string][]sentMessage
+	lastSweep time.Time
+}
+
+func NewRepeatFilter(limit int, window time.Duration) *RepeatFilter {
+	return &RepeatFilter{limit: limit, window: window, sent: map[string][]sentMessage{}}
+}
+
+func (f *RepeatFilter) Name() string {
+	return "repeat"
+}
+
+func (f *RepeatFilter) Check(ctx context.Context, message *Message) (Verdict, error) {
+	content := normalizeRepeat(message.Content)
+	if message.Edit || content == "" {
+		return Verdict{}, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sent := f.recent(message)
+	repeats := 0
+	for _, m := range sent {
+		if m.content == content {
+			repeats++
+		}
+	}
+	if repeats >= f.limit {
+		return Verdict{Action: Reject, Reason: "you're sending the same message too often"}, nil
+	}
+	return Verdict{}, nil
+}
+
+// Record remembers a message that was sent
+func (f *RepeatFilter) Record(message *Message) {
+	content := normalizeRepeat(message.Content)
+	if message.Edit || content == "" {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sent := f.recent(message)
+	if len(sent) == maxRemembered {
+		sent = slices.Delete(sent, 0, 1)
+	}
+	f.sent[message.UserId+"/"+message.RoomId] = append(sent, sentMessage{content: content, at: message.Time})
+}
+
+// recent drops the messages older than the window and returns the ones left for the author
+// and room of message. f.mu must be held.
+func (f *RepeatFilter) recent(message *Message) []sentMessage {
+	since := message.Time.Add(-f.window)
+	if f.lastSweep.Before(since) {
+		for key, sent := range f.sent {
+			if len(sent) == 0 || sent[len(sent)-1].at.Before(since) {
+				delete(f.sent, key)
+			}
+		}
+		f.lastSweep = message.Time
+	}
+
+	key := message.UserId + "/" + message.RoomId
+	sent := slices.DeleteFunc(f.sent[key], func(m sentMessage) bool { return m.at.Before(since) })
+	f.sent[key] = sent
+	return sent
+}
+
+// normalizeRepeat makes messages differing only by case and spacing compare equal
+func normalizeRepeat(content string) string {
+	return strings.Join(strings.Fields(strings.ToLower(content)), " ")
+}
@@ -0,0 +1,119 @@
+// Package moderation runs chat messages through an ordered chain of filters before they're
+// sent. A filter lets a message through, redacts it, flags it for moderators or rejects it.
+package moderation
+
+import (
+	"context"
+	"time"
+)
+
+// Action is what a filter does with a message
+type Action int
+
+const (
+	// Allow lets the message through unchanged
+	Allow Action = iota
+	// Redact replaces the content with the verdict's and goes on with the next filter
+	Redact
+	// Flag lets the message through and records it for moderators to review
+	Flag
+	// Reject stops the chain, the message isn't sent
+	Reject
+)
+
+// Message is what the filters look at. Content is the text as left by the filters before.
+type Message struct {
+	UserId  string
+	RoomId  string
+	Content string
+	// Edit is set when an existing message's content is replaced
+	Edit bool
+	Time time.Time
+}
+
+// Verdict is a filter's decision on a message. Content is only read on Redact, Reason is
+// shown to the author on Reject and to moderators on Flag.
+type Verdict struct {
+	Action  Action
+	Content string
+	Reason  string
+}
+
+// Filter inspects a message before it's sent. Filters are shared by every room and called
+// concurrently.
+type Filter interface {
+	// Name identifies the filter in flags and logs
+	Name() string
+	Check(ctx context.Context, message *Message) (Verdict, error)
+}
+
+// Recorder is implemented by filters that remember the messages they let through. Record is
+// only called once the whole chain accepted the message, with the message as the filter saw
+// it, so a message rejected further down doesn't count.
+type Recorder interface {
+	Record(message *Message)
+}
+
+// FlagReason is why a filter flagged a message
+type FlagReason struct {
+	Filter string
+	Reason string
+}
+
+// Result is what a message became after the chain
+type Result struct {
+	// Content is the content to send, redacted by the filters
+	Content string
+	// Rejected is set when a filter refused the message, by the filter named in RejectedBy
+	Rejected   bool
+	RejectedBy string
+	Reason     string
+	Flags      []FlagReason
+}
+
+// Chain runs its filters in the order they were added
+type Chain struct {
+	filters []Filter
+}
+
+func NewChain(filters ...Filter) *Chain {
+	return &Chain{filters: filters}
+}
+
+// Add appends a filter, it runs after the ones already there. Filters are added while the
+// server starts, before any message goes through the chain.
+func (c *Chain) Add(filter Filter) {
+	c.filters = append(c.filters, filter)
+}
+
+// Check runs message through the filters until one rejects it. A failing filter stops the
+// chain with its error.
+func (c *Chain) Check(ctx context.Context, message *Message) (*Result, error) {
+	checked := *message
+	result := &Result{}
+	var records []func()
+	for _, filter := range c.filters {
+		verdict, err := filter.Check(ctx, &checked)
+		if err != nil {
+			return nil, err
+		}
+		if recorder, ok := filter.(Recorder); ok {
+			seen := checked
+			records = append(records, func() { recorder.Record(&seen) })
+		}
+		switch verdict.Action {
+		case Redact:
+			checked.Content = verdict.Content
+		case Flag:
+			result.Flags = append(result.Flags, FlagReason{Filter: filter.Name(), Reason: verdict.Reason})
+		case Reject:
+			result.Rejected, result.RejectedBy, result.Reason = true, filter.Name(), verdict.Reason
+			return result, nil
+		}
+	}
+	for _, record := range records {
+		record()
+	}
+	result.Content = checked.Content
+	return result, nil
+}
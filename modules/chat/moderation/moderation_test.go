@@ -0,0 +1,125 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func check(t *testing.T, chain *Chain, message *Message) *Result {
+	t.Helper()
+	result, err := chain.Check(context.Background(), message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestChain(t *testing.T) {
+	chain := NewChain(
+		NewWordFilter([]string{"Darn"}, Redact),
+		NewWordFilter([]string{"heck"}, Flag),
+		NewLengthFilter(20),
+	)
+
+	result := check(t, chain, &Message{Content: "DARN, what the heck"})
+	if result.Rejected || result.Content != "****, what the heck" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if want := []FlagReason{{Filter: "words", Reason: "contains heck"}}; !slices.Equal(result.Flags, want) {
+		t.Fatalf("flags %+v, want %+v", result.Flags, want)
+	}
+
+	// darned isn't darn
+	if result := check(t, chain, &Message{Content: "darned"}); result.Content != "darned" {
+		t.Fatalf("partial word redacted: %+v", result)
+	}
+
+	result = check(t, chain, &Message{Content: "heck, this is way too long"})
+	if !result.Rejected || result.RejectedBy != "length" || result.Content != "" {
+		t.Fatalf("long message: %+v", result)
+	}
+
+	failing := errors.New("boom")
+	chain.Add(failingFilter{err: failing})
+	if _, err := chain.Check(context.Background(), &Message{Content: "hi"}); !errors.Is(err, failing) {
+		t.Fatalf("got %v, want the filter's error", err)
+	}
+}
+
+type failingFilter struct{ err error }
+
+func (f failingFilter) Name() string { return "failing" }
+func (f failingFilter) Check(ctx context.Context, message *Message) (Verdict, error) {
+	return Verdict{}, f.err
+}
+
+func TestLinkFilter(t *testing.T) {
+	links := NewLinkFilter(false)
+	chain := NewChain(links)
+	links.SetBlocked("quiet", true)
+
+	for _, content := range []string{"see https://example.org/x", "www.example.net", "go to example.com now", "ftp://files"} {
+		if !check(t, chain, &Message{RoomId: "quiet", Content: content}).Rejected {
+			t.Errorf("%q was let through", content)
+		}
+		if check(t, chain, &Message{RoomId: "open", Content: content}).Rejected {
+			t.Errorf("%q was rejected in a room allowing links", content)
+		}
+	}
+	if check(t, chain, &Message{RoomId: "quiet", Content: "e.g. this, version 1.2"}).Rejected {
+		t.Error("text without links was rejected")
+	}
+
+	links.Forget("quiet")
+	if links.Blocked("quiet") {
+		t.Error("forgotten room still blocks links")
+	}
+}
+
+func TestRepeatFilter(t *testing.T) {
+	chain := NewChain(NewRepeatFilter(2, time.Minute))
+	now := time.Now()
+	send := func(content string, at time.Duration, edit bool) bool {
+		return !check(t, chain, &Message{UserId: "alice", RoomId: "general", Content: content, Edit: edit, Time: now.Add(at)}).Rejected
+	}
+
+	if !send("buy now", 0, false) || !send("Buy   NOW", time.Second, false) {
+		t.Fatal("first two messages rejected")
+	}
+	if send("buy now", 2*time.Second, false) {
+		t.Fatal("third repeat let through")
+	}
+	if !send("buy now", 2*time.Second, true) || !send("something else", 3*time.Second, false) {
+		t.Fatal("edit or different message rejected")
+	}
+	if check(t, chain, &Message{UserId: "bob", RoomId: "general", Content: "buy now", Time: now}).Rejected {
+		t.Fatal("bob rejected for alice's messages")
+	}
+	if !send("buy now", time.Minute+time.Second+1, false) {
+		t.Fatal("repeat rejected once the window passed")
+	}
+}
+
+func TestRepeatFilterOnlyCountsAcceptedMessages(t *testing.T) {
+	chain := NewChain(NewRepeatFilter(1, time.Minute), NewWordFilter([]string{"darn"}, Reject))
+	now := time.Now()
+	send := func(content string) *Result {
+		return check(t, chain, &Message{UserId: "alice", RoomId: "general", Content: content, Time: now})
+	}
+
+	// retyping a message the word filter refused keeps getting the word filter's reason
+	for range 2 {
+		if result := send("darn it"); result.RejectedBy != "words" {
+			t.Fatalf("got %+v, want a rejection by the word filter", result)
+		}
+	}
+	if result := send("fine"); result.Rejected {
+		t.Fatalf("first clean message: %+v", result)
+	}
+	if result := send("fine"); result.RejectedBy != "repeat" {
+		t.Fatalf("got %+v, want a rejection for repeating", result)
+	}
+}
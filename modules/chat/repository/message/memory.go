@@ -0,0 +1,398 @@
+package messageRepository
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bozoteam/roshan/modules/chat/models"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	"gorm.io/gorm"
+)
+
+var _ MessageRepository = (*MemoryMessageRepository)(nil)
+
+// MemoryMessageRepository is a thread-safe MessageRepository kept in memory. Messages keep
+// a copy of their author as it was when they were saved.
+type MemoryMessageRepository struct {
+	mu        sync.RWMutex
+	messages  map[string]models.Message
+	reactions []models.MessageReaction
+	revisions []models.MessageRevision
+	mentions  []models.Mention
+	// attachments are kept in the order they were uploaded
+	attachments []models.Attachment
+	// markers are keyed by user id, then room id
+	markers map[string]map[string]models.ReadMarker
+	// flags are kept in the order they were raised
+	flags []models.MessageFlag
+}
+
+func NewMemoryMessageRepository() *MemoryMessageRepository {
+	return &MemoryMessageRepository{
+		messages: map[string]models.Message{},
+		markers:  map[string]map[string]models.ReadMarker{},
+	}
+}
+
+func (r *MemoryMessageRepository) SaveMessage(ctx context.Context, message *models.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, attachment := range message.Attachments {
+		i := slices.IndexFunc(r.attachments, func(a models.Attachment) bool { return a.Id == attachment.Id })
+		if i < 0 || r.attachments[i].UserId != message.UserID || r.attachments[i].MessageId != nil {
+			return ErrAttachmentUnavailable
+		}
+	}
+	messageId := message.Id
+	for i, attachment := range r.attachments {
+		if slices.ContainsFunc(message.Attachments, func(a models.Attachment) bool { return a.Id == attachment.Id }) {
+			r.attachments[i].MessageId = &messageId
+		}
+	}
+
+	stored := *message
+	if message.User != nil {
+		stored.User = message.User.Clone()
+	}
+	stored.Attachments = nil
+	stored.Reactions = nil
+	stored.Mentions = slices.Clone(message.Mentions)
+	r.messages[message.Id] = stored
+	for _, userId := range message.Mentions {
+		r.mentions = append(r.mentions, models.Mention{
+			MessageId: message.Id,
+			UserId:    userId,
+			RoomId:    message.RoomID,
+			CreatedAt: time.Now(),
+		})
+	}
+	return nil
+}
+
+func (r *MemoryMessageRepository) FindMessageById(ctx context.Context, id string) (*models.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.messages[id]; !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return r.load(id), nil
+}
+
+func (r *MemoryMessageRepository) ListThread(ctx context.Context, parentId string) ([]*models.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	replies := []*models.Message{}
+	for id, message := range r.messages {
+		if message.ParentId != nil && *message.ParentId == parentId {
+			replies = append(replies, r.load(id))
+		}
+	}
+	slices.SortFunc(replies, func(a, b *models.Message) int { return cmp.Compare(a.Id, b.Id) })
+	return replies, nil
+}
+
+func (r *MemoryMessageRepository) ListRoomAuthors(ctx context.Context, roomId string) ([]*userModel.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	authors := map[string]*userModel.User{}
+	for _, message := range r.messages {
+		if message.RoomID == roomId && message.User != nil {
+			authors[message.UserID] = message.User.Clone()
+		}
+	}
+	return slices.Collect(maps.Values(authors)), nil
+}
+
+func (r *MemoryMessageRepository) UpdateMessage(ctx context.Context, message *models.Message, revision *models.MessageRevision) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.messages[message.Id]
+	if !ok {
+		return nil
+	}
+	if revision.CreatedAt.IsZero() {
+		revision.CreatedAt = time.Now()
+	}
+	r.revisions = append(r.revisions, *revision)
+
+	stored.Content = message.Content
+	stored.EditedAt = message.EditedAt
+	stored.DeletedAt = message.DeletedAt
+	r.mentions = slices.DeleteFunc(r.mentions, func(m models.Mention) bool {
+		return m.MessageId == message.Id && !slices.Contains(message.Mentions, m.UserId)
+	})
+	for _, userId := range message.Mentions {
+		if !slices.Contains(stored.Mentions, userId) {
+			r.mentions = append(r.mentions, models.Mention{
+				MessageId: message.Id,
+				UserId:    userId,
+				RoomId:    message.RoomID,
+				CreatedAt: time.Now(),
+			})
+		}
+	}
+	stored.Mentions = slices.Clone(message.Mentions)
+	r.messages[message.Id] = stored
+	return nil
+}
+
+func (r *MemoryMessageRepository) AddReaction(ctx context.Context, reaction *models.MessageReaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if slices.ContainsFunc(r.reactions, sameReaction(reaction)) {
+		return nil
+	}
+	if reaction.CreatedAt.IsZero() {
+		reaction.CreatedAt = time.Now()
+	}
+	r.reactions = append(r.reactions, *reaction)
+	return nil
+}
+
+func (r *MemoryMessageRepository) RemoveReaction(ctx context.Context, reaction *models.MessageReaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reactions = slices.DeleteFunc(r.reactions, sameReaction(reaction))
+	return nil
+}
+
+func (r *MemoryMessageRepository) ListReactions(ctx context.Context, messageId string) ([]models.MessageReaction, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.reactionsOf(messageId), nil
+}
+
+func (r *MemoryMessageRepository) ListRevisions(ctx context.Context, messageId string) ([]*models.MessageRevision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	revisions := []*models.MessageRevision{}
+	for _, revision := range r.revisions {
+		if revision.MessageId == messageId {
+			revisions = append(revisions, &revision)
+		}
+	}
+	return revisions, nil
+}
+
+// reactionsOf is called with the lock held, reactions are kept in the order they were added
+func (r *MemoryMessageRepository) reactionsOf(messageId string) []models.MessageReaction {
+	reactions := []models.MessageReaction{}
+	for _, reaction := range r.reactions {
+		if reaction.MessageId == messageId {
+			reactions = append(reactions, reaction)
+		}
+	}
+	return reactions
+}
+
+func sameReaction(reaction *models.MessageReaction) func(models.MessageReaction) bool {
+	return func(other models.MessageReaction) bool {
+		return other.MessageId == reaction.MessageId && other.UserId == reaction.UserId && other.Emoji == reaction.Emoji
+	}
+}
+
+func (r *MemoryMessageRepository) ListUnreadMentions(ctx context.Context, userId string, limit int) ([]*models.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	messages := []*models.Message{}
+	for _, mention := range r.mentions {
+		if mention.UserId == userId && mention.ReadAt == nil {
+			messages = append(messages, r.load(mention.MessageId))
+		}
+	}
+	slices.SortFunc(messages, func(a, b *models.Message) int { return cmp.Compare(b.Id, a.Id) })
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+func (r *MemoryMessageRepository) CountUnreadMentions(ctx context.Context, userId string) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var count int64
+	for _, mention := range r.mentions {
+		if mention.UserId == userId && mention.ReadAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *MemoryMessageRepository) MarkMentionsRead(ctx context.Context, userId string, messageIds []string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, mention := range r.mentions {
+		if mention.UserId != userId || mention.ReadAt != nil {
+			continue
+		}
+		if len(messageIds) == 0 || slices.Contains(messageIds, mention.MessageId) {
+			r.mentions[i].ReadAt = &now
+		}
+	}
+	return nil
+}
+
+func (r *MemoryMessageRepository) MarkRead(ctx context.Context, marker *models.ReadMarker, now time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	markers, exists := r.markers[marker.UserId]
+	if !exists {
+		markers = map[string]models.ReadMarker{}
+		r.markers[marker.UserId] = markers
+	}
+	if previous, exists := markers[marker.RoomId]; exists && previous.MessageId >= marker.MessageId {
+		return false, nil
+	}
+	marker.UpdatedAt = now
+	markers[marker.RoomId] = *marker
+
+	for i, mention := range r.mentions {
+		if mention.UserId == marker.UserId && mention.RoomId == marker.RoomId && mention.ReadAt == nil && mention.MessageId <= marker.MessageId {
+			r.mentions[i].ReadAt = &now
+		}
+	}
+	return true, nil
+}
+
+func (r *MemoryMessageRepository) CountUnread(ctx context.Context, userId string, roomIds []string) (map[string]int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := map[string]int64{}
+	for _, message := range r.messages {
+		if !slices.Contains(roomIds, message.RoomID) || message.UserID == userId || message.IsDeleted() {
+			continue
+		}
+		if marker, exists := r.markers[userId][message.RoomID]; exists && message.Id <= marker.MessageId {
+			continue
+		}
+		counts[message.RoomID]++
+	}
+	return counts, nil
+}
+
+func (r *MemoryMessageRepository) SaveAttachment(ctx context.Context, attachment *models.Attachment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if attachment.CreatedAt.IsZero() {
+		attachment.CreatedAt = time.Now()
+	}
+	r.attachments = append(r.attachments, *attachment)
+	return nil
+}
+
+func (r *MemoryMessageRepository) FindAttachmentById(ctx context.Context, id string) (*models.Attachment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, attachment := range r.attachments {
+		if attachment.Id == id {
+			return &attachment, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *MemoryMessageRepository) SearchMessages(ctx context.Context, search *models.MessageSearch) ([]*models.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	messages := []*models.Message{}
+	for id, message := range r.messages {
+		switch {
+		case message.IsDeleted(),
+			!search.AllRooms && !slices.Contains(search.RoomIds, message.RoomID),
+			search.AuthorId != "" && message.UserID != search.AuthorId,
+			search.Since != 0 && message.Timestamp < search.Since,
+			search.Until != 0 && message.Timestamp >= search.Until,
+			search.Before != "" && id >= search.Before,
+			!models.ContainsTerms(message.Content, search.Terms):
+			continue
+		}
+		messages = append(messages, r.load(id))
+	}
+	slices.SortFunc(messages, func(a, b *models.Message) int { return cmp.Compare(b.Id, a.Id) })
+	if len(messages) > search.Limit {
+		messages = messages[:search.Limit]
+	}
+	return messages, nil
+}
+
+func (r *MemoryMessageRepository) SaveFlags(ctx context.Context, flags []models.MessageFlag) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, flag := range flags {
+		if flag.CreatedAt.IsZero() {
+			flag.CreatedAt = time.Now()
+		}
+		flag.Message = nil
+		r.flags = append(r.flags, flag)
+	}
+	return nil
+}
+
+func (r *MemoryMessageRepository) ListFlags(ctx context.Context, limit int) ([]*models.MessageFlag, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	flags := []*models.MessageFlag{}
+	for _, flag := range r.flags {
+		if flag.ResolvedAt != nil {
+			continue
+		}
+		if len(flags) == limit {
+			break
+		}
+		flag.Message = r.load(flag.MessageId)
+		flags = append(flags, &flag)
+	}
+	return flags, nil
+}
+
+func (r *MemoryMessageRepository) ResolveFlag(ctx context.Context, flagId string, moderatorId string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := slices.IndexFunc(r.flags, func(flag models.MessageFlag) bool { return flag.Id == flagId && flag.ResolvedAt == nil })
+	if i < 0 {
+		return gorm.ErrRecordNotFound
+	}
+	r.flags[i].ResolvedBy, r.flags[i].ResolvedAt = &moderatorId, &now
+	return nil
+}
+
+// load is called with the lock held, it copies a stored message with its reactions and attachments
+func (r *MemoryMessageRepository) load(id string) *models.Message {
+	message := r.messages[id]
+	if message.User != nil {
+		message.User = message.User.Clone()
+	}
+	message.Mentions = slices.Clone(message.Mentions)
+	message.Reactions = models.GroupReactions(r.reactionsOf(id))
+	message.Attachments = nil
+	for _, attachment := range r.attachments {
+		if attachment.MessageId != nil && *attachment.MessageId == id && !message.IsDeleted() {
+			message.Attachments = append(message.Attachments, attachment)
+		}
+	}
+	return &message
+}
@@ -0,0 +1,415 @@
+package messageRepository
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	log "github.com/bozoteam/roshan/adapter/log"
+	"github.com/bozoteam/roshan/modules/chat/models"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrAttachmentUnavailable is returned by SaveMessage when one of the message's attachments
+// was sent already or uploaded by someone else
+var ErrAttachmentUnavailable = errors.New("attachment unavailable")
+
+// MessageRepository stores chat messages with their reactions, revisions and attachments.
+// Lookups of missing messages fail with gorm.ErrRecordNotFound, whatever the backend.
+type MessageRepository interface {
+	// SaveMessage stores the message along with a Mention for each of its mentions, and
+	// attaches its attachments to it
+	SaveMessage(ctx context.Context, message *models.Message) error
+	// FindMessageById loads the message with its author, mentions, reactions and, unless it
+	// was deleted, attachments
+	FindMessageById(ctx context.Context, id string) (*models.Message, error)
+	// ListThread returns the replies to a message, oldest first
+	ListThread(ctx context.Context, parentId string) ([]*models.Message, error)
+	// ListRoomAuthors returns everyone who posted in the room
+	ListRoomAuthors(ctx context.Context, roomId string) ([]*userModel.User, error)
+	// UpdateMessage stores the message's content, edit and delete times together with the
+	// revision keeping the content it replaced. Its mentions become message.Mentions, the
+	// ones it already had keep their read state.
+	UpdateMessage(ctx context.Context, message *models.Message, revision *models.MessageRevision) error
+	// AddReaction does nothing when the user already reacted with that emoji
+	AddReaction(ctx context.Context, reaction *models.MessageReaction) error
+	RemoveReaction(ctx context.Context, reaction *models.MessageReaction) error
+	// ListReactions returns the message's reactions, oldest first
+	ListReactions(ctx context.Context, messageId string) ([]models.MessageReaction, error)
+	// ListRevisions returns the message's revisions, oldest first
+	ListRevisions(ctx context.Context, messageId string) ([]*models.MessageRevision, error)
+
+	// ListUnreadMentions returns up to limit messages mentioning the user that they haven't
+	// read, newest first
+	ListUnreadMentions(ctx context.Context, userId string, limit int) ([]*models.Message, error)
+	CountUnreadMentions(ctx context.Context, userId string) (int64, error)
+	// MarkMentionsRead marks the user's mentions in those messages read, every mention when
+	// messageIds is empty
+	MarkMentionsRead(ctx context.Context, userId string, messageIds []string, now time.Time) error
+
+	// MarkRead moves the user's marker in the room forward to marker.MessageId, marking their
+	// mentions up to it read. It tells whether the marker moved.
+	MarkRead(ctx context.Context, marker *models.ReadMarker, now time.Time) (bool, error)
+	// CountUnread counts, for each room, the messages from others past the user's marker
+	// that weren't deleted. Rooms without unread messages are left out.
+	CountUnread(ctx context.Context, userId string, roomIds []string) (map[string]int64, error)
+
+	SaveAttachment(ctx context.Context, attachment *models.Attachment) error
+	FindAttachmentById(ctx context.Context, id string) (*models.Attachment, error)
+
+	// SearchMessages returns up to search.Limit messages that weren't deleted and contain
+	// every term as a word, newest first
+	SearchMessages(ctx context.Context, search *models.MessageSearch) ([]*models.Message, error)
+
+	SaveFlags(ctx context.Context, flags []models.MessageFlag) error
+	// ListFlags returns up to limit flags no moderator resolved yet with their message,
+	// oldest first
+	ListFlags(ctx context.Context, limit int) ([]*models.MessageFlag, error)
+	// ResolveFlag records who reviewed a pending flag, resolved flags are not found
+	ResolveFlag(ctx context.Context, flagId string, moderatorId string, now time.Time) error
+}
+
+var _ MessageRepository = (*GormMessageRepository)(nil)
+
+func NewMessageRepository(db *gorm.DB) *GormMessageRepository {
+	return &GormMessageRepository{db: db, logger: log.LogWithModule("message_repository")}
+}
+
+// GormMessageRepository is the MessageRepository backed by Postgres
+type GormMessageRepository struct {
+	logger *slog.Logger
+	db     *gorm.DB
+}
+
+func (r *GormMessageRepository) SaveMessage(ctx context.Context, message *models.Message) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(message).Error; err != nil {
+			return err
+		}
+
+		if len(message.Attachments) > 0 {
+			ids := make([]string, len(message.Attachments))
+			for i, attachment := range message.Attachments {
+				ids[i] = attachment.Id
+			}
+			result := tx.Model(&models.Attachment{}).
+				Where("id IN ? AND user_id = ? AND message_id IS NULL", ids, message.UserID).
+				Update("message_id", message.Id)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected != int64(len(ids)) {
+				return ErrAttachmentUnavailable
+			}
+		}
+
+		if len(message.Mentions) == 0 {
+			return nil
+		}
+		mentions := make([]models.Mention, len(message.Mentions))
+		for i, userId := range message.Mentions {
+			mentions[i] = models.Mention{MessageId: message.Id, UserId: userId, RoomId: message.RoomID}
+		}
+		return tx.Create(&mentions).Error
+	})
+}
+
+func (r *GormMessageRepository) FindMessageById(ctx context.Context, id string) (*models.Message, error) {
+	var message models.Message
+	if err := r.db.WithContext(ctx).Preload("User").First(&message, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	if err := r.decorate(ctx, []*models.Message{&message}); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+func (r *GormMessageRepository) ListThread(ctx context.Context, parentId string) ([]*models.Message, error) {
+	var messages []*models.Message
+	if err := r.db.WithContext(ctx).Preload("User").Where("parent_id = ?", parentId).Order("id").Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	if err := r.decorate(ctx, messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (r *GormMessageRepository) ListRoomAuthors(ctx context.Context, roomId string) ([]*userModel.User, error) {
+	var users []*userModel.User
+	err := r.db.WithContext(ctx).
+		Where(`id IN (SELECT DISTINCT "user_id" FROM "message" WHERE "room_id" = ?)`, roomId).
+		Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// decorate fills the mentions, reactions and attachments of messages
+func (r *GormMessageRepository) decorate(ctx context.Context, messages []*models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		ids[i] = message.Id
+	}
+
+	var reactions []models.MessageReaction
+	if err := r.db.WithContext(ctx).Where("message_id IN ?", ids).Order("created_at, user_id").Find(&reactions).Error; err != nil {
+		return err
+	}
+	var mentions []models.Mention
+	if err := r.db.WithContext(ctx).Where("message_id IN ?", ids).Order("created_at, user_id").Find(&mentions).Error; err != nil {
+		return err
+	}
+	var attachments []models.Attachment
+	if err := r.db.WithContext(ctx).Where("message_id IN ?", ids).Order("created_at, id").Find(&attachments).Error; err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		var own []models.MessageReaction
+		for _, reaction := range reactions {
+			if reaction.MessageId == message.Id {
+				own = append(own, reaction)
+			}
+		}
+		message.Reactions = models.GroupReactions(own)
+		message.Mentions = nil
+		for _, mention := range mentions {
+			if mention.MessageId == message.Id {
+				message.Mentions = append(message.Mentions, mention.UserId)
+			}
+		}
+		// the files of a deleted message go with its content
+		message.Attachments = nil
+		for _, attachment := range attachments {
+			if *attachment.MessageId == message.Id && !message.IsDeleted() {
+				message.Attachments = append(message.Attachments, attachment)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *GormMessageRepository) UpdateMessage(ctx context.Context, message *models.Message, revision *models.MessageRevision) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(revision).Error; err != nil {
+			return err
+		}
+		err := tx.Model(&models.Message{Id: message.Id}).Updates(map[string]any{
+			"content":    message.Content,
+			"edited_at":  message.EditedAt,
+			"deleted_at": message.DeletedAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		dropped := tx.Where("message_id = ?", message.Id)
+		if len(message.Mentions) > 0 {
+			dropped = dropped.Where("user_id NOT IN ?", message.Mentions)
+		}
+		if err := dropped.Delete(&models.Mention{}).Error; err != nil {
+			return err
+		}
+		if len(message.Mentions) == 0 {
+			return nil
+		}
+		mentions := make([]models.Mention, len(message.Mentions))
+		for i, userId := range message.Mentions {
+			mentions[i] = models.Mention{MessageId: message.Id, UserId: userId, RoomId: message.RoomID}
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&mentions).Error
+	})
+}
+
+func (r *GormMessageRepository) AddReaction(ctx context.Context, reaction *models.MessageReaction) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(reaction).Error
+}
+
+func (r *GormMessageRepository) RemoveReaction(ctx context.Context, reaction *models.MessageReaction) error {
+	return r.db.WithContext(ctx).
+		Where("message_id = ? AND user_id = ? AND emoji = ?", reaction.MessageId, reaction.UserId, reaction.Emoji).
+		Delete(&models.MessageReaction{}).Error
+}
+
+func (r *GormMessageRepository) ListReactions(ctx context.Context, messageId string) ([]models.MessageReaction, error) {
+	var reactions []models.MessageReaction
+	err := r.db.WithContext(ctx).Where("message_id = ?", messageId).Order("created_at, user_id").Find(&reactions).Error
+	if err != nil {
+		return nil, err
+	}
+	return reactions, nil
+}
+
+func (r *GormMessageRepository) ListRevisions(ctx context.Context, messageId string) ([]*models.MessageRevision, error) {
+	var revisions []*models.MessageRevision
+	err := r.db.WithContext(ctx).Where("message_id = ?", messageId).Order("created_at, id").Find(&revisions).Error
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (r *GormMessageRepository) ListUnreadMentions(ctx context.Context, userId string, limit int) ([]*models.Message, error) {
+	var messages []*models.Message
+	err := r.db.WithContext(ctx).Preload("User").
+		Where(`id IN (SELECT "message_id" FROM "message_mention" WHERE "user_id" = ? AND "read_at" IS NULL)`, userId).
+		Order("id DESC").Limit(limit).Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := r.decorate(ctx, messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (r *GormMessageRepository) CountUnreadMentions(ctx context.Context, userId string) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.Mention{}).Where("user_id = ? AND read_at IS NULL", userId).Count(&count).Error
+	return count, err
+}
+
+func (r *GormMessageRepository) MarkMentionsRead(ctx context.Context, userId string, messageIds []string, now time.Time) error {
+	query := r.db.WithContext(ctx).Model(&models.Mention{}).Where("user_id = ? AND read_at IS NULL", userId)
+	if len(messageIds) > 0 {
+		query = query.Where("message_id IN ?", messageIds)
+	}
+	return query.Update("read_at", now).Error
+}
+
+func (r *GormMessageRepository) MarkRead(ctx context.Context, marker *models.ReadMarker, now time.Time) (bool, error) {
+	marker.UpdatedAt = now
+	moved := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "room_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"message_id", "updated_at"}),
+			Where: clause.Where{Exprs: []clause.Expression{
+				gorm.Expr(`"room_read_marker"."message_id" < "excluded"."message_id"`),
+			}},
+		}).Create(marker)
+		if result.Error != nil {
+			return result.Error
+		}
+		moved = result.RowsAffected > 0
+		if !moved {
+			return nil
+		}
+		return tx.Model(&models.Mention{}).
+			Where("user_id = ? AND room_id = ? AND read_at IS NULL AND message_id <= ?", marker.UserId, marker.RoomId, marker.MessageId).
+			Update("read_at", now).Error
+	})
+	return moved, err
+}
+
+func (r *GormMessageRepository) CountUnread(ctx context.Context, userId string, roomIds []string) (map[string]int64, error) {
+	var rows []struct {
+		RoomId string
+		Count  int64
+	}
+	err := r.db.WithContext(ctx).Table(`"message" m`).
+		Select("m.room_id, COUNT(*) AS count").
+		Joins(`LEFT JOIN "room_read_marker" r ON r.room_id = m.room_id AND r.user_id = ?`, userId).
+		Where("m.room_id IN ? AND m.user_id <> ? AND m.deleted_at = 0", roomIds, userId).
+		Where("r.message_id IS NULL OR m.id > r.message_id").
+		Group("m.room_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.RoomId] = row.Count
+	}
+	return counts, nil
+}
+
+func (r *GormMessageRepository) SaveAttachment(ctx context.Context, attachment *models.Attachment) error {
+	return r.db.WithContext(ctx).Create(attachment).Error
+}
+
+func (r *GormMessageRepository) FindAttachmentById(ctx context.Context, id string) (*models.Attachment, error) {
+	var attachment models.Attachment
+	if err := r.db.WithContext(ctx).First(&attachment, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// SearchMessages matches the terms against the message's search column, the content's
+// tsvector under the simple configuration, so words are compared lowercased and unstemmed
+func (r *GormMessageRepository) SearchMessages(ctx context.Context, search *models.MessageSearch) ([]*models.Message, error) {
+	query := r.db.WithContext(ctx).Preload("User").
+		Where("search @@ plainto_tsquery('simple', ?)", strings.Join(search.Terms, " ")).
+		Where("deleted_at = 0")
+	if !search.AllRooms {
+		query = query.Where("room_id IN ?", search.RoomIds)
+	}
+	if search.AuthorId != "" {
+		query = query.Where("user_id = ?", search.AuthorId)
+	}
+	if search.Since != 0 {
+		query = query.Where("timestamp >= ?", search.Since)
+	}
+	if search.Until != 0 {
+		query = query.Where("timestamp < ?", search.Until)
+	}
+	if search.Before != "" {
+		query = query.Where("id < ?", search.Before)
+	}
+
+	var messages []*models.Message
+	if err := query.Order("id DESC").Limit(search.Limit).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	if err := r.decorate(ctx, messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (r *GormMessageRepository) SaveFlags(ctx context.Context, flags []models.MessageFlag) error {
+	return r.db.WithContext(ctx).Omit(clause.Associations).Create(&flags).Error
+}
+
+func (r *GormMessageRepository) ListFlags(ctx context.Context, limit int) ([]*models.MessageFlag, error) {
+	var flags []*models.MessageFlag
+	err := r.db.WithContext(ctx).Preload("Message.User").
+		Where("resolved_at IS NULL").Order("id").Limit(limit).Find(&flags).Error
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*models.Message, len(flags))
+	for i, flag := range flags {
+		messages[i] = flag.Message
+	}
+	if err := r.decorate(ctx, messages); err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+func (r *GormMessageRepository) ResolveFlag(ctx context.Context, flagId string, moderatorId string, now time.Time) error {
+	result := r.db.WithContext(ctx).Model(&models.MessageFlag{}).
+		Where("id = ? AND resolved_at IS NULL", flagId).
+		Updates(map[string]any{"resolved_by": moderatorId, "resolved_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bozoteam/roshan/adapter/blobstore"
+	"github.com/bozoteam/roshan/helpers"
+	"github.com/bozoteam/roshan/modules/chat/models"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	"github.com/bozoteam/roshan/roshan_errors"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAttachmentNotFound    = status.Error(codes.NotFound, "attachment not found")
+	ErrAttachmentUnavailable = status.Error(codes.FailedPrecondition, "attachments must be your own and not sent yet")
+	ErrTooManyAttachments    = status.Errorf(codes.InvalidArgument, "a message can carry at most %d attachments", models.MaxAttachments)
+)
+
+const (
+	// multipartOverhead is the room left for the multipart framing around an uploaded file
+	multipartOverhead = 64 << 10
+
+	variantFile      = "file"
+	variantThumbnail = "thumbnail"
+)
+
+// UploadAttachment stores the file of a multipart upload, in its "file" field, and answers
+// with the attachment to reference when sending a message
+func (u *ChatUsecase) UploadAttachment(ctx *gin.Context) {
+	user := ctx.MustGet("user").(*userModel.User)
+	reqCtx := ctx.Request.Context()
+	cfg := u.attachments
+
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, cfg.MaxSize+multipartOverhead)
+	header, err := ctx.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || (err == nil && header.Size > cfg.MaxSize) {
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Files can be at most %d bytes", cfg.MaxSize)})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Expected a multipart form with a file field"})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		u.logger.ErrorContext(reqCtx, "Failed to open upload", "error", err, "user_id", user.Id)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Upload failed"})
+		return
+	}
+	defer file.Close()
+
+	// the content decides the type, whatever the client claims
+	sniffed := make([]byte, 512)
+	n, err := io.ReadFull(file, sniffed)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		u.logger.ErrorContext(reqCtx, "Failed to read upload", "error", err, "user_id", user.Id)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Upload failed"})
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(sniffed[:n]))
+	if !slices.Contains(cfg.AllowedTypes, contentType) {
+		ctx.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Files of type " + contentType + " are not accepted"})
+		return
+	}
+
+	attachment := &models.Attachment{
+		Id:          helpers.GenUUID(),
+		UserId:      user.Id,
+		Name:        attachmentName(header.Filename),
+		ContentType: contentType,
+		Size:        header.Size,
+	}
+
+	var thumbnail []byte
+	if strings.HasPrefix(contentType, "image/") {
+		if _, err := file.Seek(0, io.SeekStart); err == nil {
+			// formats without a decoder, such as WebP, are kept without a thumbnail
+			thumbnail, attachment.Width, attachment.Height, err = makeThumbnail(file, cfg.ThumbnailSize)
+			if err != nil {
+				u.logger.DebugContext(reqCtx, "No thumbnail for upload", "error", err, "content_type", contentType)
+			}
+		}
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		u.logger.ErrorContext(reqCtx, "Failed to rewind upload", "error", err, "user_id", user.Id)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Upload failed"})
+		return
+	}
+	if err := u.blobStore.Put(reqCtx, attachment.BlobKey(), contentType, file, attachment.Size); err != nil {
+		u.logger.ErrorContext(reqCtx, "Failed to store upload", "error", err, "user_id", user.Id)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Upload failed"})
+		return
+	}
+	if thumbnail != nil {
+		err := u.blobStore.Put(reqCtx, attachment.ThumbnailKey(), "image/jpeg", bytes.NewReader(thumbnail), int64(len(thumbnail)))
+		if err != nil {
+			u.logger.WarnContext(reqCtx, "Failed to store thumbnail", "error", err, "attachment_id", attachment.Id)
+		}
+		attachment.HasThumbnail = err == nil
+	}
+
+	if err := u.messageRepository.SaveAttachment(reqCtx, attachment); err != nil {
+		u.logger.ErrorContext(reqCtx, "Failed to save attachment", "error", err, "user_id", user.Id)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Upload failed"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, attachment)
+}
+
+// attachmentName keeps the base name of what the client called the file, without control
+// characters and cut to fit the column
+func attachmentName(filename string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, filepath.Base(strings.ReplaceAll(filename, "\\", "/")))
+
+	for len(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
+
+// messageAttachments loads the attachments about to be sent by user, which must be theirs
+// and not sent yet
+func (u *ChatUsecase) messageAttachments(ctx context.Context, user *userModel.User, attachmentIds []string) ([]models.Attachment, error) {
+	if len(attachmentIds) > models.MaxAttachments {
+		return nil, ErrTooManyAttachments
+	}
+
+	attachments := make([]models.Attachment, 0, len(attachmentIds))
+	for i, id := range attachmentIds {
+		if slices.Contains(attachmentIds[:i], id) {
+			return nil, ErrAttachmentUnavailable
+		}
+		attachment, err := u.messageRepository.FindAttachmentById(ctx, id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAttachmentUnavailable
+		}
+		if err != nil {
+			u.logger.ErrorContext(ctx, "failed to find attachment", "error", err, "attachment_id", id)
+			return nil, roshan_errors.ErrInternalServerError
+		}
+		if attachment.UserId != user.Id || attachment.MessageId != nil {
+			return nil, ErrAttachmentUnavailable
+		}
+		attachments = append(attachments, *attachment)
+	}
+	return attachments, nil
+}
+
+// AttachmentURLs are signed links to download an attachment
+type AttachmentURLs struct {
+	URL string
+	// ThumbnailURL is empty when the attachment has no thumbnail
+	ThumbnailURL string
+	ExpiresAt    time.Time
+}
+
+// GetAttachmentURL signs download links for an attachment. Until it's sent only its uploader
+// gets them, afterwards the message's author and whoever is in its room.
+func (u *ChatUsecase) GetAttachmentURL(ctx context.Context, attachmentId string) (*AttachmentURLs, error) {
+	user := ctx.Value("user").(*userModel.User)
+
+	attachment, err := u.messageRepository.FindAttachmentById(ctx, attachmentId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to find attachment", "error", err, "attachment_id", attachmentId)
+		return nil, roshan_errors.ErrInternalServerError
+	}
+
+	if attachment.MessageId == nil {
+		if attachment.UserId != user.Id {
+			return nil, ErrAttachmentNotFound
+		}
+	} else {
+		message, err := u.findMessage(ctx, *attachment.MessageId)
+		if err != nil {
+			return nil, err
+		}
+		if message.IsDeleted() {
+			return nil, ErrMessageDeleted
+		}
+		if message.UserID != user.Id {
+			room := u.hub.GetRoom(message.RoomID)
+			if room == nil || !room.HasUser(user.Id) {
+				return nil, ErrUserNotFoundInRoom
+			}
+		}
+	}
+
+	expiresAt := time.Now().Add(u.attachments.URLTTL)
+	urls := &AttachmentURLs{
+		URL:       u.signAttachmentURL(attachment.Id, variantFile, expiresAt.Unix()),
+		ExpiresAt: expiresAt,
+	}
+	if attachment.HasThumbnail {
+		urls.ThumbnailURL = u.signAttachmentURL(attachment.Id, variantThumbnail, expiresAt.Unix())
+	}
+	return urls, nil
+}
+
+func (u *ChatUsecase) attachmentSignature(attachmentId string, variant string, expires int64) []byte {
+	mac := hmac.New(sha256.New, []byte(u.attachments.SigningKey))
+	mac.Write([]byte(variant + "/" + attachmentId + "/" + strconv.FormatInt(expires, 10)))
+	return mac.Sum(nil)
+}
+
+func (u *ChatUsecase) signAttachmentURL(attachmentId string, variant string, expires int64) string {
+	signature := base64.RawURLEncoding.EncodeToString(u.attachmentSignature(attachmentId, variant, expires))
+	return fmt.Sprintf("/api/v1/chat/attachments/%s/%s?expires=%d&signature=%s", attachmentId, variant, expires, signature)
+}
+
+// DownloadAttachment serves a file or thumbnail through a link signed by GetAttachmentURL.
+// The link is the credential, so it works from an img tag.
+func (u *ChatUsecase) DownloadAttachment(ctx *gin.Context) {
+	reqCtx := ctx.Request.Context()
+	attachmentId, variant := ctx.Param("id"), ctx.Param("variant")
+
+	expires, err := strconv.ParseInt(ctx.Query("expires"), 10, 64)
+	signature, sigErr := base64.RawURLEncoding.DecodeString(ctx.Query("signature"))
+	if err != nil || sigErr != nil || time.Now().Unix() > expires ||
+		!hmac.Equal(signature, u.attachmentSignature(attachmentId, variant, expires)) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+
+	attachment, err := u.messageRepository.FindAttachmentById(reqCtx, attachmentId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	if err != nil {
+		u.logger.ErrorContext(reqCtx, "Failed to find attachment", "error", err, "attachment_id", attachmentId)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Download failed"})
+		return
+	}
+
+	// links outlive the message they were issued for, deleting it must still hide its files
+	if attachment.MessageId != nil {
+		message, err := u.messageRepository.FindMessageById(reqCtx, *attachment.MessageId)
+		if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && message.IsDeleted()) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+			return
+		}
+		if err != nil {
+			u.logger.ErrorContext(reqCtx, "Failed to find attachment's message", "error", err, "attachment_id", attachmentId)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Download failed"})
+			return
+		}
+	}
+
+	key, contentType, size := attachment.BlobKey(), attachment.ContentType, attachment.Size
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+	if variant == variantThumbnail {
+		key, contentType, size, disposition = attachment.ThumbnailKey(), "image/jpeg", -1, "inline"
+	}
+
+	blob, err := u.blobStore.Get(reqCtx, key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	if err != nil {
+		u.logger.ErrorContext(reqCtx, "Failed to read attachment", "error", err, "attachment_id", attachmentId)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Download failed"})
+		return
+	}
+	defer blob.Close()
+
+	ctx.DataFromReader(http.StatusOK, size, contentType, blob, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Name}),
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          fmt.Sprintf("private, max-age=%d", max(0, expires-time.Now().Unix())),
+	})
+}
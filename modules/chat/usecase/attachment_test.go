@@ -0,0 +1,125 @@
+package usecase
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bozoteam/roshan/modules/chat/models"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	"github.com/gin-gonic/gin"
+)
+
+// upload posts data as name through UploadAttachment, signed in as user
+func (f *chatFixture) upload(t *testing.T, user string, name string, data []byte) (*httptest.ResponseRecorder, *models.Attachment) {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", name)
+	part.Write(data)
+	form.Close()
+
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/api/v1/chat/attachments", &body)
+	ctx.Request.Header.Set("Content-Type", form.FormDataContentType())
+	ctx.Set("user", f.as(user).Value("user").(*userModel.User))
+	f.usecase.UploadAttachment(ctx)
+
+	attachment := &models.Attachment{}
+	if recorder.Code == http.StatusCreated {
+		if err := json.Unmarshal(recorder.Body.Bytes(), attachment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return recorder, attachment
+}
+
+// download fetches a signed URL through DownloadAttachment
+func (f *chatFixture) download(url string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.GET("/api/v1/chat/attachments/:id/:variant", f.usecase.DownloadAttachment)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+	return recorder
+}
+
+func TestAttachments(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	f := newChatFixture(t)
+	u := f.usecase
+
+	picture := image.NewNRGBA(image.Rect(0, 0, 600, 300))
+	for x := range 600 {
+		picture.Set(x, x%300, color.NRGBA{R: 0xff, A: 0x80})
+	}
+	var encoded bytes.Buffer
+	png.Encode(&encoded, picture)
+
+	recorder, attachment := f.upload(t, "alice", "../holiday.png", encoded.Bytes())
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", recorder.Code, recorder.Body)
+	}
+	if attachment.Name != "holiday.png" || attachment.ContentType != "image/png" || attachment.Width != 600 || !attachment.HasThumbnail {
+		t.Fatalf("unexpected attachment %+v", attachment)
+	}
+	if recorder, _ := f.upload(t, "alice", "run.exe", []byte("MZ\x90\x00\x03\x00\x00\x00")); recorder.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("uploading an executable: got %d, want 415", recorder.Code)
+	}
+
+	if _, err := u.SendMessage(f.as("bob"), "", f.roomId, "", []string{attachment.Id}); !errors.Is(err, ErrAttachmentUnavailable) {
+		t.Fatalf("sending someone else's attachment: got %v, want ErrAttachmentUnavailable", err)
+	}
+	message, err := u.SendMessage(f.as("alice"), "", f.roomId, "", []string{attachment.Id})
+	if err != nil || len(message.Attachments) != 1 {
+		t.Fatalf("SendMessage: %+v, %v", message, err)
+	}
+	if _, err := u.SendMessage(f.as("alice"), "again", f.roomId, "", []string{attachment.Id}); !errors.Is(err, ErrAttachmentUnavailable) {
+		t.Fatalf("sending an attachment twice: got %v, want ErrAttachmentUnavailable", err)
+	}
+
+	if _, err := u.GetAttachmentURL(f.as("carol"), attachment.Id); !errors.Is(err, ErrUserNotFoundInRoom) {
+		t.Fatalf("signing from outside the room: got %v, want ErrUserNotFoundInRoom", err)
+	}
+	urls, err := u.GetAttachmentURL(f.as("bob"), attachment.Id)
+	if err != nil || urls.ThumbnailURL == "" {
+		t.Fatalf("GetAttachmentURL: %+v, %v", urls, err)
+	}
+
+	file := f.download(urls.URL)
+	if file.Code != http.StatusOK || !bytes.Equal(file.Body.Bytes(), encoded.Bytes()) || file.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("download: %d %v", file.Code, file.Header())
+	}
+	thumbnail := f.download(urls.ThumbnailURL)
+	decoded, err := jpeg.Decode(thumbnail.Body)
+	if err != nil || decoded.Bounds().Dx() != 256 || decoded.Bounds().Dy() != 128 {
+		t.Fatalf("thumbnail: %v, %v", decoded.Bounds(), err)
+	}
+
+	tampered := strings.Replace(urls.URL, "/file?", "/thumbnail?", 1)
+	if recorder := f.download(tampered); recorder.Code != http.StatusForbidden {
+		body, _ := io.ReadAll(recorder.Body)
+		t.Fatalf("reusing a signature for another variant: %d %s", recorder.Code, body)
+	}
+
+	// links handed out before the message was deleted stop working with it
+	if _, err := u.DeleteMessage(f.as("alice"), message.Id); err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{urls.URL, urls.ThumbnailURL} {
+		if recorder := f.download(url); recorder.Code != http.StatusNotFound {
+			t.Fatalf("downloading from a deleted message: got %d, want 404", recorder.Code)
+		}
+	}
+}
@@ -1,23 +1,32 @@
 package usecase
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"context"
 
 	"encoding/json"
 
+	"github.com/bozoteam/roshan/adapter/blobstore"
+	"github.com/bozoteam/roshan/adapter/config"
 	log "github.com/bozoteam/roshan/adapter/log"
+	"github.com/bozoteam/roshan/helpers"
 	jwtRepository "github.com/bozoteam/roshan/modules/auth/repository/jwt"
+	"github.com/bozoteam/roshan/modules/chat/command"
 	"github.com/bozoteam/roshan/modules/chat/models"
-	chatModel "github.com/bozoteam/roshan/modules/chat/models"
+	"github.com/bozoteam/roshan/modules/chat/moderation"
+	messageRepository "github.com/bozoteam/roshan/modules/chat/repository/message"
 
 	userModel "github.com/bozoteam/roshan/modules/user/models"
 	userRepository "github.com/bozoteam/roshan/modules/user/repository"
 	ws_hub "github.com/bozoteam/roshan/modules/websocket/hub"
 	"github.com/bozoteam/roshan/modules/websocket/ws_client"
+	"github.com/bozoteam/roshan/modules/websocket/ws_upgrader"
+	"github.com/bozoteam/roshan/roshan_errors"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"google.golang.org/grpc/codes"
@@ -25,88 +34,233 @@ import (
 )
 
 type ChatUsecase struct {
-	hub            *ws_hub.Hub
-	logger         *slog.Logger
-	jwtRepository  *jwtRepository.JWTRepository
-	userRepository *userRepository.UserRepository
+	hub               *ws_hub.Hub
+	blobStore         blobstore.BlobStore
+	presence          *PresenceTracker
+	logger            *slog.Logger
+	jwtRepository     *jwtRepository.JWTRepository
+	userRepository    userRepository.UserRepository
+	messageRepository messageRepository.MessageRepository
+	upgrader          *ws_upgrader.Upgrader
+	readReceipts      bool
+	attachments       config.AttachmentsConfig
+	moderation        *moderation.Chain
+	links             *moderation.LinkFilter
+	commands          *command.Registry
+	roomSettings      *RoomSettingsStore
 }
 
 func NewChatUsecase(
-	userRepository *userRepository.UserRepository,
+	userRepository userRepository.UserRepository,
+	messageRepository messageRepository.MessageRepository,
 	jwtRepository *jwtRepository.JWTRepository,
+	upgrader *ws_upgrader.Upgrader,
+	blobStore blobstore.BlobStore,
+	cfg config.ChatConfig,
 ) *ChatUsecase {
-	hub := ws_hub.NewHub()
-	// go hub.Run()
-	return &ChatUsecase{
-		hub:            hub,
-		logger:         log.LogWithModule("chat_usecase"),
-		userRepository: userRepository,
-		jwtRepository:  jwtRepository,
+	hub := ws_hub.NewHub("chat")
+	presence := NewPresenceTracker(cfg.Presence, func(roomId string, data []byte) {
+		hub.BroadcastBytes(context.Background(), roomId, data)
+	})
+	chain, links := newModerationChain(cfg.Moderation)
+	u := &ChatUsecase{
+		hub:               hub,
+		blobStore:         blobStore,
+		presence:          presence,
+		logger:            log.LogWithModule("chat_usecase"),
+		userRepository:    userRepository,
+		messageRepository: messageRepository,
+		jwtRepository:     jwtRepository,
+		upgrader:          upgrader,
+		readReceipts:      cfg.ReadReceipts,
+		attachments:       cfg.Attachments,
+		moderation:        chain,
+		links:             links,
+		commands:          command.NewRegistry(),
+		roomSettings:      NewRoomSettingsStore(),
 	}
+	u.registerBuiltinCommands()
+	return u
 }
 
 // ChatRoomResponse represents a chat room with its users
 type ChatRoomResponse struct {
-	*models.Room
+	*ws_hub.RoomSnapshot
+	// Unread counts the messages the user asking hasn't read yet
+	Unread int64  `json:"unread,omitempty"`
+	Topic  string `json:"topic,omitempty"`
 }
 
 var (
 	ErrRoomNotFound       = status.Error(codes.NotFound, "room not found")
 	ErrUserNotFoundInRoom = status.Error(codes.PermissionDenied, "user not found in room")
 	ErrUserNotCreator     = status.Error(codes.PermissionDenied, "user cannot delete room, not creator")
+	ErrInvalidContent     = status.Errorf(codes.InvalidArgument, "message must be between 1 and %d bytes", models.MaxMessageLength)
+	ErrTooManyUsers       = status.Errorf(codes.InvalidArgument, "presence can be asked for at most %d users at once", maxPresenceUsers)
 )
 
-func (u *ChatUsecase) SendMessage(ctx context.Context, content string, roomId string) error {
+const maxPresenceUsers = 100
+
+// SendMessage posts a message to the room, as a reply in parentId's thread when it isn't empty.
+// The users it @mentions are resolved now, renaming someone later doesn't change them.
+// Messages carrying attachments may have no content. The moderation filters may reject the
+// message or change its content. Messages starting with a slash run a command instead, a
+// double slash sends them as typed without the first one.
+func (u *ChatUsecase) SendMessage(ctx context.Context, content string, roomId string, parentId string, attachmentIds []string) (*models.Message, error) {
 	user := ctx.Value("user").(*userModel.User)
 
+	if content != "" || len(attachmentIds) == 0 {
+		if err := models.ValidateContent(content); err != nil {
+			return nil, ErrInvalidContent
+		}
+	}
+
 	room := u.hub.GetRoom(roomId)
 	if room == nil {
-		return ErrRoomNotFound
+		return nil, ErrRoomNotFound
 	}
 
-	if room.UserIsInRoom(user.Id) == false {
-		return ErrUserNotFoundInRoom
+	if !room.HasUser(user.Id) {
+		return nil, ErrUserNotFoundInRoom
+	}
+
+	if name, args, ok := command.Parse(content); ok {
+		if len(attachmentIds) > 0 {
+			return nil, ErrCommandAttachments
+		}
+		return u.runCommand(ctx, user, room, name, args, parentId)
+	}
+	if err := u.checkMuted(roomId, user.Id); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(content, "//") {
+		content = content[1:]
+	}
+
+	return u.post(ctx, user, room, &draft{content: content, parentId: parentId, attachmentIds: attachmentIds})
+}
+
+// draft is a message about to be posted
+type draft struct {
+	content string
+	// kind is empty for plain messages, messages written by commands skip moderation
+	kind          string
+	parentId      string
+	attachmentIds []string
+}
+
+// post saves a message from user and broadcasts it to the room
+func (u *ChatUsecase) post(ctx context.Context, user *userModel.User, room *ws_hub.RoomSnapshot, d *draft) (*models.Message, error) {
+	content := d.content
+	var flags []moderation.FlagReason
+	if d.kind != models.MessageKindSystem {
+		var err error
+		if content, flags, err = u.moderate(ctx, user, room.Id, content, false); err != nil {
+			return nil, err
+		}
 	}
 
 	// Create message with proper metadata
 	message := &models.Message{
-		RoomID:    roomId,
+		Id:        helpers.GenUUID(),
+		RoomID:    room.Id,
+		UserID:    user.Id,
 		User:      user,
 		Content:   content,
+		Kind:      d.kind,
+		Nick:      u.roomSettings.Nick(room.Id, user.Id),
+		Reactions: []models.Reaction{},
 		Timestamp: time.Now().UnixNano(),
 	}
 
+	if d.parentId != "" {
+		threadId, err := u.threadOf(ctx, d.parentId, room.Id)
+		if err != nil {
+			return nil, err
+		}
+		message.ParentId = &threadId
+	}
+
+	mentions, err := u.resolveMentions(ctx, room, user, content)
+	if err != nil {
+		return nil, err
+	}
+	message.Mentions = mentions
+
+	if message.Attachments, err = u.messageAttachments(ctx, user, d.attachmentIds); err != nil {
+		return nil, err
+	}
+
+	err = u.messageRepository.SaveMessage(ctx, message)
+	if errors.Is(err, messageRepository.ErrAttachmentUnavailable) {
+		return nil, ErrAttachmentUnavailable
+	}
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to save message", "error", err, "room_id", room.Id)
+		return nil, roshan_errors.ErrInternalServerError
+	}
+
+	u.recordFlags(ctx, message, flags)
+
 	data, err := json.Marshal(message)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Broadcast the message
-	go u.hub.BroadcastBytes(roomId, data)
-	return nil
+	go u.hub.BroadcastBytes(ctx, room.Id, data)
+	u.notifyMentions(ctx, message, message.Mentions)
+	u.presence.StopTyping(user.Id, room.Id)
+	u.presence.Active(user.Id)
+	return message, nil
+}
+
+// GetPresence returns the presence of each user, in the order asked
+func (u *ChatUsecase) GetPresence(ctx context.Context, userIds []string) ([]models.Presence, error) {
+	if len(userIds) > maxPresenceUsers {
+		return nil, ErrTooManyUsers
+	}
+	return u.presence.Get(userIds), nil
 }
 
 func (u *ChatUsecase) CreateRoom(ctx context.Context, name string) (*ChatRoomResponse, error) {
 	user := ctx.Value("user").(*userModel.User)
 
-	room := models.NewRoom(name, user.Id, []string{"chat"}, "chat")
+	room := ws_hub.NewRoom(name, user.Id, []string{"chat"}, "chat")
 
 	u.hub.CreateRoom(room)
 
 	return &ChatRoomResponse{
-		Room: room,
+		RoomSnapshot: room.Snapshot(),
 	}, nil
 }
 
+// ListRooms returns the open rooms with their topic, and their unread counts when a user is
+// asking
 func (u *ChatUsecase) ListRooms(ctx context.Context) ([]*ChatRoomResponse, error) {
 	rooms := u.hub.ListRooms()
 
+	unread := map[string]int64{}
+	if user, ok := ctx.Value("user").(*userModel.User); ok && len(rooms) > 0 {
+		roomIds := make([]string, len(rooms))
+		for i, room := range rooms {
+			roomIds[i] = room.Id
+		}
+		counts, err := u.messageRepository.CountUnread(ctx, user.Id, roomIds)
+		if err != nil {
+			u.logger.ErrorContext(ctx, "failed to count unread messages", "error", err, "user_id", user.Id)
+			return nil, roshan_errors.ErrInternalServerError
+		}
+		unread = counts
+	}
+
 	responseRooms := make([]*ChatRoomResponse, 0, len(rooms))
 	for _, room := range rooms {
 		responseRooms = append(responseRooms, &ChatRoomResponse{
-			Room: room.(*models.Room),
-		},
-		)
+			RoomSnapshot: room,
+			Unread:       unread[room.Id],
+			Topic:        u.roomSettings.Topic(room.Id),
+		})
 	}
 
 	return responseRooms, nil
@@ -115,19 +269,20 @@ func (u *ChatUsecase) ListRooms(ctx context.Context) ([]*ChatRoomResponse, error
 func (u *ChatUsecase) DeleteRoom(ctx context.Context, roomId string) (*ChatRoomResponse, error) {
 	user := ctx.Value("user").(*userModel.User)
 
-	room := u.hub.GetRoom(roomId).(*chatModel.Room)
+	room := u.hub.GetRoom(roomId)
 	if room == nil {
 		return nil, ErrRoomNotFound
 	}
 
-	if room.CreatorID != user.Id {
+	if room.CreatorId != user.Id {
 		return nil, ErrUserNotCreator
 	}
 
-	u.hub.DeleteRoom(room.ID)
+	u.hub.DeleteRoom(room.Id)
+	u.forgetRoom(room.Id)
 
 	return &ChatRoomResponse{
-		Room: room,
+		RoomSnapshot: room,
 	}, nil
 }
 
@@ -139,33 +294,81 @@ func (u *ChatUsecase) JoinRoom(ctx *gin.Context, roomID string) {
 		ctx.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
 		return
 	}
-
-	// Allow all origins for the WebSocket upgrade
-	upgrader := websocket.Upgrader{
-		HandshakeTimeout:  time.Second * 5,
-		ReadBufferSize:    1024,
-		WriteBufferSize:   1024,
-		CheckOrigin:       func(r *http.Request) bool { return true },
-		EnableCompression: false,
+	if !u.roomSettings.KickedUntil(roomID, user.Id, time.Now()).IsZero() {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "You were kicked from this room, try again later"})
+		return
 	}
 
-	conn, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	conn, release, err := u.upgrader.Upgrade(ctx, user.Id)
 	if err != nil {
-		u.logger.Error("Failed to upgrade connection", "error", err)
+		u.logger.ErrorContext(ctx.Request.Context(), "Failed to upgrade connection", "error", err, "user_id", user.Id)
 		return
 	}
+	defer release()
 
 	// Create client
-	client := ws_client.NewClient(conn, user, roomID)
+	onMessage := func(data []byte) {
+		u.handleEvent(ctx.Request.Context(), user, roomID, data)
+	}
+	client := ws_client.NewClient(ctx.Request.Context(), conn, user, roomID, u.upgrader.Timings(), onMessage)
 
-	// Register client to room
-	u.hub.Register(client, roomID, "chat")
+	// Register client to room, it may have closed since we looked it up
+	if !u.hub.Register(client, roomID, "chat") {
+		client.Close(websocket.CloseNormalClosure, "room closed")
+		client.WaitUnregister()
+		return
+	}
+	u.presence.Connected(user.Id, roomID)
 
-	u.logger.Info("User connected to room", "user_id", user.Id, "room_id", roomID)
+	u.logger.InfoContext(ctx.Request.Context(), "User connected to room", "user_id", user.Id, "room_id", roomID)
 
 	// Handle unregistration when the client disconnects
 	// This runs in the same goroutine as HandleWebSocket
 	client.WaitUnregister()
 	u.hub.Unregister(client, roomID)
-	u.logger.Info("User disconnected from room", "user_id", user.Id, "room_id", roomID)
+	u.presence.Disconnected(user.Id, roomID)
+	if u.hub.GetRoom(roomID) == nil {
+		u.forgetRoom(roomID)
+	}
+	u.logger.InfoContext(ctx.Request.Context(), "User disconnected from room", "user_id", user.Id, "room_id", roomID)
+}
+
+// handleEvent applies a frame sent by a client, anything it doesn't understand is dropped
+func (u *ChatUsecase) handleEvent(ctx context.Context, user *userModel.User, roomId string, data []byte) {
+	var event models.ClientEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		u.logger.DebugContext(ctx, "Ignoring malformed client frame", "error", err, "user_id", user.Id)
+		return
+	}
+
+	switch event.Type {
+	case models.EventTypingStarted:
+		u.presence.StartTyping(user.Id, roomId)
+	case models.EventTypingStopped:
+		u.presence.StopTyping(user.Id, roomId)
+	case models.EventActive:
+		u.presence.Active(user.Id)
+	case models.EventRead:
+		if _, err := u.markRead(ctx, user, roomId, event.MessageID); err != nil {
+			u.logger.DebugContext(ctx, "Ignoring read event", "error", err, "user_id", user.Id)
+		}
+	default:
+		u.logger.DebugContext(ctx, "Ignoring unknown client event", "type", event.Type, "user_id", user.Id)
+	}
+}
+
+// HasRoom tells whether the chat room is open
+func (u *ChatUsecase) HasRoom(roomId string) bool {
+	return u.hub.HasRoom(roomId)
+}
+
+// HealthCheck reports whether the hub still answers
+func (u *ChatUsecase) HealthCheck(ctx context.Context) error {
+	return u.hub.Ping(ctx)
+}
+
+// Shutdown disconnects everyone in the hub, asking them to reconnect after reconnectDelay
+func (u *ChatUsecase) Shutdown(reconnectDelay time.Duration) {
+	u.hub.Shutdown(reconnectDelay)
+	u.presence.Stop()
 }
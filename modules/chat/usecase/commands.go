@@ -0,0 +1,347 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bozoteam/roshan/helpers"
+	"github.com/bozoteam/roshan/modules/chat/command"
+	"github.com/bozoteam/roshan/modules/chat/models"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	ws_hub "github.com/bozoteam/roshan/modules/websocket/hub"
+	"github.com/bozoteam/roshan/roshan_errors"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrUnknownCommand     = status.Error(codes.InvalidArgument, "unknown command, /help lists them")
+	ErrCommandNotAllowed  = status.Error(codes.PermissionDenied, "you can't run this command")
+	ErrCommandAttachments = status.Error(codes.InvalidArgument, "commands can't carry attachments")
+	ErrNoSuchMember       = status.Error(codes.NotFound, "nobody by that name in the room")
+	ErrTargetIsModerator  = status.Error(codes.PermissionDenied, "moderators can't be kicked or muted")
+	ErrInvalidNick        = status.Errorf(codes.InvalidArgument, "nicks are 1 to %d characters, without control characters", maxNickLength)
+	ErrNickTaken          = status.Error(codes.AlreadyExists, "someone in the room goes by that name")
+	ErrInvalidMute        = status.Errorf(codes.InvalidArgument, "mutes last between %s and %s", minMute, maxMute)
+	ErrInvalidDice        = status.Errorf(codes.InvalidArgument, "roll up to %d dice of 2 to %d sides, e.g. 2d6 or d20+3", maxDice, maxDieSides)
+	ErrInvalidTopic       = status.Errorf(codes.InvalidArgument, "topics are at most %d characters", maxTopicLength)
+)
+
+const (
+	maxNickLength  = 32
+	maxTopicLength = 200
+
+	defaultMute = 10 * time.Minute
+	minMute     = time.Minute
+	maxMute     = 24 * time.Hour
+	// kickedFor keeps kicked users out of the room long enough for them to notice
+	kickedFor = 5 * time.Minute
+
+	maxDice     = 100
+	maxDieSides = 1000
+)
+
+// RegisterCommand adds a slash command, next to the built-in ones. It must be called before
+// the server starts.
+func (u *ChatUsecase) RegisterCommand(cmd *command.Command) error {
+	return u.commands.Register(cmd)
+}
+
+func (u *ChatUsecase) registerBuiltinCommands() {
+	moderatorsOnly := func(call *command.Call) bool { return call.User.IsModerator() }
+	builtins := []*command.Command{
+		{Name: "help", Usage: "[command]", Description: "Lists the commands you can run", Run: u.helpCommand},
+		{Name: "me", Usage: "<action>", Description: "Describes what you're doing", Run: u.meCommand},
+		{Name: "nick", Usage: "[name]", Description: "Sets the name you go by in this room, or takes it back", Run: u.nickCommand},
+		{Name: "roll", Usage: "[dice]", Description: "Rolls dice, e.g. 2d6 or d20+3", Run: u.rollCommand},
+		{Name: "topic", Usage: "[topic]", Description: "Shows the room's topic, its creator and moderators can change it", Run: u.topicCommand},
+		{Name: "kick", Usage: "<name> [reason]", Description: "Disconnects someone from the room for a while", Allowed: moderatorsOnly, Run: u.kickCommand},
+		{Name: "mute", Usage: "<name> [duration]", Description: "Keeps someone from talking in the room, for 10m unless told otherwise", Allowed: moderatorsOnly, Run: u.muteCommand},
+		{Name: "unmute", Usage: "<name>", Description: "Lets someone talk in the room again", Allowed: moderatorsOnly, Run: u.unmuteCommand},
+	}
+	for _, cmd := range builtins {
+		if err := u.commands.Register(cmd); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// runCommand runs a command typed in the room. What it posts is sent like any message from
+// user, a notice is returned to them without being stored.
+func (u *ChatUsecase) runCommand(ctx context.Context, user *userModel.User, room *ws_hub.RoomSnapshot, name string, args string, parentId string) (*models.Message, error) {
+	cmd := u.commands.Lookup(name)
+	if cmd == nil {
+		return nil, ErrUnknownCommand
+	}
+	call := &command.Call{User: user, Room: room, ParentId: parentId, Args: args}
+	if cmd.Allowed != nil && !cmd.Allowed(call) {
+		return nil, ErrCommandNotAllowed
+	}
+	// muted users can still find out how long for
+	if name != "help" {
+		if err := u.checkMuted(room.Id, user.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := cmd.Run(ctx, call)
+	if _, isStatus := status.FromError(err); err != nil && !isStatus {
+		u.logger.ErrorContext(ctx, "command failed", "error", err, "command", name, "room_id", room.Id)
+		return nil, roshan_errors.ErrInternalServerError
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Post != "" {
+		if err := models.ValidateContent(result.Post); err != nil {
+			return nil, ErrInvalidContent
+		}
+		return u.post(ctx, user, room, &draft{content: result.Post, kind: result.Kind, parentId: parentId})
+	}
+	return &models.Message{
+		Id:        helpers.GenUUID(),
+		RoomID:    room.Id,
+		UserID:    user.Id,
+		User:      user,
+		Content:   result.Notice,
+		Kind:      models.MessageKindNotice,
+		Reactions: []models.Reaction{},
+		Timestamp: time.Now().UnixNano(),
+	}, nil
+}
+
+// checkMuted fails while the user is muted in the room
+func (u *ChatUsecase) checkMuted(roomId string, userId string) error {
+	if until := u.roomSettings.MutedUntil(roomId, userId, time.Now()); !until.IsZero() {
+		return status.Errorf(codes.PermissionDenied, "you are muted in this room until %s", until.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// forgetRoom drops what commands and moderators set in a room that closed
+func (u *ChatUsecase) forgetRoom(roomId string) {
+	u.roomSettings.Forget(roomId)
+	u.links.Forget(roomId)
+}
+
+// displayName is the user's nick in the room, or their name
+func (u *ChatUsecase) displayName(roomId string, user *userModel.User) string {
+	if nick := u.roomSettings.Nick(roomId, user.Id); nick != "" {
+		return nick
+	}
+	return user.Name
+}
+
+// findMember returns the member of the room going by name, a leading @ is ignored
+func (u *ChatUsecase) findMember(room *ws_hub.RoomSnapshot, name string) (*userModel.User, error) {
+	name = strings.TrimPrefix(name, "@")
+	nicks := u.roomSettings.Nicks(room.Id)
+	for _, member := range room.Members {
+		if strings.EqualFold(member.User.Name, name) || strings.EqualFold(nicks[member.User.Id], name) {
+			return &member.User, nil
+		}
+	}
+	return nil, ErrNoSuchMember
+}
+
+func (u *ChatUsecase) helpCommand(ctx context.Context, call *command.Call) (*command.Result, error) {
+	if call.Args == "" {
+		return &command.Result{Notice: command.Help(u.commands.Available(call))}, nil
+	}
+	cmd := u.commands.Lookup(strings.ToLower(strings.TrimPrefix(call.Args, "/")))
+	if cmd == nil || (cmd.Allowed != nil && !cmd.Allowed(call)) {
+		return nil, ErrUnknownCommand
+	}
+	return &command.Result{Notice: command.HelpLine(cmd)}, nil
+}
+
+func (u *ChatUsecase) meCommand(ctx context.Context, call *command.Call) (*command.Result, error) {
+	if call.Args == "" {
+		return nil, u.commands.Lookup("me").UsageError()
+	}
+	return &command.Result{Post: call.Args, Kind: models.MessageKindAction}, nil
+}
+
+func (u *ChatUsecase) nickCommand(ctx context.Context, call *command.Call) (*command.Result, error) {
+	roomId, user := call.Room.Id, call.User
+	before := u.displayName(roomId, user)
+
+	nick := call.Args
+	if nick == "" {
+		if u.roomSettings.Nick(roomId, user.Id) == "" {
+			return &command.Result{Notice: "You have no nick in this room"}, nil
+		}
+		u.roomSettings.SetNick(roomId, user.Id, "")
+		return &command.Result{Post: before + " is " + user.Name + " again", Kind: models.MessageKindSystem}, nil
+	}
+
+	if utf8.RuneCountInString(nick) > maxNickLength || strings.IndexFunc(nick, unicode.IsControl) >= 0 {
+		return nil, ErrInvalidNick
+	}
+	if member, err := u.findMember(call.Room, nick); err == nil && member.Id != user.Id {
+		return nil, ErrNickTaken
+	}
+	// nicks are shown next to every message, the word list applies to them too
+	moderated, _, err := u.moderate(ctx, user, roomId, nick, true)
+	if err != nil {
+		return nil, err
+	}
+	if moderated != nick {
+		return nil, ErrInvalidNick
+	}
+
+	u.roomSettings.SetNick(roomId, user.Id, nick)
+	return &command.Result{Post: before + " is now known as " + nick, Kind: models.MessageKindSystem}, nil
+}
+
+var dicePattern = regexp.MustCompile(`^(\d{0,3})d(\d{1,4})([+-]\d{1,4})?$`)
+
+func (u *ChatUsecase) rollCommand(ctx context.Context, call *command.Call) (*command.Result, error) {
+	dice := strings.ToLower(strings.ReplaceAll(call.Args, " ", ""))
+	if dice == "" {
+		dice = "1d6"
+	}
+	match := dicePattern.FindStringSubmatch(dice)
+	if match == nil {
+		return nil, ErrInvalidDice
+	}
+	count, sides, modifier := 1, 0, 0
+	if match[1] != "" {
+		count, _ = strconv.Atoi(match[1])
+	}
+	sides, _ = strconv.Atoi(match[2])
+	if match[3] != "" {
+		modifier, _ = strconv.Atoi(match[3])
+	}
+	if count < 1 || count > maxDice || sides < 2 || sides > maxDieSides {
+		return nil, ErrInvalidDice
+	}
+
+	rolls := make([]string, count)
+	total := modifier
+	for i := range rolls {
+		roll := rand.IntN(sides) + 1
+		rolls[i] = strconv.Itoa(roll)
+		total += roll
+	}
+	if modifier != 0 {
+		rolls = append(rolls, strconv.Itoa(modifier))
+	}
+
+	expression := fmt.Sprintf("%dd%d%s", count, sides, match[3])
+	outcome := strings.ReplaceAll(strings.Join(rolls, " + "), "+ -", "- ")
+	if len(rolls) > 1 {
+		outcome += " = " + strconv.Itoa(total)
+	}
+	return &command.Result{
+		Post: fmt.Sprintf("%s rolled %s: %s", u.displayName(call.Room.Id, call.User), expression, outcome),
+		Kind: models.MessageKindSystem,
+	}, nil
+}
+
+func (u *ChatUsecase) topicCommand(ctx context.Context, call *command.Call) (*command.Result, error) {
+	roomId, user := call.Room.Id, call.User
+	if call.Args == "" {
+		if topic := u.roomSettings.Topic(roomId); topic != "" {
+			return &command.Result{Notice: "Topic: " + topic}, nil
+		}
+		return &command.Result{Notice: "No topic is set"}, nil
+	}
+
+	if call.Room.CreatorId != user.Id && !user.IsModerator() {
+		return nil, ErrCommandNotAllowed
+	}
+	if utf8.RuneCountInString(call.Args) > maxTopicLength {
+		return nil, ErrInvalidTopic
+	}
+	topic, _, err := u.moderate(ctx, user, roomId, call.Args, true)
+	if err != nil {
+		return nil, err
+	}
+
+	u.roomSettings.SetTopic(roomId, topic)
+	return &command.Result{Post: u.displayName(roomId, user) + " set the topic: " + topic, Kind: models.MessageKindSystem}, nil
+}
+
+// moderationTarget finds the member a moderator's command is about, with the rest of the arguments
+func (u *ChatUsecase) moderationTarget(cmd string, call *command.Call) (*userModel.User, string, error) {
+	name, rest, _ := strings.Cut(call.Args, " ")
+	if name == "" {
+		return nil, "", u.commands.Lookup(cmd).UsageError()
+	}
+	target, err := u.findMember(call.Room, name)
+	if err != nil {
+		return nil, "", err
+	}
+	if target.IsModerator() {
+		return nil, "", ErrTargetIsModerator
+	}
+	return target, strings.TrimSpace(rest), nil
+}
+
+func (u *ChatUsecase) kickCommand(ctx context.Context, call *command.Call) (*command.Result, error) {
+	target, reason, err := u.moderationTarget("kick", call)
+	if err != nil {
+		return nil, err
+	}
+	roomId := call.Room.Id
+
+	u.roomSettings.Kick(roomId, target.Id, time.Now().Add(kickedFor))
+	announcement := u.displayName(roomId, target) + " was kicked by " + u.displayName(roomId, call.User)
+	if reason != "" {
+		announcement += ": " + reason
+	}
+	// the target's sockets close once the announcement had the time to reach them
+	time.AfterFunc(time.Second, func() {
+		u.hub.Disconnect(roomId, target.Id, websocket.ClosePolicyViolation, "kicked from the room")
+	})
+	u.logger.InfoContext(ctx, "user kicked", "room_id", roomId, "user_id", target.Id, "moderator_id", call.User.Id)
+	return &command.Result{Post: announcement, Kind: models.MessageKindSystem}, nil
+}
+
+func (u *ChatUsecase) muteCommand(ctx context.Context, call *command.Call) (*command.Result, error) {
+	target, rest, err := u.moderationTarget("mute", call)
+	if err != nil {
+		return nil, err
+	}
+	duration := defaultMute
+	if rest != "" {
+		if duration, err = time.ParseDuration(rest); err != nil || duration < minMute || duration > maxMute {
+			return nil, ErrInvalidMute
+		}
+	}
+	roomId := call.Room.Id
+
+	u.roomSettings.Mute(roomId, target.Id, time.Now().Add(duration))
+	u.logger.InfoContext(ctx, "user muted", "room_id", roomId, "user_id", target.Id, "duration", duration, "moderator_id", call.User.Id)
+	return &command.Result{
+		Post: fmt.Sprintf("%s was muted for %s by %s", u.displayName(roomId, target), duration, u.displayName(roomId, call.User)),
+		Kind: models.MessageKindSystem,
+	}, nil
+}
+
+func (u *ChatUsecase) unmuteCommand(ctx context.Context, call *command.Call) (*command.Result, error) {
+	target, _, err := u.moderationTarget("unmute", call)
+	if err != nil {
+		return nil, err
+	}
+	roomId := call.Room.Id
+
+	if u.roomSettings.MutedUntil(roomId, target.Id, time.Now()).IsZero() {
+		return &command.Result{Notice: u.displayName(roomId, target) + " isn't muted"}, nil
+	}
+	u.roomSettings.Mute(roomId, target.Id, time.Time{})
+	return &command.Result{
+		Post: u.displayName(roomId, target) + " can talk again, thanks to " + u.displayName(roomId, call.User),
+		Kind: models.MessageKindSystem,
+	}, nil
+}
@@ -0,0 +1,218 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/bozoteam/roshan/helpers"
+	"github.com/bozoteam/roshan/modules/chat/models"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	ws_hub "github.com/bozoteam/roshan/modules/websocket/hub"
+	"github.com/bozoteam/roshan/roshan_errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrMessageNotFound = status.Error(codes.NotFound, "message not found")
+	ErrMessageDeleted  = status.Error(codes.FailedPrecondition, "message was deleted")
+	ErrNotAuthor       = status.Error(codes.PermissionDenied, "only the author can edit a message")
+	ErrNotAuthorOrMod  = status.Error(codes.PermissionDenied, "only the author or a moderator can delete a message")
+	ErrNotModerator    = status.Error(codes.PermissionDenied, "moderator role required")
+	ErrInvalidEmoji    = status.Error(codes.InvalidArgument, "invalid emoji")
+	ErrNotEditable     = status.Error(codes.FailedPrecondition, "messages written by commands can't be edited")
+)
+
+// findMessage loads a message, including deleted ones
+func (u *ChatUsecase) findMessage(ctx context.Context, messageId string) (*models.Message, error) {
+	message, err := u.messageRepository.FindMessageById(ctx, messageId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to find message", "error", err, "message_id", messageId)
+		return nil, roshan_errors.ErrInternalServerError
+	}
+	return message, nil
+}
+
+// EditMessage replaces the content of one of the user's messages, keeping the old one as a
+// revision. The new content goes through the moderation filters.
+func (u *ChatUsecase) EditMessage(ctx context.Context, messageId string, content string) (*models.Message, error) {
+	user := ctx.Value("user").(*userModel.User)
+
+	if err := models.ValidateContent(content); err != nil {
+		return nil, ErrInvalidContent
+	}
+
+	message, err := u.findMessage(ctx, messageId)
+	if err != nil {
+		return nil, err
+	}
+	if message.UserID != user.Id {
+		return nil, ErrNotAuthor
+	}
+	if message.IsDeleted() {
+		return nil, ErrMessageDeleted
+	}
+	if message.Kind == models.MessageKindSystem {
+		return nil, ErrNotEditable
+	}
+	if err := u.checkMuted(message.RoomID, user.Id); err != nil {
+		return nil, err
+	}
+
+	content, flags, err := u.moderate(ctx, user, message.RoomID, content, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// the mentions follow the new content, only the users it adds are notified
+	room := u.hub.GetRoom(message.RoomID)
+	if room == nil {
+		room = &ws_hub.RoomSnapshot{Id: message.RoomID}
+	}
+	mentions, err := u.resolveMentions(ctx, room, user, content)
+	if err != nil {
+		return nil, err
+	}
+	added := slices.DeleteFunc(slices.Clone(mentions), func(userId string) bool {
+		return slices.Contains(message.Mentions, userId)
+	})
+
+	revision := &models.MessageRevision{
+		Id:        helpers.GenUUID(),
+		MessageId: message.Id,
+		Content:   message.Content,
+		EditedBy:  user.Id,
+	}
+	message.Content = content
+	message.EditedAt = time.Now().UnixNano()
+	message.Mentions = mentions
+	if err := u.messageRepository.UpdateMessage(ctx, message, revision); err != nil {
+		u.logger.ErrorContext(ctx, "failed to edit message", "error", err, "message_id", messageId)
+		return nil, roshan_errors.ErrInternalServerError
+	}
+	u.recordFlags(ctx, message, flags)
+
+	u.broadcastMessageEvent(ctx, models.EventMessageEdited, message)
+	u.notifyMentions(ctx, message, added)
+	return message, nil
+}
+
+// DeleteMessage clears a message, by its author or a moderator. The content stays
+// available to moderators as the last revision.
+func (u *ChatUsecase) DeleteMessage(ctx context.Context, messageId string) (*models.Message, error) {
+	user := ctx.Value("user").(*userModel.User)
+
+	message, err := u.findMessage(ctx, messageId)
+	if err != nil {
+		return nil, err
+	}
+	if message.UserID != user.Id && !user.IsModerator() {
+		return nil, ErrNotAuthorOrMod
+	}
+	if message.IsDeleted() {
+		return nil, ErrMessageDeleted
+	}
+
+	revision := &models.MessageRevision{
+		Id:        helpers.GenUUID(),
+		MessageId: message.Id,
+		Content:   message.Content,
+		EditedBy:  user.Id,
+	}
+	message.Content = ""
+	message.Attachments = nil
+	message.DeletedAt = time.Now().UnixNano()
+	if err := u.messageRepository.UpdateMessage(ctx, message, revision); err != nil {
+		u.logger.ErrorContext(ctx, "failed to delete message", "error", err, "message_id", messageId)
+		return nil, roshan_errors.ErrInternalServerError
+	}
+	u.logger.InfoContext(ctx, "message deleted", "message_id", messageId, "room_id", message.RoomID, "by_author", message.UserID == user.Id)
+
+	u.broadcastMessageEvent(ctx, models.EventMessageDeleted, message)
+	return message, nil
+}
+
+// ReactToMessage adds or, with remove, takes back the user's reaction. Only people in the
+// message's room can react.
+func (u *ChatUsecase) ReactToMessage(ctx context.Context, messageId string, emoji string, remove bool) (*models.Message, error) {
+	user := ctx.Value("user").(*userModel.User)
+
+	if err := models.ValidateEmoji(emoji); err != nil {
+		return nil, ErrInvalidEmoji
+	}
+
+	message, err := u.findMessage(ctx, messageId)
+	if err != nil {
+		return nil, err
+	}
+	if message.IsDeleted() {
+		return nil, ErrMessageDeleted
+	}
+	room := u.hub.GetRoom(message.RoomID)
+	if room == nil || !room.HasUser(user.Id) {
+		return nil, ErrUserNotFoundInRoom
+	}
+
+	reaction := &models.MessageReaction{MessageId: message.Id, UserId: user.Id, Emoji: emoji}
+	if remove {
+		err = u.messageRepository.RemoveReaction(ctx, reaction)
+	} else {
+		err = u.messageRepository.AddReaction(ctx, reaction)
+	}
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to save reaction", "error", err, "message_id", messageId)
+		return nil, roshan_errors.ErrInternalServerError
+	}
+
+	reactions, err := u.messageRepository.ListReactions(ctx, message.Id)
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to list reactions", "error", err, "message_id", messageId)
+		return nil, roshan_errors.ErrInternalServerError
+	}
+	message.Reactions = models.GroupReactions(reactions)
+
+	u.broadcastMessageEvent(ctx, models.EventMessageReactions, message)
+	return message, nil
+}
+
+// ListMessageRevisions returns the earlier contents of a message, oldest first, to moderators
+func (u *ChatUsecase) ListMessageRevisions(ctx context.Context, messageId string) ([]*models.MessageRevision, error) {
+	user := ctx.Value("user").(*userModel.User)
+
+	if !user.IsModerator() {
+		return nil, ErrNotModerator
+	}
+
+	message, err := u.findMessage(ctx, messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := u.messageRepository.ListRevisions(ctx, message.Id)
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to list revisions", "error", err, "message_id", messageId)
+		return nil, roshan_errors.ErrInternalServerError
+	}
+	return revisions, nil
+}
+
+// broadcastMessageEvent tells the message's room, if it's still open, how the message looks now
+func (u *ChatUsecase) broadcastMessageEvent(ctx context.Context, eventType string, message *models.Message) {
+	data, err := json.Marshal(&models.MessageEvent{
+		Type:      eventType,
+		RoomID:    message.RoomID,
+		Message:   message,
+		Timestamp: time.Now().UnixNano(),
+	})
+	if err != nil {
+		panic(err)
+	}
+	u.hub.BroadcastBytes(ctx, message.RoomID, data)
+}
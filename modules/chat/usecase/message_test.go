@@ -0,0 +1,653 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bozoteam/roshan/adapter/blobstore"
+	"github.com/bozoteam/roshan/adapter/config"
+	"github.com/bozoteam/roshan/modules/chat/command"
+	"github.com/bozoteam/roshan/modules/chat/models"
+	"github.com/bozoteam/roshan/modules/chat/moderation"
+	messageRepository "github.com/bozoteam/roshan/modules/chat/repository/message"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	userRepository "github.com/bozoteam/roshan/modules/user/repository"
+	ws_hub "github.com/bozoteam/roshan/modules/websocket/hub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeClient is a room member whose frames pile up in its buffered channel
+type fakeClient struct {
+	user *userModel.User
+	send chan []byte
+}
+
+func (c *fakeClient) GetID() string                 { return c.user.Id }
+func (c *fakeClient) GetSender() chan []byte        { return c.send }
+func (c *fakeClient) GetUser() *userModel.User      { return c.user }
+func (c *fakeClient) WaitUnregister()               {}
+func (c *fakeClient) Close(code int, reason string) {}
+
+// nextEvent skips frames until a message event of eventType
+func (c *fakeClient) nextEvent(t *testing.T, eventType string) *models.MessageEvent {
+	t.Helper()
+	event := &models.MessageEvent{}
+	c.next(t, eventType, event)
+	return event
+}
+
+// next decodes into event the first frame of eventType, dropping the frames before it
+func (c *fakeClient) next(t *testing.T, eventType string, event any) {
+	t.Helper()
+	for {
+		select {
+		case data := <-c.send:
+			var frame struct{ Type string }
+			if json.Unmarshal(data, &frame) == nil && frame.Type == eventType {
+				if err := json.Unmarshal(data, event); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+		default:
+			t.Fatalf("no %s event", eventType)
+		}
+	}
+}
+
+type chatFixture struct {
+	usecase *ChatUsecase
+	roomId  string
+	members map[string]*fakeClient
+}
+
+// newChatFixture opens a room with alice and bob in it, carol is a moderator outside of it
+func newChatFixture(t *testing.T) *chatFixture {
+	t.Helper()
+
+	blobStore, err := blobstore.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default().Chat
+	cfg.Attachments.SigningKey = "chat-usecase-test-signing-key-32-characters"
+	usecase := NewChatUsecase(
+		userRepository.NewMemoryUserRepository(),
+		messageRepository.NewMemoryMessageRepository(),
+		nil, nil,
+		blobStore,
+		cfg,
+	)
+	t.Cleanup(func() { usecase.Shutdown(0) })
+
+	room := ws_hub.NewRoom("general", "alice", []string{"chat"}, "chat")
+	usecase.hub.CreateRoom(room)
+
+	f := &chatFixture{usecase: usecase, roomId: room.GetID(), members: map[string]*fakeClient{}}
+	for _, name := range []string{"alice", "bob"} {
+		client := &fakeClient{user: &userModel.User{Id: name, Name: name, Role: userModel.RoleUser}, send: make(chan []byte, 64)}
+		usecase.hub.Register(client, room.GetID(), "chat")
+		usecase.presence.Connected(name, room.GetID())
+		f.members[name] = client
+	}
+	return f
+}
+
+func (f *chatFixture) as(name string) context.Context {
+	if client, ok := f.members[name]; ok {
+		return context.WithValue(context.Background(), "user", client.user)
+	}
+	return context.WithValue(context.Background(), "user", &userModel.User{Id: name, Name: name, Role: userModel.RoleModerator})
+}
+
+func TestEditAndDeleteMessages(t *testing.T) {
+	f := newChatFixture(t)
+	u := f.usecase
+
+	message, err := u.SendMessage(f.as("alice"), "helo", f.roomId, "", nil)
+	if err != nil || message.Id == "" {
+		t.Fatalf("SendMessage: %+v, %v", message, err)
+	}
+	if _, err := u.SendMessage(f.as("alice"), "", f.roomId, "", nil); !errors.Is(err, ErrInvalidContent) {
+		t.Fatalf("empty message: got %v, want ErrInvalidContent", err)
+	}
+
+	if _, err := u.EditMessage(f.as("bob"), message.Id, "mine now"); !errors.Is(err, ErrNotAuthor) {
+		t.Fatalf("bob editing: got %v, want ErrNotAuthor", err)
+	}
+	if _, err := u.EditMessage(f.as("carol"), message.Id, "moderated"); !errors.Is(err, ErrNotAuthor) {
+		t.Fatalf("moderator editing: got %v, want ErrNotAuthor", err)
+	}
+	edited, err := u.EditMessage(f.as("alice"), message.Id, "hello")
+	if err != nil || edited.Content != "hello" || edited.EditedAt == 0 {
+		t.Fatalf("EditMessage: %+v, %v", edited, err)
+	}
+	if event := f.members["bob"].nextEvent(t, models.EventMessageEdited); event.Message.Content != "hello" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	if _, err := u.DeleteMessage(f.as("bob"), message.Id); !errors.Is(err, ErrNotAuthorOrMod) {
+		t.Fatalf("bob deleting: got %v, want ErrNotAuthorOrMod", err)
+	}
+	deleted, err := u.DeleteMessage(f.as("carol"), message.Id)
+	if err != nil || deleted.Content != "" || !deleted.IsDeleted() {
+		t.Fatalf("DeleteMessage: %+v, %v", deleted, err)
+	}
+	f.members["alice"].nextEvent(t, models.EventMessageDeleted)
+	if _, err := u.EditMessage(f.as("alice"), message.Id, "undo"); !errors.Is(err, ErrMessageDeleted) {
+		t.Fatalf("editing a deleted message: got %v, want ErrMessageDeleted", err)
+	}
+
+	if _, err := u.ListMessageRevisions(f.as("alice"), message.Id); !errors.Is(err, ErrNotModerator) {
+		t.Fatalf("author listing revisions: got %v, want ErrNotModerator", err)
+	}
+	revisions, err := u.ListMessageRevisions(f.as("carol"), message.Id)
+	if err != nil || len(revisions) != 2 {
+		t.Fatalf("ListMessageRevisions: %+v, %v", revisions, err)
+	}
+	if revisions[0].Content != "helo" || revisions[0].EditedBy != "alice" || revisions[1].Content != "hello" || revisions[1].EditedBy != "carol" {
+		t.Fatalf("unexpected revisions %+v, %+v", revisions[0], revisions[1])
+	}
+}
+
+func TestReactToMessage(t *testing.T) {
+	f := newChatFixture(t)
+	u := f.usecase
+
+	message, err := u.SendMessage(f.as("alice"), "lunch?", f.roomId, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"bob", "alice", "bob"} {
+		if _, err := u.ReactToMessage(f.as(name), message.Id, "👍", false); err != nil {
+			t.Fatalf("%s reacting: %v", name, err)
+		}
+	}
+	reacted, err := u.ReactToMessage(f.as("alice"), message.Id, "🍕", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reacted.Reactions) != 2 || reacted.Reactions[0].Emoji != "👍" || len(reacted.Reactions[0].UserIDs) != 2 {
+		t.Fatalf("unexpected reactions %+v", reacted.Reactions)
+	}
+	f.members["bob"].nextEvent(t, models.EventMessageReactions)
+
+	removed, err := u.ReactToMessage(f.as("bob"), message.Id, "👍", true)
+	if err != nil || len(removed.Reactions[0].UserIDs) != 1 || removed.Reactions[0].UserIDs[0] != "alice" {
+		t.Fatalf("removing a reaction: %+v, %v", removed, err)
+	}
+
+	if _, err := u.ReactToMessage(f.as("carol"), message.Id, "👍", false); !errors.Is(err, ErrUserNotFoundInRoom) {
+		t.Fatalf("reacting from outside the room: got %v, want ErrUserNotFoundInRoom", err)
+	}
+	if _, err := u.ReactToMessage(f.as("bob"), message.Id, "thumbs up", false); !errors.Is(err, ErrInvalidEmoji) {
+		t.Fatalf("reacting with spaces: got %v, want ErrInvalidEmoji", err)
+	}
+	if _, err := u.ReactToMessage(f.as("bob"), "missing", "👍", false); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("reacting to a missing message: got %v, want ErrMessageNotFound", err)
+	}
+}
+
+func TestThreadsAndMentions(t *testing.T) {
+	f := newChatFixture(t)
+	u := f.usecase
+
+	// dave posts once and leaves, he can still be mentioned
+	dave := &fakeClient{user: &userModel.User{Id: "dave", Name: "Dave", Role: userModel.RoleUser}, send: make(chan []byte, 64)}
+	u.hub.Register(dave, f.roomId, "chat")
+	f.members["dave"] = dave
+	if _, err := u.SendMessage(f.as("dave"), "brb", f.roomId, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	u.hub.Unregister(dave, f.roomId)
+
+	root, err := u.SendMessage(f.as("alice"), "@BOB @alice @dave @carol see alice@example.com", f.roomId, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(root.Mentions, []string{"bob", "dave"}) {
+		t.Fatalf("mentions %v, want bob and dave", root.Mentions)
+	}
+	var mention models.MentionEvent
+	f.members["bob"].next(t, models.EventMention, &mention)
+	if mention.Message.Id != root.Id || mention.UnreadMentions != 1 {
+		t.Fatalf("unexpected mention event %+v", mention)
+	}
+
+	reply, err := u.SendMessage(f.as("bob"), "on it", f.roomId, root.Id, nil)
+	if err != nil || reply.ThreadId() != root.Id {
+		t.Fatalf("replying: %+v, %v", reply, err)
+	}
+	// replying to a reply stays in the thread
+	nested, err := u.SendMessage(f.as("alice"), "thanks", f.roomId, reply.Id, nil)
+	if err != nil || nested.ThreadId() != root.Id {
+		t.Fatalf("replying to a reply: %+v, %v", nested, err)
+	}
+	if _, err := u.SendMessage(f.as("alice"), "hm", f.roomId, "missing", nil); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("replying to a missing message: got %v, want ErrMessageNotFound", err)
+	}
+
+	parent, replies, err := u.ListThread(f.as("carol"), nested.Id)
+	if err != nil || parent.Id != root.Id || len(replies) != 2 || replies[0].Id != reply.Id || replies[1].Id != nested.Id {
+		t.Fatalf("ListThread: %+v, %+v, %v", parent, replies, err)
+	}
+	if !slices.Equal(parent.Mentions, root.Mentions) {
+		t.Fatalf("stored mentions %v, want %v", parent.Mentions, root.Mentions)
+	}
+	if _, _, err := u.ListThread(f.as("bob"), nested.Id); err != nil {
+		t.Fatalf("ListThread by a member: %v", err)
+	}
+	// dave left the room
+	if _, _, err := u.ListThread(context.WithValue(context.Background(), "user", dave.user), root.Id); !errors.Is(err, ErrUserNotFoundInRoom) {
+		t.Fatalf("ListThread from outside the room: got %v, want ErrUserNotFoundInRoom", err)
+	}
+
+	messages, unread, err := u.ListMentions(f.as("dave"))
+	if err != nil || unread != 1 || len(messages) != 1 || messages[0].Id != root.Id {
+		t.Fatalf("ListMentions: %+v, %d, %v", messages, unread, err)
+	}
+	if unread, err := u.MarkMentionsRead(f.as("dave"), nil); err != nil || unread != 0 {
+		t.Fatalf("MarkMentionsRead: %d, %v", unread, err)
+	}
+	if _, unread, _ := u.ListMentions(f.as("bob")); unread != 1 {
+		t.Fatalf("dave reading his mentions changed bob's count to %d", unread)
+	}
+
+	// edits resolve the mentions again, only the users added are notified
+	edited, err := u.SendMessage(f.as("alice"), "standup", f.roomId, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited, err = u.EditMessage(f.as("alice"), edited.Id, "standup @bob"); err != nil || !slices.Equal(edited.Mentions, []string{"bob"}) {
+		t.Fatalf("adding a mention: %+v, %v", edited, err)
+	}
+	f.members["bob"].next(t, models.EventMention, &mention)
+	if mention.Message.Id != edited.Id || mention.UnreadMentions != 2 {
+		t.Fatalf("unexpected mention event %+v", mention)
+	}
+	if edited, err = u.EditMessage(f.as("alice"), edited.Id, "standup @bob @dave"); err != nil || !slices.Equal(edited.Mentions, []string{"bob", "dave"}) {
+		t.Fatalf("adding another mention: %+v, %v", edited, err)
+	}
+	for len(f.members["bob"].send) > 0 {
+		var frame struct{ Type string }
+		if json.Unmarshal(<-f.members["bob"].send, &frame); frame.Type == models.EventMention {
+			t.Fatal("bob was notified again of an existing mention")
+		}
+	}
+	if edited, err = u.EditMessage(f.as("alice"), edited.Id, "standup @dave"); err != nil || !slices.Equal(edited.Mentions, []string{"dave"}) {
+		t.Fatalf("removing a mention: %+v, %v", edited, err)
+	}
+	stored, _, err := u.ListThread(f.as("carol"), edited.Id)
+	if err != nil || !slices.Equal(stored.Mentions, []string{"dave"}) {
+		t.Fatalf("stored mentions after the edits: %+v, %v", stored, err)
+	}
+	if _, unread, _ := u.ListMentions(f.as("bob")); unread != 1 {
+		t.Fatalf("bob has %d unread mentions after being edited out, want 1", unread)
+	}
+	if _, unread, _ := u.ListMentions(f.as("dave")); unread != 1 {
+		t.Fatalf("dave has %d unread mentions, want the edit's", unread)
+	}
+}
+
+func TestParseMentions(t *testing.T) {
+	got := models.ParseMentions("@Ana, @ana and @Zoë; me@mail.com (@42)")
+	if want := []string{"ana", "zoë", "42"}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestReadMarkers(t *testing.T) {
+	f := newChatFixture(t)
+	u := f.usecase
+
+	unread := func(name string) int64 {
+		t.Helper()
+		rooms, err := u.ListRooms(f.as(name))
+		if err != nil || len(rooms) != 1 {
+			t.Fatalf("ListRooms: %+v, %v", rooms, err)
+		}
+		return rooms[0].Unread
+	}
+
+	var sent []*models.Message
+	for _, content := range []string{"one", "two", "three"} {
+		message, err := u.SendMessage(f.as("alice"), content, f.roomId, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, message)
+	}
+	if _, err := u.SendMessage(f.as("bob"), "hi", f.roomId, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if unread("bob") != 3 || unread("alice") != 1 {
+		t.Fatalf("bob has %d unread, alice %d, want 3 and 1", unread("bob"), unread("alice"))
+	}
+
+	if count, err := u.MarkRead(f.as("bob"), f.roomId, sent[1].Id); err != nil || count != 1 {
+		t.Fatalf("MarkRead: %d, %v", count, err)
+	}
+	var receipt models.ReadReceipt
+	f.members["alice"].next(t, models.EventRead, &receipt)
+	if receipt.UserID != "bob" || receipt.MessageID != sent[1].Id {
+		t.Fatalf("unexpected receipt %+v", receipt)
+	}
+
+	// going back doesn't move the marker
+	if count, err := u.MarkRead(f.as("bob"), f.roomId, sent[0].Id); err != nil || count != 1 {
+		t.Fatalf("MarkRead of an older message: %d, %v", count, err)
+	}
+	if _, err := u.MarkRead(f.as("bob"), "elsewhere", sent[2].Id); !errors.Is(err, ErrMessageInOtherRoom) {
+		t.Fatalf("marking another room: got %v, want ErrMessageInOtherRoom", err)
+	}
+
+	// carol isn't in the room, so no marker is saved and no receipt is sent there
+	if _, err := u.MarkRead(f.as("carol"), f.roomId, sent[2].Id); !errors.Is(err, ErrUserNotFoundInRoom) {
+		t.Fatalf("marking from outside the room: got %v, want ErrUserNotFoundInRoom", err)
+	}
+	u.handleEvent(context.Background(), &userModel.User{Id: "carol", Name: "carol"}, f.roomId, []byte(`{"type":"read","message_id":"`+sent[2].Id+`"}`))
+	for len(f.members["alice"].send) > 0 {
+		if data := <-f.members["alice"].send; strings.Contains(string(data), `"carol"`) {
+			t.Fatalf("carol's read reached the room: %s", data)
+		}
+	}
+
+	// reading from the socket reads the mentions along the way
+	mention, err := u.SendMessage(f.as("alice"), "@bob?", f.roomId, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.handleEvent(context.Background(), f.members["bob"].user, f.roomId, []byte(`{"type":"read","message_id":"`+mention.Id+`"}`))
+	if count := unread("bob"); count != 0 {
+		t.Fatalf("bob has %d unread after reading everything", count)
+	}
+	if _, mentions, _ := u.ListMentions(f.as("bob")); mentions != 0 {
+		t.Fatalf("bob has %d unread mentions after reading the room", mentions)
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	f := newChatFixture(t)
+	u := f.usecase
+
+	var sent []*models.Message
+	for _, content := range []string{"Deploy is green", "who broke the deploy?", "lunch?", "deploying again, green this time"} {
+		message, err := u.SendMessage(f.as("alice"), content, f.roomId, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, message)
+	}
+	if _, err := u.DeleteMessage(f.as("alice"), sent[0].Id); err != nil {
+		t.Fatal(err)
+	}
+
+	results, cursor, err := u.SearchMessages(f.as("bob"), &SearchQuery{Text: "DEPLOY"})
+	if err != nil || len(results) != 1 || results[0].Message.Id != sent[1].Id || cursor != "" {
+		t.Fatalf("SearchMessages: %+v, %q, %v", results, cursor, err)
+	}
+	want := []models.SnippetPart{{Text: "who broke the "}, {Text: "deploy", Match: true}, {Text: "?"}}
+	if !slices.Equal(results[0].Snippet, want) {
+		t.Fatalf("snippet %+v, want %+v", results[0].Snippet, want)
+	}
+
+	// every word has to match
+	if results, _, _ := u.SearchMessages(f.as("bob"), &SearchQuery{Text: "green deploy"}); len(results) != 0 {
+		t.Fatalf("searching for two words found %+v", results)
+	}
+
+	// pages follow the cursor, newest first
+	for _, content := range []string{"lunch at noon", "lunch at one"} {
+		if _, err := u.SendMessage(f.as("bob"), content, f.roomId, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var found []string
+	cursor = ""
+	for page := 0; ; page++ {
+		results, next, err := u.SearchMessages(f.as("alice"), &SearchQuery{Text: "lunch", Cursor: cursor, PageSize: 2})
+		if err != nil || page > 2 {
+			t.Fatalf("SearchMessages page %d: %v", page, err)
+		}
+		for _, result := range results {
+			found = append(found, result.Message.Content)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if want := []string{"lunch at one", "lunch at noon", "lunch?"}; !slices.Equal(found, want) {
+		t.Fatalf("paged through %v, want %v", found, want)
+	}
+	if _, _, err := u.SearchMessages(f.as("alice"), &SearchQuery{Text: "lunch", Cursor: sent[2].Id}); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("raw message id as cursor: got %v, want ErrInvalidCursor", err)
+	}
+
+	if results, _, _ := u.SearchMessages(f.as("alice"), &SearchQuery{Text: "lunch", AuthorId: "alice"}); len(results) != 1 {
+		t.Fatalf("searching alice's messages found %+v", results)
+	}
+	if results, _, _ := u.SearchMessages(f.as("alice"), &SearchQuery{Text: "lunch", Until: sent[3].Timestamp}); len(results) != 1 {
+		t.Fatalf("searching before the last deploy found %+v", results)
+	}
+
+	// only members and moderators read the room
+	dave := context.WithValue(context.Background(), "user", &userModel.User{Id: "dave", Name: "dave", Role: userModel.RoleUser})
+	if results, _, err := u.SearchMessages(dave, &SearchQuery{Text: "lunch"}); err != nil || len(results) != 0 {
+		t.Fatalf("dave searching: %+v, %v", results, err)
+	}
+	if _, _, err := u.SearchMessages(dave, &SearchQuery{Text: "lunch", RoomId: f.roomId}); !errors.Is(err, ErrUserNotFoundInRoom) {
+		t.Fatalf("dave searching the room: got %v, want ErrUserNotFoundInRoom", err)
+	}
+	if results, _, _ := u.SearchMessages(f.as("carol"), &SearchQuery{Text: "lunch", RoomId: f.roomId}); len(results) != 3 {
+		t.Fatalf("moderator searching found %+v", results)
+	}
+	if _, _, err := u.SearchMessages(f.as("alice"), &SearchQuery{Text: " ?! "}); !errors.Is(err, ErrEmptySearch) {
+		t.Fatalf("searching punctuation: got %v, want ErrEmptySearch", err)
+	}
+}
+
+func TestBuildSnippet(t *testing.T) {
+	content := "one two three four five six seven eight nine ten eleven twelve Match thirteen"
+	got := models.BuildSnippet(content, []string{"match"})
+	want := []models.SnippetPart{{Text: "…five six seven eight nine ten eleven twelve "}, {Text: "Match", Match: true}, {Text: " thirteen"}}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+// flagLunch flags any message about lunch
+type flagLunch struct{}
+
+func (flagLunch) Name() string { return "lunch" }
+func (flagLunch) Check(ctx context.Context, message *moderation.Message) (moderation.Verdict, error) {
+	if strings.Contains(message.Content, "lunch") {
+		return moderation.Verdict{Action: moderation.Flag, Reason: "off topic"}, nil
+	}
+	return moderation.Verdict{}, nil
+}
+
+func TestModeration(t *testing.T) {
+	f := newChatFixture(t)
+	u := f.usecase
+	u.AddFilter(flagLunch{})
+
+	for range 3 {
+		if _, err := u.SendMessage(f.as("alice"), "first!", f.roomId, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := u.SendMessage(f.as("alice"), "First!", f.roomId, "", nil); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("fourth repeat: got %v, want a rejection", err)
+	}
+
+	if err := u.SetRoomLinks(f.as("alice"), f.roomId, false); !errors.Is(err, ErrNotModerator) {
+		t.Fatalf("alice blocking links: got %v, want ErrNotModerator", err)
+	}
+	if err := u.SetRoomLinks(f.as("carol"), f.roomId, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.SendMessage(f.as("bob"), "see https://example.com", f.roomId, "", nil); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("link in a blocking room: got %v, want a rejection", err)
+	}
+
+	message, err := u.SendMessage(f.as("bob"), "lunch?", f.roomId, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// edits go through the filters too
+	edit, err := u.SendMessage(f.as("alice"), "on topic", f.roomId, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.EditMessage(f.as("alice"), edit.Id, "lunch at noon"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.EditMessage(f.as("alice"), edit.Id, "www.example.com"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("editing a link in: got %v, want a rejection", err)
+	}
+
+	if _, err := u.ListFlaggedMessages(f.as("bob")); !errors.Is(err, ErrNotModerator) {
+		t.Fatalf("bob listing flags: got %v, want ErrNotModerator", err)
+	}
+	flags, err := u.ListFlaggedMessages(f.as("carol"))
+	if err != nil || len(flags) != 2 {
+		t.Fatalf("ListFlaggedMessages: %+v, %v", flags, err)
+	}
+	if flags[0].Message.Id != message.Id || flags[0].Filter != "lunch" || flags[0].Reason != "off topic" || flags[1].Message.Content != "lunch at noon" {
+		t.Fatalf("unexpected flags %+v, %+v", flags[0], flags[1])
+	}
+
+	if err := u.ResolveFlag(f.as("carol"), flags[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.ResolveFlag(f.as("carol"), flags[0].Id); !errors.Is(err, ErrFlagNotFound) {
+		t.Fatalf("resolving twice: got %v, want ErrFlagNotFound", err)
+	}
+	if flags, _ := u.ListFlaggedMessages(f.as("carol")); len(flags) != 1 || flags[0].Message.Id != edit.Id {
+		t.Fatalf("pending flags after resolving one: %+v", flags)
+	}
+}
+
+func TestSlashCommands(t *testing.T) {
+	f := newChatFixture(t)
+	u := f.usecase
+
+	carol := &fakeClient{user: &userModel.User{Id: "carol", Name: "carol", Role: userModel.RoleModerator}, send: make(chan []byte, 64)}
+	u.hub.Register(carol, f.roomId, "chat")
+	f.members["carol"] = carol
+
+	send := func(name string, content string) (*models.Message, error) {
+		t.Helper()
+		return u.SendMessage(f.as(name), content, f.roomId, "", nil)
+	}
+	mustSend := func(name string, content string, kind string) *models.Message {
+		t.Helper()
+		message, err := send(name, content)
+		if err != nil || message.Kind != kind {
+			t.Fatalf("%s sending %q: %+v, %v", name, content, message, err)
+		}
+		return message
+	}
+
+	if help := mustSend("bob", "/help", models.MessageKindNotice); !strings.Contains(help.Content, "/roll [dice]") || strings.Contains(help.Content, "/kick") {
+		t.Fatalf("help for bob: %q", help.Content)
+	}
+	if help := mustSend("carol", "/help kick", models.MessageKindNotice); !strings.HasPrefix(help.Content, "/kick <name> [reason]") {
+		t.Fatalf("help for carol: %q", help.Content)
+	}
+	if _, err := send("bob", "/nope"); !errors.Is(err, ErrUnknownCommand) {
+		t.Fatalf("unknown command: got %v, want ErrUnknownCommand", err)
+	}
+	if message := mustSend("bob", "//etc/hosts", ""); message.Content != "/etc/hosts" {
+		t.Fatalf("escaped slash sent as %q", message.Content)
+	}
+
+	if message := mustSend("alice", "/me waves", models.MessageKindAction); message.Content != "waves" {
+		t.Fatalf("/me sent %q", message.Content)
+	}
+	roll := mustSend("alice", "/roll 2d6+1", models.MessageKindSystem)
+	if !regexp.MustCompile(`^alice rolled 2d6\+1: [1-6] \+ [1-6] \+ 1 = \d+$`).MatchString(roll.Content) {
+		t.Fatalf("roll posted %q", roll.Content)
+	}
+	if _, err := u.EditMessage(f.as("alice"), roll.Id, "alice rolled 2d6+1: 6 + 6 + 1 = 13"); !errors.Is(err, ErrNotEditable) {
+		t.Fatalf("editing a roll: got %v, want ErrNotEditable", err)
+	}
+	if _, err := send("alice", "/roll 2d1"); !errors.Is(err, ErrInvalidDice) {
+		t.Fatalf("one sided dice: got %v, want ErrInvalidDice", err)
+	}
+
+	if message := mustSend("alice", "/nick Al", models.MessageKindSystem); message.Content != "alice is now known as Al" {
+		t.Fatalf("/nick posted %q", message.Content)
+	}
+	if message := mustSend("alice", "hi", ""); message.Nick != "Al" {
+		t.Fatalf("message sent with nick %q", message.Nick)
+	}
+	if _, err := send("bob", "/nick al"); !errors.Is(err, ErrNickTaken) {
+		t.Fatalf("taking alice's nick: got %v, want ErrNickTaken", err)
+	}
+
+	if _, err := send("bob", "/topic mine"); !errors.Is(err, ErrCommandNotAllowed) {
+		t.Fatalf("bob setting the topic: got %v, want ErrCommandNotAllowed", err)
+	}
+	mustSend("alice", "/topic Release day", models.MessageKindSystem)
+	if topic := mustSend("bob", "/topic", models.MessageKindNotice); topic.Content != "Topic: Release day" {
+		t.Fatalf("/topic answered %q", topic.Content)
+	}
+	if rooms, _ := u.ListRooms(f.as("bob")); rooms[0].Topic != "Release day" {
+		t.Fatalf("room listed with topic %q", rooms[0].Topic)
+	}
+
+	if _, err := send("bob", "/mute alice"); !errors.Is(err, ErrCommandNotAllowed) {
+		t.Fatalf("bob muting: got %v, want ErrCommandNotAllowed", err)
+	}
+	if _, err := send("carol", "/mute bob 1s"); !errors.Is(err, ErrInvalidMute) {
+		t.Fatalf("muting for a second: got %v, want ErrInvalidMute", err)
+	}
+	if message := mustSend("carol", "/mute @Bob 5m", models.MessageKindSystem); message.Content != "bob was muted for 5m0s by carol" {
+		t.Fatalf("/mute posted %q", message.Content)
+	}
+	if _, err := send("bob", "let me talk"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("muted bob talking: got %v, want PermissionDenied", err)
+	}
+	if _, err := send("bob", "/roll"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("muted bob rolling: got %v, want PermissionDenied", err)
+	}
+	mustSend("bob", "/help", models.MessageKindNotice)
+	mustSend("carol", "/unmute bob", models.MessageKindSystem)
+	mustSend("bob", "thanks", "")
+
+	if _, err := send("carol", "/kick carol"); !errors.Is(err, ErrTargetIsModerator) {
+		t.Fatalf("kicking a moderator: got %v, want ErrTargetIsModerator", err)
+	}
+	if _, err := send("carol", "/kick dave"); !errors.Is(err, ErrNoSuchMember) {
+		t.Fatalf("kicking a stranger: got %v, want ErrNoSuchMember", err)
+	}
+	if message := mustSend("carol", "/kick Al too loud", models.MessageKindSystem); message.Content != "Al was kicked by carol: too loud" {
+		t.Fatalf("/kick posted %q", message.Content)
+	}
+	if u.roomSettings.KickedUntil(f.roomId, "alice", time.Now()).IsZero() {
+		t.Fatal("alice can join again right after being kicked")
+	}
+
+	err := u.RegisterCommand(&command.Command{Name: "ping", Description: "Pongs", Run: func(ctx context.Context, call *command.Call) (*command.Result, error) {
+		return &command.Result{Notice: "pong"}, nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pong := mustSend("bob", "/ping", models.MessageKindNotice); pong.Content != "pong" {
+		t.Fatalf("/ping answered %q", pong.Content)
+	}
+	if err := u.RegisterCommand(&command.Command{Name: "roll", Run: func(ctx context.Context, call *command.Call) (*command.Result, error) { return nil, nil }}); err == nil {
+		t.Fatal("registered /roll twice")
+	}
+}
@@ -0,0 +1,147 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	"github.com/bozoteam/roshan/helpers"
+	"github.com/bozoteam/roshan/modules/chat/models"
+	"github.com/bozoteam/roshan/modules/chat/moderation"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	"github.com/bozoteam/roshan/roshan_errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+var ErrFlagNotFound = status.Error(codes.NotFound, "flag not found or already resolved")
+
+// maxListedFlags is how many pending flags ListFlaggedMessages returns
+const maxListedFlags = 50
+
+var wordActions = map[string]moderation.Action{
+	config.WordActionRedact: moderation.Redact,
+	config.WordActionReject: moderation.Reject,
+	config.WordActionFlag:   moderation.Flag,
+}
+
+// newModerationChain builds the built-in filters, cheapest checks first. The link filter is
+// returned too so rooms can be configured.
+func newModerationChain(cfg config.ModerationConfig) (*moderation.Chain, *moderation.LinkFilter) {
+	links := moderation.NewLinkFilter(cfg.BlockLinks)
+	chain := moderation.NewChain(
+		moderation.NewLengthFilter(cfg.MaxLength),
+		moderation.NewRepeatFilter(cfg.RepeatLimit, cfg.RepeatWindow),
+		links,
+	)
+	if len(cfg.Words) > 0 {
+		chain.Add(moderation.NewWordFilter(cfg.Words, wordActions[cfg.WordAction]))
+	}
+	return chain, links
+}
+
+// AddFilter appends a filter to the ones messages go through, after the built-in ones. It
+// must be called before the server starts.
+func (u *ChatUsecase) AddFilter(filter moderation.Filter) {
+	u.moderation.Add(filter)
+}
+
+// moderate runs content through the filters, returning what to send and the flags to record
+// once the message is saved
+func (u *ChatUsecase) moderate(ctx context.Context, user *userModel.User, roomId string, content string, edit bool) (string, []moderation.FlagReason, error) {
+	result, err := u.moderation.Check(ctx, &moderation.Message{
+		UserId:  user.Id,
+		RoomId:  roomId,
+		Content: content,
+		Edit:    edit,
+		Time:    time.Now(),
+	})
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to moderate message", "error", err, "room_id", roomId, "user_id", user.Id)
+		return "", nil, roshan_errors.ErrInternalServerError
+	}
+	if result.Rejected {
+		u.logger.InfoContext(ctx, "message rejected", "filter", result.RejectedBy, "room_id", roomId, "user_id", user.Id)
+		return "", nil, status.Error(codes.InvalidArgument, "message rejected: "+result.Reason)
+	}
+	if content != "" && models.ValidateContent(result.Content) != nil {
+		return "", nil, ErrInvalidContent
+	}
+	return result.Content, result.Flags, nil
+}
+
+// recordFlags stores the flags raised on a message that was sent. Failing to do so doesn't
+// take the message back.
+func (u *ChatUsecase) recordFlags(ctx context.Context, message *models.Message, reasons []moderation.FlagReason) {
+	if len(reasons) == 0 {
+		return
+	}
+	flags := make([]models.MessageFlag, len(reasons))
+	for i, reason := range reasons {
+		flags[i] = models.MessageFlag{
+			Id:        helpers.GenUUID(),
+			MessageId: message.Id,
+			Filter:    reason.Filter,
+			Reason:    reason.Reason,
+		}
+	}
+	if err := u.messageRepository.SaveFlags(ctx, flags); err != nil {
+		u.logger.ErrorContext(ctx, "failed to flag message", "error", err, "message_id", message.Id)
+		return
+	}
+	u.logger.InfoContext(ctx, "message flagged", "message_id", message.Id, "room_id", message.RoomID, "flags", len(flags))
+}
+
+// ListFlaggedMessages returns the oldest flags waiting for a moderator
+func (u *ChatUsecase) ListFlaggedMessages(ctx context.Context) ([]*models.MessageFlag, error) {
+	user := ctx.Value("user").(*userModel.User)
+
+	if !user.IsModerator() {
+		return nil, ErrNotModerator
+	}
+
+	flags, err := u.messageRepository.ListFlags(ctx, maxListedFlags)
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to list flags", "error", err)
+		return nil, roshan_errors.ErrInternalServerError
+	}
+	return flags, nil
+}
+
+// ResolveFlag takes a flag off the review queue. Moderators delete the message separately
+// when it has to go.
+func (u *ChatUsecase) ResolveFlag(ctx context.Context, flagId string) error {
+	user := ctx.Value("user").(*userModel.User)
+
+	if !user.IsModerator() {
+		return ErrNotModerator
+	}
+
+	err := u.messageRepository.ResolveFlag(ctx, flagId, user.Id, time.Now())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrFlagNotFound
+	}
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to resolve flag", "error", err, "flag_id", flagId)
+		return roshan_errors.ErrInternalServerError
+	}
+	return nil
+}
+
+// SetRoomLinks lets moderators allow or block links in a room, whatever the default
+func (u *ChatUsecase) SetRoomLinks(ctx context.Context, roomId string, allowed bool) error {
+	user := ctx.Value("user").(*userModel.User)
+
+	if !user.IsModerator() {
+		return ErrNotModerator
+	}
+	if u.hub.GetRoom(roomId) == nil {
+		return ErrRoomNotFound
+	}
+
+	u.links.SetBlocked(roomId, !allowed)
+	u.logger.InfoContext(ctx, "room links changed", "room_id", roomId, "allowed", allowed, "moderator_id", user.Id)
+	return nil
+}
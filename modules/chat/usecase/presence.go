@@ -0,0 +1,303 @@
+package usecase
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	"github.com/bozoteam/roshan/modules/chat/models"
+)
+
+// PresenceTracker follows who is connected to the chat, how recently they did something
+// and who is typing where. Changes are batched and each room is sent at most one
+// RoomPresence per flush interval, however many people type in it.
+type PresenceTracker struct {
+	cfg       config.PresenceConfig
+	broadcast func(roomId string, data []byte)
+	now       func() time.Time
+
+	mu sync.Mutex
+	// users holds who is connected, and who went offline until ForgetAfter passes
+	users map[string]*userPresence
+	// typing maps a room to its typists and when their typing expires
+	typing map[string]map[string]time.Time
+	// pending maps rooms with unsent changes to the users whose presence changed
+	pending map[string]map[string]struct{}
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type userPresence struct {
+	// rooms counts the sockets the user has open in each room
+	rooms      map[string]int
+	lastActive time.Time
+	// state is the last state rooms were told about
+	state models.PresenceState
+}
+
+// NewPresenceTracker sends room frames through broadcast, it stops flushing once Stop is called
+func NewPresenceTracker(cfg config.PresenceConfig, broadcast func(roomId string, data []byte)) *PresenceTracker {
+	t := &PresenceTracker{
+		cfg:       cfg,
+		broadcast: broadcast,
+		now:       time.Now,
+		users:     make(map[string]*userPresence),
+		typing:    make(map[string]map[string]time.Time),
+		pending:   make(map[string]map[string]struct{}),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *PresenceTracker) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.Flush()
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// Stop ends the flush loop
+func (t *PresenceTracker) Stop() {
+	select {
+	case <-t.stop:
+	default:
+		close(t.stop)
+	}
+	<-t.done
+}
+
+// Connected records a socket of the user opening in a room
+func (t *PresenceTracker) Connected(userId string, roomId string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	user, exists := t.users[userId]
+	if !exists {
+		user = &userPresence{rooms: make(map[string]int), state: models.PresenceOffline}
+		t.users[userId] = user
+	}
+	user.rooms[roomId]++
+	user.lastActive = t.now()
+	t.refresh(userId, user)
+	// a room the user just entered hasn't heard of them yet
+	if user.rooms[roomId] == 1 {
+		t.markChanged(roomId, userId)
+	}
+}
+
+// Disconnected records a socket of the user closing
+func (t *PresenceTracker) Disconnected(userId string, roomId string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	user, exists := t.users[userId]
+	if !exists || user.rooms[roomId] == 0 {
+		return
+	}
+
+	user.rooms[roomId]--
+	if user.rooms[roomId] == 0 {
+		delete(user.rooms, roomId)
+		t.stopTyping(userId, roomId)
+		// the room still has to hear the user went offline
+		if len(user.rooms) == 0 {
+			user.state = models.PresenceOffline
+			t.markChanged(roomId, userId)
+		}
+	}
+	t.refresh(userId, user)
+}
+
+// Active records activity from the user, bringing them back online
+func (t *PresenceTracker) Active(userId string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active(userId)
+}
+
+func (t *PresenceTracker) active(userId string) {
+	if user, exists := t.users[userId]; exists {
+		user.lastActive = t.now()
+		t.refresh(userId, user)
+	}
+}
+
+// StartTyping shows the user as typing in the room until StopTyping is called or
+// TypingTimeout passes without another StartTyping
+func (t *PresenceTracker) StartTyping(userId string, roomId string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	user, exists := t.users[userId]
+	if !exists || user.rooms[roomId] == 0 {
+		return
+	}
+	t.active(userId)
+
+	typists, exists := t.typing[roomId]
+	if !exists {
+		typists = make(map[string]time.Time)
+		t.typing[roomId] = typists
+	}
+	if _, typing := typists[userId]; !typing {
+		t.markChanged(roomId, "")
+	}
+	typists[userId] = t.now().Add(t.cfg.TypingTimeout)
+}
+
+// StopTyping removes the user from the room's typists
+func (t *PresenceTracker) StopTyping(userId string, roomId string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopTyping(userId, roomId)
+}
+
+func (t *PresenceTracker) stopTyping(userId string, roomId string) {
+	typists := t.typing[roomId]
+	if _, typing := typists[userId]; !typing {
+		return
+	}
+	delete(typists, userId)
+	if len(typists) == 0 {
+		delete(t.typing, roomId)
+	}
+	t.markChanged(roomId, "")
+}
+
+// Get returns the presence of each user, users never seen are offline
+func (t *PresenceTracker) Get(userIds []string) []models.Presence {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	presences := make([]models.Presence, len(userIds))
+	for i, userId := range userIds {
+		presences[i] = models.Presence{UserID: userId, State: models.PresenceOffline}
+		if user, exists := t.users[userId]; exists {
+			presences[i].State = t.state(user)
+			presences[i].LastActiveAt = user.lastActive.UnixNano()
+		}
+	}
+	return presences
+}
+
+// Rooms returns the rooms the user has a socket open in
+func (t *PresenceTracker) Rooms(userId string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	user, exists := t.users[userId]
+	if !exists {
+		return nil
+	}
+	return slices.Sorted(maps.Keys(user.rooms))
+}
+
+// Flush expires typists, moves inactive users to idle or away and sends the pending changes.
+// Users offline for longer than ForgetAfter are forgotten once their rooms heard they left.
+// It runs every FlushInterval.
+func (t *PresenceTracker) Flush() {
+	t.mu.Lock()
+	now := t.now()
+	for roomId, typists := range t.typing {
+		for userId, expiresAt := range typists {
+			if now.After(expiresAt) {
+				t.stopTyping(userId, roomId)
+			}
+		}
+	}
+	for userId, user := range t.users {
+		t.refresh(userId, user)
+	}
+
+	frames := make(map[string]*models.RoomPresence, len(t.pending))
+	for roomId, changed := range t.pending {
+		frame := &models.RoomPresence{
+			Type:      "presence",
+			RoomID:    roomId,
+			Users:     make([]models.Presence, 0, len(changed)),
+			Typing:    slices.Sorted(maps.Keys(t.typing[roomId])),
+			Timestamp: now.UnixNano(),
+		}
+		if frame.Typing == nil {
+			frame.Typing = []string{}
+		}
+		for _, userId := range slices.Sorted(maps.Keys(changed)) {
+			user := t.users[userId]
+			frame.Users = append(frame.Users, models.Presence{
+				UserID:       userId,
+				State:        user.state,
+				LastActiveAt: user.lastActive.UnixNano(),
+			})
+		}
+		frames[roomId] = frame
+	}
+	clear(t.pending)
+
+	for userId, user := range t.users {
+		if len(user.rooms) == 0 && now.Sub(user.lastActive) >= t.cfg.ForgetAfter {
+			delete(t.users, userId)
+		}
+	}
+	t.mu.Unlock()
+
+	for roomId, frame := range frames {
+		data, err := json.Marshal(frame)
+		if err != nil {
+			panic(err)
+		}
+		t.broadcast(roomId, data)
+	}
+}
+
+// state is the presence of a user from its sockets and last activity
+func (t *PresenceTracker) state(user *userPresence) models.PresenceState {
+	inactive := t.now().Sub(user.lastActive)
+	switch {
+	case len(user.rooms) == 0:
+		return models.PresenceOffline
+	case inactive >= t.cfg.AwayAfter:
+		return models.PresenceAway
+	case inactive >= t.cfg.IdleAfter:
+		return models.PresenceIdle
+	default:
+		return models.PresenceOnline
+	}
+}
+
+// refresh queues the user's new state for every room they're in when it changed
+func (t *PresenceTracker) refresh(userId string, user *userPresence) {
+	state := t.state(user)
+	if state == user.state {
+		return
+	}
+	user.state = state
+	for roomId := range user.rooms {
+		t.markChanged(roomId, userId)
+	}
+}
+
+// markChanged queues a frame for the room, with the user's presence when userId isn't empty
+func (t *PresenceTracker) markChanged(roomId string, userId string) {
+	changed, exists := t.pending[roomId]
+	if !exists {
+		changed = make(map[string]struct{})
+		t.pending[roomId] = changed
+	}
+	if userId != "" {
+		changed[userId] = struct{}{}
+	}
+}
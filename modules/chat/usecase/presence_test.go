@@ -0,0 +1,156 @@
+package usecase
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	"github.com/bozoteam/roshan/modules/chat/models"
+)
+
+type presenceFixture struct {
+	tracker *PresenceTracker
+	clock   time.Time
+
+	mu     sync.Mutex
+	frames []*models.RoomPresence
+}
+
+// newPresenceFixture returns a tracker on a fake clock, flushed only when the test calls Flush
+func newPresenceFixture(t *testing.T) *presenceFixture {
+	t.Helper()
+
+	cfg := config.Default().Chat.Presence
+	cfg.FlushInterval = time.Hour
+
+	f := &presenceFixture{clock: time.Unix(1_700_000_000, 0)}
+	f.tracker = NewPresenceTracker(cfg, func(roomId string, data []byte) {
+		frame := &models.RoomPresence{}
+		if err := json.Unmarshal(data, frame); err != nil {
+			t.Errorf("undecodable frame: %v", err)
+		}
+		f.mu.Lock()
+		f.frames = append(f.frames, frame)
+		f.mu.Unlock()
+	})
+	f.tracker.now = func() time.Time { return f.clock }
+	t.Cleanup(f.tracker.Stop)
+	return f
+}
+
+// flush returns the frames sent by one flush
+func (f *presenceFixture) flush() []*models.RoomPresence {
+	f.tracker.Flush()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	frames := f.frames
+	f.frames = nil
+	return frames
+}
+
+func TestTypingIsCoalesced(t *testing.T) {
+	f := newPresenceFixture(t)
+	for _, user := range []string{"alice", "bob", "carol"} {
+		f.tracker.Connected(user, "room")
+	}
+	f.flush()
+
+	for range 10 {
+		f.tracker.StartTyping("alice", "room")
+		f.tracker.StartTyping("bob", "room")
+	}
+	f.tracker.StartTyping("carol", "elsewhere")
+
+	frames := f.flush()
+	if len(frames) != 1 {
+		t.Fatalf("got %d frames, want 1: %+v", len(frames), frames)
+	}
+	if got := frames[0].Typing; !slices.Equal(got, []string{"alice", "bob"}) {
+		t.Fatalf("typing %v, want alice and bob", got)
+	}
+	if frames := f.flush(); len(frames) != 0 {
+		t.Fatalf("nothing changed but got %+v", frames)
+	}
+
+	// typing expires unless it's renewed
+	f.clock = f.clock.Add(4 * time.Second)
+	f.tracker.StartTyping("alice", "room")
+	f.clock = f.clock.Add(4 * time.Second)
+	frames = f.flush()
+	if len(frames) != 1 || !slices.Equal(frames[0].Typing, []string{"alice"}) {
+		t.Fatalf("expected only bob to expire: %+v", frames)
+	}
+
+	f.tracker.StopTyping("alice", "room")
+	frames = f.flush()
+	if len(frames) != 1 || len(frames[0].Typing) != 0 {
+		t.Fatalf("expected an empty typing list: %+v", frames)
+	}
+}
+
+func TestPresenceStates(t *testing.T) {
+	f := newPresenceFixture(t)
+	state := func(user string) models.PresenceState {
+		return f.tracker.Get([]string{user})[0].State
+	}
+
+	if state("alice") != models.PresenceOffline {
+		t.Fatal("unknown user isn't offline")
+	}
+
+	f.tracker.Connected("alice", "room")
+	f.tracker.Connected("alice", "other")
+	frames := f.flush()
+	if len(frames) != 2 || frames[0].Users[0].State != models.PresenceOnline {
+		t.Fatalf("expected both rooms to see alice online: %+v", frames)
+	}
+
+	f.clock = f.clock.Add(2 * time.Minute)
+	if state("alice") != models.PresenceIdle {
+		t.Fatalf("alice is %s after 2 minutes, want idle", state("alice"))
+	}
+	f.clock = f.clock.Add(10 * time.Minute)
+	frames = f.flush()
+	if state("alice") != models.PresenceAway || len(frames) != 2 || frames[0].Users[0].State != models.PresenceAway {
+		t.Fatalf("expected alice away: %s, %+v", state("alice"), frames)
+	}
+
+	f.tracker.Active("alice")
+	if state("alice") != models.PresenceOnline {
+		t.Fatal("activity didn't bring alice back online")
+	}
+	f.flush()
+
+	// closing one of two rooms keeps alice online, the second makes her offline
+	f.tracker.Disconnected("alice", "other")
+	if frames := f.flush(); len(frames) != 0 || state("alice") != models.PresenceOnline {
+		t.Fatalf("alice should still be online: %s, %+v", state("alice"), frames)
+	}
+	f.tracker.Disconnected("alice", "room")
+	frames = f.flush()
+	if len(frames) != 1 || frames[0].RoomID != "room" || frames[0].Users[0].State != models.PresenceOffline {
+		t.Fatalf("expected the last room to see alice leave: %+v", frames)
+	}
+
+	presence := f.tracker.Get([]string{"alice"})[0]
+	if presence.State != models.PresenceOffline || presence.LastActiveAt != f.clock.UnixNano() {
+		t.Fatalf("unexpected presence %+v", presence)
+	}
+
+	// offline users are forgotten after a while, connected ones never are
+	f.tracker.Connected("bob", "room")
+	f.clock = f.clock.Add(config.Default().Chat.Presence.ForgetAfter)
+	f.flush()
+	if _, remembered := f.tracker.users["alice"]; remembered {
+		t.Fatal("alice is still remembered")
+	}
+	if _, remembered := f.tracker.users["bob"]; !remembered {
+		t.Fatal("bob was forgotten while connected")
+	}
+	if presence := f.tracker.Get([]string{"alice"})[0]; presence.State != models.PresenceOffline || presence.LastActiveAt != 0 {
+		t.Fatalf("unexpected presence of a forgotten user %+v", presence)
+	}
+}
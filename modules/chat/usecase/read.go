@@ -0,0 +1,69 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/bozoteam/roshan/modules/chat/models"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	"github.com/bozoteam/roshan/roshan_errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var ErrMessageInOtherRoom = status.Error(codes.InvalidArgument, "message is not in this room")
+
+// MarkRead moves the user's read marker in the room up to messageId and returns how many
+// messages are still unread there. Markers never move back, reading an older message
+// changes nothing. The user's mentions up to the marker are read along with it.
+func (u *ChatUsecase) MarkRead(ctx context.Context, roomId string, messageId string) (int64, error) {
+	user := ctx.Value("user").(*userModel.User)
+	return u.markRead(ctx, user, roomId, messageId)
+}
+
+func (u *ChatUsecase) markRead(ctx context.Context, user *userModel.User, roomId string, messageId string) (int64, error) {
+	message, err := u.findMessage(ctx, messageId)
+	if err != nil {
+		return 0, err
+	}
+	if message.RoomID != roomId {
+		return 0, ErrMessageInOtherRoom
+	}
+	// receipts are broadcast to the room, outsiders have nothing to mark there
+	room := u.hub.GetRoom(roomId)
+	if room == nil || !room.HasUser(user.Id) {
+		return 0, ErrUserNotFoundInRoom
+	}
+
+	marker := &models.ReadMarker{UserId: user.Id, RoomId: roomId, MessageId: message.Id}
+	moved, err := u.messageRepository.MarkRead(ctx, marker, time.Now())
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to save read marker", "error", err, "user_id", user.Id, "room_id", roomId)
+		return 0, roshan_errors.ErrInternalServerError
+	}
+	if moved && u.readReceipts {
+		u.broadcastReadReceipt(ctx, marker)
+	}
+
+	counts, err := u.messageRepository.CountUnread(ctx, user.Id, []string{roomId})
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to count unread messages", "error", err, "user_id", user.Id, "room_id", roomId)
+		return 0, roshan_errors.ErrInternalServerError
+	}
+	return counts[roomId], nil
+}
+
+func (u *ChatUsecase) broadcastReadReceipt(ctx context.Context, marker *models.ReadMarker) {
+	data, err := json.Marshal(&models.ReadReceipt{
+		Type:      models.EventRead,
+		RoomID:    marker.RoomId,
+		UserID:    marker.UserId,
+		MessageID: marker.MessageId,
+		Timestamp: time.Now().UnixNano(),
+	})
+	if err != nil {
+		panic(err)
+	}
+	u.hub.BroadcastBytes(ctx, marker.RoomId, data)
+}
@@ -0,0 +1,125 @@
+package usecase
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// roomSettings is what commands changed in a room. Rooms only live in memory, so does this.
+type roomSettings struct {
+	topic string
+	// nicks, muted and kicked are keyed by user id, the last two until when they last
+	nicks  map[string]string
+	muted  map[string]time.Time
+	kicked map[string]time.Time
+}
+
+// RoomSettingsStore keeps the settings of each room
+type RoomSettingsStore struct {
+	mu    sync.RWMutex
+	rooms map[string]*roomSettings
+}
+
+func NewRoomSettingsStore() *RoomSettingsStore {
+	return &RoomSettingsStore{rooms: map[string]*roomSettings{}}
+}
+
+// room is called with the write lock held
+func (s *RoomSettingsStore) room(roomId string) *roomSettings {
+	room, exists := s.rooms[roomId]
+	if !exists {
+		room = &roomSettings{nicks: map[string]string{}, muted: map[string]time.Time{}, kicked: map[string]time.Time{}}
+		s.rooms[roomId] = room
+	}
+	return room
+}
+
+func (s *RoomSettingsStore) Topic(roomId string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if room, exists := s.rooms[roomId]; exists {
+		return room.topic
+	}
+	return ""
+}
+
+func (s *RoomSettingsStore) SetTopic(roomId string, topic string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.room(roomId).topic = topic
+}
+
+// Nick is the name the user goes by in the room, empty when they didn't pick one
+func (s *RoomSettingsStore) Nick(roomId string, userId string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if room, exists := s.rooms[roomId]; exists {
+		return room.nicks[userId]
+	}
+	return ""
+}
+
+// Nicks returns the room's nicks keyed by user id
+func (s *RoomSettingsStore) Nicks(roomId string) map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if room, exists := s.rooms[roomId]; exists {
+		return maps.Clone(room.nicks)
+	}
+	return map[string]string{}
+}
+
+// SetNick gives the user a nick in the room, an empty one takes it back
+func (s *RoomSettingsStore) SetNick(roomId string, userId string, nick string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if nick == "" {
+		delete(s.room(roomId).nicks, userId)
+		return
+	}
+	s.room(roomId).nicks[userId] = nick
+}
+
+// MutedUntil tells until when the user is muted in the room, the zero time once they aren't
+func (s *RoomSettingsStore) MutedUntil(roomId string, userId string, now time.Time) time.Time {
+	return s.until(roomId, now, func(room *roomSettings) map[string]time.Time { return room.muted }, userId)
+}
+
+// Mute keeps the user from talking in the room until then, the zero time unmutes them
+func (s *RoomSettingsStore) Mute(roomId string, userId string, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.room(roomId).muted[userId] = until
+}
+
+// KickedUntil tells until when the user can't join the room, the zero time once they can
+func (s *RoomSettingsStore) KickedUntil(roomId string, userId string, now time.Time) time.Time {
+	return s.until(roomId, now, func(room *roomSettings) map[string]time.Time { return room.kicked }, userId)
+}
+
+func (s *RoomSettingsStore) Kick(roomId string, userId string, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.room(roomId).kicked[userId] = until
+}
+
+func (s *RoomSettingsStore) until(roomId string, now time.Time, times func(*roomSettings) map[string]time.Time, userId string) time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	room, exists := s.rooms[roomId]
+	if !exists {
+		return time.Time{}
+	}
+	if until := times(room)[userId]; until.After(now) {
+		return until
+	}
+	return time.Time{}
+}
+
+// Forget drops the room's settings once it's deleted
+func (s *RoomSettingsStore) Forget(roomId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rooms, roomId)
+}
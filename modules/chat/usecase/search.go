@@ -0,0 +1,102 @@
+package usecase
+
+import (
+	"context"
+	"slices"
+
+	"github.com/bozoteam/roshan/modules/chat/models"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	"github.com/bozoteam/roshan/roshan_errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrEmptySearch   = status.Error(codes.InvalidArgument, "search for at least one word")
+	ErrInvalidCursor = status.Error(codes.InvalidArgument, "invalid cursor")
+)
+
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 50
+)
+
+// SearchQuery is what SearchMessages looks for. Empty fields don't filter.
+type SearchQuery struct {
+	Text     string
+	RoomId   string
+	AuthorId string
+	// Since and Until bound the message timestamps, in unix nanoseconds
+	Since int64
+	Until int64
+	// Cursor is the opaque NextCursor of the previous page, empty for the first one
+	Cursor   string
+	PageSize int
+}
+
+// SearchMessages finds the messages containing every word of the query, newest first, with
+// a snippet around what matched. Users search the rooms they're in, moderators every room.
+// The cursor returned is empty on the last page.
+func (u *ChatUsecase) SearchMessages(ctx context.Context, query *SearchQuery) ([]*models.SearchResult, string, error) {
+	user := ctx.Value("user").(*userModel.User)
+
+	terms := models.ParseSearchTerms(query.Text)
+	if len(terms) == 0 {
+		return nil, "", ErrEmptySearch
+	}
+
+	before, err := models.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, "", ErrInvalidCursor
+	}
+
+	pageSize := query.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultSearchPageSize
+	}
+	pageSize = min(pageSize, maxSearchPageSize)
+
+	search := &models.MessageSearch{
+		Terms:    terms,
+		AllRooms: user.IsModerator(),
+		AuthorId: query.AuthorId,
+		Since:    query.Since,
+		Until:    query.Until,
+		Before:   before,
+		// one more than asked tells whether there is a next page
+		Limit: pageSize + 1,
+	}
+	if !search.AllRooms {
+		search.RoomIds = u.presence.Rooms(user.Id)
+	}
+	if query.RoomId != "" {
+		if !search.AllRooms && !slices.Contains(search.RoomIds, query.RoomId) {
+			return nil, "", ErrUserNotFoundInRoom
+		}
+		search.RoomIds, search.AllRooms = []string{query.RoomId}, false
+	}
+	if !search.AllRooms && len(search.RoomIds) == 0 {
+		return []*models.SearchResult{}, "", nil
+	}
+
+	messages, err := u.messageRepository.SearchMessages(ctx, search)
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to search messages", "error", err, "user_id", user.Id)
+		return nil, "", roshan_errors.ErrInternalServerError
+	}
+
+	nextCursor := ""
+	if len(messages) > pageSize {
+		messages = messages[:pageSize]
+		nextCursor = models.EncodeCursor(messages[pageSize-1].Id)
+	}
+
+	results := make([]*models.SearchResult, len(messages))
+	for i, message := range messages {
+		results[i] = &models.SearchResult{
+			Message: message,
+			Snippet: models.BuildSnippet(message.Content, terms),
+		}
+	}
+	return results, nextCursor, nil
+}
@@ -0,0 +1,168 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/bozoteam/roshan/modules/chat/models"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	ws_hub "github.com/bozoteam/roshan/modules/websocket/hub"
+	"github.com/bozoteam/roshan/roshan_errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrParentInOtherRoom = status.Error(codes.InvalidArgument, "a reply must be in the same room as its parent")
+	ErrTooManyMentions   = status.Errorf(codes.InvalidArgument, "at most %d mentions can be marked read at once", maxMarkedMentions)
+)
+
+const (
+	// maxListedMentions is how many unread mentions ListMentions returns
+	maxListedMentions = 50
+	maxMarkedMentions = 100
+)
+
+// threadOf returns the thread a reply to parentId goes in. Threads are one level deep,
+// replying to a reply answers its thread.
+func (u *ChatUsecase) threadOf(ctx context.Context, parentId string, roomId string) (string, error) {
+	parent, err := u.findMessage(ctx, parentId)
+	if err != nil {
+		return "", err
+	}
+	if parent.RoomID != roomId {
+		return "", ErrParentInOtherRoom
+	}
+	if parent.IsDeleted() {
+		return "", ErrMessageDeleted
+	}
+	return parent.ThreadId(), nil
+}
+
+// ListThread returns the message starting messageId's thread and its replies, oldest first.
+// Only people in the message's room and moderators can read it.
+func (u *ChatUsecase) ListThread(ctx context.Context, messageId string) (*models.Message, []*models.Message, error) {
+	user := ctx.Value("user").(*userModel.User)
+
+	message, err := u.findMessage(ctx, messageId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.IsModerator() {
+		room := u.hub.GetRoom(message.RoomID)
+		if room == nil || !room.HasUser(user.Id) {
+			return nil, nil, ErrUserNotFoundInRoom
+		}
+	}
+	parent := message
+	if message.ParentId != nil {
+		if parent, err = u.findMessage(ctx, *message.ParentId); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	replies, err := u.messageRepository.ListThread(ctx, parent.Id)
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to list thread", "error", err, "message_id", parent.Id)
+		return nil, nil, roshan_errors.ErrInternalServerError
+	}
+	return parent, replies, nil
+}
+
+// resolveMentions maps the names @mentioned in content to the ids of the users in the room,
+// or who posted in it, going by those names. The author mentioning themselves is ignored.
+func (u *ChatUsecase) resolveMentions(ctx context.Context, room *ws_hub.RoomSnapshot, author *userModel.User, content string) ([]string, error) {
+	names := models.ParseMentions(content)
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	candidates := make([]*userModel.User, 0, len(room.Members))
+	for i := range room.Members {
+		candidates = append(candidates, &room.Members[i].User)
+	}
+	authors, err := u.messageRepository.ListRoomAuthors(ctx, room.Id)
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to list room authors", "error", err, "room_id", room.Id)
+		return nil, roshan_errors.ErrInternalServerError
+	}
+	candidates = append(candidates, authors...)
+
+	var mentions []string
+	seen := map[string]bool{author.Id: true}
+	for _, name := range names {
+		for _, candidate := range candidates {
+			if !seen[candidate.Id] && strings.EqualFold(candidate.Name, name) {
+				seen[candidate.Id] = true
+				mentions = append(mentions, candidate.Id)
+			}
+		}
+	}
+	return mentions, nil
+}
+
+// notifyMentions sends a MentionEvent about message to every socket the users have open.
+// Users who aren't connected find the mention through ListMentions.
+func (u *ChatUsecase) notifyMentions(ctx context.Context, message *models.Message, userIds []string) {
+	for _, userId := range userIds {
+		unread, err := u.messageRepository.CountUnreadMentions(ctx, userId)
+		if err != nil {
+			u.logger.ErrorContext(ctx, "failed to count unread mentions", "error", err, "user_id", userId)
+			continue
+		}
+
+		data, err := json.Marshal(&models.MentionEvent{
+			Type:           models.EventMention,
+			Message:        message,
+			UnreadMentions: unread,
+			Timestamp:      time.Now().UnixNano(),
+		})
+		if err != nil {
+			panic(err)
+		}
+		for _, roomId := range u.presence.Rooms(userId) {
+			u.hub.SendBytes(roomId, userId, data)
+		}
+	}
+}
+
+// ListMentions returns the latest messages mentioning the user that they haven't read, newest
+// first, and how many unread mentions they have in total
+func (u *ChatUsecase) ListMentions(ctx context.Context) ([]*models.Message, int64, error) {
+	user := ctx.Value("user").(*userModel.User)
+
+	messages, err := u.messageRepository.ListUnreadMentions(ctx, user.Id, maxListedMentions)
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to list mentions", "error", err, "user_id", user.Id)
+		return nil, 0, roshan_errors.ErrInternalServerError
+	}
+	unread, err := u.messageRepository.CountUnreadMentions(ctx, user.Id)
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to count unread mentions", "error", err, "user_id", user.Id)
+		return nil, 0, roshan_errors.ErrInternalServerError
+	}
+	return messages, unread, nil
+}
+
+// MarkMentionsRead marks the user's mentions in messageIds read, all of them when it's empty,
+// and returns how many are left unread
+func (u *ChatUsecase) MarkMentionsRead(ctx context.Context, messageIds []string) (int64, error) {
+	user := ctx.Value("user").(*userModel.User)
+
+	if len(messageIds) > maxMarkedMentions {
+		return 0, ErrTooManyMentions
+	}
+
+	if err := u.messageRepository.MarkMentionsRead(ctx, user.Id, messageIds, time.Now()); err != nil {
+		u.logger.ErrorContext(ctx, "failed to mark mentions read", "error", err, "user_id", user.Id)
+		return 0, roshan_errors.ErrInternalServerError
+	}
+	unread, err := u.messageRepository.CountUnreadMentions(ctx, user.Id)
+	if err != nil {
+		u.logger.ErrorContext(ctx, "failed to count unread mentions", "error", err, "user_id", user.Id)
+		return 0, roshan_errors.ErrInternalServerError
+	}
+	return unread, nil
+}
@@ -0,0 +1,86 @@
+package usecase
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+// maxThumbnailPixels keeps huge or forged dimensions from being decoded into memory
+const maxThumbnailPixels = 25_000_000
+
+// makeThumbnail returns the image's dimensions and a JPEG fitting in a size x size square.
+// Formats the standard library can't decode fail, images too big to decode safely come
+// back without a thumbnail.
+func makeThumbnail(r io.ReadSeeker, size int) (thumbnail []byte, width int, height int, err error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if config.Width*config.Height > maxThumbnailPixels {
+		return nil, config.Width, config.Height, nil
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, 0, err
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(img, size), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, 0, 0, err
+	}
+	return buf.Bytes(), config.Width, config.Height, nil
+}
+
+// scaleDown fits img in a size x size square, averaging the pixels each thumbnail pixel
+// covers. Transparent areas are laid on white since JPEG has no alpha.
+func scaleDown(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	width, height := srcWidth, srcHeight
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, srcHeight*size/srcWidth)
+		} else {
+			width, height = max(1, srcWidth*size/srcHeight), size
+		}
+	}
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		y0 := bounds.Min.Y + y*srcHeight/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*srcHeight/height)
+		for x := range width {
+			x0 := bounds.Min.X + x*srcWidth/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*srcWidth/width)
+
+			var r, g, b, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					// RGBA is premultiplied, adding the missing alpha blends in white
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r += uint64(pr + 0xffff - pa)
+					g += uint64(pg + 0xffff - pa)
+					b += uint64(pb + 0xffff - pa)
+					n++
+				}
+			}
+			thumbnail.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return thumbnail
+}
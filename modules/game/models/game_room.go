@@ -1,9 +1,9 @@
 package models
 
 import (
-	"github.com/bozoteam/roshan/modules/chat/models"
+	ws_hub "github.com/bozoteam/roshan/modules/websocket/hub"
 )
 
-func NewGameRoom(name string, creatorId string, game string) *models.Room {
-	return models.NewRoom(name, creatorId, []string{"team1", "team2", "all", "watcher"}, game)
+func NewGameRoom(name string, creatorId string, game string) *ws_hub.Room {
+	return ws_hub.NewRoom(name, creatorId, []string{"team1", "team2", "all", "watcher"}, game)
 }
@@ -7,28 +7,30 @@ import (
 	"time"
 
 	"github.com/bozoteam/roshan/adapter/log"
-	"github.com/bozoteam/roshan/modules/chat/models"
 	gameModel "github.com/bozoteam/roshan/modules/game/models"
 	userModel "github.com/bozoteam/roshan/modules/user/models"
 	ws_hub "github.com/bozoteam/roshan/modules/websocket/hub"
 	"github.com/bozoteam/roshan/modules/websocket/ws_client"
+	"github.com/bozoteam/roshan/modules/websocket/ws_upgrader"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
 type GameUsecase struct {
-	hub    *ws_hub.Hub
-	logger *slog.Logger
+	hub      *ws_hub.Hub
+	logger   *slog.Logger
+	upgrader *ws_upgrader.Upgrader
 }
 
 type GameRoomResponse struct {
-	*models.Room
+	*ws_hub.RoomSnapshot
 }
 
-func NewGameUsecase() *GameUsecase {
+func NewGameUsecase(upgrader *ws_upgrader.Upgrader) *GameUsecase {
 	return &GameUsecase{
-		hub:    ws_hub.NewHub(),
-		logger: log.LogWithModule("game_usecase"),
+		hub:      ws_hub.NewHub("game"),
+		logger:   log.LogWithModule("game_usecase"),
+		upgrader: upgrader,
 	}
 }
 
@@ -41,7 +43,7 @@ func (u *GameUsecase) CreateRoom(ctx context.Context, name string, game string)
 	u.hub.CreateRoom(room)
 
 	return &GameRoomResponse{
-		Room: room,
+		RoomSnapshot: room.Snapshot(),
 	}, nil
 }
 
@@ -56,34 +58,30 @@ func (u *GameUsecase) JoinRoom(ctx *gin.Context, roomID string, team string) {
 
 	// TODO: validate team
 
-	// Allow all origins for the WebSocket upgrade
-	upgrader := websocket.Upgrader{
-		HandshakeTimeout:  time.Second * 5,
-		ReadBufferSize:    1024,
-		WriteBufferSize:   1024,
-		CheckOrigin:       func(r *http.Request) bool { return true },
-		EnableCompression: false,
-	}
-
-	conn, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	conn, release, err := u.upgrader.Upgrade(ctx, user.Id)
 	if err != nil {
-		u.logger.Error("Failed to upgrade connection", "error", err)
+		u.logger.ErrorContext(ctx.Request.Context(), "Failed to upgrade connection", "error", err, "user_id", user.Id)
 		return
 	}
+	defer release()
 
 	// Create client
-	client := ws_client.NewClient(conn, user, roomID)
+	client := ws_client.NewClient(ctx.Request.Context(), conn, user, roomID, u.upgrader.Timings(), nil)
 
-	// Register client to room
-	u.hub.Register(client, roomID, team)
+	// Register client to room, it may have closed since we looked it up
+	if !u.hub.Register(client, roomID, team) {
+		client.Close(websocket.CloseNormalClosure, "room closed")
+		client.WaitUnregister()
+		return
+	}
 
-	u.logger.Info("User connected to room", "user_id", user.Id, "room_id", roomID)
+	u.logger.InfoContext(ctx.Request.Context(), "User connected to room", "user_id", user.Id, "room_id", roomID)
 
 	// Handle unregistration when the client disconnects
 	// This runs in the same goroutine as HandleWebSocket
 	client.WaitUnregister()
 	u.hub.Unregister(client, roomID)
-	u.logger.Info("User disconnected from room", "user_id", user.Id, "room_id", roomID)
+	u.logger.InfoContext(ctx.Request.Context(), "User disconnected from room", "user_id", user.Id, "room_id", roomID)
 }
 
 func (u *GameUsecase) ListRooms(ctx context.Context) ([]*GameRoomResponse, error) {
@@ -92,10 +90,24 @@ func (u *GameUsecase) ListRooms(ctx context.Context) ([]*GameRoomResponse, error
 	responseRooms := make([]*GameRoomResponse, 0, len(rooms))
 	for _, room := range rooms {
 		responseRooms = append(responseRooms, &GameRoomResponse{
-			Room: room.(*models.Room),
-		},
-		)
+			RoomSnapshot: room,
+		})
 	}
 
 	return responseRooms, nil
 }
+
+// HasRoom tells whether the game room is open
+func (u *GameUsecase) HasRoom(roomId string) bool {
+	return u.hub.HasRoom(roomId)
+}
+
+// HealthCheck reports whether the hub still answers
+func (u *GameUsecase) HealthCheck(ctx context.Context) error {
+	return u.hub.Ping(ctx)
+}
+
+// Shutdown disconnects everyone in the hub, asking them to reconnect after reconnectDelay
+func (u *GameUsecase) Shutdown(reconnectDelay time.Duration) {
+	u.hub.Shutdown(reconnectDelay)
+}
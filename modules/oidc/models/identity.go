@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// UserIdentity links an account at an external OIDC provider to a roshan user
+type UserIdentity struct {
+	Id        string `gorm:"primaryKey"`
+	UserId    string `gorm:"not null"`
+	Provider  string `gorm:"type:varchar(64);not null"`
+	Subject   string `gorm:"type:varchar(255);not null"`
+	Email     string `gorm:"type:varchar(255)"`
+	CreatedAt time.Time
+}
+
+func (UserIdentity) TableName() string {
+	return "user_identity"
+}
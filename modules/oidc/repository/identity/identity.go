@@ -0,0 +1,55 @@
+package identityRepository
+
+import (
+	"context"
+	"log/slog"
+
+	log "github.com/bozoteam/roshan/adapter/log"
+	"github.com/bozoteam/roshan/modules/oidc/models"
+	"gorm.io/gorm"
+)
+
+// IdentityRepository stores the external identities linked to users. A provider and
+// subject pair can only be linked once.
+type IdentityRepository interface {
+	FindIdentity(ctx context.Context, provider string, subject string) (*models.UserIdentity, error)
+	ListIdentitiesByUser(ctx context.Context, userId string) ([]*models.UserIdentity, error)
+	SaveIdentity(ctx context.Context, identity *models.UserIdentity) error
+	DeleteIdentity(ctx context.Context, identity *models.UserIdentity) error
+}
+
+var _ IdentityRepository = (*GormIdentityRepository)(nil)
+
+func NewIdentityRepository(db *gorm.DB) *GormIdentityRepository {
+	return &GormIdentityRepository{db: db, logger: log.LogWithModule("identity_repository")}
+}
+
+// GormIdentityRepository is the IdentityRepository backed by Postgres
+type GormIdentityRepository struct {
+	logger *slog.Logger
+	db     *gorm.DB
+}
+
+func (r *GormIdentityRepository) FindIdentity(ctx context.Context, provider string, subject string) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	if err := r.db.WithContext(ctx).Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *GormIdentityRepository) ListIdentitiesByUser(ctx context.Context, userId string) ([]*models.UserIdentity, error) {
+	var identities []*models.UserIdentity
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userId).Order("created_at").Find(&identities).Error; err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (r *GormIdentityRepository) SaveIdentity(ctx context.Context, identity *models.UserIdentity) error {
+	return r.db.WithContext(ctx).Create(identity).Error
+}
+
+func (r *GormIdentityRepository) DeleteIdentity(ctx context.Context, identity *models.UserIdentity) error {
+	return r.db.WithContext(ctx).Delete(identity).Error
+}
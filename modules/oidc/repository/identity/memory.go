@@ -0,0 +1,76 @@
+package identityRepository
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bozoteam/roshan/helpers"
+	"github.com/bozoteam/roshan/modules/oidc/models"
+	"gorm.io/gorm"
+)
+
+var _ IdentityRepository = (*MemoryIdentityRepository)(nil)
+
+// MemoryIdentityRepository is a thread-safe IdentityRepository kept in memory
+type MemoryIdentityRepository struct {
+	mu         sync.RWMutex
+	identities map[string]models.UserIdentity
+}
+
+func NewMemoryIdentityRepository() *MemoryIdentityRepository {
+	return &MemoryIdentityRepository{identities: map[string]models.UserIdentity{}}
+}
+
+func (r *MemoryIdentityRepository) FindIdentity(ctx context.Context, provider string, subject string) (*models.UserIdentity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *MemoryIdentityRepository) ListIdentitiesByUser(ctx context.Context, userId string) ([]*models.UserIdentity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	identities := []*models.UserIdentity{}
+	for _, identity := range r.identities {
+		if identity.UserId == userId {
+			identities = append(identities, &identity)
+		}
+	}
+	slices.SortFunc(identities, func(a, b *models.UserIdentity) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.Id, b.Id))
+	})
+	return identities, nil
+}
+
+func (r *MemoryIdentityRepository) SaveIdentity(ctx context.Context, identity *models.UserIdentity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, other := range r.identities {
+		if other.Provider == identity.Provider && other.Subject == identity.Subject {
+			return helpers.NewUniqueViolation("unique_provider_subject")
+		}
+	}
+	if identity.CreatedAt.IsZero() {
+		identity.CreatedAt = time.Now()
+	}
+	r.identities[identity.Id] = *identity
+	return nil
+}
+
+func (r *MemoryIdentityRepository) DeleteIdentity(ctx context.Context, identity *models.UserIdentity) error {
+	r.mu.Lock()
+	delete(r.identities, identity.Id)
+	r.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,194 @@
+package providerRepository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	log "github.com/bozoteam/roshan/adapter/log"
+	"github.com/bozoteam/roshan/helpers"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	flowTTL        = 10 * time.Minute
+	requestTimeout = 10 * time.Second
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown oidc provider")
+	ErrNonceMismatch   = errors.New("id token nonce mismatch")
+	ErrMissingIdToken  = errors.New("token response has no id_token")
+)
+
+// Claims are the ID token claims roshan cares about
+type Claims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// Flow is the server side state of an authorization-code flow, keyed by its state parameter
+type Flow struct {
+	Provider string
+	Nonce    string
+	Verifier string
+	// Set when an authenticated user is linking a new identity instead of logging in
+	LinkUserId string
+	ExpiresAt  time.Time
+}
+
+type provider struct {
+	config config.OIDCProviderConfig
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+type ProviderRepository struct {
+	logger     *slog.Logger
+	httpClient *http.Client
+	providers  map[string]*provider
+
+	mu    sync.Mutex
+	flows map[string]*Flow
+}
+
+func NewProviderRepository(configs []config.OIDCProviderConfig) *ProviderRepository {
+	providers := make(map[string]*provider, len(configs))
+	for _, c := range configs {
+		providers[c.Name] = &provider{config: c}
+	}
+
+	return &ProviderRepository{
+		logger:     log.LogWithModule("oidc_provider_repository"),
+		httpClient: &http.Client{Timeout: requestTimeout},
+		providers:  providers,
+		flows:      make(map[string]*Flow),
+	}
+}
+
+// discover fetches the discovery document on first use, so roshan can start before its providers
+func (r *ProviderRepository) discover(ctx context.Context, name string) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p, ok := r.providers[name]
+	if !ok {
+		return nil, nil, ErrUnknownProvider
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth != nil {
+		return p.oauth, p.verifier, nil
+	}
+
+	discovered, err := oidc.NewProvider(oidc.ClientContext(ctx, r.httpClient), p.config.IssuerURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("discovering %s: %w", name, err)
+	}
+
+	p.oauth = &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Endpoint:     discovered.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}
+	p.verifier = discovered.Verifier(&oidc.Config{ClientID: p.config.ClientID})
+	return p.oauth, p.verifier, nil
+}
+
+func (r *ProviderRepository) HasProvider(name string) bool {
+	_, ok := r.providers[name]
+	return ok
+}
+
+// StartFlow stores a new flow and returns its state along with the provider URL to redirect to
+func (r *ProviderRepository) StartFlow(ctx context.Context, name string, linkUserId string) (string, string, error) {
+	oauth, _, err := r.discover(ctx, name)
+	if err != nil {
+		return "", "", err
+	}
+
+	state := helpers.GenSecureToken(32)
+	flow := &Flow{
+		Provider:   name,
+		Nonce:      helpers.GenSecureToken(32),
+		Verifier:   oauth2.GenerateVerifier(),
+		LinkUserId: linkUserId,
+		ExpiresAt:  time.Now().Add(flowTTL),
+	}
+
+	r.mu.Lock()
+	now := time.Now()
+	for s, f := range r.flows {
+		if now.After(f.ExpiresAt) {
+			delete(r.flows, s)
+		}
+	}
+	r.flows[state] = flow
+	r.mu.Unlock()
+
+	url := oauth.AuthCodeURL(state, oidc.Nonce(flow.Nonce), oauth2.S256ChallengeOption(flow.Verifier))
+	return state, url, nil
+}
+
+// TakeFlow returns the flow of a state and forgets it, so a state can only be used once
+func (r *ProviderRepository) TakeFlow(state string) (*Flow, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	flow, ok := r.flows[state]
+	if !ok {
+		return nil, false
+	}
+	delete(r.flows, state)
+
+	if time.Now().After(flow.ExpiresAt) {
+		return nil, false
+	}
+	return flow, true
+}
+
+// Exchange redeems an authorization code and returns the claims of the verified ID token
+func (r *ProviderRepository) Exchange(ctx context.Context, flow *Flow, code string) (*Claims, error) {
+	oauth, verifier, err := r.discover(ctx, flow.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = oidc.ClientContext(ctx, r.httpClient)
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(flow.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchanging code: %w", err)
+	}
+
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, ErrMissingIdToken
+	}
+
+	idToken, err := verifier.Verify(ctx, rawIdToken)
+	if err != nil {
+		return nil, fmt.Errorf("verifying id token: %w", err)
+	}
+
+	if idToken.Nonce != flow.Nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims Claims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("parsing id token claims: %w", err)
+	}
+	return &claims, nil
+}
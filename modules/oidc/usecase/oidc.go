@@ -0,0 +1,336 @@
+package usecase
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+
+	log "github.com/bozoteam/roshan/adapter/log"
+	"github.com/bozoteam/roshan/helpers"
+	authUsecase "github.com/bozoteam/roshan/modules/auth/usecase"
+	"github.com/bozoteam/roshan/modules/oidc/models"
+	identityRepository "github.com/bozoteam/roshan/modules/oidc/repository/identity"
+	providerRepository "github.com/bozoteam/roshan/modules/oidc/repository/provider"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	userRepository "github.com/bozoteam/roshan/modules/user/repository"
+	"github.com/bozoteam/roshan/roshan_errors"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcStateCookie     = "oidc_state"
+	oidcStateCookiePath = "/api/v1/auth/oidc"
+	oidcStateMaxAge     = 600
+	maxDisplayNameLen   = 64
+)
+
+// oidcError is reported to the frontend as the oidc_error query parameter
+type oidcError string
+
+func (e oidcError) Error() string {
+	return string(e)
+}
+
+const (
+	errOIDCProvider        oidcError = "provider_error"
+	errOIDCInvalidState    oidcError = "invalid_state"
+	errOIDCExchange        oidcError = "exchange_failed"
+	errOIDCEmailRequired   oidcError = "email_required"
+	errOIDCEmailUnverified oidcError = "email_unverified"
+	errOIDCEmailInUse      oidcError = "email_in_use"
+	errOIDCIdentityInUse   oidcError = "identity_in_use"
+	errOIDCInvalidProfile  oidcError = "invalid_profile"
+	errOIDCInternal        oidcError = "internal_error"
+)
+
+var (
+	ErrIdentityNotFound = status.Error(codes.NotFound, "identity not found")
+	ErrLastLoginMethod  = status.Error(codes.FailedPrecondition, "set a password before unlinking your last identity")
+)
+
+// OIDCUsecase signs users in through external OpenID Connect providers
+type OIDCUsecase struct {
+	logger       *slog.Logger
+	authUsecase  *authUsecase.AuthUsecase
+	userRepo     userRepository.UserRepository
+	identityRepo identityRepository.IdentityRepository
+	providerRepo *providerRepository.ProviderRepository
+	frontendURL  string
+}
+
+func NewOIDCUsecase(
+	authUsecase *authUsecase.AuthUsecase,
+	userRepository userRepository.UserRepository,
+	identityRepository identityRepository.IdentityRepository,
+	providerRepository *providerRepository.ProviderRepository,
+	frontendURL string,
+) *OIDCUsecase {
+	return &OIDCUsecase{
+		logger:       log.LogWithModule("oidc_usecase"),
+		authUsecase:  authUsecase,
+		userRepo:     userRepository,
+		identityRepo: identityRepository,
+		providerRepo: providerRepository,
+		frontendURL:  frontendURL,
+	}
+}
+
+// Login redirects the browser to the provider to sign in or sign up
+func (u *OIDCUsecase) Login(ctx *gin.Context) {
+	u.start(ctx, "")
+}
+
+// Link redirects an authenticated user to the provider to attach a new identity to their account
+func (u *OIDCUsecase) Link(ctx *gin.Context) {
+	user := ctx.MustGet("user").(*userModel.User)
+	u.start(ctx, user.Id)
+}
+
+func (u *OIDCUsecase) start(ctx *gin.Context, linkUserId string) {
+	provider := ctx.Param("provider")
+	if !u.providerRepo.HasProvider(provider) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+
+	state, redirectURL, err := u.providerRepo.StartFlow(ctx.Request.Context(), provider, linkUserId)
+	if err != nil {
+		u.logger.ErrorContext(ctx.Request.Context(), "failed to start oidc flow", "error", err, "provider", provider)
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider unavailable"})
+		return
+	}
+
+	// The state is bound to the browser that started the flow, this stops login CSRF.
+	// SameSite=Lax because the callback is a cross-site navigation coming from the provider.
+	ctx.Header("Set-Cookie", fmt.Sprintf("%s=%s; HttpOnly; SameSite=Lax; Path=%s; Max-Age=%d",
+		oidcStateCookie, state, oidcStateCookiePath, oidcStateMaxAge))
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Redirect(http.StatusFound, redirectURL)
+}
+
+// Callback finishes the flow: it logs the user in, creating an account on first sign in, or links the identity
+func (u *OIDCUsecase) Callback(ctx *gin.Context) {
+	ctx.Header("Set-Cookie", fmt.Sprintf("%s=deleted; HttpOnly; SameSite=Lax; Path=%s; Max-Age=0",
+		oidcStateCookie, oidcStateCookiePath))
+	ctx.Header("Cache-Control", "no-store")
+
+	if providerErr := ctx.Query("error"); providerErr != "" {
+		u.logger.WarnContext(ctx.Request.Context(), "oidc provider returned an error", "error", providerErr, "provider", ctx.Param("provider"))
+		u.redirect(ctx, "oidc_error", string(errOIDCProvider))
+		return
+	}
+
+	state := ctx.Query("state")
+	cookie, err := ctx.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(state)) != 1 {
+		u.redirect(ctx, "oidc_error", string(errOIDCInvalidState))
+		return
+	}
+
+	flow, ok := u.providerRepo.TakeFlow(state)
+	if !ok || flow.Provider != ctx.Param("provider") {
+		u.redirect(ctx, "oidc_error", string(errOIDCInvalidState))
+		return
+	}
+
+	claims, err := u.providerRepo.Exchange(ctx.Request.Context(), flow, ctx.Query("code"))
+	if err != nil {
+		u.logger.ErrorContext(ctx.Request.Context(), "failed to exchange oidc code", "error", err, "provider", flow.Provider)
+		u.redirect(ctx, "oidc_error", string(errOIDCExchange))
+		return
+	}
+
+	if flow.LinkUserId != "" {
+		if err := u.link(ctx.Request.Context(), flow.Provider, flow.LinkUserId, claims); err != nil {
+			u.redirect(ctx, "oidc_error", err.Error())
+			return
+		}
+		u.redirect(ctx, "oidc", "linked")
+		return
+	}
+
+	user, err := u.resolveUser(ctx.Request.Context(), flow.Provider, claims)
+	if err != nil {
+		u.redirect(ctx, "oidc_error", err.Error())
+		return
+	}
+
+	token, err := u.authUsecase.IssueTokens(ctx.Request.Context(), user)
+	if err != nil {
+		u.redirect(ctx, "oidc_error", string(errOIDCInternal))
+		return
+	}
+
+	for _, cookie := range authUsecase.AuthCookies(token) {
+		ctx.Writer.Header().Add("Set-Cookie", cookie)
+	}
+	u.redirect(ctx, "oidc", "login")
+}
+
+func (u *OIDCUsecase) redirect(ctx *gin.Context, key string, value string) {
+	target, err := url.Parse(u.frontendURL)
+	if err != nil {
+		target = &url.URL{Path: "/"}
+	}
+	query := target.Query()
+	query.Set(key, value)
+	target.RawQuery = query.Encode()
+	ctx.Redirect(http.StatusFound, target.String())
+}
+
+func (u *OIDCUsecase) link(ctx context.Context, provider string, userId string, claims *providerRepository.Claims) error {
+	existing, err := u.identityRepo.FindIdentity(ctx, provider, claims.Subject)
+	if err == nil {
+		if existing.UserId == userId {
+			return nil
+		}
+		return errOIDCIdentityInUse
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return errOIDCInternal
+	}
+
+	identity := &models.UserIdentity{
+		Id:       helpers.GenUUID(),
+		UserId:   userId,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	if err := u.identityRepo.SaveIdentity(ctx, identity); err != nil {
+		if helpers.IsErrorCode(err, helpers.UniqueViolation) {
+			return errOIDCIdentityInUse
+		}
+		return errOIDCInternal
+	}
+
+	u.logger.InfoContext(ctx, "identity linked", "user_id", userId, "provider", provider)
+	return nil
+}
+
+// resolveUser returns the user behind an identity, signing them up on first use.
+// An identity is never linked to an existing account by email alone: the owner
+// of that account has to sign in and link it themselves.
+func (u *OIDCUsecase) resolveUser(ctx context.Context, provider string, claims *providerRepository.Claims) (*userModel.User, error) {
+	identity, err := u.identityRepo.FindIdentity(ctx, provider, claims.Subject)
+	if err == nil {
+		user, err := u.userRepo.FindUserById(ctx, identity.UserId)
+		if err != nil {
+			return nil, errOIDCInternal
+		}
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errOIDCInternal
+	}
+
+	if claims.Email == "" {
+		return nil, errOIDCEmailRequired
+	}
+	// otherwise anyone could claim an address, and its owner couldn't sign up with it anymore
+	if !claims.EmailVerified {
+		return nil, errOIDCEmailUnverified
+	}
+	if _, err := u.userRepo.FindUserByEmail(ctx, claims.Email); err == nil {
+		return nil, errOIDCEmailInUse
+	}
+
+	user := &userModel.User{
+		Id:    helpers.GenUUID(),
+		Email: claims.Email,
+		Name:  displayName(claims),
+		Role:  userModel.RoleUser,
+	}
+	if err := userModel.ValidateUser(user); err != nil {
+		return nil, errOIDCInvalidProfile
+	}
+
+	if err := u.userRepo.SaveUser(ctx, user); err != nil {
+		if helpers.IsErrorCode(err, helpers.UniqueViolation) {
+			return nil, errOIDCEmailInUse
+		}
+		return nil, errOIDCInternal
+	}
+
+	if err := u.link(ctx, provider, user.Id, claims); err != nil {
+		if err := u.userRepo.DeleteUser(ctx, user); err != nil {
+			u.logger.ErrorContext(ctx, "failed to roll back oidc sign up", "error", err, "user_id", user.Id)
+		}
+		return nil, err
+	}
+
+	u.logger.InfoContext(ctx, "user signed up through oidc", "user_id", user.Id, "provider", provider)
+	return user, nil
+}
+
+// displayName derives a valid user name from the profile, names may only hold letters and digits
+func displayName(claims *providerRepository.Claims) string {
+	localPart, _, _ := strings.Cut(claims.Email, "@")
+	for _, candidate := range []string{claims.PreferredUsername, claims.Name, localPart} {
+		name := strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, candidate)
+		if name != "" {
+			runes := []rune(name)
+			return string(runes[:min(len(runes), maxDisplayNameLen)])
+		}
+	}
+	return "user"
+}
+
+func (u *OIDCUsecase) ListIdentities(ctx context.Context) ([]*models.UserIdentity, error) {
+	user := ctx.Value("user").(*userModel.User)
+
+	identities, err := u.identityRepo.ListIdentitiesByUser(ctx, user.Id)
+	if err != nil {
+		return nil, roshan_errors.ErrInternalServerError
+	}
+	return identities, nil
+}
+
+// UnlinkIdentity detaches an identity, as long as the user keeps some way to sign in
+func (u *OIDCUsecase) UnlinkIdentity(ctx context.Context, id string) (*models.UserIdentity, error) {
+	user, err := authUsecase.RequireSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := u.identityRepo.ListIdentitiesByUser(ctx, user.Id)
+	if err != nil {
+		return nil, roshan_errors.ErrInternalServerError
+	}
+
+	var identity *models.UserIdentity
+	for _, i := range identities {
+		if i.Id == id {
+			identity = i
+			break
+		}
+	}
+	if identity == nil {
+		return nil, ErrIdentityNotFound
+	}
+
+	if len(identities) == 1 && !user.HasPassword() {
+		return nil, ErrLastLoginMethod
+	}
+
+	if err := u.identityRepo.DeleteIdentity(ctx, identity); err != nil {
+		return nil, roshan_errors.ErrInternalServerError
+	}
+
+	u.logger.InfoContext(ctx, "identity unlinked", "user_id", user.Id, "provider", identity.Provider)
+	return identity, nil
+}
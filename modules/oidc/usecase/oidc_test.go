@@ -0,0 +1,462 @@
+package usecase
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	"github.com/bozoteam/roshan/helpers"
+	apiKeyRepository "github.com/bozoteam/roshan/modules/auth/repository/apikey"
+	jwtRepository "github.com/bozoteam/roshan/modules/auth/repository/jwt"
+	lockoutRepository "github.com/bozoteam/roshan/modules/auth/repository/lockout"
+	ticketRepository "github.com/bozoteam/roshan/modules/auth/repository/ticket"
+	authUsecase "github.com/bozoteam/roshan/modules/auth/usecase"
+	identityRepository "github.com/bozoteam/roshan/modules/oidc/repository/identity"
+	providerRepository "github.com/bozoteam/roshan/modules/oidc/repository/provider"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	userRepository "github.com/bozoteam/roshan/modules/user/repository"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	mockClientID    = "roshan"
+	mockRedirectURL = "http://roshan.test/api/v1/auth/oidc/mock/callback"
+	frontendURL     = "http://frontend.test/"
+)
+
+// mockGrant is what the mock provider remembers about a code it handed out
+type mockGrant struct {
+	challenge string
+	claims    map[string]any
+}
+
+// mockProvider is an OIDC issuer serving discovery, its keys and a token endpoint. Users
+// "sign in" through authorize, which hands out a code the way the provider's login page would.
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+	// nonce, when set, replaces the one asked for in the ID tokens issued
+	nonce string
+	// unverified makes the provider report emails it hasn't verified
+	unverified bool
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key, grants: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/authorize",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/keys",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", p.token)
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// authorize checks the request roshan redirected the browser with and returns the code the
+// provider sends back, along with the state to send it with
+func (p *mockProvider) authorize(t *testing.T, location string, subject string, email string) (string, string) {
+	t.Helper()
+
+	target, err := url.Parse(location)
+	if err != nil || !strings.HasPrefix(location, p.server.URL+"/authorize?") {
+		t.Fatalf("redirected to %q instead of the provider", location)
+	}
+	query := target.Query()
+	for param, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             mockClientID,
+		"redirect_uri":          mockRedirectURL,
+		"code_challenge_method": "S256",
+	} {
+		if got := query.Get(param); got != want {
+			t.Fatalf("%s is %q, want %q", param, got, want)
+		}
+	}
+	if query.Get("state") == "" || query.Get("nonce") == "" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization request lacks state, nonce or PKCE: %s", location)
+	}
+
+	nonce := query.Get("nonce")
+	p.mu.Lock()
+	if p.nonce != "" {
+		nonce = p.nonce
+	}
+	code := helpers.GenSecureToken(16)
+	p.grants[code] = mockGrant{
+		challenge: query.Get("code_challenge"),
+		claims: map[string]any{
+			"sub":                subject,
+			"email":              email,
+			"email_verified":     !p.unverified,
+			"preferred_username": strings.Split(email, "@")[0],
+			"nonce":              nonce,
+		},
+	}
+	p.mu.Unlock()
+	return code, query.Get("state")
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.mu.Lock()
+	grant, ok := p.grants[r.PostForm.Get("code")]
+	delete(p.grants, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	claims := grant.claims
+	now := time.Now()
+	claims["iss"], claims["aud"] = p.server.URL, mockClientID
+	claims["iat"], claims["exp"] = now.Unix(), now.Add(time.Minute).Unix()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "provider-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     p.sign(claims),
+	})
+}
+
+// sign returns claims as an RS256 JWT
+func (p *mockProvider) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+type oidcFixture struct {
+	usecase    *OIDCUsecase
+	users      *userRepository.MemoryUserRepository
+	identities *identityRepository.MemoryIdentityRepository
+	provider   *mockProvider
+	router     *gin.Engine
+	// linkAs is the session user of link requests
+	linkAs *userModel.User
+}
+
+func newOIDCFixture(t *testing.T) *oidcFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	cfg := config.Default()
+	cfg.JWT.Secret = "test-access-secret-at-least-32-characters"
+	cfg.JWT.RefreshSecret = "test-refresh-secret-at-least-32-characters"
+
+	f := &oidcFixture{
+		users:      userRepository.NewMemoryUserRepository(),
+		identities: identityRepository.NewMemoryIdentityRepository(),
+		provider:   newMockProvider(t),
+	}
+	auth := authUsecase.NewAuthUsecase(
+		f.users,
+		jwtRepository.NewJWTRepository(cfg.JWT),
+		lockoutRepository.NewMemoryLockoutRepository(),
+		apiKeyRepository.NewMemoryApiKeyRepository(),
+		ticketRepository.NewTicketRepository(cfg.Auth.TicketTTL),
+		cfg.Auth.Lockout,
+		nil,
+		nil,
+	)
+	providers := providerRepository.NewProviderRepository([]config.OIDCProviderConfig{{
+		Name:         "mock",
+		IssuerURL:    f.provider.server.URL,
+		ClientID:     mockClientID,
+		ClientSecret: "mock-secret",
+		RedirectURL:  mockRedirectURL,
+	}})
+	f.usecase = NewOIDCUsecase(auth, f.users, f.identities, providers, frontendURL)
+
+	f.router = gin.New()
+	f.router.GET("/api/v1/auth/oidc/:provider/login", f.usecase.Login)
+	f.router.GET("/api/v1/auth/oidc/:provider/callback", f.usecase.Callback)
+	f.router.GET("/api/v1/auth/oidc/:provider/link", func(ctx *gin.Context) {
+		ctx.Set("user", f.linkAs)
+		f.usecase.Link(ctx)
+	})
+	return f
+}
+
+func (f *oidcFixture) get(target string, cookie string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, target, nil)
+	if cookie != "" {
+		request.Header.Set("Cookie", cookie)
+	}
+	recorder := httptest.NewRecorder()
+	f.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// start begins a login, or a link when linkAs is set, and returns the provider URL along
+// with the state cookie set for the browser
+func (f *oidcFixture) start(t *testing.T, linkAs *userModel.User) (string, string) {
+	t.Helper()
+
+	action := "login"
+	if f.linkAs = linkAs; linkAs != nil {
+		action = "link"
+	}
+	recorder := f.get("/api/v1/auth/oidc/mock/"+action, "")
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("%s: %d %s", action, recorder.Code, recorder.Body)
+	}
+	cookie := recorder.Header().Get("Set-Cookie")
+	if !strings.HasPrefix(cookie, oidcStateCookie+"=") || !strings.Contains(cookie, "HttpOnly") {
+		t.Fatalf("unexpected state cookie %q", cookie)
+	}
+	cookie, _, _ = strings.Cut(cookie, ";")
+	return recorder.Header().Get("Location"), cookie
+}
+
+// callback sends the browser back to roshan and returns where the frontend is told the flow went
+func (f *oidcFixture) callback(t *testing.T, code string, state string, cookie string) (url.Values, *httptest.ResponseRecorder) {
+	t.Helper()
+
+	recorder := f.get("/api/v1/auth/oidc/mock/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), cookie)
+	location := recorder.Header().Get("Location")
+	if recorder.Code != http.StatusFound || !strings.HasPrefix(location, frontendURL) {
+		t.Fatalf("callback: %d to %q", recorder.Code, location)
+	}
+	target, _ := url.Parse(location)
+	return target.Query(), recorder
+}
+
+// signIn runs a whole flow for the provider's user, linking it to linkAs when set
+func (f *oidcFixture) signIn(t *testing.T, subject string, email string, linkAs *userModel.User) (url.Values, *httptest.ResponseRecorder) {
+	t.Helper()
+
+	location, cookie := f.start(t, linkAs)
+	code, state := f.provider.authorize(t, location, subject, email)
+	return f.callback(t, code, state, cookie)
+}
+
+func (f *oidcFixture) createUser(t *testing.T, name string, password string) *userModel.User {
+	t.Helper()
+
+	user := &userModel.User{Id: helpers.GenUUID(), Name: name, Email: name + "@example.com", Role: userModel.RoleUser}
+	if password != "" {
+		hash, err := helpers.HashPassword(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user.Password = hash
+	}
+	if err := f.users.SaveUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestLoginSignsUpThenSignsIn(t *testing.T) {
+	f := newOIDCFixture(t)
+	ctx := context.Background()
+
+	result, recorder := f.signIn(t, "sub-1", "ana@example.com", nil)
+	if result.Get("oidc") != "login" {
+		t.Fatalf("first sign in: %v", result)
+	}
+	var cookies []string
+	for _, cookie := range recorder.Header().Values("Set-Cookie") {
+		name, _, _ := strings.Cut(cookie, "=")
+		cookies = append(cookies, name)
+	}
+	if strings.Join(cookies, ",") != oidcStateCookie+",access_token,refresh_token" {
+		t.Fatalf("cookies set %v, want the state cleared and the session tokens", cookies)
+	}
+
+	user, err := f.users.FindUserByEmail(ctx, "ana@example.com")
+	if err != nil || user.Name != "ana" || user.HasPassword() {
+		t.Fatalf("signed up user: %+v, %v", user, err)
+	}
+	identity, err := f.identities.FindIdentity(ctx, "mock", "sub-1")
+	if err != nil || identity.UserId != user.Id {
+		t.Fatalf("identity: %+v, %v", identity, err)
+	}
+
+	// the same identity signs into the same account, whatever email it reports now
+	if result, _ := f.signIn(t, "sub-1", "ana@elsewhere.example", nil); result.Get("oidc") != "login" {
+		t.Fatalf("second sign in: %v", result)
+	}
+	if _, err := f.users.FindUserByEmail(ctx, "ana@elsewhere.example"); err == nil {
+		t.Fatal("signing in again created another account")
+	}
+}
+
+func TestLoginDoesntTakeOverAccountsByEmail(t *testing.T) {
+	f := newOIDCFixture(t)
+	f.createUser(t, "bob", "battery staple")
+
+	if result, _ := f.signIn(t, "sub-2", "bob@example.com", nil); result.Get("oidc_error") != string(errOIDCEmailInUse) {
+		t.Fatalf("got %v, want %s", result, errOIDCEmailInUse)
+	}
+	if _, err := f.identities.FindIdentity(context.Background(), "mock", "sub-2"); err == nil {
+		t.Fatal("the identity was linked to bob")
+	}
+}
+
+func TestSignUpNeedsAVerifiedEmail(t *testing.T) {
+	f := newOIDCFixture(t)
+	ctx := context.Background()
+
+	f.provider.unverified = true
+	if result, _ := f.signIn(t, "sub-6", "fay@example.com", nil); result.Get("oidc_error") != string(errOIDCEmailUnverified) {
+		t.Fatalf("got %v, want %s", result, errOIDCEmailUnverified)
+	}
+	if _, err := f.users.FindUserByEmail(ctx, "fay@example.com"); err == nil {
+		t.Fatal("an account was created for an unverified email")
+	}
+
+	f.provider.unverified = false
+	if result, _ := f.signIn(t, "sub-6", "fay@example.com", nil); result.Get("oidc") != "login" {
+		t.Fatalf("verified sign up: %v", result)
+	}
+}
+
+func TestCallbackValidatesStateAndNonce(t *testing.T) {
+	f := newOIDCFixture(t)
+
+	// the state cookie must be the one of the browser that started the flow
+	location, cookie := f.start(t, nil)
+	code, state := f.provider.authorize(t, location, "sub-3", "cleo@example.com")
+	if result, _ := f.callback(t, code, state, oidcStateCookie+"=someone-elses"); result.Get("oidc_error") != string(errOIDCInvalidState) {
+		t.Fatalf("mismatched cookie: %v", result)
+	}
+	if result, _ := f.callback(t, code, state, ""); result.Get("oidc_error") != string(errOIDCInvalidState) {
+		t.Fatalf("missing cookie: %v", result)
+	}
+
+	// a state only works once
+	if result, _ := f.callback(t, code, state, cookie); result.Get("oidc") != "login" {
+		t.Fatalf("valid callback: %v", result)
+	}
+	if result, _ := f.callback(t, code, state, cookie); result.Get("oidc_error") != string(errOIDCInvalidState) {
+		t.Fatalf("replayed state: %v", result)
+	}
+
+	// an ID token minted for another flow is refused
+	f.provider.nonce = "not-the-flows-nonce"
+	if result, _ := f.signIn(t, "sub-4", "dan@example.com", nil); result.Get("oidc_error") != string(errOIDCExchange) {
+		t.Fatalf("wrong nonce: %v", result)
+	}
+	f.provider.nonce = ""
+
+	// the provider refuses a code redeemed without the flow's PKCE verifier
+	location, cookie = f.start(t, nil)
+	code, _ = f.provider.authorize(t, location, "sub-5", "eve@example.com")
+	otherLocation, _ := f.start(t, nil)
+	_, otherState := f.provider.authorize(t, otherLocation, "sub-5", "eve@example.com")
+	if result, _ := f.callback(t, code, otherState, oidcStateCookie+"="+otherState); result.Get("oidc_error") != string(errOIDCExchange) {
+		t.Fatalf("code redeemed with another flow's verifier: %v", result)
+	}
+
+	if result := f.get("/api/v1/auth/oidc/mock/callback?error=access_denied", cookie); !strings.Contains(result.Header().Get("Location"), "oidc_error="+string(errOIDCProvider)) {
+		t.Fatalf("provider error: %s", result.Header().Get("Location"))
+	}
+}
+
+func TestLinkAndUnlinkIdentities(t *testing.T) {
+	f := newOIDCFixture(t)
+	ana := f.createUser(t, "ana", "")
+	bob := f.createUser(t, "bob", "battery staple")
+	asAna := context.WithValue(context.Background(), "user", ana)
+
+	if result, _ := f.signIn(t, "ana-at-mock", "ana@mock.example", ana); result.Get("oidc") != "linked" {
+		t.Fatalf("linking: %v", result)
+	}
+	// linking again is a no-op, someone else can't take the identity
+	if result, _ := f.signIn(t, "ana-at-mock", "ana@mock.example", ana); result.Get("oidc") != "linked" {
+		t.Fatalf("linking twice: %v", result)
+	}
+	if result, _ := f.signIn(t, "ana-at-mock", "ana@mock.example", bob); result.Get("oidc_error") != string(errOIDCIdentityInUse) {
+		t.Fatalf("linking someone else's identity: %v", result)
+	}
+
+	identities, err := f.usecase.ListIdentities(asAna)
+	if err != nil || len(identities) != 1 || identities[0].Subject != "ana-at-mock" {
+		t.Fatalf("ListIdentities: %+v, %v", identities, err)
+	}
+	first := identities[0]
+
+	// ana has no password, her only identity is her only way in
+	if _, err := f.usecase.UnlinkIdentity(asAna, first.Id); !errors.Is(err, ErrLastLoginMethod) {
+		t.Fatalf("unlinking the last identity: got %v, want ErrLastLoginMethod", err)
+	}
+	if _, err := f.usecase.UnlinkIdentity(context.WithValue(context.Background(), "user", bob), first.Id); !errors.Is(err, ErrIdentityNotFound) {
+		t.Fatalf("unlinking someone else's identity: got %v, want ErrIdentityNotFound", err)
+	}
+
+	if result, _ := f.signIn(t, "ana-elsewhere", "ana@mock.example", ana); result.Get("oidc") != "linked" {
+		t.Fatalf("linking a second identity: %v", result)
+	}
+	if _, err := f.usecase.UnlinkIdentity(asAna, first.Id); err != nil {
+		t.Fatalf("unlinking with another identity left: %v", err)
+	}
+
+	// with a password, the last identity can go
+	if result, _ := f.signIn(t, "bob-at-mock", "bob@mock.example", bob); result.Get("oidc") != "linked" {
+		t.Fatalf("linking bob: %v", result)
+	}
+	asBob := context.WithValue(context.Background(), "user", bob)
+	identities, _ = f.usecase.ListIdentities(asBob)
+	if _, err := f.usecase.UnlinkIdentity(asBob, identities[0].Id); err != nil {
+		t.Fatalf("unlinking with a password set: %v", err)
+	}
+}
@@ -11,11 +11,26 @@ type User struct {
 	Id       string `validate:"uuid" json:"id" gorm:"primaryKey"`
 	Email    string `validate:"email,max=255" gorm:"type:varchar(255);unique;not null" json:"email"`
 	Name     string `validate:"required,alphanumunicode,max=255" gorm:"type:varchar(255);not null" json:"name"`
-	Password string `validate:"required,ascii,max=72" json:"-" gorm:"type:varchar(72);not null"`
+	Password string `validate:"omitempty,ascii,max=72" json:"-" gorm:"type:varchar(72);not null"`
+	Role     string `validate:"oneof=user moderator admin" json:"-" gorm:"type:varchar(32);not null;default:user"`
 
-	RefreshToken string    `json:"-" gorm:"type:varchar(1024);"`
-	CreatedAt    time.Time `json:"-"`
-	UpdatedAt    time.Time `json:"-"`
+	RefreshToken string `json:"-" gorm:"type:varchar(1024);"`
+	// DisabledAt is set when an operator disables the account, it can't sign in until re-enabled
+	DisabledAt *time.Time `json:"-"`
+	CreatedAt  time.Time  `json:"-"`
+	UpdatedAt  time.Time  `json:"-"`
+}
+
+const (
+	RoleUser = "user"
+	// RoleModerator can remove other people's chat messages and read edit histories
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// IsRole tells whether role is one of the roles above
+func IsRole(role string) bool {
+	return role == RoleUser || role == RoleModerator || role == RoleAdmin
 }
 
 var _ helpers.Cloneable[User] = (*User)(nil)
@@ -24,6 +39,24 @@ func (u *User) Clone() *User {
 	return helpers.Clone(u)
 }
 
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// IsModerator is true for moderators and admins
+func (u *User) IsModerator() bool {
+	return u.Role == RoleModerator || u.Role == RoleAdmin
+}
+
+func (u *User) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
+
+// HasPassword is false for users that only ever signed in through an external identity provider
+func (u *User) HasPassword() bool {
+	return u.Password != ""
+}
+
 var modelValidator = validator.New()
 
 func (User) TableName() string {
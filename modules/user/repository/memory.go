@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bozoteam/roshan/helpers"
+	"github.com/bozoteam/roshan/modules/user/models"
+	"gorm.io/gorm"
+)
+
+var _ UserRepository = (*MemoryUserRepository)(nil)
+
+// MemoryUserRepository is a thread-safe UserRepository kept in memory, for tests and
+// for running without Postgres. Users are copied in and out, like rows would be.
+type MemoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[string]models.User
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: map[string]models.User{}}
+}
+
+func (r *MemoryUserRepository) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *MemoryUserRepository) FindUserById(ctx context.Context, id string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) FindUserByIdAndToken(ctx context.Context, id, token string) (*models.User, error) {
+	user, err := r.FindUserById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// a cleared token is NULL in Postgres and never matches
+	if token == "" || user.RefreshToken != token {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+// SaveUser inserts or updates the user, enforcing the unique email constraint
+func (r *MemoryUserRepository) SaveUser(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, other := range r.users {
+		if id != user.Id && other.Email == user.Email {
+			return helpers.NewUniqueViolation("unique_email")
+		}
+	}
+
+	now := time.Now()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	user.UpdatedAt = now
+	r.users[user.Id] = *user
+	return nil
+}
+
+func (r *MemoryUserRepository) DeleteUser(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	delete(r.users, user.Id)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *MemoryUserRepository) SaveRefreshToken(ctx context.Context, user *models.User, refreshToken string) error {
+	return r.setRefreshToken(user, refreshToken)
+}
+
+func (r *MemoryUserRepository) DeleteRefreshToken(ctx context.Context, user *models.User) error {
+	return r.setRefreshToken(user, "")
+}
+
+// setRefreshToken updates the stored user and the given one, as gorm does with Model().Update()
+func (r *MemoryUserRepository) setRefreshToken(user *models.User, refreshToken string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if stored, ok := r.users[user.Id]; ok {
+		stored.RefreshToken = refreshToken
+		stored.UpdatedAt = now
+		r.users[user.Id] = stored
+	}
+	user.RefreshToken = refreshToken
+	user.UpdatedAt = now
+	return nil
+}
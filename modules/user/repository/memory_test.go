@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bozoteam/roshan/helpers"
+	"github.com/bozoteam/roshan/modules/user/models"
+	"gorm.io/gorm"
+)
+
+func TestMemoryUserRepositoryUniqueEmailUnderContention(t *testing.T) {
+	repo := NewMemoryUserRepository()
+	ctx := context.Background()
+
+	var (
+		wg      sync.WaitGroup
+		created atomic.Int32
+	)
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := repo.SaveUser(ctx, &models.User{Id: fmt.Sprint(i), Email: "same@example.com"})
+			switch {
+			case err == nil:
+				created.Add(1)
+			case !helpers.IsErrorCode(err, helpers.UniqueViolation):
+				t.Errorf("unexpected error %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created.Load() != 1 {
+		t.Fatalf("%d users share one email", created.Load())
+	}
+}
+
+func TestMemoryUserRepositoryCopiesUsers(t *testing.T) {
+	repo := NewMemoryUserRepository()
+	ctx := context.Background()
+
+	user := &models.User{Id: "1", Email: "alice@example.com", Name: "alice"}
+	if err := repo.SaveUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	user.Name = "changed without saving"
+
+	stored, err := repo.FindUserByEmail(ctx, "alice@example.com")
+	if err != nil || stored.Name != "alice" {
+		t.Fatalf("stored user changed: %+v, %v", stored, err)
+	}
+}
+
+func TestMemoryUserRepositoryRefreshToken(t *testing.T) {
+	repo := NewMemoryUserRepository()
+	ctx := context.Background()
+
+	user := &models.User{Id: "1", Email: "alice@example.com"}
+	if err := repo.SaveUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.FindUserByIdAndToken(ctx, "1", ""); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("empty token matched: %v", err)
+	}
+
+	if err := repo.SaveRefreshToken(ctx, user, "token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.FindUserByIdAndToken(ctx, "1", "token"); err != nil {
+		t.Fatalf("FindUserByIdAndToken: %v", err)
+	}
+
+	if err := repo.DeleteRefreshToken(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.FindUserByIdAndToken(ctx, "1", "token"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("deleted token still matches: %v", err)
+	}
+}
@@ -1,7 +1,7 @@
 package repository
 
 import (
-	"fmt"
+	"context"
 	"log/slog"
 
 	log "github.com/bozoteam/roshan/adapter/log"
@@ -9,42 +9,57 @@ import (
 	"gorm.io/gorm"
 )
 
-func NewUserRepository(db *gorm.DB) *UserRepository {
-	return &UserRepository{db: db, logger: log.LogWithModule("user_repository")}
+// UserRepository stores users and their refresh token. Lookups of missing users fail
+// with gorm.ErrRecordNotFound and duplicate emails with a unique violation, whatever the backend.
+type UserRepository interface {
+	FindUserByEmail(ctx context.Context, email string) (*models.User, error)
+	FindUserById(ctx context.Context, id string) (*models.User, error)
+	FindUserByIdAndToken(ctx context.Context, id, token string) (*models.User, error)
+	SaveUser(ctx context.Context, user *models.User) error
+	DeleteUser(ctx context.Context, user *models.User) error
+	SaveRefreshToken(ctx context.Context, user *models.User, refreshToken string) error
+	DeleteRefreshToken(ctx context.Context, user *models.User) error
 }
 
-type UserRepository struct {
+var _ UserRepository = (*GormUserRepository)(nil)
+
+func NewUserRepository(db *gorm.DB) *GormUserRepository {
+	return &GormUserRepository{db: db, logger: log.LogWithModule("user_repository")}
+}
+
+// GormUserRepository is the UserRepository backed by Postgres
+type GormUserRepository struct {
 	logger *slog.Logger
 	db     *gorm.DB
 }
 
-func (r *UserRepository) FindUserByEmail(email string) (*models.User, error) {
+func (r *GormUserRepository) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	var user models.User
-	if err := r.db.Where("email = ?", email).First(&user).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("email = ?", email).First(&user).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (r *UserRepository) SaveRefreshToken(user *models.User, refreshToken string) error {
-	if err := r.db.Model(user).Update("refresh_token", refreshToken).Error; err != nil {
-		fmt.Println(err)
+func (r *GormUserRepository) SaveRefreshToken(ctx context.Context, user *models.User, refreshToken string) error {
+	if err := r.db.WithContext(ctx).Model(user).Update("refresh_token", refreshToken).Error; err != nil {
+		r.logger.ErrorContext(ctx, "failed to save refresh token", "error", err, "user_id", user.Id)
 		return err
 	}
 	return nil
 }
 
-func (r *UserRepository) FindUserById(id string) (*models.User, error) {
+func (r *GormUserRepository) FindUserById(ctx context.Context, id string) (*models.User, error) {
 	var user models.User
-	err := r.db.First(&user, "id = ?", id).Error
+	err := r.db.WithContext(ctx).First(&user, "id = ?", id).Error
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (r *UserRepository) SaveUser(user *models.User) error {
-	return r.db.Save(user).Error
+func (r *GormUserRepository) SaveUser(ctx context.Context, user *models.User) error {
+	return r.db.WithContext(ctx).Save(user).Error
 }
 
 // func (c *UserRepository) UpdateUser(updates map[string]any, user *models.User) error {
@@ -55,9 +70,9 @@ func (r *UserRepository) SaveUser(user *models.User) error {
 // 	return c.db.Model(&user).Updates(updates).Error
 // }
 
-func (r *UserRepository) FindUserByIdAndToken(id, token string) (*models.User, error) {
+func (r *GormUserRepository) FindUserByIdAndToken(ctx context.Context, id, token string) (*models.User, error) {
 	var user models.User
-	if err := r.db.Where("id = ? AND refresh_token = ?", id, token).First(&user).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("id = ? AND refresh_token = ?", id, token).First(&user).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
@@ -70,15 +85,15 @@ func (r *UserRepository) FindUserByIdAndToken(id, token string) (*models.User, e
 // 	return nil
 // }
 
-func (r *UserRepository) DeleteUser(user *models.User) error {
-	if err := r.db.Delete(user).Error; err != nil {
+func (r *GormUserRepository) DeleteUser(ctx context.Context, user *models.User) error {
+	if err := r.db.WithContext(ctx).Delete(user).Error; err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *UserRepository) DeleteRefreshToken(user *models.User) error {
-	if err := r.db.Model(user).Update("refresh_token", nil).Error; err != nil {
+func (r *GormUserRepository) DeleteRefreshToken(ctx context.Context, user *models.User) error {
+	if err := r.db.WithContext(ctx).Model(user).Update("refresh_token", nil).Error; err != nil {
 		return err
 	}
 	return nil
@@ -0,0 +1,102 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bozoteam/roshan/helpers"
+	"github.com/bozoteam/roshan/modules/user/models"
+	"github.com/bozoteam/roshan/roshan_errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// The operations below are run by operators from the command line. They act on any
+// account, found by email, and don't look at a session user.
+
+var ErrInvalidRole = status.Error(codes.InvalidArgument, "role must be user, moderator or admin")
+
+func (u *UserUsecase) findByEmail(ctx context.Context, email string) (*models.User, error) {
+	user, err := u.userRepo.FindUserByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, roshan_errors.ErrInternalServerError
+	}
+	return user, nil
+}
+
+// SetRole makes the account a regular user, a moderator or an admin
+func (u *UserUsecase) SetRole(ctx context.Context, email string, role string) (*models.User, error) {
+	if !models.IsRole(role) {
+		return nil, ErrInvalidRole
+	}
+
+	user, err := u.findByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Role = role
+	if err := u.userRepo.SaveUser(ctx, user); err != nil {
+		return nil, roshan_errors.ErrInternalServerError
+	}
+	u.logger.InfoContext(ctx, "role changed", "user_id", user.Id, "role", role)
+	return user, nil
+}
+
+// SetDisabled disables or re-enables the account. Disabling also drops the refresh token,
+// access tokens and API keys are refused from then on by the auth middleware.
+func (u *UserUsecase) SetDisabled(ctx context.Context, email string, disabled bool) (*models.User, error) {
+	user, err := u.findByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	user.DisabledAt = nil
+	if disabled {
+		now := time.Now()
+		user.DisabledAt = &now
+	}
+	if err := u.userRepo.SaveUser(ctx, user); err != nil {
+		return nil, roshan_errors.ErrInternalServerError
+	}
+	if disabled {
+		if err := u.userRepo.DeleteRefreshToken(ctx, user); err != nil {
+			return nil, roshan_errors.ErrInternalServerError
+		}
+	}
+	u.logger.InfoContext(ctx, "account status changed", "user_id", user.Id, "disabled", disabled)
+	return user, nil
+}
+
+// ResetPassword replaces the password and signs the account out of its refresh token
+func (u *UserUsecase) ResetPassword(ctx context.Context, email string, password string) (*models.User, error) {
+	if password == "" {
+		return nil, roshan_errors.ErrInvalidRequest
+	}
+
+	user, err := u.findByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := helpers.HashPassword(password)
+	if err != nil {
+		// bcrypt refuses passwords over 72 bytes
+		return nil, roshan_errors.ErrInvalidRequest
+	}
+	user.Password = hash
+
+	if err := u.userRepo.SaveUser(ctx, user); err != nil {
+		return nil, roshan_errors.ErrInternalServerError
+	}
+	if err := u.userRepo.DeleteRefreshToken(ctx, user); err != nil {
+		return nil, roshan_errors.ErrInternalServerError
+	}
+	u.logger.InfoContext(ctx, "password reset", "user_id", user.Id)
+	return user, nil
+}
@@ -7,22 +7,22 @@ import (
 
 	log "github.com/bozoteam/roshan/adapter/log"
 	"github.com/bozoteam/roshan/helpers"
+	authUsecase "github.com/bozoteam/roshan/modules/auth/usecase"
 	"github.com/bozoteam/roshan/modules/user/models"
 	userRepository "github.com/bozoteam/roshan/modules/user/repository"
 	"github.com/bozoteam/roshan/roshan_errors"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"gorm.io/gorm"
 )
 
 type UserUsecase struct {
 	logger   *slog.Logger
-	userRepo *userRepository.UserRepository
+	userRepo userRepository.UserRepository
 }
 
-func NewUserUsecase(db *gorm.DB) *UserUsecase {
+func NewUserUsecase(userRepository userRepository.UserRepository) *UserUsecase {
 	return &UserUsecase{
-		userRepo: userRepository.NewUserRepository(db),
+		userRepo: userRepository,
 		logger:   log.LogWithModule("user_usecase"),
 	}
 }
@@ -52,14 +52,15 @@ func (c *UserUsecase) CreateUser(ctx context.Context, useReq *UserCreateInput) (
 		Name:     useReq.Name,
 		Email:    useReq.Email,
 		Password: hashedPassword,
+		Role:     models.RoleUser,
 	}
 
 	if err := models.ValidateUser(user); err != nil {
 		return nil, roshan_errors.ErrInvalidRequest
 	}
 
-	if err := c.userRepo.SaveUser(user); err != nil {
-		if helpers.IsErrorCode(err, "23505") {
+	if err := c.userRepo.SaveUser(ctx, user); err != nil {
+		if helpers.IsErrorCode(err, helpers.UniqueViolation) {
 			return nil, ErrEmailAlreadyExists
 		}
 		return nil, roshan_errors.ErrInternalServerError
@@ -76,7 +77,11 @@ type UserUpdateInput struct {
 }
 
 func (u *UserUsecase) UpdateUser(ctx context.Context, input *UserUpdateInput) (*models.User, error) {
-	user := ctx.Value("user").(*models.User)
+	// a key changing the email or password would turn itself into a full session
+	user, err := authUsecase.RequireSession(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	if input.Name != nil {
 		user.Name = *input.Name
@@ -96,8 +101,8 @@ func (u *UserUsecase) UpdateUser(ctx context.Context, input *UserUpdateInput) (*
 		return nil, roshan_errors.ErrInvalidRequest
 	}
 
-	if err := u.userRepo.SaveUser(user); err != nil {
-		if helpers.IsErrorCode(err, "23505") {
+	if err := u.userRepo.SaveUser(ctx, user); err != nil {
+		if helpers.IsErrorCode(err, helpers.UniqueViolation) {
 			return nil, ErrEmailAlreadyExists
 		}
 		return nil, roshan_errors.ErrInternalServerError
@@ -107,9 +112,12 @@ func (u *UserUsecase) UpdateUser(ctx context.Context, input *UserUpdateInput) (*
 }
 
 func (u *UserUsecase) DeleteUser(ctx context.Context) (*models.User, error) {
-	user := ctx.Value("user").(*models.User)
+	user, err := authUsecase.RequireSession(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	if err := u.userRepo.DeleteUser(user); err != nil {
+	if err := u.userRepo.DeleteUser(ctx, user); err != nil {
 		return nil, ErrUserNotFound
 	}
 
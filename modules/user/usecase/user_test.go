@@ -0,0 +1,135 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bozoteam/roshan/helpers"
+	authModel "github.com/bozoteam/roshan/modules/auth/models"
+	authUsecase "github.com/bozoteam/roshan/modules/auth/usecase"
+	"github.com/bozoteam/roshan/modules/user/models"
+	userRepository "github.com/bozoteam/roshan/modules/user/repository"
+	"github.com/bozoteam/roshan/roshan_errors"
+)
+
+func TestCreateUser(t *testing.T) {
+	repo := userRepository.NewMemoryUserRepository()
+	usecase := NewUserUsecase(repo)
+	ctx := context.Background()
+
+	user, err := usecase.CreateUser(ctx, &UserCreateInput{Name: "alice", Email: "alice@example.com", Password: "correct horse"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if user.Role != models.RoleUser || !helpers.CheckPasswordHash("correct horse", user.Password) {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	_, err = usecase.CreateUser(ctx, &UserCreateInput{Name: "other", Email: "alice@example.com", Password: "x"})
+	if !errors.Is(err, ErrEmailAlreadyExists) {
+		t.Fatalf("duplicate email: got %v, want ErrEmailAlreadyExists", err)
+	}
+
+	_, err = usecase.CreateUser(ctx, &UserCreateInput{Name: "bad name!", Email: "bob@example.com", Password: "x"})
+	if !errors.Is(err, roshan_errors.ErrInvalidRequest) {
+		t.Fatalf("invalid name: got %v, want ErrInvalidRequest", err)
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	repo := userRepository.NewMemoryUserRepository()
+	usecase := NewUserUsecase(repo)
+	ctx := context.Background()
+
+	alice, err := usecase.CreateUser(ctx, &UserCreateInput{Name: "alice", Email: "alice@example.com", Password: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := usecase.CreateUser(ctx, &UserCreateInput{Name: "bob", Email: "bob@example.com", Password: "battery staple"}); err != nil {
+		t.Fatal(err)
+	}
+
+	userCtx := context.WithValue(ctx, "user", alice)
+	taken := "bob@example.com"
+	if _, err := usecase.UpdateUser(userCtx, &UserUpdateInput{Email: &taken}); !errors.Is(err, ErrEmailAlreadyExists) {
+		t.Fatalf("got %v, want ErrEmailAlreadyExists", err)
+	}
+
+	// the failed update already changed the session user, as with a real database
+	name, email := "alicia", "alice@example.com"
+	if _, err := usecase.UpdateUser(userCtx, &UserUpdateInput{Name: &name, Email: &email}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	stored, err := repo.FindUserById(ctx, alice.Id)
+	if err != nil || stored.Name != "alicia" {
+		t.Fatalf("update not stored: %+v, %v", stored, err)
+	}
+}
+
+func TestApiKeysCantTakeOverTheAccount(t *testing.T) {
+	repo := userRepository.NewMemoryUserRepository()
+	usecase := NewUserUsecase(repo)
+	ctx := context.Background()
+
+	alice, err := usecase.CreateUser(ctx, &UserCreateInput{Name: "alice", Email: "alice@example.com", Password: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyCtx := context.WithValue(context.WithValue(ctx, "user", alice), "api_key", &authModel.ApiKey{Scopes: authModel.ScopeUser})
+
+	password := "stolen"
+	if _, err := usecase.UpdateUser(keyCtx, &UserUpdateInput{Password: &password}); !errors.Is(err, authUsecase.ErrSessionRequired) {
+		t.Fatalf("UpdateUser: got %v, want ErrSessionRequired", err)
+	}
+	if _, err := usecase.DeleteUser(keyCtx); !errors.Is(err, authUsecase.ErrSessionRequired) {
+		t.Fatalf("DeleteUser: got %v, want ErrSessionRequired", err)
+	}
+	stored, err := repo.FindUserById(ctx, alice.Id)
+	if err != nil || !helpers.CheckPasswordHash("correct horse", stored.Password) {
+		t.Fatalf("the key changed the account: %+v, %v", stored, err)
+	}
+}
+
+func TestOperatorCommands(t *testing.T) {
+	repo := userRepository.NewMemoryUserRepository()
+	usecase := NewUserUsecase(repo)
+	ctx := context.Background()
+
+	alice, err := usecase.CreateUser(ctx, &UserCreateInput{Name: "alice", Email: "alice@example.com", Password: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SaveRefreshToken(ctx, alice, "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := usecase.SetRole(ctx, "alice@example.com", "root"); !errors.Is(err, ErrInvalidRole) {
+		t.Fatalf("got %v, want ErrInvalidRole", err)
+	}
+	if _, err := usecase.SetRole(ctx, "nobody@example.com", models.RoleAdmin); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("got %v, want ErrUserNotFound", err)
+	}
+	if user, err := usecase.SetRole(ctx, "alice@example.com", models.RoleAdmin); err != nil || !user.IsAdmin() {
+		t.Fatalf("SetRole: %+v, %v", user, err)
+	}
+
+	if _, err := usecase.SetDisabled(ctx, "alice@example.com", true); err != nil {
+		t.Fatalf("SetDisabled: %v", err)
+	}
+	stored, _ := repo.FindUserById(ctx, alice.Id)
+	if !stored.IsDisabled() || stored.RefreshToken != "" {
+		t.Fatalf("disabled account kept its session: %+v", stored)
+	}
+	if user, err := usecase.SetDisabled(ctx, "alice@example.com", false); err != nil || user.IsDisabled() {
+		t.Fatalf("re-enabling: %+v, %v", user, err)
+	}
+
+	if _, err := usecase.ResetPassword(ctx, "alice@example.com", "battery staple"); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	stored, _ = repo.FindUserById(ctx, alice.Id)
+	if !helpers.CheckPasswordHash("battery staple", stored.Password) {
+		t.Fatal("password was not replaced")
+	}
+}
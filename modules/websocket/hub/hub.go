@@ -1,213 +1,281 @@
 package ws_hub
 
 import (
+	"context"
 	"encoding/json"
-	"maps"
+	"math/rand/v2"
 	"slices"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/bozoteam/roshan/helpers"
+	"github.com/bozoteam/roshan/adapter/metrics"
+	"github.com/bozoteam/roshan/adapter/tracing"
 	userModel "github.com/bozoteam/roshan/modules/user/models"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type ClientTeam struct {
-	ClientI
-	Team string // Team the client belongs to
-}
-
 // ClintI defines the interface for clients
 type ClientI interface {
 	GetID() string
 	GetSender() chan []byte
 	GetUser() *userModel.User
 	WaitUnregister()
+	Close(code int, reason string)
 }
 
-// RoomI defines the interface for rooms
-type RoomI interface {
-	GetID() string
-	GetClients() map[string]ClientTeam
-	SetSomeoneEntered(bool)
-	GetSomeoneEntered() bool
-	Clone() RoomI
-	UserIsInRoom(userId string) bool
-	GetClientsFromTeam(team string) []ClientTeam
-	GetTeamMapping() map[string][]ClientTeam
-	RegisterClient(client ClientI, team string)
-	UnregisterClient(id string)
-}
-
-// Hub manages all rooms and connections
+// shardCount spreads the rooms over this many maps, each behind its own lock
+const shardCount = 64
+
+type shard struct {
+	mu    sync.RWMutex
+	rooms map[string]*Room
+}
+
+// Hub manages all rooms and connections. Shard locks only guard which rooms exist, they're
+// always taken before a room lock and never while one is held.
 type Hub struct {
-	rooms map[string]RoomI
-	mu    *sync.RWMutex
+	name   string
+	shards [shardCount]shard
+
+	framesSent    prometheus.Counter
+	framesDropped prometheus.Counter
 }
 
-// NewHub creates a new hub
-func NewHub() *Hub {
-	return &Hub{
-		rooms: make(map[string]RoomI, 1024),
-		mu:    new(sync.RWMutex),
+// NewHub creates a new hub, name tells hubs apart in metrics
+func NewHub(name string) *Hub {
+	h := &Hub{
+		name:          name,
+		framesSent:    metrics.WebSocketFrames.WithLabelValues(name, "sent"),
+		framesDropped: metrics.WebSocketFrames.WithLabelValues(name, "dropped"),
+	}
+	for i := range h.shards {
+		h.shards[i].rooms = make(map[string]*Room)
 	}
+	metrics.TrackHub(name, h)
+	return h
 }
 
-func (h *Hub) GetRoom(roomId string) RoomI {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// shard returns the shard of a room, picked with an FNV-1a hash of its id
+func (h *Hub) shard(roomId string) *shard {
+	hash := uint32(2166136261)
+	for i := 0; i < len(roomId); i++ {
+		hash ^= uint32(roomId[i])
+		hash *= 16777619
+	}
+	return &h.shards[hash%shardCount]
+}
 
-	room, exists := h.rooms[roomId]
-	if !exists {
-		return nil
+func (h *Hub) room(roomId string) *Room {
+	shard := h.shard(roomId)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.rooms[roomId]
+}
+
+// rooms returns every open room
+func (h *Hub) rooms() []*Room {
+	var rooms []*Room
+	for i := range h.shards {
+		shard := &h.shards[i]
+		shard.mu.RLock()
+		for _, room := range shard.rooms {
+			rooms = append(rooms, room)
+		}
+		shard.mu.RUnlock()
 	}
-	return room.Clone()
+	return rooms
 }
 
-func (h *Hub) DeleteRoom(roomId string) {
-	h.mu.Lock()
-	delete(h.rooms, roomId)
-	h.mu.Unlock()
+// drop removes room from the hub when close reports it closed
+func (h *Hub) drop(room *Room, close func() bool) {
+	shard := h.shard(room.id)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if close() && shard.rooms[room.id] == room {
+		delete(shard.rooms, room.id)
+	}
 }
 
-func (h *Hub) CreateRoom(room RoomI) {
-	h.mu.Lock()
-	h.rooms[room.GetID()] = room
-	h.mu.Unlock()
+// Stats returns how many rooms are open and how many clients are connected to them
+func (h *Hub) Stats() (rooms int, connections int) {
+	open := h.rooms()
+	for _, room := range open {
+		connections += room.Len()
+	}
+	return len(open), connections
+}
 
+// send queues data for a client without blocking, a client whose buffer is full misses the frame
+func (h *Hub) send(sender chan []byte, data []byte) bool {
+	select {
+	case sender <- data:
+		h.framesSent.Inc()
+		return true
+	default:
+		h.framesDropped.Inc()
+		return false
+	}
+}
+
+func (h *Hub) deliver(recipients []chan []byte, data []byte) {
+	for _, sender := range recipients {
+		h.send(sender, data)
+	}
+}
+
+// Ping fails when the shard locks can't be taken before ctx is done, which means a
+// goroutine is stuck while holding one
+func (h *Hub) Ping(ctx context.Context) error {
+	acquired := make(chan struct{})
 	go func() {
-		<-time.NewTimer(time.Second * 5).C
-		h.mu.RLock()
-		entered := room.GetSomeoneEntered()
-		id := room.GetID()
-		h.mu.RUnlock()
-		if !entered {
-			h.DeleteRoom(id)
+		for i := range h.shards {
+			h.shards[i].mu.RLock()
+			h.shards[i].mu.RUnlock()
 		}
+		close(acquired)
 	}()
-}
 
-func (h *Hub) ListRooms() []RoomI {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	return slices.Collect(maps.Values(helpers.Clone(h.rooms)))
+	select {
+	case <-acquired:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (h *Hub) Register(client ClientI, roomId string, team string) {
-	h.mu.Lock()
-	room, exists := h.rooms[roomId]
-	if !exists {
-		h.mu.Unlock()
-		return
+// GetRoom returns a snapshot of the room, nil when it doesn't exist
+func (h *Hub) GetRoom(roomId string) *RoomSnapshot {
+	room := h.room(roomId)
+	if room == nil {
+		return nil
 	}
+	return room.Snapshot()
+}
 
-	room.SetSomeoneEntered(true)
-	clients := room.GetClients()
-	clients[client.GetID()] = ClientTeam{
-		ClientI: client,
-		Team:    team,
+// HasRoom tells whether the room is open, without taking a snapshot of it
+func (h *Hub) HasRoom(roomId string) bool {
+	return h.room(roomId) != nil
+}
+
+// DeleteRoom closes the room, clients still connected stay connected until they leave
+func (h *Hub) DeleteRoom(roomId string) {
+	if room := h.room(roomId); room != nil {
+		h.drop(room, room.close)
 	}
+}
 
-	teamMap := room.GetTeamMapping()
-	teamMap[team] = append(teamMap[team], ClientTeam{
-		ClientI: client,
-		Team:    team,
+// CreateRoom opens room, it's closed again if nobody joins within 5 seconds
+func (h *Hub) CreateRoom(room *Room) {
+	shard := h.shard(room.id)
+	shard.mu.Lock()
+	shard.rooms[room.id] = room
+	shard.mu.Unlock()
+
+	time.AfterFunc(5*time.Second, func() {
+		h.drop(room, room.closeIfUnused)
 	})
-	h.mu.Unlock()
+}
 
-	h.sendUserList(roomId)
+// ListRooms returns a snapshot of every room, oldest first
+func (h *Hub) ListRooms() []*RoomSnapshot {
+	rooms := h.rooms()
+	snapshots := make([]*RoomSnapshot, 0, len(rooms))
+	for _, room := range rooms {
+		snapshots = append(snapshots, room.Snapshot())
+	}
+
+	// room ids are UUIDv7, so they sort by creation time
+	slices.SortFunc(snapshots, func(a, b *RoomSnapshot) int { return strings.Compare(a.Id, b.Id) })
+	return snapshots
+}
+
+// Register adds client to a team of the room and sends everyone the new user list.
+// It's false when the room doesn't exist or was closed.
+func (h *Hub) Register(client ClientI, roomId string, team string) bool {
+	room := h.room(roomId)
+	if room == nil {
+		return false
+	}
+	return room.register(client, team, h.deliver)
 }
 
+// Unregister removes client from the room, the room is closed once it's empty
 func (h *Hub) Unregister(client ClientI, roomId string) {
-	h.mu.Lock()
-	room, exists := h.rooms[roomId]
-	if !exists {
-		h.mu.Unlock()
+	room := h.room(roomId)
+	if room == nil {
 		return
 	}
-
-	room.UnregisterClient(client.GetID())
-	clients := room.GetClients()
-	delete(clients, client.GetID())
-	if len(clients) == 0 {
-		delete(h.rooms, roomId)
+	if room.unregister(client, h.deliver) {
+		h.drop(room, room.closeIfEmpty)
 	}
-	h.mu.Unlock()
-
-	h.sendUserList(roomId)
 }
 
 // BroadcastBytes sends data to all clients in a room
-func (h *Hub) BroadcastBytes(roomId string, data []byte) {
-	h.mu.RLock()
-	room, exists := h.rooms[roomId]
-	if !exists {
-		h.mu.RUnlock()
+func (h *Hub) BroadcastBytes(ctx context.Context, roomId string, data []byte) {
+	_, span := tracing.Tracer().Start(ctx, "hub.broadcast", trace.WithAttributes(
+		attribute.String("hub", h.name),
+		attribute.String("room.id", roomId),
+	))
+	defer span.End()
+
+	room := h.room(roomId)
+	if room == nil {
 		return
 	}
 
-	clients := room.GetClients()
-	chans := make([]chan []byte, 0, len(clients))
-	for _, client := range clients {
-		chans = append(chans, client.GetSender())
-	}
-	h.mu.RUnlock()
-
-	for _, c := range chans {
-		select {
-		case c <- data:
-		default:
+	recipients := room.recipients()
+	dropped := 0
+	for _, c := range recipients {
+		if !h.send(c, data) {
+			dropped++
 		}
 	}
+	span.SetAttributes(attribute.Int("recipients", len(recipients)), attribute.Int("dropped", dropped))
 }
 
 func (h *Hub) SendBytesToTeam(roomId string, team string, data []byte) {
-	h.mu.RLock()
-	room, exists := h.rooms[roomId]
-	if !exists {
-		h.mu.RUnlock()
+	room := h.room(roomId)
+	if room == nil {
 		return
 	}
-
-	teamClients := room.GetClientsFromTeam(team)
-	chans := make([]chan []byte, 0, len(teamClients))
-	for _, client := range teamClients {
-		chans = append(chans, client.GetSender())
-	}
-	h.mu.RUnlock()
-
-	for _, c := range chans {
-		select {
-		case c <- data:
-		default:
-		}
-	}
+	h.deliver(room.teamRecipients(team), data)
 }
 
 // SendBytes sends data to a specific client in a room
 func (h *Hub) SendBytes(roomId string, clientId string, data []byte) bool {
-	h.mu.RLock()
-	room, exists := h.rooms[roomId]
-	if !exists {
-		h.mu.RUnlock()
+	room := h.room(roomId)
+	if room == nil {
 		return false
 	}
 
-	clients := room.GetClients()
-	client, exists := clients[clientId]
+	sender, exists := room.recipient(clientId)
 	if !exists {
-		h.mu.RUnlock()
 		return false
 	}
+	return h.send(sender, data)
+}
 
-	sender := client.GetSender()
-	h.mu.RUnlock()
+// Disconnect closes the sockets the user has in the room, they leave it as they unregister.
+// It returns how many were closed.
+func (h *Hub) Disconnect(roomId string, userId string, code int, reason string) int {
+	room := h.room(roomId)
+	if room == nil {
+		return 0
+	}
 
-	select {
-	case sender <- data:
-		return true
-	default:
-		return false
+	closed := 0
+	for _, client := range room.clients() {
+		if client.GetUser().Id == userId {
+			client.Close(code, reason)
+			closed++
+		}
 	}
+	return closed
 }
 
 // RoomUserList represents a room event (like user list updates)
@@ -217,35 +285,43 @@ type RoomUserList struct {
 	Timestamp int64                        `json:"timestamp"`
 }
 
-func (h *Hub) sendUserList(roomId string) {
-	h.mu.RLock()
-	room, exists := h.rooms[roomId]
-	if !exists {
-		h.mu.RUnlock()
-		return
+// ServerGoingAway is sent to every client right before the server shuts down
+type ServerGoingAway struct {
+	Type string `json:"type"`
+	// How long the client should wait before reconnecting
+	ReconnectAfterMs int64 `json:"reconnect_after_ms"`
+	Timestamp        int64 `json:"timestamp"`
+}
+
+// Shutdown tells every connected client the server is going away and closes its socket.
+// Reconnect hints are spread between reconnectDelay and twice that, so clients don't all
+// come back to the next instance at once.
+func (h *Hub) Shutdown(reconnectDelay time.Duration) {
+	var clients []ClientI
+	for _, room := range h.rooms() {
+		clients = append(clients, room.clients()...)
 	}
 
-	tMap := room.GetTeamMapping()
-	output := make(map[string][]*userModel.User, len(tMap))
-	for team, clients := range tMap {
-		users := make([]*userModel.User, len(clients))
-		for i, client := range clients {
-			users[i] = client.GetUser()
+	now := time.Now().UnixNano()
+	for _, client := range clients {
+		delay := reconnectDelay
+		if reconnectDelay > 0 {
+			delay += rand.N(reconnectDelay)
 		}
-		output[team] = users
-	}
 
-	roomID := room.GetID()
-	h.mu.RUnlock()
+		data, err := json.Marshal(&ServerGoingAway{
+			Type:             "server_going_away",
+			ReconnectAfterMs: delay.Milliseconds(),
+			Timestamp:        now,
+		})
+		if err != nil {
+			panic(err)
+		}
 
-	data, err := json.Marshal(&RoomUserList{
-		RoomID:    roomID,
-		Teams:     output,
-		Timestamp: time.Now().UnixNano(),
-	})
-	if err != nil {
-		panic(err)
+		select {
+		case client.GetSender() <- data:
+		default:
+		}
+		client.Close(websocket.CloseGoingAway, "server shutting down")
 	}
-
-	go h.BroadcastBytes(roomID, data)
 }
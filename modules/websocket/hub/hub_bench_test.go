@@ -0,0 +1,114 @@
+package ws_hub_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync/atomic"
+	"testing"
+
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+	ws_hub "github.com/bozoteam/roshan/modules/websocket/hub"
+)
+
+// fakeClient stands in for a WebSocket client, frames pile up in its buffered channel
+type fakeClient struct {
+	user *userModel.User
+	send chan []byte
+}
+
+func newFakeClient(id string) *fakeClient {
+	return &fakeClient{
+		user: &userModel.User{Id: id, Name: "user" + id, Email: id + "@example.com"},
+		send: make(chan []byte, 256),
+	}
+}
+
+func (c *fakeClient) GetID() string                 { return c.user.Id }
+func (c *fakeClient) GetSender() chan []byte        { return c.send }
+func (c *fakeClient) GetUser() *userModel.User      { return c.user }
+func (c *fakeClient) WaitUnregister()               {}
+func (c *fakeClient) Close(code int, reason string) {}
+
+// populatedHub returns a hub with rooms rooms of members clients each
+func populatedHub(b *testing.B, rooms int, members int) *ws_hub.Hub {
+	b.Helper()
+
+	hub := ws_hub.NewHub(fmt.Sprintf("bench_%d_%d", rooms, members))
+	for r := range rooms {
+		room := ws_hub.NewRoom(fmt.Sprint("room", r), "creator", []string{"chat"}, "chat")
+		hub.CreateRoom(room)
+		for m := range members {
+			hub.Register(newFakeClient(fmt.Sprintf("%d-%d", r, m)), room.GetID(), "chat")
+		}
+	}
+	return hub
+}
+
+func BenchmarkListRooms(b *testing.B) {
+	for _, rooms := range []int{100, 1000, 5000} {
+		b.Run(fmt.Sprintf("rooms=%d/members=4", rooms), func(b *testing.B) {
+			hub := populatedHub(b, rooms, 4)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for b.Loop() {
+				if got := len(hub.ListRooms()); got != rooms {
+					b.Fatalf("listed %d rooms, want %d", got, rooms)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkHubLoad broadcasts into random rooms from every core while a tenth of the
+// operations are clients joining and leaving, the traffic of a busy instance
+func BenchmarkHubLoad(b *testing.B) {
+	for _, rooms := range []int{100, 2000} {
+		b.Run(fmt.Sprintf("rooms=%d/members=8", rooms), func(b *testing.B) {
+			hub := ws_hub.NewHub(fmt.Sprintf("load_%d", rooms))
+			ids := make([]string, rooms)
+			for r := range rooms {
+				room := ws_hub.NewRoom(fmt.Sprint("room", r), "creator", []string{"chat"}, "chat")
+				hub.CreateRoom(room)
+				ids[r] = room.GetID()
+				for m := range 8 {
+					hub.Register(newDrainedClient(b, fmt.Sprintf("%d-%d", r, m)), ids[r], "chat")
+				}
+			}
+			frame := []byte(`{"content":"hello"}`)
+			var joins atomic.Int64
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					roomId := ids[rand.N(len(ids))]
+					if i%10 != 0 {
+						hub.BroadcastBytes(context.Background(), roomId, frame)
+						continue
+					}
+					client := newFakeClient(fmt.Sprint("churn-", joins.Add(1)))
+					hub.Register(client, roomId, "chat")
+					hub.Unregister(client, roomId)
+				}
+			})
+		})
+	}
+}
+
+// newDrainedClient is a client whose frames are read as fast as they come
+func newDrainedClient(b *testing.B, id string) *fakeClient {
+	client := newFakeClient(id)
+	done := make(chan struct{})
+	b.Cleanup(func() { close(done) })
+	go func() {
+		for {
+			select {
+			case <-client.send:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return client
+}
@@ -0,0 +1,130 @@
+package ws_hub_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	ws_hub "github.com/bozoteam/roshan/modules/websocket/hub"
+)
+
+func TestSnapshotsAreDetached(t *testing.T) {
+	hub := ws_hub.NewHub("test_snapshots")
+	room := ws_hub.NewRoom("general", "creator", []string{"chat"}, "chat")
+	hub.CreateRoom(room)
+
+	alice := newFakeClient("alice")
+	hub.Register(alice, room.GetID(), "chat")
+
+	snapshot := hub.GetRoom(room.GetID())
+	if snapshot == nil || snapshot.Name != "general" || !snapshot.HasUser("alice") {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+
+	// changes on either side don't reach the other
+	hub.Register(newFakeClient("bob"), room.GetID(), "chat")
+	snapshot.Members[0].User.Name = "mallory"
+	snapshot.Teams[0] = "tampered"
+
+	if snapshot.HasUser("bob") {
+		t.Fatal("snapshot saw a later registration")
+	}
+	fresh := hub.GetRoom(room.GetID())
+	if len(fresh.Members) != 2 || fresh.Members[0].User.Name != "useralice" || fresh.Teams[0] != "chat" {
+		t.Fatalf("live room was changed through a snapshot: %+v", fresh)
+	}
+	if alice.user.Name != "useralice" {
+		t.Fatal("client's user was changed through a snapshot")
+	}
+
+	if hub.GetRoom("missing") != nil {
+		t.Fatal("snapshot of a missing room")
+	}
+}
+
+func TestListRoomsOldestFirst(t *testing.T) {
+	hub := ws_hub.NewHub("test_list")
+	var ids []string
+	for _, name := range []string{"a", "b", "c"} {
+		room := ws_hub.NewRoom(name, "creator", []string{"chat"}, "chat")
+		hub.CreateRoom(room)
+		ids = append(ids, room.GetID())
+	}
+
+	rooms := hub.ListRooms()
+	if len(rooms) != len(ids) {
+		t.Fatalf("listed %d rooms, want %d", len(rooms), len(ids))
+	}
+	for i, room := range rooms {
+		if room.Id != ids[i] {
+			t.Fatalf("room %d is %s, want %s", i, room.Id, ids[i])
+		}
+	}
+}
+
+func TestReconnectKeepsTheNewConnection(t *testing.T) {
+	hub := ws_hub.NewHub("test_reconnect")
+	room := ws_hub.NewRoom("general", "creator", []string{"chat"}, "chat")
+	hub.CreateRoom(room)
+
+	old, fresh := newFakeClient("alice"), newFakeClient("alice")
+	hub.Register(old, room.GetID(), "chat")
+	hub.Register(fresh, room.GetID(), "chat")
+
+	// the old connection going away doesn't take the new one with it
+	hub.Unregister(old, room.GetID())
+	snapshot := hub.GetRoom(room.GetID())
+	if snapshot == nil || len(snapshot.Members) != 1 {
+		t.Fatalf("unexpected room %+v", snapshot)
+	}
+	if !hub.SendBytes(room.GetID(), "alice", []byte("hi")) || len(fresh.send) == 0 {
+		t.Fatal("frame didn't reach the new connection")
+	}
+
+	hub.Unregister(fresh, room.GetID())
+	if hub.GetRoom(room.GetID()) != nil {
+		t.Fatal("empty room is still open")
+	}
+	if hub.Register(newFakeClient("bob"), room.GetID(), "chat") {
+		t.Fatal("joined a closed room")
+	}
+}
+
+func TestConcurrentRooms(t *testing.T) {
+	hub := ws_hub.NewHub("test_concurrent")
+	rooms := make([]string, 50)
+	for i := range rooms {
+		room := ws_hub.NewRoom(fmt.Sprint("room", i), "creator", []string{"chat"}, "chat")
+		hub.CreateRoom(room)
+		rooms[i] = room.GetID()
+		hub.Register(newFakeClient(fmt.Sprint("owner", i)), rooms[i], "chat")
+	}
+
+	var wg sync.WaitGroup
+	for i, roomId := range rooms {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := range 20 {
+				client := newFakeClient(fmt.Sprintf("%d-%d", i, j))
+				hub.Register(client, roomId, "chat")
+				hub.BroadcastBytes(context.Background(), roomId, []byte("hi"))
+				hub.Unregister(client, roomId)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				hub.ListRooms()
+				hub.Stats()
+			}
+		}()
+	}
+	wg.Wait()
+
+	open, connections := hub.Stats()
+	if open != len(rooms) || connections != len(rooms) {
+		t.Fatalf("%d rooms and %d connections left, want %d of each", open, connections, len(rooms))
+	}
+}
@@ -0,0 +1,241 @@
+package ws_hub
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bozoteam/roshan/helpers"
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+)
+
+// member is a client registered in a room
+type member struct {
+	client ClientI
+	team   string
+}
+
+// Room holds who is connected to a room. Its state is only reached through its own
+// methods, which take the room lock, so a busy room never holds up the others.
+type Room struct {
+	id        string
+	name      string
+	creatorId string
+	kind      string
+	teams     []string
+
+	mu sync.RWMutex
+	// members are keyed by client id
+	members map[string]*member
+	// byTeam keeps each team's members in the order they joined
+	byTeam map[string][]*member
+	// senders is replaced, never modified, when members change, so broadcasts can keep
+	// using it after the lock is released
+	senders        []chan []byte
+	someoneEntered bool
+	// closed is set once the room left the hub, nobody can join it after that
+	closed bool
+}
+
+func NewRoom(name string, creatorId string, teams []string, kind string) *Room {
+	return &Room{
+		id:        helpers.GenUUID(),
+		name:      name,
+		creatorId: creatorId,
+		kind:      kind,
+		teams:     teams,
+		members:   make(map[string]*member),
+		byTeam:    make(map[string][]*member),
+	}
+}
+
+func (r *Room) GetID() string {
+	return r.id
+}
+
+// Snapshot copies the room
+func (r *Room) Snapshot() *RoomSnapshot {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	members := make([]Member, 0, len(r.members))
+	for _, team := range slices.Sorted(maps.Keys(r.byTeam)) {
+		for _, m := range r.byTeam[team] {
+			members = append(members, Member{
+				ClientId: m.client.GetID(),
+				Team:     team,
+				User:     *m.client.GetUser(),
+			})
+		}
+	}
+
+	return &RoomSnapshot{
+		Id:        r.id,
+		Name:      r.name,
+		CreatorId: r.creatorId,
+		Kind:      r.kind,
+		Teams:     slices.Clone(r.teams),
+		Members:   members,
+	}
+}
+
+// Len returns how many clients are connected
+func (r *Room) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.members)
+}
+
+// deliver sends a frame to some of a room's clients
+type deliver func(recipients []chan []byte, data []byte)
+
+// register adds client to team and sends the new user list through deliver. The list goes
+// out before the lock is released, so clients see the lists in the order changes happened.
+// It's false when the room already closed.
+func (r *Room) register(client ClientI, team string, deliver deliver) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return false
+	}
+
+	// the same client id connecting again takes over the old connection's spot
+	if previous, exists := r.members[client.GetID()]; exists {
+		r.removeMember(previous)
+	}
+
+	m := &member{client: client, team: team}
+	r.members[client.GetID()] = m
+	r.byTeam[team] = append(r.byTeam[team], m)
+	r.someoneEntered = true
+	r.membersChanged(deliver)
+	return true
+}
+
+// unregister removes client and sends the new user list through deliver, it returns
+// whether the room is left empty
+func (r *Room) unregister(client ClientI, deliver deliver) (empty bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// a newer connection with the same id may have replaced this one already
+	if m, exists := r.members[client.GetID()]; exists && m.client == client {
+		r.removeMember(m)
+		r.membersChanged(deliver)
+	}
+	return len(r.members) == 0
+}
+
+// removeMember is called with the lock held
+func (r *Room) removeMember(m *member) {
+	delete(r.members, m.client.GetID())
+
+	teamMembers := slices.DeleteFunc(r.byTeam[m.team], func(other *member) bool { return other == m })
+	if len(teamMembers) == 0 {
+		delete(r.byTeam, m.team)
+	} else {
+		r.byTeam[m.team] = teamMembers
+	}
+}
+
+// membersChanged is called with the lock held
+func (r *Room) membersChanged(deliver deliver) {
+	senders := make([]chan []byte, 0, len(r.members))
+	for _, m := range r.members {
+		senders = append(senders, m.client.GetSender())
+	}
+	r.senders = senders
+
+	teams := make(map[string][]*userModel.User, len(r.byTeam))
+	for team, members := range r.byTeam {
+		users := make([]*userModel.User, len(members))
+		for i, m := range members {
+			users[i] = m.client.GetUser()
+		}
+		teams[team] = users
+	}
+
+	data, err := json.Marshal(&RoomUserList{
+		RoomID:    r.id,
+		Teams:     teams,
+		Timestamp: time.Now().UnixNano(),
+	})
+	if err != nil {
+		panic(err)
+	}
+	deliver(r.senders, data)
+}
+
+// recipients returns the senders of every client
+func (r *Room) recipients() []chan []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.senders
+}
+
+// teamRecipients returns the senders of the clients in team
+func (r *Room) teamRecipients(team string) []chan []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	senders := make([]chan []byte, len(r.byTeam[team]))
+	for i, m := range r.byTeam[team] {
+		senders[i] = m.client.GetSender()
+	}
+	return senders
+}
+
+// recipient returns the sender of one client
+func (r *Room) recipient(clientId string) (chan []byte, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, exists := r.members[clientId]
+	if !exists {
+		return nil, false
+	}
+	return m.client.GetSender(), true
+}
+
+// clients returns every connected client
+func (r *Room) clients() []ClientI {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	clients := make([]ClientI, 0, len(r.members))
+	for _, m := range r.members {
+		clients = append(clients, m.client)
+	}
+	return clients
+}
+
+// close marks the room closed, it's called by the hub as it drops the room
+func (r *Room) close() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return true
+}
+
+// closeIfEmpty closes the room when nobody is connected
+func (r *Room) closeIfEmpty() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.members) == 0 {
+		r.closed = true
+	}
+	return r.closed
+}
+
+// closeIfUnused closes the room when nobody ever joined it
+func (r *Room) closeIfUnused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.someoneEntered {
+		r.closed = true
+	}
+	return r.closed
+}
@@ -0,0 +1,41 @@
+package ws_hub
+
+import (
+	"slices"
+
+	userModel "github.com/bozoteam/roshan/modules/user/models"
+)
+
+// Member is a client connected to a room, as seen in a snapshot
+type Member struct {
+	ClientId string
+	Team     string
+	User     userModel.User
+}
+
+// RoomSnapshot is a copy of a room taken under its lock. Nothing in it is shared with
+// the live room, so it can be read and passed around freely, but it isn't kept up to date.
+type RoomSnapshot struct {
+	Id        string
+	Name      string
+	CreatorId string
+	Kind      string
+	// Teams are the teams clients may join
+	Teams []string
+	// Members are grouped by team, in the order they joined
+	Members []Member
+}
+
+// HasUser tells whether the user had a client in the room
+func (s *RoomSnapshot) HasUser(userId string) bool {
+	return slices.ContainsFunc(s.Members, func(m Member) bool { return m.User.Id == userId })
+}
+
+// MembersByTeam groups the members by the team they joined
+func (s *RoomSnapshot) MembersByTeam() map[string][]Member {
+	teams := make(map[string][]Member)
+	for _, member := range s.Members {
+		teams[member.Team] = append(teams[member.Team], member)
+	}
+	return teams
+}
@@ -1,6 +1,9 @@
 package ws_client
 
 import (
+	"context"
+
+	"github.com/bozoteam/roshan/adapter/log"
 	userModel "github.com/bozoteam/roshan/modules/user/models"
 	"github.com/bozoteam/roshan/modules/websocket/ws_pump"
 	"github.com/gorilla/websocket"
@@ -13,14 +16,20 @@ type Client struct {
 	pump *ws_pump.Pump `json:"-"`
 }
 
-// NewClient creates a new client
-func NewClient(conn *websocket.Conn, user *userModel.User, roomID string) *Client {
+// NewClient creates a new client, ctx is the upgrade request's and only used to tag its logs.
+// onMessage, when not nil, receives the frames the client sends besides heartbeats.
+func NewClient(ctx context.Context, conn *websocket.Conn, user *userModel.User, roomID string, timings ws_pump.Timings, onMessage func(data []byte)) *Client {
 	send := make(chan []byte, 8)
+	logger := log.LogWithModule("ws_pump").With(
+		"user_id", user.Id,
+		"room_id", roomID,
+		"request_id", log.RequestIdFromContext(ctx),
+	)
 
 	c := &Client{
 		User: user,
 		send: send,
-		pump: ws_pump.NewPump(conn, send),
+		pump: ws_pump.NewPump(conn, send, timings, logger, onMessage),
 	}
 
 	c.pump.Start()
@@ -40,6 +49,9 @@ func (c *Client) WaitUnregister() {
 func (c *Client) GetSender() chan []byte {
 	return c.send
 }
+func (c *Client) Close(code int, reason string) {
+	c.pump.Close(code, reason)
+}
 
 // ClientRegistration holds data for registering a client to a room
 type ClientRegistration struct {
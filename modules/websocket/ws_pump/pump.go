@@ -1,35 +1,69 @@
 package ws_pump
 
 import (
-	"fmt"
+	"log/slog"
 	"time"
 
+	"github.com/bozoteam/roshan/adapter/metrics"
 	"github.com/gorilla/websocket"
 )
 
-func NewPump(conn *websocket.Conn, sendChan chan []byte) *Pump {
+// Timings control the keepalive of a connection
+type Timings struct {
+	// How often the client is sent a PING
+	PingInterval time.Duration
+	// How long the client has to answer a PING
+	PongWait time.Duration
+	// Time allowed to write a message to the client
+	WriteWait time.Duration
+}
+
+// NewPump logs through logger, which callers decorate with whatever identifies the connection.
+// Frames other than heartbeats are passed to onMessage, from the read goroutine, when it isn't nil.
+func NewPump(conn *websocket.Conn, sendChan chan []byte, timings Timings, logger *slog.Logger, onMessage func(data []byte)) *Pump {
 	return &Pump{
+		logger:     logger.With("remote_addr", conn.RemoteAddr().String()),
 		conn:       conn,
 		send:       sendChan,
+		timings:    timings,
 		pingNotify: make(chan struct{}),
+		closing:    make(chan closeRequest, 1),
+		onMessage:  onMessage,
 
 		Unregister: make(chan struct{}),
 	}
 }
 
 type Pump struct {
+	logger     *slog.Logger
 	conn       *websocket.Conn
 	send       chan []byte
+	timings    Timings
 	pingNotify chan struct{}
+	closing    chan closeRequest
+	onMessage  func(data []byte)
 
 	Unregister chan struct{}
 }
 
+type closeRequest struct {
+	code   int
+	reason string
+}
+
 func (p *Pump) Start() {
 	go p.writePump()
 	go p.readPump()
 }
 
+// Close flushes the messages already queued and ends the connection with a close frame
+func (p *Pump) Close(code int, reason string) {
+	select {
+	case p.closing <- closeRequest{code: code, reason: reason}:
+	default:
+	}
+}
+
 // readPump handles reading messages from a client
 func (p *Pump) readPump() {
 	defer func() {
@@ -37,37 +71,44 @@ func (p *Pump) readPump() {
 		p.conn.Close()
 	}()
 
-	// p.conn.SetReadLimit(maxMessageSize)
-	p.conn.SetReadLimit(4) //ping
 	p.conn.SetReadDeadline(time.Time{})
 
 	for {
 		_, msg, err := p.conn.ReadMessage()
 		if err != nil {
-			// if websocket.IsUnexpectedCloseError(err,
-			// 	websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-			// }
-			fmt.Println(err)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				p.logger.Warn("websocket closed unexpectedly", "error", err)
+			} else {
+				p.logger.Debug("websocket closed", "error", err)
+			}
 			break
 		}
 		if string(msg) == "PONG" {
-			fmt.Println("Received PONG")
-			p.pingNotify <- struct{}{}
+			p.logger.Debug("received PONG")
+			// the write side may already be gone while closing
+			select {
+			case p.pingNotify <- struct{}{}:
+			default:
+			}
 			continue
 		}
 		if string(msg) == "PING" {
-			fmt.Println("Received PING")
+			p.logger.Debug("received PING")
 			err := p.writeMessage([]byte("PONG"))
 			if err != nil {
+				p.logger.Debug("failed to send PONG", "error", err)
 				break
 			}
-			fmt.Println("SENDING PONG")
+			continue
+		}
+		if p.onMessage != nil {
+			p.onMessage(msg)
 		}
 	}
 }
 
 func (c *Pump) writeMessage(message []byte) error {
-	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	c.conn.SetWriteDeadline(time.Now().Add(c.timings.WriteWait))
 
 	w, err := c.conn.NextWriter(websocket.TextMessage)
 	if err != nil {
@@ -87,11 +128,15 @@ func (c *Pump) writeMessage(message []byte) error {
 
 // writePump handles sending messages to a client
 func (c *Pump) writePump() {
-	ticker := time.NewTicker(time.Second * 10)
+	ticker := time.NewTicker(c.timings.PingInterval)
+	// Once a close frame is out the read side waits for the client's answer and closes the socket
+	handshaking := false
 	defer func() {
-		fmt.Println("Closing WritePump")
+		c.logger.Debug("write pump stopped")
 		ticker.Stop()
-		c.conn.Close()
+		if !handshaking {
+			c.conn.Close()
+		}
 	}()
 
 	for {
@@ -100,45 +145,50 @@ func (c *Pump) writePump() {
 			if !ok {
 				// The hub closed the channel
 				// c.conn.WriteMessage(websocket.CloseMessage, []byte("CLOSED"))
-				// fmt.Println(errors.New("hub closed the channel"))
 				break
 			}
 			err := c.writeMessage(message)
 			if err != nil {
-				fmt.Println("Error writing message:", err)
+				c.logger.Debug("failed to write message", "error", err)
 				break
 			}
 
+		case req := <-c.closing:
+			for flushing := true; flushing; {
+				select {
+				case message := <-c.send:
+					if err := c.writeMessage(message); err != nil {
+						return
+					}
+				default:
+					flushing = false
+				}
+			}
+
+			c.conn.SetWriteDeadline(time.Now().Add(c.timings.WriteWait))
+			err := c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(req.code, req.reason))
+			if err != nil {
+				return
+			}
+			c.conn.SetReadDeadline(time.Now().Add(c.timings.PongWait))
+			handshaking = true
+			return
+
 		case <-ticker.C:
-			fmt.Println("sending ping")
 			err := c.writeMessage([]byte("PING"))
 			if err != nil {
-				fmt.Println("Error sending ping:", err)
+				c.logger.Debug("failed to send PING", "error", err)
 				break
 			}
 			select {
 			case <-c.pingNotify:
 				continue
-			case <-time.After(time.Second * 5):
-				fmt.Println("Ping timeout")
+			case <-time.After(c.timings.PongWait):
+				c.logger.Info("ping timeout, closing websocket")
+				metrics.PingTimeouts.Inc()
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 		}
 	}
 }
-
-// Constants for WebSocket
-const (
-	// Time allowed to write a message to the peer
-	writeWait = 5 * time.Second
-
-	// Time allowed to read the next pong message from the peer
-	pongWait = 5 * time.Second
-
-	// send pings to peer with this period
-	pingPeriod = (pongWait * 9) / 10
-
-	// Maximum message size allowed from peer
-	maxMessageSize = 1024
-)
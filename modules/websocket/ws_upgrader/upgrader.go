@@ -0,0 +1,165 @@
+package ws_upgrader
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/bozoteam/roshan/adapter/config"
+	"github.com/bozoteam/roshan/adapter/log"
+	"github.com/bozoteam/roshan/modules/websocket/ws_pump"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrTooManyConnections = errors.New("too many websocket connections")
+	ErrShuttingDown       = errors.New("server is shutting down")
+)
+
+// Upgrader is shared by every WebSocket route so origin checks and connection limits
+// apply the same way to chat and game rooms
+type Upgrader struct {
+	logger          *slog.Logger
+	upgrader        websocket.Upgrader
+	allowedOrigins  map[string]struct{}
+	maxConnsPerUser int
+	readLimit       int64
+	timings         ws_pump.Timings
+
+	mu       sync.Mutex
+	conns    map[string]int
+	closing  bool
+	inFlight sync.WaitGroup
+}
+
+// NewUpgrader creates the upgrader, allowedOrigins should be the list the CORS middleware uses
+func NewUpgrader(cfg config.WebSocketConfig, allowedOrigins []string) *Upgrader {
+	u := &Upgrader{
+		logger:          log.LogWithModule("ws_upgrader"),
+		allowedOrigins:  make(map[string]struct{}, len(allowedOrigins)),
+		maxConnsPerUser: cfg.MaxConnsPerUser,
+		readLimit:       cfg.ReadLimit,
+		timings: ws_pump.Timings{
+			PingInterval: cfg.PingInterval,
+			PongWait:     cfg.PongWait,
+			WriteWait:    cfg.WriteWait,
+		},
+		conns: make(map[string]int),
+	}
+	for _, origin := range allowedOrigins {
+		if origin != "" {
+			u.allowedOrigins[origin] = struct{}{}
+		}
+	}
+
+	u.upgrader = websocket.Upgrader{
+		HandshakeTimeout:  cfg.HandshakeTimeout,
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		CheckOrigin:       u.checkOrigin,
+		EnableCompression: cfg.EnableCompression,
+	}
+	return u
+}
+
+// checkOrigin rejects cross-site upgrades, browsers attach cookies to those.
+// Requests without an Origin header don't come from a browser and are let through.
+func (u *Upgrader) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if _, ok := u.allowedOrigins[origin]; ok {
+		return true
+	}
+	u.logger.WarnContext(r.Context(), "rejected websocket origin", "origin", origin)
+	return false
+}
+
+// Timings returns the keepalive settings for connections made through this upgrader
+func (u *Upgrader) Timings() ws_pump.Timings {
+	return u.timings
+}
+
+func (u *Upgrader) acquire(userId string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closing {
+		return ErrShuttingDown
+	}
+	if u.conns[userId] >= u.maxConnsPerUser {
+		return ErrTooManyConnections
+	}
+	u.conns[userId]++
+	u.inFlight.Add(1)
+	return nil
+}
+
+func (u *Upgrader) release(userId string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.conns[userId]--
+	if u.conns[userId] <= 0 {
+		delete(u.conns, userId)
+	}
+	u.inFlight.Done()
+}
+
+// Shutdown stops accepting new connections, the ones already open are left alone
+func (u *Upgrader) Shutdown() {
+	u.mu.Lock()
+	u.closing = true
+	u.mu.Unlock()
+}
+
+// Wait blocks until every connection made through the upgrader is gone or ctx is done.
+// Only call it after Shutdown.
+func (u *Upgrader) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		u.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Upgrade switches the request over to a WebSocket on behalf of userId. The returned
+// func must be called once the connection is gone to free the user's slot.
+// On failure a response has already been written.
+func (u *Upgrader) Upgrade(ctx *gin.Context, userId string) (*websocket.Conn, func(), error) {
+	if err := u.acquire(userId); err != nil {
+		if err == ErrShuttingDown {
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down"})
+		} else {
+			ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many connections"})
+		}
+		return nil, nil, err
+	}
+
+	conn, err := u.upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		u.release(userId)
+		return nil, nil, err
+	}
+	conn.SetReadLimit(u.readLimit)
+	// The HTTP part of the request is over, its span would otherwise last as long as the socket
+	span := trace.SpanFromContext(ctx.Request.Context())
+	span.SetAttributes(attribute.Int("http.response.status_code", http.StatusSwitchingProtocols))
+	span.End()
+
+	var once sync.Once
+	return conn, func() { once.Do(func() { u.release(userId) }) }, nil
+}
@@ -27,4 +27,10 @@ var (
 
 	ErrAuthFailedMsg = "authentication failed"
 	ErrAuthFailed    = status.Error(codes.Unauthenticated, ErrAuthFailedMsg)
+
+	ErrInsufficientScopeMsg = "api key lacks the required scope"
+	ErrInsufficientScope    = status.Error(codes.PermissionDenied, ErrInsufficientScopeMsg)
+
+	ErrAdminRequiredMsg = "admin role required"
+	ErrAdminRequired    = status.Error(codes.PermissionDenied, ErrAdminRequiredMsg)
 )
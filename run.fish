@@ -25,4 +25,4 @@ end
 ./dev/migrate.fish; or cleanup
 ./dev/apply.fish; or cleanup
 
-go run -race ./cmd/server/main.go; or cleanup
+go run -race ./cmd/server; or cleanup